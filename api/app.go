@@ -469,14 +469,23 @@ func runCommand(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	once := r.URL.Query().Get("once")
 	interactive := r.URL.Query().Get("interactive")
 	rec.Log(u.Email, "run-command", "app="+appName, "command="+string(c))
-	app, err := getApp(appName, u, r)
+	a, err := getApp(appName, u, r)
 	if err != nil {
 		return err
 	}
+	args := provision.RunArgs{
+		Once:        once == "true",
+		Interactive: interactive == "true",
+		Units:       r.URL.Query()["unit"],
+		Process:     r.URL.Query().Get("process"),
+	}
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
-	err = app.Run(string(c), writer, once == "true", interactive == "true")
+	err = a.Run(string(c), writer, args)
+	if err == app.ErrRunFilterNotSupported {
+		return &errors.HTTP{Code: http.StatusNotImplemented, Message: err.Error()}
+	}
 	if err != nil {
 		writer.Encode(tsuruIo.SimpleJsonMessage{Error: err.Error()})
 		return err
@@ -819,6 +828,50 @@ func unbindServiceInstance(w http.ResponseWriter, r *http.Request, t auth.Token)
 	return nil
 }
 
+// rollingOptions reads the rolling options from the request, using the
+// given options as defaults for the values missing in the request.
+func rollingOptions(r *http.Request, defaults *provision.RollingOptions) (*provision.RollingOptions, error) {
+	var opts provision.RollingOptions
+	if defaults != nil {
+		opts = *defaults
+	}
+	values := []struct {
+		name  string
+		value *int
+	}{
+		{"max-surge", &opts.MaxSurge},
+		{"max-unavailable", &opts.MaxUnavailable},
+	}
+	for _, v := range values {
+		str := r.FormValue(v.name)
+		if str == "" {
+			continue
+		}
+		n, err := strconv.Atoi(str)
+		if err != nil || n < 0 {
+			return nil, &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: fmt.Sprintf("Invalid %s: the value must be a non-negative integer.", v.name),
+			}
+		}
+		*v.value = n
+	}
+	if str := r.FormValue("pause"); str != "" {
+		seconds, err := strconv.Atoi(str)
+		if err != nil || seconds < 0 {
+			return nil, &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid pause: the value must be a non-negative number of seconds.",
+			}
+		}
+		opts.Pause = time.Duration(seconds) * time.Second
+	}
+	if !opts.Enabled() {
+		return nil, nil
+	}
+	return &opts, nil
+}
+
 func restart(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	process := r.URL.Query().Get("process")
 	w.Header().Set("Content-Type", "text")
@@ -832,6 +885,10 @@ func restart(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	if err != nil {
 		return err
 	}
+	instance.Rolling, err = rollingOptions(r, instance.Rolling)
+	if err != nil {
+		return err
+	}
 	keepAliveWriter := tsuruIo.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
 	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
@@ -843,6 +900,29 @@ func restart(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	return nil
 }
 
+func setAppRolling(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	instance, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	opts, err := rollingOptions(r, nil)
+	if err != nil {
+		return err
+	}
+	extra := []interface{}{"app=" + appName}
+	if opts != nil {
+		extra = append(extra, fmt.Sprintf("max-surge=%d", opts.MaxSurge),
+			fmt.Sprintf("max-unavailable=%d", opts.MaxUnavailable), fmt.Sprintf("pause=%s", opts.Pause))
+	}
+	rec.Log(u.Email, "set-app-rolling", extra...)
+	return instance.SetRollingOptions(opts)
+}
+
 func addLog(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	queryValues := r.URL.Query()
 	app, err := app.GetByName(queryValues.Get(":app"))
@@ -1069,6 +1149,26 @@ func appMetricEnvs(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	return json.NewEncoder(w).Encode(a.MetricEnvs())
 }
 
+func appUnitsMetrics(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	a, err := getApp(r.URL.Query().Get(":app"), u, r)
+	if err != nil {
+		return err
+	}
+	metrics, err := a.UnitsMetrics()
+	if err == app.ErrMetricsNotSupported {
+		return &errors.HTTP{Code: http.StatusNotImplemented, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(metrics)
+}
+
 func appRebuildRoutes(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	u, err := t.User()
 	if err != nil {
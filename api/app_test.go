@@ -1413,6 +1413,28 @@ func (s *S) TestRunOnceHandler(c *check.C) {
 	c.Assert(action, rectest.IsRecorded)
 }
 
+func (s *S) TestRunHandlerInUnits(c *check.C) {
+	s.provisioner.PrepareOutput([]byte("lots of files"))
+	a := app.App{Name: "secrets", Platform: "zend", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	s.provisioner.AddUnits(&a, 1, "worker", nil)
+	url := fmt.Sprintf("/apps/%s/run/?:app=%s&process=worker", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("ls"))
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = runCommand(recorder, request, s.token)
+	c.Assert(err, check.IsNil)
+	c.Assert(recorder.Body.String(), check.Equals, `{"Message":"[secrets-1] lots of files"}`+"\n")
+	expected := "[ -f /home/application/apprc ] && source /home/application/apprc;"
+	expected += " [ -d /home/application/current ] && cd /home/application/current;"
+	expected += " ls"
+	cmds := s.provisioner.GetCmds(expected, &a)
+	c.Assert(cmds, check.HasLen, 1)
+	c.Assert(cmds[0].Units, check.DeepEquals, []string{"secrets-1"})
+}
+
 func (s *S) TestRunHandler(c *check.C) {
 	s.provisioner.PrepareOutput([]byte("lots of\nfiles"))
 	a := app.App{Name: "secrets", Platform: "zend", Teams: []string{s.team.Name}}
@@ -2342,7 +2364,8 @@ func (s *S) TestAppLogFollowWithPubSub(c *check.C) {
 		case <-time.After(50 * time.Millisecond):
 		}
 		logTracker.Lock()
-		for listener = range logTracker.conn {
+		for l := range logTracker.conn {
+			listener = l.(*app.LogListener)
 		}
 		logTracker.Unlock()
 	}
@@ -2391,7 +2414,8 @@ func (s *S) TestAppLogFollowWithFilter(c *check.C) {
 		case <-time.After(50 * time.Millisecond):
 		}
 		logTracker.Lock()
-		for listener = range logTracker.conn {
+		for l := range logTracker.conn {
+			listener = l.(*app.LogListener)
 		}
 		logTracker.Unlock()
 	}
@@ -2992,6 +3016,82 @@ func (s *S) TestRestartHandler(c *check.C) {
 	c.Assert(action, rectest.IsRecorded)
 }
 
+func (s *S) TestRestartHandlerWithRollingOptions(c *check.C) {
+	a := app.App{
+		Name:     "stress",
+		Platform: "zend",
+		Teams:    []string{s.team.Name},
+	}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	err = a.SetRollingOptions(&provision.RollingOptions{MaxSurge: 1, Pause: time.Minute})
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/restart?:app=%s&max-unavailable=2&pause=5", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = restart(recorder, request, s.token)
+	c.Assert(err, check.IsNil)
+	c.Assert(s.provisioner.RestartRollingOptions(&a), check.DeepEquals, &provision.RollingOptions{
+		MaxSurge:       1,
+		MaxUnavailable: 2,
+		Pause:          5 * time.Second,
+	})
+	var dbApp app.App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&dbApp)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Rolling, check.DeepEquals, &provision.RollingOptions{MaxSurge: 1, Pause: time.Minute})
+}
+
+func (s *S) TestRestartHandlerInvalidRollingOptions(c *check.C) {
+	a := app.App{Name: "stress", Platform: "zend", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/restart?:app=%s&max-surge=-1", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	err = restart(recorder, request, s.token)
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, check.Equals, "Invalid max-surge: the value must be a non-negative integer.")
+	c.Assert(s.provisioner.Restarts(&a, ""), check.Equals, 0)
+}
+
+func (s *S) TestSetAppRolling(c *check.C) {
+	a := app.App{Name: "stress", Platform: "zend", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/rolling?:app=%s", a.Name, a.Name)
+	body := strings.NewReader("max-surge=2&max-unavailable=1&pause=30")
+	request, err := http.NewRequest("POST", url, body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	err = setAppRolling(recorder, request, s.token)
+	c.Assert(err, check.IsNil)
+	var dbApp app.App
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&dbApp)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Rolling, check.DeepEquals, &provision.RollingOptions{
+		MaxSurge:       2,
+		MaxUnavailable: 1,
+		Pause:          30 * time.Second,
+	})
+	request, err = http.NewRequest("POST", url, strings.NewReader("max-surge=0"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder = httptest.NewRecorder()
+	err = setAppRolling(recorder, request, s.token)
+	c.Assert(err, check.IsNil)
+	dbApp = app.App{}
+	err = s.conn.Apps().Find(bson.M{"name": a.Name}).One(&dbApp)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Rolling, check.IsNil)
+}
+
 func (s *S) TestRestartHandlerReturns404IfTheAppDoesNotExist(c *check.C) {
 	request, err := http.NewRequest("GET", "/apps/unknown/restart?:app=unknown", nil)
 	c.Assert(err, check.IsNil)
@@ -3693,6 +3793,45 @@ func (s *S) TestMEtricEnvsWhenAppDoesNotExist(c *check.C) {
 	c.Assert(recorder.Body.String(), check.Matches, "^App .* not found.\n$")
 }
 
+func (s *S) TestUnitsMetrics(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, s.user)
+	c.Assert(err, check.IsNil)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 2, "web", nil)
+	request, err := http.NewRequest("GET", "/apps/myappx/units/metrics", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var metrics []provision.UnitMetrics
+	err = json.Unmarshal(recorder.Body.Bytes(), &metrics)
+	c.Assert(err, check.IsNil)
+	units, err := s.provisioner.Units(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, []provision.UnitMetrics{
+		{ID: units[0].ID, ProcessName: "web"},
+		{ID: units[1].ID, ProcessName: "web"},
+	})
+}
+
+func (s *S) TestUnitsMetricsWhenUserDoesNotHaveAccess(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend"}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/myappx/units/metrics", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
 func (s *S) TestRebuildRoutes(c *check.C) {
 	config.Set("docker:router", "fake")
 	defer config.Unset("docker:router")
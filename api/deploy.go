@@ -17,6 +17,8 @@ import (
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/rec"
 	"github.com/tsuru/tsuru/service"
 )
 
@@ -74,6 +76,10 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 		instance = &app
 		userName = t.GetUserName()
 	}
+	instance.Rolling, err = rollingOptions(r, instance.Rolling)
+	if err != nil {
+		return err
+	}
 	writer := io.NewKeepAliveWriter(w, 30*time.Second, "please wait...")
 	defer writer.Stop()
 	err = app.Deploy(app.DeployOptions{
@@ -91,6 +97,120 @@ func deploy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	return err
 }
 
+func deployCanary(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var file multipart.File
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		file, _, err = r.FormFile("file")
+		if err != nil {
+			return &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			}
+		}
+	}
+	version := r.FormValue("version")
+	archiveURL := r.FormValue("archive-url")
+	image := r.FormValue("image")
+	if version == "" && archiveURL == "" && image == "" && file == nil {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: "you must specify either the version, the archive-url, the image or upload a file",
+		}
+	}
+	var opts provision.CanaryOptions
+	if units := r.FormValue("units"); units != "" {
+		opts.Units, err = strconv.Atoi(units)
+		if err != nil || opts.Units < 1 {
+			return &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid number of units: the number must be an integer greater than 0.",
+			}
+		}
+	}
+	if weight := r.FormValue("weight"); weight != "" {
+		opts.Weight, err = strconv.Atoi(weight)
+		if err != nil || opts.Weight < 0 || opts.Weight > 99 {
+			return &errors.HTTP{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid weight: the weight must be an integer between 0 and 99.",
+			}
+		}
+	}
+	appName := r.URL.Query().Get(":appname")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	instance, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "deploy-canary", "app="+appName, fmt.Sprintf("units=%d", opts.Units), fmt.Sprintf("weight=%d", opts.Weight))
+	w.Header().Set("Content-Type", "application/json")
+	keepAliveWriter := io.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &io.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	err = app.Deploy(app.DeployOptions{
+		App:          &instance,
+		Version:      version,
+		ArchiveURL:   archiveURL,
+		File:         file,
+		Image:        image,
+		OutputStream: writer,
+		User:         t.GetUserName(),
+		Canary:       &opts,
+	})
+	if err != nil {
+		writer.Encode(io.SimpleJsonMessage{Error: err.Error()})
+	}
+	return nil
+}
+
+func deployCanaryPromote(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":appname")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	instance, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "deploy-canary-promote", "app="+appName)
+	w.Header().Set("Content-Type", "application/json")
+	keepAliveWriter := io.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &io.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	err = app.PromoteCanary(&instance, writer)
+	if err != nil {
+		writer.Encode(io.SimpleJsonMessage{Error: err.Error()})
+	}
+	return nil
+}
+
+func deployCanaryAbort(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	appName := r.URL.Query().Get(":appname")
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	instance, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	rec.Log(u.Email, "deploy-canary-abort", "app="+appName)
+	w.Header().Set("Content-Type", "application/json")
+	keepAliveWriter := io.NewKeepAliveWriter(w, 30*time.Second, "")
+	defer keepAliveWriter.Stop()
+	writer := &io.SimpleJsonMessageEncoderWriter{Encoder: json.NewEncoder(keepAliveWriter)}
+	err = app.AbortCanary(&instance, writer)
+	if err != nil {
+		writer.Encode(io.SimpleJsonMessage{Error: err.Error()})
+	}
+	return nil
+}
+
 func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	appName := r.URL.Query().Get(":appname")
 	instance, err := app.GetByName(appName)
@@ -104,6 +224,10 @@ func deployRollback(w http.ResponseWriter, r *http.Request, t auth.Token) error
 			Message: "you cannot rollback without an image name",
 		}
 	}
+	instance.Rolling, err = rollingOptions(r, instance.Rolling)
+	if err != nil {
+		return err
+	}
 	w.Header().Set("Content-Type", "application/json")
 	keepAliveWriter := io.NewKeepAliveWriter(w, 30*time.Second, "")
 	defer keepAliveWriter.Stop()
@@ -8,6 +8,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
@@ -734,6 +735,129 @@ func (s *DeploySuite) TestDeployInfoByUserWithoutAccess(c *check.C) {
 	c.Assert(body, check.Equals, "Deploy not found.\n")
 }
 
+func (s *DeploySuite) TestDeployCanary(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	defer app.Delete(&a, nil)
+	url := fmt.Sprintf("/apps/%s/deploy/canary?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("image=my-image-123:v2&units=2&weight=10"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	c.Assert(recorder.Body.String(), check.Equals, "{\"Message\":\"Canary deploy called\"}\n")
+	canary := s.provisioner.Canary(&a)
+	c.Assert(canary, check.NotNil)
+	c.Assert(canary.Units, check.Equals, 2)
+	c.Assert(canary.Weight, check.Equals, 10)
+	c.Assert(canary.Image, check.Equals, "my-image-123:v2")
+	var deploy app.DeployData
+	err = s.conn.Deploys().Find(bson.M{"app": a.Name}).One(&deploy)
+	c.Assert(err, check.IsNil)
+	c.Assert(deploy.Origin, check.Equals, "canary")
+	c.Assert(deploy.Image, check.Equals, "my-image-123:v2")
+}
+
+func (s *DeploySuite) TestDeployCanaryInvalidWeight(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	defer app.Delete(&a, nil)
+	url := fmt.Sprintf("/apps/%s/deploy/canary?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("version=a345f3e&weight=100"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "Invalid weight: the weight must be an integer between 0 and 99.\n")
+	c.Assert(s.provisioner.Canary(&a), check.IsNil)
+}
+
+func (s *DeploySuite) TestDeployCanaryWithoutSource(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	defer app.Delete(&a, nil)
+	url := fmt.Sprintf("/apps/%s/deploy/canary?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, strings.NewReader("units=1"))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Equals, "you must specify either the version, the archive-url, the image or upload a file\n")
+}
+
+func (s *DeploySuite) TestDeployCanaryPromote(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	defer app.Delete(&a, nil)
+	_, err = s.provisioner.CanaryDeploy(&a, provision.CanaryOptions{Units: 1}, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/deploy/canary/promote?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "{\"Message\":\"Promote canary called\"}\n")
+	c.Assert(s.provisioner.Canary(&a), check.IsNil)
+}
+
+func (s *DeploySuite) TestDeployCanaryAbort(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	defer app.Delete(&a, nil)
+	_, err = s.provisioner.CanaryDeploy(&a, provision.CanaryOptions{Units: 1}, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	url := fmt.Sprintf("/apps/%s/deploy/canary/abort?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "{\"Message\":\"Abort canary called\"}\n")
+	c.Assert(s.provisioner.Canary(&a), check.IsNil)
+}
+
+func (s *DeploySuite) TestDeployCanaryAbortWithoutCanary(c *check.C) {
+	user, _ := s.token.User()
+	a := app.App{Name: "otherapp", Platform: "python", Teams: []string{s.team.Name}}
+	err := app.CreateApp(&a, user)
+	c.Assert(err, check.IsNil)
+	defer app.Delete(&a, nil)
+	url := fmt.Sprintf("/apps/%s/deploy/canary/abort?:appname=%s", a.Name, a.Name)
+	request, err := http.NewRequest("POST", url, nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	server := RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, "{\"Message\":\"\",\"Error\":\"no canary deploy in progress for this app\"}\n")
+}
+
 func (s *DeploySuite) TestDeployRollbackHandler(c *check.C) {
 	user, _ := s.token.User()
 	a := app.App{Name: "otherapp", Platform: "python", Teams: []string{s.team.Name}}
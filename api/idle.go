@@ -0,0 +1,55 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/rec"
+)
+
+func setIdlePolicy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var policy struct {
+		Window int
+	}
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&policy)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the idle policy in JSON format"}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "set-idle-policy", "app="+appName, "window="+strconv.Itoa(policy.Window))
+	a, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	err = a.SetIdlePolicy(policy.Window)
+	if err == app.ErrInvalidIdleWindow || err == app.ErrIdleNotSupported {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return err
+}
+
+func getIdlePolicy(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	a, err := getApp(r.URL.Query().Get(":app"), u, r)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(a.Idle)
+}
@@ -0,0 +1,72 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/rec/rectest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestSetIdlePolicy(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"window": 30}`)
+	request, err := http.NewRequest("POST", "/apps/myappx/idle", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Window, check.Equals, 30)
+	action := rectest.Action{
+		Action: "set-idle-policy",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=myappx", "window=30"},
+	}
+	c.Assert(action, rectest.IsRecorded)
+}
+
+func (s *S) TestSetIdlePolicyInvalid(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	for _, b := range []string{`{"window": -1}`, `not json`} {
+		request, err := http.NewRequest("POST", "/apps/myappx/idle", strings.NewReader(b))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m := RunServer(true)
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest, check.Commentf(b))
+	}
+}
+
+func (s *S) TestGetIdlePolicy(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", Teams: []string{s.team.Name}, Idle: app.IdlePolicy{Window: 15, Sleeping: true}}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/myappx/idle", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var policy app.IdlePolicy
+	err = json.NewDecoder(recorder.Body).Decode(&policy)
+	c.Assert(err, check.IsNil)
+	c.Assert(policy.Window, check.Equals, 15)
+	c.Assert(policy.Sleeping, check.Equals, true)
+}
@@ -0,0 +1,102 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/rec"
+)
+
+func listJobs(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	a, err := getApp(r.URL.Query().Get(":app"), u, r)
+	if err != nil {
+		return err
+	}
+	jobs, err := a.Jobs()
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(jobs)
+}
+
+func addJob(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var job app.Job
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&job)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the job in JSON format"}
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "add-job", "app="+appName, "job="+job.Name, "schedule="+job.Schedule, "command="+job.Command)
+	a, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	err = a.AddJob(job)
+	if err == app.ErrJobAlreadyExists {
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	if app.IsJobValidationError(err) {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusCreated)
+	return nil
+}
+
+func removeJob(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	jobName := r.URL.Query().Get(":job")
+	rec.Log(u.Email, "remove-job", "app="+appName, "job="+jobName)
+	a, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	err = a.RemoveJob(jobName)
+	if err == app.ErrJobNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
+func listJobRuns(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	a, err := getApp(r.URL.Query().Get(":app"), u, r)
+	if err != nil {
+		return err
+	}
+	runs, err := a.JobRuns(r.URL.Query().Get(":job"))
+	if err == app.ErrJobNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(runs)
+}
@@ -0,0 +1,195 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/rec/rectest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) createJobsApp(c *check.C) *app.App {
+	a := app.App{Name: "myappx", Platform: "zend", Teams: []string{s.team.Name}, Plan: app.Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	return &a
+}
+
+func (s *S) TestAddJob(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Jobs().RemoveAll(nil)
+	body := strings.NewReader(`{"name": "cleanup", "schedule": "*/5 * * * *", "command": "python cleanup.py"}`)
+	request, err := http.NewRequest("POST", "/apps/myappx/jobs", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Name, check.Equals, "cleanup")
+	c.Assert(jobs[0].AppName, check.Equals, "myappx")
+	c.Assert(jobs[0].Schedule, check.Equals, "*/5 * * * *")
+	c.Assert(jobs[0].Command, check.Equals, "python cleanup.py")
+	c.Assert(jobs[0].NextRun.Minute()%5, check.Equals, 0)
+	action := rectest.Action{
+		Action: "add-job",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=myappx", "job=cleanup", "schedule=*/5 * * * *", "command=python cleanup.py"},
+	}
+	c.Assert(action, rectest.IsRecorded)
+}
+
+func (s *S) TestAddJobInvalidSchedule(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"name": "cleanup", "schedule": "* * *", "command": "python cleanup.py"}`)
+	request, err := http.NewRequest("POST", "/apps/myappx/jobs", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, `invalid schedule "\* \* \*".*\n`)
+}
+
+func (s *S) TestAddJobAlreadyExists(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Jobs().RemoveAll(nil)
+	err := a.AddJob(app.Job{Name: "cleanup", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	body := strings.NewReader(`{"name": "cleanup", "schedule": "@hourly", "command": "ls"}`)
+	request, err := http.NewRequest("POST", "/apps/myappx/jobs", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestAddJobWhenUserDoesNotHaveAccess(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend"}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"name": "cleanup", "schedule": "@daily", "command": "ls"}`)
+	request, err := http.NewRequest("POST", "/apps/myappx/jobs", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusForbidden)
+}
+
+func (s *S) TestListJobs(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Jobs().RemoveAll(nil)
+	err := a.AddJob(app.Job{Name: "cleanup", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myappx/jobs", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var jobs []app.Job
+	err = json.Unmarshal(recorder.Body.Bytes(), &jobs)
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Name, check.Equals, "cleanup")
+	c.Assert(jobs[0].Schedule, check.Equals, "@daily")
+}
+
+func (s *S) TestRemoveJob(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Jobs().RemoveAll(nil)
+	err := a.AddJob(app.Job{Name: "cleanup", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("DELETE", "/apps/myappx/jobs/cleanup", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 0)
+}
+
+func (s *S) TestRemoveJobNotFound(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("DELETE", "/apps/myappx/jobs/cleanup", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListJobRuns(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	defer s.conn.Jobs().RemoveAll(nil)
+	defer s.conn.JobRuns().RemoveAll(nil)
+	err := a.AddJob(app.Job{Name: "cleanup", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	err = s.conn.JobRuns().Insert(app.JobRun{
+		ID:         bson.NewObjectId(),
+		AppName:    "myappx",
+		JobName:    "cleanup",
+		StartTime:  now,
+		EndTime:    now,
+		ExitStatus: 2,
+		Output:     "failed",
+	})
+	c.Assert(err, check.IsNil)
+	request, err := http.NewRequest("GET", "/apps/myappx/jobs/cleanup/runs", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var runs []app.JobRun
+	err = json.Unmarshal(recorder.Body.Bytes(), &runs)
+	c.Assert(err, check.IsNil)
+	c.Assert(runs, check.HasLen, 1)
+	c.Assert(runs[0].ExitStatus, check.Equals, 2)
+	c.Assert(runs[0].Output, check.Equals, "failed")
+}
+
+func (s *S) TestListJobRunsJobNotFound(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/myappx/jobs/cleanup/runs", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
@@ -4,37 +4,37 @@
 
 package api
 
-import (
-	"sync"
+import "sync"
 
-	"github.com/tsuru/tsuru/app"
-)
+type streamListener interface {
+	Close() error
+}
 
 type logStreamTracker struct {
 	sync.Mutex
-	conn map[*app.LogListener]struct{}
+	conn map[streamListener]struct{}
 }
 
-func (t *logStreamTracker) add(l *app.LogListener) {
+func (t *logStreamTracker) add(l streamListener) {
 	t.Lock()
 	defer t.Unlock()
 	if t.conn == nil {
-		t.conn = make(map[*app.LogListener]struct{})
+		t.conn = make(map[streamListener]struct{})
 	}
 	t.conn[l] = struct{}{}
 }
 
-func (t *logStreamTracker) remove(l *app.LogListener) {
+func (t *logStreamTracker) remove(l streamListener) {
 	t.Lock()
 	defer t.Unlock()
 	if t.conn == nil {
-		t.conn = make(map[*app.LogListener]struct{})
+		t.conn = make(map[streamListener]struct{})
 	}
 	delete(t.conn, l)
 }
 
 func (t *logStreamTracker) String() string {
-	return "log pub/sub connections"
+	return "log and unit event pub/sub connections"
 }
 
 func (t *logStreamTracker) Shutdown() {
@@ -12,6 +12,7 @@ import (
 
 	"gopkg.in/mgo.v2/bson"
 
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
 	terrors "github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/provision"
@@ -163,3 +164,66 @@ func poolUpdateHandler(w http.ResponseWriter, r *http.Request, t auth.Token) err
 	}
 	return nil
 }
+
+func poolUsageHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	poolName := r.URL.Query().Get(":name")
+	rec.Log(u.Email, "pool-usage", "pool="+poolName)
+	usages, err := app.PoolUsages(poolName)
+	if err == provision.ErrPoolNotFound {
+		return &terrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+	if !u.IsAdmin() {
+		teams, err := u.Teams()
+		if err != nil {
+			return err
+		}
+		userTeams := make(map[string]bool, len(teams))
+		for _, team := range teams {
+			userTeams[team.Name] = true
+		}
+		var filtered []app.PoolUsage
+		for _, usage := range usages {
+			if userTeams[usage.Team] {
+				filtered = append(filtered, usage)
+			}
+		}
+		usages = filtered
+	}
+	if len(usages) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(usages)
+}
+
+func setPoolQuotaHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var quota provision.PoolQuota
+	err := json.NewDecoder(r.Body).Decode(&quota)
+	if err != nil {
+		return &terrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	err = provision.SetPoolQuota(r.URL.Query().Get(":name"), quota)
+	if err == provision.ErrPoolNotFound {
+		return &terrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		return &terrors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	return nil
+}
+
+func removePoolQuotaHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	err := provision.RemovePoolQuota(r.URL.Query().Get(":name"), r.URL.Query().Get(":team"))
+	if err == provision.ErrPoolNotFound {
+		return &terrors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
@@ -10,9 +10,11 @@ import (
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/errors"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/quota"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -247,3 +249,110 @@ func (s *S) TestPoolUpdateNotOverwriteDefaultPoolHandler(c *check.C) {
 	c.Assert(e.Code, check.Equals, http.StatusPreconditionFailed)
 	c.Assert(e.Message, check.Equals, "Default pool already exists.")
 }
+
+func (s *S) TestPoolUsageHandler(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "pool1"})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("pool1")
+	err = provision.SetPoolQuota("pool1", provision.PoolQuota{Team: s.team.Name, Memory: 2048, Units: 4})
+	c.Assert(err, check.IsNil)
+	apps := []app.App{
+		{Name: "app1", TeamOwner: s.team.Name, Pool: "pool1", Plan: app.Plan{Memory: 512, CpuShare: 100}, Quota: quota.Quota{Limit: -1, InUse: 2}},
+		{Name: "app2", TeamOwner: "otherteam", Pool: "pool1", Plan: app.Plan{Memory: 512}, Quota: quota.Quota{Limit: -1, InUse: 1}},
+	}
+	for _, a := range apps {
+		err = s.conn.Apps().Insert(a)
+		c.Assert(err, check.IsNil)
+		defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	}
+	req, err := http.NewRequest("GET", "/pool/pool1/usage?:name=pool1", nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	err = poolUsageHandler(rec, req, s.admintoken)
+	c.Assert(err, check.IsNil)
+	c.Assert(rec.Header().Get("Content-Type"), check.Equals, "application/json")
+	var usages []app.PoolUsage
+	err = json.NewDecoder(rec.Body).Decode(&usages)
+	c.Assert(err, check.IsNil)
+	c.Assert(usages, check.DeepEquals, []app.PoolUsage{
+		{Pool: "pool1", Team: "otherteam", Memory: 512, Units: 1},
+		{
+			Pool:     "pool1",
+			Team:     s.team.Name,
+			Memory:   1024,
+			CpuShare: 200,
+			Units:    2,
+			Quota:    &provision.PoolQuota{Team: s.team.Name, Memory: 2048, Units: 4},
+		},
+	})
+	req, err = http.NewRequest("GET", "/pool/pool1/usage?:name=pool1", nil)
+	c.Assert(err, check.IsNil)
+	rec = httptest.NewRecorder()
+	err = poolUsageHandler(rec, req, s.token)
+	c.Assert(err, check.IsNil)
+	usages = nil
+	err = json.NewDecoder(rec.Body).Decode(&usages)
+	c.Assert(err, check.IsNil)
+	c.Assert(usages, check.HasLen, 1)
+	c.Assert(usages[0].Team, check.Equals, s.team.Name)
+}
+
+func (s *S) TestPoolUsageHandlerPoolNotFound(c *check.C) {
+	req, err := http.NewRequest("GET", "/pool/unknown/usage?:name=unknown", nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	err = poolUsageHandler(rec, req, s.admintoken)
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestSetPoolQuotaHandler(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "pool1"})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("pool1")
+	b := bytes.NewBufferString(`{"team": "tsuruteam", "memory": 1024, "cpushare": 300, "units": 3}`)
+	req, err := http.NewRequest("POST", "/pool/pool1/quota?:name=pool1", b)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	err = setPoolQuotaHandler(rec, req, nil)
+	c.Assert(err, check.IsNil)
+	pool, err := provision.GetPool("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(pool.Quotas, check.DeepEquals, []provision.PoolQuota{
+		{Team: "tsuruteam", Memory: 1024, CpuShare: 300, Units: 3},
+	})
+}
+
+func (s *S) TestSetPoolQuotaHandlerInvalid(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "pool1"})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("pool1")
+	b := bytes.NewBufferString(`{"team": "tsuruteam", "units": -1}`)
+	req, err := http.NewRequest("POST", "/pool/pool1/quota?:name=pool1", b)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	err = setPoolQuotaHandler(rec, req, nil)
+	c.Assert(err, check.NotNil)
+	e, ok := err.(*errors.HTTP)
+	c.Assert(ok, check.Equals, true)
+	c.Assert(e.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(e.Message, check.Equals, "Quota limits can't be negative.")
+}
+
+func (s *S) TestRemovePoolQuotaHandler(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "pool1"})
+	c.Assert(err, check.IsNil)
+	defer provision.RemovePool("pool1")
+	err = provision.SetPoolQuota("pool1", provision.PoolQuota{Team: "tsuruteam", Units: 3})
+	c.Assert(err, check.IsNil)
+	req, err := http.NewRequest("DELETE", "/pool/pool1/quota/tsuruteam?:name=pool1&:team=tsuruteam", nil)
+	c.Assert(err, check.IsNil)
+	rec := httptest.NewRecorder()
+	err = removePoolQuotaHandler(rec, req, nil)
+	c.Assert(err, check.IsNil)
+	pool, err := provision.GetPool("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(pool.Quotas, check.HasLen, 0)
+}
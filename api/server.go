@@ -125,6 +125,7 @@ func RunServer(dry bool) http.Handler {
 	runHandler := authorizationRequiredHandler(runCommand)
 	m.Add("Post", "/apps/{app}/run", runHandler)
 	m.Add("Post", "/apps/{app}/restart", authorizationRequiredHandler(restart))
+	m.Add("Post", "/apps/{app}/rolling", authorizationRequiredHandler(setAppRolling))
 	m.Add("Post", "/apps/{app}/start", authorizationRequiredHandler(start))
 	m.Add("Post", "/apps/{app}/stop", authorizationRequiredHandler(stop))
 	m.Add("Get", "/apps/{appname}/quota", AdminRequiredHandler(getAppQuota))
@@ -139,6 +140,17 @@ func RunServer(dry bool) http.Handler {
 	m.Add("Delete", "/apps/{app}/lock", forceDeleteLockHandler)
 	m.Add("Put", "/apps/{app}/units", authorizationRequiredHandler(addUnits))
 	m.Add("Delete", "/apps/{app}/units", authorizationRequiredHandler(removeUnits))
+	m.Add("Get", "/apps/{app}/units/metrics", authorizationRequiredHandler(appUnitsMetrics))
+	m.Add("Get", "/apps/{app}/jobs", authorizationRequiredHandler(listJobs))
+	m.Add("Post", "/apps/{app}/jobs", authorizationRequiredHandler(addJob))
+	m.Add("Delete", "/apps/{app}/jobs/{job}", authorizationRequiredHandler(removeJob))
+	m.Add("Get", "/apps/{app}/jobs/{job}/runs", authorizationRequiredHandler(listJobRuns))
+	m.Add("Get", "/apps/{app}/idle", authorizationRequiredHandler(getIdlePolicy))
+	m.Add("Post", "/apps/{app}/idle", authorizationRequiredHandler(setIdlePolicy))
+	m.Add("Get", "/apps/{app}/unit-events", authorizationRequiredHandler(unitEvents))
+	m.Add("Get", "/apps/{app}/webhooks", authorizationRequiredHandler(listWebhooks))
+	m.Add("Post", "/apps/{app}/webhooks", authorizationRequiredHandler(addWebhook))
+	m.Add("Delete", "/apps/{app}/webhooks", authorizationRequiredHandler(removeWebhook))
 	registerUnitHandler := authorizationRequiredHandler(registerUnit)
 	m.Add("Post", "/apps/{app}/units/register", registerUnitHandler)
 	setUnitStatusHandler := authorizationRequiredHandler(setUnitStatus)
@@ -149,6 +161,9 @@ func RunServer(dry bool) http.Handler {
 	logPostHandler := authorizationRequiredHandler(addLog)
 	m.Add("Post", "/apps/{app}/log", logPostHandler)
 	m.Add("Post", "/apps/{appname}/deploy/rollback", authorizationRequiredHandler(deployRollback))
+	m.Add("Post", "/apps/{appname}/deploy/canary", authorizationRequiredHandler(deployCanary))
+	m.Add("Post", "/apps/{appname}/deploy/canary/promote", authorizationRequiredHandler(deployCanaryPromote))
+	m.Add("Post", "/apps/{appname}/deploy/canary/abort", authorizationRequiredHandler(deployCanaryAbort))
 	m.Add("Post", "/apps/{app}/pool", authorizationRequiredHandler(appChangePool))
 	m.Add("Get", "/apps/{app}/metric/envs", authorizationRequiredHandler(appMetricEnvs))
 	m.Add("Post", "/apps/{app}/routes", AdminRequiredHandler(appRebuildRoutes))
@@ -227,6 +242,9 @@ func RunServer(dry bool) http.Handler {
 	m.Add("Post", "/pool/{name}", AdminRequiredHandler(poolUpdateHandler))
 	m.Add("Post", "/pool/{name}/team", AdminRequiredHandler(addTeamToPoolHandler))
 	m.Add("Delete", "/pool/{name}/team", AdminRequiredHandler(removeTeamToPoolHandler))
+	m.Add("Post", "/pool/{name}/quota", AdminRequiredHandler(setPoolQuotaHandler))
+	m.Add("Delete", "/pool/{name}/quota/{team}", AdminRequiredHandler(removePoolQuotaHandler))
+	m.Add("Get", "/pool/{name}/usage", authorizationRequiredHandler(poolUsageHandler))
 
 	m.Add("Post", "/role", AdminRequiredHandler(addRole))
 	m.Add("Delete", "/role", AdminRequiredHandler(removeRole))
@@ -340,6 +358,9 @@ func RunServer(dry bool) http.Handler {
 		idleTracker := newIdleTracker()
 		shutdown.Register(idleTracker)
 		shutdown.Register(&logTracker)
+		jobScheduler := app.NewJobScheduler()
+		shutdown.Register(jobScheduler)
+		go jobScheduler.Run()
 		readTimeout, _ := config.GetInt("server:read-timeout")
 		writeTimeout, _ := config.GetInt("server:write-timeout")
 		srv := &graceful.Server{
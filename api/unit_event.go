@@ -0,0 +1,140 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/auth"
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/rec"
+)
+
+type webhookData struct {
+	URL string `json:"url"`
+}
+
+func unitEvents(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "unit-events", "app="+appName)
+	a, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	l, err := app.NewUnitEventListener(&a)
+	if err != nil {
+		return err
+	}
+	logTracker.add(l)
+	defer func() {
+		logTracker.remove(l)
+		l.Close()
+	}()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	encoder := json.NewEncoder(w)
+	for evt := range l.C {
+		err := encoder.Encode(evt)
+		if err != nil {
+			break
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return nil
+}
+
+func listWebhooks(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	a, err := getApp(r.URL.Query().Get(":app"), u, r)
+	if err != nil {
+		return err
+	}
+	hooks := a.Webhooks
+	if hooks == nil {
+		hooks = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(hooks)
+}
+
+func decodeWebhook(r *http.Request) (string, error) {
+	var data webhookData
+	if r.Body == nil {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the webhook url."}
+	}
+	defer r.Body.Close()
+	err := json.NewDecoder(r.Body).Decode(&data)
+	if err != nil {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: "Invalid JSON in request body."}
+	}
+	if data.URL == "" {
+		return "", &errors.HTTP{Code: http.StatusBadRequest, Message: "You must provide the webhook url."}
+	}
+	return data.URL, nil
+}
+
+func addWebhook(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	hook, err := decodeWebhook(r)
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "add-webhook", "app="+appName, "url="+hook)
+	a, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	err = a.AddWebhook(hook)
+	switch err {
+	case nil:
+		w.WriteHeader(http.StatusCreated)
+		return nil
+	case app.ErrInvalidWebhook, app.ErrWebhookHostNotAllowed:
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	case app.ErrWebhookAlreadyExists:
+		return &errors.HTTP{Code: http.StatusConflict, Message: err.Error()}
+	}
+	return err
+}
+
+func removeWebhook(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	hook, err := decodeWebhook(r)
+	if err != nil {
+		return err
+	}
+	u, err := t.User()
+	if err != nil {
+		return err
+	}
+	appName := r.URL.Query().Get(":app")
+	rec.Log(u.Email, "remove-webhook", "app="+appName, "url="+hook)
+	a, err := getApp(appName, u, r)
+	if err != nil {
+		return err
+	}
+	err = a.RemoveWebhook(hook)
+	if err == app.ErrWebhookNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,163 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/rec/rectest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestUnitEvents(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	server := httptest.NewServer(RunServer(true))
+	defer server.Close()
+	request, err := http.NewRequest("GET", server.URL+"/apps/myappx/unit-events", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	resp, err := http.DefaultClient.Do(request)
+	c.Assert(err, check.IsNil)
+	defer resp.Body.Close()
+	defer logTracker.Shutdown()
+	c.Assert(resp.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("Content-Type"), check.Equals, "application/json")
+	app.PublishUnitEvent(app.UnitEvent{App: "myappx", Unit: "u1", Kind: app.UnitEventCrashed})
+	var evt app.UnitEvent
+	err = json.NewDecoder(resp.Body).Decode(&evt)
+	c.Assert(err, check.IsNil)
+	c.Assert(evt.App, check.Equals, "myappx")
+	c.Assert(evt.Unit, check.Equals, "u1")
+	c.Assert(evt.Kind, check.Equals, app.UnitEventCrashed)
+	action := rectest.Action{
+		Action: "unit-events",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=myappx"},
+	}
+	c.Assert(action, rectest.IsRecorded)
+}
+
+func (s *S) TestUnitEventsAppNotFound(c *check.C) {
+	request, err := http.NewRequest("GET", "/apps/unknown/unit-events", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *S) TestListWebhooks(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", Teams: []string{s.team.Name}, Webhooks: []string{"http://hooks.example.com"}}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	request, err := http.NewRequest("GET", "/apps/myappx/webhooks", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	var hooks []string
+	err = json.NewDecoder(recorder.Body).Decode(&hooks)
+	c.Assert(err, check.IsNil)
+	c.Assert(hooks, check.DeepEquals, []string{"http://hooks.example.com"})
+}
+
+func (s *S) TestAddWebhook(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"url": "http://hooks.example.com"}`)
+	request, err := http.NewRequest("POST", "/apps/myappx/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusCreated)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Webhooks, check.DeepEquals, []string{"http://hooks.example.com"})
+	action := rectest.Action{
+		Action: "add-webhook",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=myappx", "url=http://hooks.example.com"},
+	}
+	c.Assert(action, rectest.IsRecorded)
+}
+
+func (s *S) TestAddWebhookInvalid(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	bodies := []string{`{"url": ""}`, `{"url": "hooks.example.com"}`, `{"url": "http://127.0.0.1/hooks"}`, `not json`}
+	for _, b := range bodies {
+		request, err := http.NewRequest("POST", "/apps/myappx/webhooks", strings.NewReader(b))
+		c.Assert(err, check.IsNil)
+		request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+		recorder := httptest.NewRecorder()
+		m := RunServer(true)
+		m.ServeHTTP(recorder, request)
+		c.Check(recorder.Code, check.Equals, http.StatusBadRequest, check.Commentf(b))
+	}
+}
+
+func (s *S) TestAddWebhookAlreadyExists(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", Teams: []string{s.team.Name}, Webhooks: []string{"http://hooks.example.com"}}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"url": "http://hooks.example.com"}`)
+	request, err := http.NewRequest("POST", "/apps/myappx/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusConflict)
+}
+
+func (s *S) TestRemoveWebhook(c *check.C) {
+	a := app.App{Name: "myappx", Platform: "zend", Teams: []string{s.team.Name}, Webhooks: []string{"http://hooks.example.com"}}
+	err := s.conn.Apps().Insert(&a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"url": "http://hooks.example.com"}`)
+	request, err := http.NewRequest("DELETE", "/apps/myappx/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Webhooks, check.HasLen, 0)
+	action := rectest.Action{
+		Action: "remove-webhook",
+		User:   s.user.Email,
+		Extra:  []interface{}{"app=myappx", "url=http://hooks.example.com"},
+	}
+	c.Assert(action, rectest.IsRecorded)
+}
+
+func (s *S) TestRemoveWebhookNotFound(c *check.C) {
+	a := s.createJobsApp(c)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	body := strings.NewReader(`{"url": "http://hooks.example.com"}`)
+	request, err := http.NewRequest("DELETE", "/apps/myappx/webhooks", body)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	recorder := httptest.NewRecorder()
+	m := RunServer(true)
+	m.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
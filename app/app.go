@@ -37,10 +37,12 @@ var (
 	nameRegexp  = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
 	cnameRegexp = regexp.MustCompile(`^(\*\.)?[a-zA-Z0-9][\w-.]+$`)
 
-	ErrAlreadyHaveAccess = stderr.New("team already have access to this app")
-	ErrNoAccess          = stderr.New("team does not have access to this app")
-	ErrCannotOrphanApp   = stderr.New("cannot revoke access from this team, as it's the unique team with access to the app")
-	ErrDisabledPlatform  = stderr.New("Disabled Platform, only admin users can create applications with the platform")
+	ErrAlreadyHaveAccess     = stderr.New("team already have access to this app")
+	ErrNoAccess              = stderr.New("team does not have access to this app")
+	ErrCannotOrphanApp       = stderr.New("cannot revoke access from this team, as it's the unique team with access to the app")
+	ErrDisabledPlatform      = stderr.New("Disabled Platform, only admin users can create applications with the platform")
+	ErrMetricsNotSupported   = stderr.New("provisioner does not support unit metrics")
+	ErrRunFilterNotSupported = stderr.New("provisioner does not support running commands in specific units")
 )
 
 const (
@@ -89,6 +91,9 @@ type App struct {
 	Lock           AppLock
 	Plan           Plan
 	Pool           string
+	Rolling        *provision.RollingOptions `bson:",omitempty"`
+	Webhooks       []string                  `bson:",omitempty"`
+	Idle           IdlePolicy
 
 	quota.Quota
 }
@@ -271,21 +276,32 @@ func CreateApp(app *App, user *auth.User) error {
 // ChangePlan changes the plan of the application.
 //
 // It may change the state of the application if the new plan includes a new
-// router or a change in the amount of available memory.
+// router or a change in the amount of available memory. The new plan must fit
+// in the quota of the team owner in the pool of the application.
 func (app *App) ChangePlan(planName string, w io.Writer) error {
 	plan, err := findPlanByName(planName)
 	if err != nil {
 		return err
 	}
-	var oldPlan Plan
-	oldPlan, app.Plan = app.Plan, *plan
-	actions := []*action.Action{
-		&moveRouterUnits,
-		&saveApp,
-		&restartApp,
-		&removeOldBackend,
-	}
-	return action.NewPipeline(actions...).Execute(app, &oldPlan, w)
+	return withPoolQuotaLock(app.Pool, app.TeamOwner, func() error {
+		units, err := appUnitCount(app)
+		if err != nil {
+			return err
+		}
+		err = checkPoolQuota(app, app.Pool, units, *plan)
+		if err != nil {
+			return err
+		}
+		var oldPlan Plan
+		oldPlan, app.Plan = app.Plan, *plan
+		actions := []*action.Action{
+			&moveRouterUnits,
+			&saveApp,
+			&restartApp,
+			&removeOldBackend,
+		}
+		return action.NewPipeline(actions...).Execute(app, &oldPlan, w)
+	})
 }
 
 // unbind takes all service instances that are bound to the app, and unbind
@@ -387,6 +403,10 @@ func Delete(app *App, w io.Writer) error {
 	if err != nil {
 		logErr("Unable to mark old deploys as removed", err)
 	}
+	err = removeAppJobs(appName)
+	if err != nil {
+		logErr("Unable to remove app jobs", err)
+	}
 	return nil
 }
 
@@ -738,19 +758,29 @@ func (app *App) ChangePool(newPoolName string) error {
 	if poolName == "" {
 		return stderr.New("This pool doesn't exists.")
 	}
-	conn, err := db.Conn()
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	err = conn.Apps().Update(
-		bson.M{"name": app.Name},
-		bson.M{"$set": bson.M{"pool": poolName}},
-	)
-	if err != nil {
-		return err
-	}
-	return nil
+	return withPoolQuotaLock(poolName, app.TeamOwner, func() error {
+		units, err := appUnitCount(app)
+		if err != nil {
+			return err
+		}
+		err = checkPoolQuota(app, poolName, units, app.Plan)
+		if err != nil {
+			return err
+		}
+		conn, err := db.Conn()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		err = conn.Apps().Update(
+			bson.M{"name": app.Name},
+			bson.M{"$set": bson.M{"pool": poolName}},
+		)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
 }
 
 // setEnv sets the given environment variable in the app.
@@ -803,7 +833,7 @@ func (app *App) InstanceEnv(name string) map[string]bind.EnvVar {
 
 // Run executes the command in app units, sourcing apprc before running the
 // command.
-func (app *App) Run(cmd string, w io.Writer, once bool, interactive bool) error {
+func (app *App) Run(cmd string, w io.Writer, args provision.RunArgs) error {
 	if !app.Available() {
 		return stderr.New("App must be available to run commands")
 	}
@@ -811,23 +841,31 @@ func (app *App) Run(cmd string, w io.Writer, once bool, interactive bool) error
 	logWriter := LogWriter{App: app, Source: "app-run"}
 	logWriter.Async()
 	defer logWriter.Close()
-	return app.sourced(cmd, io.MultiWriter(w, &logWriter), once, interactive)
+	return app.sourced(cmd, io.MultiWriter(w, &logWriter), args)
 }
 
-func (app *App) sourced(cmd string, w io.Writer, once bool, interactive bool) error {
+func (app *App) sourced(cmd string, w io.Writer, args provision.RunArgs) error {
 	source := "[ -f /home/application/apprc ] && source /home/application/apprc"
 	cd := fmt.Sprintf("[ -d %s ] && cd %s", defaultAppDir, defaultAppDir)
 	cmd = fmt.Sprintf("%s; %s; %s", source, cd, cmd)
-	return app.run(cmd, w, once, interactive)
+	return app.run(cmd, w, args)
 }
 
-func (app *App) run(cmd string, w io.Writer, once bool, interactive bool) error {
-	if once {
-		if interactive {
-			return Provisioner.ExecuteCommandOnce(w, w, app, cmd, "-i", "-t")
-		} else {
-			return Provisioner.ExecuteCommandOnce(w, w, app, cmd)
+func (app *App) run(cmd string, w io.Writer, args provision.RunArgs) error {
+	var cmdArgs []string
+	if args.Interactive {
+		cmdArgs = []string{"-i", "-t"}
+	}
+	if len(args.Units) > 0 || args.Process != "" {
+		executor, ok := Provisioner.(provision.UnitCommandExecutor)
+		if !ok {
+			return ErrRunFilterNotSupported
 		}
+		filter := provision.RunFilter{Units: args.Units, Process: args.Process, Once: args.Once}
+		return executor.ExecuteCommandInUnits(w, w, app, filter, cmd, cmdArgs...)
+	}
+	if args.Once {
+		return Provisioner.ExecuteCommandOnce(w, w, app, cmd, cmdArgs...)
 	}
 	return Provisioner.ExecuteCommand(w, w, app, cmd)
 }
@@ -893,6 +931,35 @@ func (app *App) GetTeamOwner() string {
 	return app.TeamOwner
 }
 
+// GetRollingOptions returns the options used when replacing the units of the
+// app.
+func (app *App) GetRollingOptions() *provision.RollingOptions {
+	return app.Rolling
+}
+
+// SetRollingOptions saves the default options used when replacing the units of
+// the app. A nil value disables rolling replacements.
+func (app *App) SetRollingOptions(opts *provision.RollingOptions) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var update bson.M
+	if opts.Enabled() {
+		update = bson.M{"$set": bson.M{"rolling": opts}}
+	} else {
+		opts = nil
+		update = bson.M{"$unset": bson.M{"rolling": ""}}
+	}
+	err = conn.Apps().Update(bson.M{"name": app.Name}, update)
+	if err != nil {
+		return err
+	}
+	app.Rolling = opts
+	return nil
+}
+
 // GetTeamsNames returns the names of teams app.
 func (app *App) GetTeamsName() []string {
 	return app.Teams
@@ -935,16 +1002,22 @@ func (app *App) SetQuotaInUse(inUse int) error {
 			Available: uint(app.Quota.Limit),
 		}
 	}
-	conn, err := db.Conn()
-	if err != nil {
+	return withPoolQuotaLock(app.Pool, app.TeamOwner, func() error {
+		err := checkPoolQuota(app, app.Pool, inUse, app.Plan)
+		if err != nil {
+			return err
+		}
+		conn, err := db.Conn()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"quota.inuse": inUse}})
+		if err == mgo.ErrNotFound {
+			return ErrAppNotFound
+		}
 		return err
-	}
-	defer conn.Close()
-	err = conn.Apps().Update(bson.M{"name": app.Name}, bson.M{"$set": bson.M{"quota.inuse": inUse}})
-	if err == mgo.ErrNotFound {
-		return ErrAppNotFound
-	}
-	return err
+	})
 }
 
 // GetPlatform returns the platform of the app.
@@ -1456,6 +1529,15 @@ func (app *App) MetricEnvs() map[string]string {
 	return Provisioner.MetricEnvs(app)
 }
 
+// UnitsMetrics returns the live resource usage of each unit of the app.
+func (app *App) UnitsMetrics() ([]provision.UnitMetrics, error) {
+	metricsProv, ok := Provisioner.(provision.MetricsProvisioner)
+	if !ok {
+		return nil, ErrMetricsNotSupported
+	}
+	return metricsProv.UnitsMetrics(app)
+}
+
 func (app *App) Shell(opts provision.ShellOptions) error {
 	opts.App = app
 	return Provisioner.Shell(opts)
@@ -2160,7 +2160,7 @@ func (s *S) TestRun(c *check.C) {
 	defer s.provisioner.Destroy(&app)
 	s.provisioner.AddUnits(&app, 1, "web", nil)
 	var buf bytes.Buffer
-	err := app.Run("ls -lh", &buf, false, false)
+	err := app.Run("ls -lh", &buf, provision.RunArgs{})
 	c.Assert(err, check.IsNil)
 	c.Assert(buf.String(), check.Equals, "a lot of files")
 	expected := "[ -f /home/application/apprc ] && source /home/application/apprc;"
@@ -2198,7 +2198,7 @@ func (s *S) TestRunOnce(c *check.C) {
 	defer s.provisioner.Destroy(&app)
 	s.provisioner.AddUnits(&app, 1, "web", nil)
 	var buf bytes.Buffer
-	err := app.Run("ls -lh", &buf, true, false)
+	err := app.Run("ls -lh", &buf, provision.RunArgs{Once: true})
 	c.Assert(err, check.IsNil)
 	c.Assert(buf.String(), check.Equals, "a lot of files")
 	expected := "[ -f /home/application/apprc ] && source /home/application/apprc;"
@@ -2208,6 +2208,25 @@ func (s *S) TestRunOnce(c *check.C) {
 	c.Assert(cmds, check.HasLen, 1)
 }
 
+func (s *S) TestRunInUnits(c *check.C) {
+	s.provisioner.PrepareOutput([]byte("a lot of files"))
+	app := App{Name: "myapp"}
+	s.provisioner.Provision(&app)
+	defer s.provisioner.Destroy(&app)
+	s.provisioner.AddUnits(&app, 2, "web", nil)
+	s.provisioner.AddUnits(&app, 1, "worker", nil)
+	var buf bytes.Buffer
+	err := app.Run("ls -lh", &buf, provision.RunArgs{Units: []string{"myapp-1", "myapp-2"}, Process: "worker"})
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "[myapp-2] a lot of files")
+	expected := "[ -f /home/application/apprc ] && source /home/application/apprc;"
+	expected += " [ -d /home/application/current ] && cd /home/application/current;"
+	expected += " ls -lh"
+	cmds := s.provisioner.GetCmds(expected, &app)
+	c.Assert(cmds, check.HasLen, 1)
+	c.Assert(cmds[0].Units, check.DeepEquals, []string{"myapp-2"})
+}
+
 func (s *S) TestRunWithoutEnv(c *check.C) {
 	s.provisioner.PrepareOutput([]byte("a lot of files"))
 	app := App{
@@ -2217,7 +2236,7 @@ func (s *S) TestRunWithoutEnv(c *check.C) {
 	defer s.provisioner.Destroy(&app)
 	s.provisioner.AddUnits(&app, 1, "web", nil)
 	var buf bytes.Buffer
-	err := app.run("ls -lh", &buf, false)
+	err := app.run("ls -lh", &buf, provision.RunArgs{})
 	c.Assert(err, check.IsNil)
 	c.Assert(buf.String(), check.Equals, "a lot of files")
 	cmds := s.provisioner.GetCmds("ls -lh", &app)
@@ -3108,6 +3127,19 @@ func (s *S) TestAppMetricEnvs(c *check.C) {
 	c.Assert(envs, check.DeepEquals, expected)
 }
 
+func (s *S) TestAppUnitsMetrics(c *check.C) {
+	a := App{Name: "appName", Platform: "python"}
+	err := s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	metrics, err := a.UnitsMetrics()
+	c.Assert(err, check.IsNil)
+	units, err := s.provisioner.Units(&a)
+	c.Assert(err, check.IsNil)
+	c.Assert(metrics, check.DeepEquals, []provision.UnitMetrics{{ID: units[0].ID, ProcessName: "web"}})
+}
+
 func (s *S) TestChangePlan(c *check.C) {
 	plan := Plan{Name: "something", Router: "fake-hc", CpuShare: 100, Memory: 268435456}
 	err := s.conn.Plans().Insert(plan)
@@ -0,0 +1,60 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestCronScheduleNext(c *check.C) {
+	base := time.Date(2015, time.October, 14, 10, 32, 15, 0, time.UTC)
+	var tests = []struct {
+		spec     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2015, time.October, 14, 10, 33, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2015, time.October, 14, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2015, time.October, 14, 11, 0, 0, 0, time.UTC)},
+		{"30 2 * * *", time.Date(2015, time.October, 15, 2, 30, 0, 0, time.UTC)},
+		{"0 9-17/4 * * *", time.Date(2015, time.October, 14, 13, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2015, time.November, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2015, time.October, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 5", time.Date(2015, time.October, 16, 0, 0, 0, 0, time.UTC)},
+		{"5,10 12 25 12 *", time.Date(2015, time.December, 25, 12, 5, 0, 0, time.UTC)},
+		{"@daily", time.Date(2015, time.October, 15, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2015, time.October, 14, 11, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2016, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, t := range tests {
+		schedule, err := parseCronSchedule(t.spec)
+		c.Check(err, check.IsNil)
+		c.Check(schedule.next(base), check.DeepEquals, t.expected, check.Commentf(t.spec))
+	}
+}
+
+func (s *S) TestParseCronScheduleInvalid(c *check.C) {
+	var tests = []struct {
+		spec string
+		err  string
+	}{
+		{"* * * *", `invalid schedule "\* \* \* \*": expected 5 fields, got 4`},
+		{"60 * * * *", `invalid schedule .*: value out of range in minute field: "60"`},
+		{"* 24 * * *", `invalid schedule .*: value out of range in hour field: "24"`},
+		{"* * 0 * *", `invalid schedule .*: value out of range in day of month field: "0"`},
+		{"* * * 13 *", `invalid schedule .*: value out of range in month field: "13"`},
+		{"* * * * 7", `invalid schedule .*: value out of range in day of week field: "7"`},
+		{"*/0 * * * *", `invalid schedule .*: invalid step in minute field: "\*/0"`},
+		{"a * * * *", `invalid schedule .*: invalid value in minute field: "a"`},
+		{"10-5 * * * *", `invalid schedule .*: value out of range in minute field: "10-5"`},
+		{"@often", `invalid schedule "@often": expected 5 fields, got 1`},
+	}
+	for _, t := range tests {
+		_, err := parseCronSchedule(t.spec)
+		c.Check(err, check.ErrorMatches, t.err)
+	}
+}
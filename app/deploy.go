@@ -23,6 +23,11 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
+var (
+	ErrCanaryNotSupported    = errors.New("provisioner does not support canary deploys")
+	ErrGitDeployNotSupported = errors.New("provisioner does not support git deploys")
+)
+
 type DeployData struct {
 	ID          bson.ObjectId `bson:"_id,omitempty"`
 	App         string
@@ -216,6 +221,7 @@ type DeployOptions struct {
 	OutputStream io.Writer
 	User         string
 	Image        string
+	Canary       *provision.CanaryOptions
 }
 
 // Deploy runs a deployment of an application. It will first try to run an
@@ -248,6 +254,18 @@ func Deploy(opts DeployOptions) error {
 }
 
 func deployToProvisioner(opts *DeployOptions, writer io.Writer) (string, error) {
+	if opts.Canary != nil {
+		deployer, ok := Provisioner.(provision.CanaryDeployer)
+		if !ok {
+			return "", ErrCanaryNotSupported
+		}
+		canaryOpts := *opts.Canary
+		canaryOpts.Image = opts.Image
+		canaryOpts.Version = opts.Version
+		canaryOpts.ArchiveURL = opts.ArchiveURL
+		canaryOpts.File = opts.File
+		return deployer.CanaryDeploy(opts.App, canaryOpts, writer)
+	}
 	if opts.Image != "" {
 		if deployer, ok := Provisioner.(provision.ImageDeployer); ok {
 			return deployer.ImageDeploy(opts.App, opts.Image, writer)
@@ -263,7 +281,11 @@ func deployToProvisioner(opts *DeployOptions, writer io.Writer) (string, error)
 			return deployer.ArchiveDeploy(opts.App, opts.ArchiveURL, writer)
 		}
 	}
-	return Provisioner.(provision.GitDeployer).GitDeploy(opts.App, opts.Version, writer)
+	deployer, ok := Provisioner.(provision.GitDeployer)
+	if !ok {
+		return "", ErrGitDeployNotSupported
+	}
+	return deployer.GitDeploy(opts.App, opts.Version, writer)
 }
 
 func saveDeployData(opts *DeployOptions, imageId, log string, duration time.Duration, deployError error) error {
@@ -281,7 +303,9 @@ func saveDeployData(opts *DeployOptions, imageId, log string, duration time.Dura
 		Log:       log,
 		User:      opts.User,
 	}
-	if opts.Commit != "" {
+	if opts.Canary != nil {
+		deploy.Origin = "canary"
+	} else if opts.Commit != "" {
 		deploy.Origin = "git"
 	} else if opts.Image != "" {
 		deploy.Origin = "rollback"
@@ -294,6 +318,34 @@ func saveDeployData(opts *DeployOptions, imageId, log string, duration time.Dura
 	return conn.Deploys().Insert(deploy)
 }
 
+// PromoteCanary replaces all the old units of the app with units running the
+// image of the canary deploy in progress.
+func PromoteCanary(app *App, w io.Writer) error {
+	deployer, ok := Provisioner.(provision.CanaryDeployer)
+	if !ok {
+		return ErrCanaryNotSupported
+	}
+	logWriter := LogWriter{App: app}
+	logWriter.Async()
+	defer logWriter.Close()
+	writer := io.MultiWriter(&tsuruIo.NoErrorWriter{Writer: w}, &logWriter)
+	return deployer.PromoteCanary(app, writer)
+}
+
+// AbortCanary removes the units of the canary deploy in progress, leaving the
+// old units of the app untouched.
+func AbortCanary(app *App, w io.Writer) error {
+	deployer, ok := Provisioner.(provision.CanaryDeployer)
+	if !ok {
+		return ErrCanaryNotSupported
+	}
+	logWriter := LogWriter{App: app}
+	logWriter.Async()
+	defer logWriter.Close()
+	writer := io.MultiWriter(&tsuruIo.NoErrorWriter{Writer: w}, &logWriter)
+	return deployer.AbortCanary(app, writer)
+}
+
 func incrementDeploy(app *App) error {
 	conn, err := db.Conn()
 	if err != nil {
@@ -13,6 +13,7 @@ import (
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/auth"
 	"github.com/tsuru/tsuru/auth/native"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/provisiontest"
 	"github.com/tsuru/tsuru/repository"
 	"github.com/tsuru/tsuru/repository/repositorytest"
@@ -816,6 +817,68 @@ func (s *S) TestDeployToProvisionerImage(c *check.C) {
 	c.Assert(logs, check.Equals, "Image deploy called")
 }
 
+func (s *S) TestDeployToProvisionerCanary(c *check.C) {
+	a := App{
+		Name:     "someApp",
+		Platform: "django",
+		Teams:    []string{s.team.Name},
+	}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	writer := &bytes.Buffer{}
+	opts := DeployOptions{
+		App:     &a,
+		Version: "version",
+		Canary:  &provision.CanaryOptions{Units: 2, Weight: 20},
+	}
+	imageId, err := deployToProvisioner(&opts, writer)
+	c.Assert(err, check.IsNil)
+	c.Assert(imageId, check.Equals, "app-image")
+	c.Assert(writer.String(), check.Equals, "Canary deploy called")
+	canary := s.provisioner.Canary(&a)
+	c.Assert(canary, check.NotNil)
+	c.Assert(canary.Version, check.Equals, "version")
+	c.Assert(canary.Units, check.Equals, 2)
+	c.Assert(canary.Weight, check.Equals, 20)
+}
+
+func (s *S) TestPromoteCanary(c *check.C) {
+	a := App{Name: "someApp", Platform: "django", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = PromoteCanary(&a, ioutil.Discard)
+	c.Assert(err, check.Equals, provision.ErrCanaryNotFound)
+	_, err = s.provisioner.CanaryDeploy(&a, provision.CanaryOptions{Image: "my-image-x"}, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	writer := &bytes.Buffer{}
+	err = PromoteCanary(&a, writer)
+	c.Assert(err, check.IsNil)
+	c.Assert(writer.String(), check.Equals, "Promote canary called")
+	c.Assert(s.provisioner.Canary(&a), check.IsNil)
+}
+
+func (s *S) TestAbortCanary(c *check.C) {
+	a := App{Name: "someApp", Platform: "django", Teams: []string{s.team.Name}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	defer s.conn.Apps().Remove(bson.M{"name": a.Name})
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	_, err = s.provisioner.CanaryDeploy(&a, provision.CanaryOptions{Image: "my-image-x"}, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	writer := &bytes.Buffer{}
+	err = AbortCanary(&a, writer)
+	c.Assert(err, check.IsNil)
+	c.Assert(writer.String(), check.Equals, "Abort canary called")
+	c.Assert(s.provisioner.Canary(&a), check.IsNil)
+}
+
 func (s *S) TestMarkDeploysAsRemoved(c *check.C) {
 	s.createAdminUserAndTeam(c)
 	a := App{Name: "someApp"}
@@ -0,0 +1,112 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	stderr "errors"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	ErrInvalidIdleWindow = stderr.New("invalid idle window, it must be a positive number of minutes or zero to disable the policy")
+	ErrIdleNotSupported  = stderr.New("the router of the app does not report traffic, idle policies are not supported")
+)
+
+// IdlePolicy controls whether the provisioner may stop all units of an app
+// that isn't receiving requests, starting them again on the next request.
+type IdlePolicy struct {
+	// Window is the number of minutes without requests before the app is
+	// put to sleep. Zero disables the policy.
+	Window int
+
+	// Sleeping indicates whether the units of the app are currently
+	// stopped by the idle policy.
+	Sleeping bool
+
+	// Since is the last time the policy was changed or the app went to
+	// sleep or woke up. Apps are never considered idle before Since plus
+	// Window.
+	Since time.Time
+}
+
+// SetIdlePolicy changes the idle window of the app, in minutes. A sleeping
+// app keeps sleeping until its next request even if the policy is disabled.
+// The policy can only be enabled in apps whose router reports traffic.
+func (app *App) SetIdlePolicy(window int) error {
+	if window < 0 {
+		return ErrInvalidIdleWindow
+	}
+	if window > 0 {
+		routerName, err := app.GetRouter()
+		if err != nil {
+			return err
+		}
+		r, err := router.Get(routerName)
+		if err != nil {
+			return err
+		}
+		if _, ok := r.(router.TrafficReporter); !ok {
+			return ErrIdleNotSupported
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	now := time.Now().UTC()
+	err = conn.Apps().Update(
+		bson.M{"name": app.Name},
+		bson.M{"$set": bson.M{"idle.window": window, "idle.since": now}},
+	)
+	if err != nil {
+		return err
+	}
+	app.Idle.Window = window
+	app.Idle.Since = now
+	return nil
+}
+
+// SetSleeping marks the app as sleeping or awake.
+func SetSleeping(appName string, sleeping bool) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Apps().Update(
+		bson.M{"name": appName},
+		bson.M{"$set": bson.M{"idle.sleeping": sleeping, "idle.since": time.Now().UTC()}},
+	)
+}
+
+// IdleCandidates returns the apps with an idle policy that are currently
+// awake.
+func IdleCandidates() ([]App, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"idle.window": bson.M{"$gt": 0}, "idle.sleeping": bson.M{"$ne": true}}).All(&apps)
+	return apps, err
+}
+
+// SleepingApps returns all apps put to sleep by their idle policies.
+func SleepingApps() ([]App, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(bson.M{"idle.sleeping": true}).All(&apps)
+	return apps, err
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/check.v1"
+)
+
+// opaqueRouter is a router unable to report traffic.
+type opaqueRouter struct {
+	router.Router
+}
+
+func init() {
+	router.Register("opaque", func(string) (router.Router, error) {
+		return opaqueRouter{}, nil
+	})
+}
+
+func (s *S) TestSetIdlePolicy(c *check.C) {
+	a := App{Name: "sleepy", Plan: Plan{Router: "fake"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	before := time.Now().UTC().Add(-time.Second)
+	err = a.SetIdlePolicy(30)
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Idle.Window, check.Equals, 30)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Window, check.Equals, 30)
+	c.Assert(dbApp.Idle.Sleeping, check.Equals, false)
+	c.Assert(dbApp.Idle.Since.After(before), check.Equals, true)
+}
+
+func (s *S) TestSetIdlePolicyInvalidWindow(c *check.C) {
+	a := App{Name: "sleepy"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.SetIdlePolicy(-1)
+	c.Assert(err, check.Equals, ErrInvalidIdleWindow)
+}
+
+func (s *S) TestSetIdlePolicyRouterWithoutTraffic(c *check.C) {
+	config.Set("routers:opaque:type", "opaque")
+	defer config.Unset("routers:opaque")
+	a := App{Name: "sleepy", Plan: Plan{Router: "opaque"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.SetIdlePolicy(30)
+	c.Assert(err, check.Equals, ErrIdleNotSupported)
+	err = a.SetIdlePolicy(0)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestSetSleeping(c *check.C) {
+	a := App{Name: "sleepy", Idle: IdlePolicy{Window: 10}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = SetSleeping(a.Name, true)
+	c.Assert(err, check.IsNil)
+	dbApp, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Sleeping, check.Equals, true)
+	c.Assert(dbApp.Idle.Window, check.Equals, 10)
+	err = SetSleeping(a.Name, false)
+	c.Assert(err, check.IsNil)
+	dbApp, err = GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Sleeping, check.Equals, false)
+}
+
+func (s *S) TestIdleCandidatesAndSleepingApps(c *check.C) {
+	apps := []App{
+		{Name: "noidle"},
+		{Name: "awake", Idle: IdlePolicy{Window: 10}},
+		{Name: "sleeping", Idle: IdlePolicy{Window: 10, Sleeping: true}},
+	}
+	for _, a := range apps {
+		err := s.conn.Apps().Insert(a)
+		c.Assert(err, check.IsNil)
+	}
+	candidates, err := IdleCandidates()
+	c.Assert(err, check.IsNil)
+	c.Assert(candidates, check.HasLen, 1)
+	c.Assert(candidates[0].Name, check.Equals, "awake")
+	sleeping, err := SleepingApps()
+	c.Assert(err, check.IsNil)
+	c.Assert(sleeping, check.HasLen, 1)
+	c.Assert(sleeping[0].Name, check.Equals, "sleeping")
+}
@@ -0,0 +1,376 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	maxJobOutputSize = 64 * 1024
+	jobRunsLimit     = 100
+)
+
+var (
+	ErrJobNotFound      = errors.New("job not found")
+	ErrJobAlreadyExists = errors.New("there is already a job with this name")
+	ErrInvalidJobName   = errors.New("Invalid job name, job name should have at most 63 " +
+		"characters, containing only lower case letters, numbers or dashes, " +
+		"starting with a letter.")
+	ErrJobWithoutCommand = errors.New("job command is required")
+
+	jobSchedulerInterval = 30 * time.Second
+)
+
+// Job is a command executed periodically in one unit of an app, following a
+// cron-like schedule. Jobs are either declared in the tsuru.yaml file of the
+// app, being replaced on each deploy, or added through the API.
+type Job struct {
+	Name     string    `json:"name"`
+	AppName  string    `json:"app"`
+	Schedule string    `json:"schedule"`
+	Command  string    `json:"command"`
+	FromYaml bool      `json:"fromYaml"`
+	NextRun  time.Time `json:"nextRun"`
+}
+
+// JobRun stores the result of one execution of a job.
+type JobRun struct {
+	ID         bson.ObjectId `bson:"_id" json:"id"`
+	AppName    string        `json:"app"`
+	JobName    string        `json:"job"`
+	StartTime  time.Time     `json:"startTime"`
+	EndTime    time.Time     `json:"endTime"`
+	ExitStatus int           `json:"exitStatus"`
+	Output     string        `json:"output"`
+	Error      string        `json:"error,omitempty"`
+}
+
+type jobValidationError struct{ msg string }
+
+func (e *jobValidationError) Error() string {
+	return e.msg
+}
+
+// IsJobValidationError returns whether the given error was caused by an
+// invalid job.
+func IsJobValidationError(err error) bool {
+	_, ok := err.(*jobValidationError)
+	return ok || err == ErrInvalidJobName || err == ErrJobWithoutCommand
+}
+
+func (job *Job) validate() error {
+	if !nameRegexp.MatchString(job.Name) {
+		return ErrInvalidJobName
+	}
+	if strings.TrimSpace(job.Command) == "" {
+		return ErrJobWithoutCommand
+	}
+	schedule, err := parseCronSchedule(job.Schedule)
+	if err != nil {
+		return &jobValidationError{msg: err.Error()}
+	}
+	job.NextRun = schedule.next(time.Now().UTC())
+	if job.NextRun.IsZero() {
+		return &jobValidationError{msg: fmt.Sprintf("invalid schedule %q: it never matches", job.Schedule)}
+	}
+	return nil
+}
+
+// AddJob adds a new job to the app.
+func (app *App) AddJob(job Job) error {
+	job.AppName = app.Name
+	job.FromYaml = false
+	err := job.validate()
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Jobs().Insert(job)
+	if mgo.IsDup(err) {
+		return ErrJobAlreadyExists
+	}
+	return err
+}
+
+// RemoveJob removes a job from the app, keeping its run history.
+func (app *App) RemoveJob(name string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Jobs().Remove(bson.M{"appname": app.Name, "name": name})
+	if err == mgo.ErrNotFound {
+		return ErrJobNotFound
+	}
+	return err
+}
+
+// Jobs returns the list of jobs of the app.
+func (app *App) Jobs() ([]Job, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	jobs := []Job{}
+	err = conn.Jobs().Find(bson.M{"appname": app.Name}).Sort("name").All(&jobs)
+	return jobs, err
+}
+
+// JobRuns returns the latest runs of the given job, most recent first.
+func (app *App) JobRuns(name string) ([]JobRun, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	n, err := conn.Jobs().Find(bson.M{"appname": app.Name, "name": name}).Count()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrJobNotFound
+	}
+	runs := []JobRun{}
+	query := bson.M{"appname": app.Name, "jobname": name}
+	err = conn.JobRuns().Find(query).Sort("-starttime").Limit(jobRunsLimit).All(&runs)
+	return runs, err
+}
+
+func jobsFromYaml(appName string, yamlJobs map[string]provision.TsuruYamlJob) ([]Job, error) {
+	jobs := make([]Job, 0, len(yamlJobs))
+	for name, yamlJob := range yamlJobs {
+		job := Job{
+			Name:     name,
+			AppName:  appName,
+			Schedule: yamlJob.Schedule,
+			Command:  yamlJob.Command,
+			FromYaml: true,
+		}
+		err := job.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid job %q in tsuru.yaml: %s", name, err)
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// ValidateYamlJobs checks the jobs declared in the tsuru.yaml file of the app,
+// so deploys with invalid jobs fail before any unit is replaced.
+func ValidateYamlJobs(appName string, jobs map[string]provision.TsuruYamlJob) error {
+	_, err := jobsFromYaml(appName, jobs)
+	return err
+}
+
+// SyncYamlJobs replaces the jobs declared in the tsuru.yaml file of the app
+// with the given ones. Jobs added through the API are kept, unless a job with
+// the same name is declared in the tsuru.yaml file.
+func SyncYamlJobs(appName string, yamlJobs map[string]provision.TsuruYamlJob) error {
+	jobs, err := jobsFromYaml(appName, yamlJobs)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	names := make([]string, len(jobs))
+	for i, job := range jobs {
+		names[i] = job.Name
+		_, err = conn.Jobs().Upsert(bson.M{"appname": appName, "name": job.Name}, job)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = conn.Jobs().RemoveAll(bson.M{
+		"appname":  appName,
+		"fromyaml": true,
+		"name":     bson.M{"$nin": names},
+	})
+	return err
+}
+
+func removeAppJobs(appName string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Jobs().RemoveAll(bson.M{"appname": appName})
+	if err != nil {
+		return err
+	}
+	_, err = conn.JobRuns().RemoveAll(bson.M{"appname": appName})
+	return err
+}
+
+// claim reserves the current run of the job by moving its next run forward,
+// so only one tsurud instance fires each run of the job.
+func (job *Job) claim(now time.Time) (bool, error) {
+	schedule, err := parseCronSchedule(job.Schedule)
+	if err != nil {
+		return false, err
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	query := bson.M{"appname": job.AppName, "name": job.Name, "nextrun": job.NextRun}
+	err = conn.Jobs().Update(query, bson.M{"$set": bson.M{"nextrun": schedule.next(now)}})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+type exitStatusError interface {
+	ExitStatus() int
+}
+
+// run executes the job in one unit of the app, storing the result in the
+// run history of the job. The output of the job is also sent to the app log.
+func (job *Job) run() (*JobRun, error) {
+	run := JobRun{
+		ID:        bson.NewObjectId(),
+		AppName:   job.AppName,
+		JobName:   job.Name,
+		StartTime: time.Now().UTC(),
+	}
+	var output jobOutput
+	app, err := GetByName(job.AppName)
+	if err == nil {
+		logWriter := LogWriter{App: app, Source: "app-job"}
+		logWriter.Async()
+		err = app.sourced(job.Command, io.MultiWriter(&output, &logWriter), provision.RunArgs{Once: true})
+		logWriter.Close()
+	}
+	run.EndTime = time.Now().UTC()
+	run.Output = output.String()
+	if err != nil {
+		run.Error = err.Error()
+		run.ExitStatus = -1
+		if statusErr, ok := err.(exitStatusError); ok {
+			run.ExitStatus = statusErr.ExitStatus()
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return &run, conn.JobRuns().Insert(run)
+}
+
+// jobOutput is a writer that keeps only the last bytes written to it.
+type jobOutput struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (o *jobOutput) Write(p []byte) (int, error) {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	n, err := o.buf.Write(p)
+	if o.buf.Len() > maxJobOutputSize {
+		o.buf.Next(o.buf.Len() - maxJobOutputSize)
+	}
+	return n, err
+}
+
+func (o *jobOutput) String() string {
+	o.mut.Lock()
+	defer o.mut.Unlock()
+	return o.buf.String()
+}
+
+// JobScheduler periodically looks for jobs whose next run is due, running
+// them.
+type JobScheduler struct {
+	done chan bool
+	wg   sync.WaitGroup
+}
+
+func NewJobScheduler() *JobScheduler {
+	return &JobScheduler{done: make(chan bool)}
+}
+
+func (s *JobScheduler) Run() {
+	for {
+		s.runOnce(time.Now().UTC())
+		select {
+		case <-s.done:
+			return
+		case <-time.After(jobSchedulerInterval):
+		}
+	}
+}
+
+// Shutdown stops the scheduler, waiting for running jobs to finish.
+func (s *JobScheduler) Shutdown() {
+	s.done <- true
+	s.wg.Wait()
+}
+
+func (s *JobScheduler) String() string {
+	return "job scheduler"
+}
+
+func (s *JobScheduler) runOnce(now time.Time) {
+	conn, err := db.Conn()
+	if err != nil {
+		log.Errorf("[jobs] unable to connect to the database: %s", err)
+		return
+	}
+	var jobs []Job
+	err = conn.Jobs().Find(bson.M{"nextrun": bson.M{"$lte": now}}).All(&jobs)
+	conn.Close()
+	if err != nil {
+		log.Errorf("[jobs] unable to list jobs: %s", err)
+		return
+	}
+	for _, job := range jobs {
+		claimed, err := job.claim(now)
+		if err != nil {
+			log.Errorf("[jobs] unable to schedule job %s of app %s: %s", job.Name, job.AppName, err)
+			continue
+		}
+		if !claimed {
+			continue
+		}
+		s.wg.Add(1)
+		go func(job Job) {
+			defer s.wg.Done()
+			run, err := job.run()
+			if err != nil {
+				log.Errorf("[jobs] unable to store run of job %s of app %s: %s", job.Name, job.AppName, err)
+				return
+			}
+			if run.Error != "" {
+				log.Errorf("[jobs] job %s of app %s failed: %s", job.Name, job.AppName, run.Error)
+			}
+		}(job)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"errors"
+	"time"
+
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestAddJob(c *check.C) {
+	a := App{Name: "myapp"}
+	err := a.AddJob(Job{Name: "cleanup", Schedule: "*/10 * * * *", Command: "python cleanup.py"})
+	c.Assert(err, check.IsNil)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Name, check.Equals, "cleanup")
+	c.Assert(jobs[0].AppName, check.Equals, "myapp")
+	c.Assert(jobs[0].Command, check.Equals, "python cleanup.py")
+	c.Assert(jobs[0].FromYaml, check.Equals, false)
+	c.Assert(jobs[0].NextRun.After(time.Now()), check.Equals, true)
+	c.Assert(jobs[0].NextRun.Minute()%10, check.Equals, 0)
+}
+
+func (s *S) TestAddJobAlreadyExists(c *check.C) {
+	a := App{Name: "myapp"}
+	err := a.AddJob(Job{Name: "cleanup", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	err = a.AddJob(Job{Name: "cleanup", Schedule: "@hourly", Command: "ls"})
+	c.Assert(err, check.Equals, ErrJobAlreadyExists)
+	other := App{Name: "otherapp"}
+	err = other.AddJob(Job{Name: "cleanup", Schedule: "@hourly", Command: "ls"})
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestAddJobValidation(c *check.C) {
+	a := App{Name: "myapp"}
+	err := a.AddJob(Job{Name: "Clean Up", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.Equals, ErrInvalidJobName)
+	err = a.AddJob(Job{Name: "cleanup", Schedule: "@daily"})
+	c.Assert(err, check.Equals, ErrJobWithoutCommand)
+	err = a.AddJob(Job{Name: "cleanup", Schedule: "* *", Command: "ls"})
+	c.Assert(err, check.ErrorMatches, `invalid schedule "\* \*": expected 5 fields, got 2`)
+	c.Assert(IsJobValidationError(err), check.Equals, true)
+	err = a.AddJob(Job{Name: "cleanup", Schedule: "0 0 31 2 *", Command: "ls"})
+	c.Assert(err, check.ErrorMatches, `invalid schedule "0 0 31 2 \*": it never matches`)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 0)
+}
+
+func (s *S) TestRemoveJob(c *check.C) {
+	a := App{Name: "myapp"}
+	err := a.AddJob(Job{Name: "cleanup", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	err = a.RemoveJob("cleanup")
+	c.Assert(err, check.IsNil)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 0)
+	err = a.RemoveJob("cleanup")
+	c.Assert(err, check.Equals, ErrJobNotFound)
+}
+
+func (s *S) TestJobRunsJobNotFound(c *check.C) {
+	a := App{Name: "myapp"}
+	_, err := a.JobRuns("cleanup")
+	c.Assert(err, check.Equals, ErrJobNotFound)
+}
+
+func (s *S) TestSyncYamlJobs(c *check.C) {
+	a := App{Name: "myapp"}
+	err := a.AddJob(Job{Name: "manual", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	err = a.AddJob(Job{Name: "report", Schedule: "@daily", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	err = SyncYamlJobs(a.Name, map[string]provision.TsuruYamlJob{
+		"report":  {Schedule: "@hourly", Command: "python report.py"},
+		"cleanup": {Schedule: "*/5 * * * *", Command: "python cleanup.py"},
+	})
+	c.Assert(err, check.IsNil)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 3)
+	c.Assert(jobs[0].Name, check.Equals, "cleanup")
+	c.Assert(jobs[0].FromYaml, check.Equals, true)
+	c.Assert(jobs[1].Name, check.Equals, "manual")
+	c.Assert(jobs[1].FromYaml, check.Equals, false)
+	c.Assert(jobs[2].Name, check.Equals, "report")
+	c.Assert(jobs[2].Schedule, check.Equals, "@hourly")
+	c.Assert(jobs[2].Command, check.Equals, "python report.py")
+	c.Assert(jobs[2].FromYaml, check.Equals, true)
+	err = SyncYamlJobs(a.Name, nil)
+	c.Assert(err, check.IsNil)
+	jobs, err = a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Name, check.Equals, "manual")
+}
+
+func (s *S) TestSyncYamlJobsInvalidJob(c *check.C) {
+	err := SyncYamlJobs("myapp", map[string]provision.TsuruYamlJob{
+		"cleanup": {Schedule: "every minute", Command: "ls"},
+	})
+	c.Assert(err, check.ErrorMatches, `invalid job "cleanup" in tsuru.yaml: invalid schedule .*`)
+	count, err := s.conn.Jobs().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (s *S) TestJobSchedulerRunOnce(c *check.C) {
+	a := App{Name: "myapp", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	s.provisioner.AddUnits(&a, 1, "web", nil)
+	err = a.AddJob(Job{Name: "cleanup", Schedule: "@hourly", Command: "python cleanup.py"})
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	past := now.Add(-time.Minute).Truncate(time.Minute)
+	err = s.conn.Jobs().Update(bson.M{"name": "cleanup"}, bson.M{"$set": bson.M{"nextrun": past}})
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareOutput([]byte("cleaned"))
+	scheduler := NewJobScheduler()
+	scheduler.runOnce(now)
+	scheduler.runOnce(now)
+	scheduler.wg.Wait()
+	expected := "[ -f /home/application/apprc ] && source /home/application/apprc;"
+	expected += " [ -d /home/application/current ] && cd /home/application/current;"
+	expected += " python cleanup.py"
+	c.Assert(s.provisioner.GetCmds(expected, &a), check.HasLen, 1)
+	runs, err := a.JobRuns("cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(runs, check.HasLen, 1)
+	c.Assert(runs[0].Output, check.Equals, "cleaned")
+	c.Assert(runs[0].ExitStatus, check.Equals, 0)
+	c.Assert(runs[0].Error, check.Equals, "")
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs[0].NextRun.After(now), check.Equals, true)
+	c.Assert(jobs[0].NextRun.Minute(), check.Equals, 0)
+}
+
+func (s *S) TestJobSchedulerRunOnceFailure(c *check.C) {
+	a := App{Name: "myapp", Platform: "python"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	defer s.provisioner.Destroy(&a)
+	err = a.AddJob(Job{Name: "cleanup", Schedule: "@hourly", Command: "python cleanup.py"})
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	err = s.conn.Jobs().Update(bson.M{"name": "cleanup"}, bson.M{"$set": bson.M{"nextrun": now.Add(-time.Minute)}})
+	c.Assert(err, check.IsNil)
+	s.provisioner.PrepareFailure("ExecuteCommandOnce", errors.New("something went wrong"))
+	scheduler := NewJobScheduler()
+	scheduler.runOnce(now)
+	scheduler.wg.Wait()
+	runs, err := a.JobRuns("cleanup")
+	c.Assert(err, check.IsNil)
+	c.Assert(runs, check.HasLen, 1)
+	c.Assert(runs[0].ExitStatus, check.Equals, -1)
+	c.Assert(runs[0].Error, check.Equals, "something went wrong")
+}
+
+func (s *S) TestJobSchedulerRunOnceSkipsFutureJobs(c *check.C) {
+	a := App{Name: "myapp"}
+	err := a.AddJob(Job{Name: "cleanup", Schedule: "@hourly", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	scheduler := NewJobScheduler()
+	scheduler.runOnce(time.Now().UTC())
+	scheduler.wg.Wait()
+	count, err := s.conn.JobRuns().Find(nil).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
+
+func (s *S) TestJobOutputKeepsLastBytes(c *check.C) {
+	var output jobOutput
+	data := make([]byte, maxJobOutputSize)
+	for i := range data {
+		data[i] = 'a'
+	}
+	output.Write(data)
+	output.Write([]byte("end"))
+	str := output.String()
+	c.Assert(str, check.HasLen, maxJobOutputSize)
+	c.Assert(str[len(str)-4:], check.Equals, "aend")
+}
+
+func (s *S) TestDeleteRemovesJobs(c *check.C) {
+	a := App{Name: "myapp", Platform: "python", Owner: s.user.Email}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.provisioner.Provision(&a)
+	err = a.AddJob(Job{Name: "cleanup", Schedule: "@hourly", Command: "ls"})
+	c.Assert(err, check.IsNil)
+	err = Delete(&a, nil)
+	c.Assert(err, check.IsNil)
+	count, err := s.conn.Jobs().Find(bson.M{"appname": a.Name}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(count, check.Equals, 0)
+}
@@ -0,0 +1,249 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PoolUsage is the amount of resources used by the apps of a team in a pool,
+// along with the quota of the team in the pool, if any. Memory and CpuShare
+// are the sums of the plans of every unit. Units reserved for apps and not
+// created yet are counted as well.
+type PoolUsage struct {
+	Pool     string
+	Team     string
+	Memory   int64
+	CpuShare int
+	Units    int
+	Quota    *provision.PoolQuota
+}
+
+func (u *PoolUsage) add(units int, plan Plan) {
+	u.Units += units
+	u.Memory += int64(units) * plan.Memory
+	u.CpuShare += units * plan.CpuShare
+}
+
+type PoolQuotaExceededError struct {
+	Pool      string
+	Team      string
+	Resource  string
+	Limit     int64
+	Requested int64
+}
+
+func (err *PoolQuotaExceededError) Error() string {
+	return fmt.Sprintf("Quota of team %s in pool %s exceeded. Limit of %s: %d. Requested: %d.",
+		err.Team, err.Pool, err.Resource, err.Limit, err.Requested)
+}
+
+// poolQuotaLockExpiration is how long a pool quota lock is held at most, in
+// case its owner dies without releasing it.
+var poolQuotaLockExpiration = time.Minute
+
+type poolQuotaLock struct {
+	ID    string `bson:"_id"`
+	Until time.Time
+}
+
+// acquirePoolQuotaLock locks the quota of a team in a pool, waiting up to the
+// given timeout for the lock to be released by its current owner.
+func acquirePoolQuotaLock(conn *db.Storage, id string, timeout time.Duration) (bool, error) {
+	timeoutChan := time.After(timeout)
+	coll := conn.Collection("pool_quota_locks")
+	for {
+		now := time.Now().UTC()
+		lock := poolQuotaLock{ID: id, Until: now.Add(poolQuotaLockExpiration)}
+		err := coll.Insert(lock)
+		if mgo.IsDup(err) {
+			err = coll.Update(bson.M{"_id": id, "until": bson.M{"$lt": now}}, lock)
+		}
+		if err == nil {
+			return true, nil
+		}
+		if err != mgo.ErrNotFound && !mgo.IsDup(err) {
+			return false, err
+		}
+		select {
+		case <-timeoutChan:
+			return false, nil
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// withPoolQuotaLock runs fn holding the lock of the quota of the team in the
+// pool, so concurrent changes to the units of the apps of the team can't
+// exceed the quota together. Teams without a quota in the pool aren't locked.
+func withPoolQuotaLock(poolName, team string, fn func() error) error {
+	if poolName == "" {
+		return fn()
+	}
+	pool, err := provision.GetPool(poolName)
+	if err == provision.ErrPoolNotFound {
+		return fn()
+	}
+	if err != nil {
+		return err
+	}
+	if pool.QuotaForTeam(team) == nil {
+		return fn()
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	id := poolName + "/" + team
+	locked, err := acquirePoolQuotaLock(conn, id, 10*time.Second)
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("unable to lock the quota of team %s in pool %s", team, poolName)
+	}
+	defer conn.Collection("pool_quota_locks").RemoveId(id)
+	return fn()
+}
+
+// appUnitCount returns the number of units of the app, including the units
+// reserved and not created yet.
+func appUnitCount(app *App) (int, error) {
+	units, err := Provisioner.Units(app)
+	if err != nil {
+		return 0, err
+	}
+	if len(units) > app.Quota.InUse {
+		return len(units), nil
+	}
+	return app.Quota.InUse, nil
+}
+
+func listPoolApps(query bson.M) ([]App, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var apps []App
+	err = conn.Apps().Find(query).Select(bson.M{"name": 1, "teamowner": 1, "plan": 1, "quota": 1}).All(&apps)
+	return apps, err
+}
+
+// PoolUsages returns the usage of each team in the given pool, including the
+// teams with a quota in the pool and no apps in it, sorted by team name.
+func PoolUsages(poolName string) ([]PoolUsage, error) {
+	pool, err := provision.GetPool(poolName)
+	if err != nil {
+		return nil, err
+	}
+	apps, err := listPoolApps(bson.M{"pool": poolName})
+	if err != nil {
+		return nil, err
+	}
+	usages := make(map[string]*PoolUsage)
+	usageFor := func(team string) *PoolUsage {
+		if usages[team] == nil {
+			usages[team] = &PoolUsage{Pool: poolName, Team: team, Quota: pool.QuotaForTeam(team)}
+		}
+		return usages[team]
+	}
+	for _, q := range pool.Quotas {
+		usageFor(q.Team)
+	}
+	for i := range apps {
+		units, err := appUnitCount(&apps[i])
+		if err != nil {
+			return nil, err
+		}
+		usageFor(apps[i].TeamOwner).add(units, apps[i].Plan)
+	}
+	teams := make([]string, 0, len(usages))
+	for team := range usages {
+		teams = append(teams, team)
+	}
+	sort.Strings(teams)
+	result := make([]PoolUsage, len(teams))
+	for i, team := range teams {
+		result[i] = *usages[team]
+	}
+	return result, nil
+}
+
+// checkPoolQuota checks whether the quota of the team owner of the app in the
+// given pool allows the app to have the given number of units with the given
+// plan. The other apps of the team in the pool are counted as they are. The
+// check must run holding the lock taken by withPoolQuotaLock.
+func checkPoolQuota(app *App, poolName string, units int, plan Plan) error {
+	if poolName == "" {
+		return nil
+	}
+	pool, err := provision.GetPool(poolName)
+	if err == provision.ErrPoolNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	quota := pool.QuotaForTeam(app.TeamOwner)
+	if quota == nil {
+		return nil
+	}
+	apps, err := listPoolApps(bson.M{"pool": poolName, "teamowner": app.TeamOwner, "name": bson.M{"$ne": app.Name}})
+	if err != nil {
+		return err
+	}
+	usage := PoolUsage{Pool: poolName, Team: app.TeamOwner}
+	for i := range apps {
+		units, err := appUnitCount(&apps[i])
+		if err != nil {
+			return err
+		}
+		usage.add(units, apps[i].Plan)
+	}
+	usage.add(units, plan)
+	exceeded := func(resource string, limit, requested int64) error {
+		return &PoolQuotaExceededError{
+			Pool:      poolName,
+			Team:      app.TeamOwner,
+			Resource:  resource,
+			Limit:     limit,
+			Requested: requested,
+		}
+	}
+	if quota.Units > 0 && usage.Units > quota.Units {
+		return exceeded("units", int64(quota.Units), int64(usage.Units))
+	}
+	if quota.Memory > 0 && usage.Memory > quota.Memory {
+		return exceeded("memory", quota.Memory, usage.Memory)
+	}
+	if quota.CpuShare > 0 && usage.CpuShare > quota.CpuShare {
+		return exceeded("cpu share", int64(quota.CpuShare), int64(usage.CpuShare))
+	}
+	return nil
+}
+
+// CheckPoolQuota checks whether the quota of the team owner of the app in its
+// pool allows the app to have the given number of units, for changes in the
+// units that aren't reserved, like in deploys of apps with unlimited quota.
+func CheckPoolQuota(a provision.App, units int) error {
+	app := &App{
+		Name:      a.GetName(),
+		TeamOwner: a.GetTeamOwner(),
+		Pool:      a.GetPool(),
+		Plan:      Plan{Memory: a.GetMemory(), CpuShare: a.GetCpuShare()},
+	}
+	return withPoolQuotaLock(app.Pool, app.TeamOwner, func() error {
+		return checkPoolQuota(app, app.Pool, units, app.Plan)
+	})
+}
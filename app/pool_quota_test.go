@@ -0,0 +1,207 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/quota"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestPoolUsages(c *check.C) {
+	err := provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: "team-b", Memory: 4096, Units: 10})
+	c.Assert(err, check.IsNil)
+	err = provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: "team-c", Units: 2})
+	c.Assert(err, check.IsNil)
+	apps := []App{
+		{Name: "app1", TeamOwner: "team-b", Pool: s.Pool, Plan: s.defaultPlan, Quota: quota.Quota{Limit: -1, InUse: 2}},
+		{Name: "app2", TeamOwner: "team-b", Pool: s.Pool, Plan: Plan{Memory: 512, CpuShare: 50}, Quota: quota.Quota{Limit: -1, InUse: 3}},
+		{Name: "app3", TeamOwner: "team-a", Pool: s.Pool, Plan: s.defaultPlan, Quota: quota.Quota{Limit: -1, InUse: 1}},
+		{Name: "app4", TeamOwner: "team-b", Pool: "other", Plan: s.defaultPlan, Quota: quota.Quota{Limit: -1, InUse: 5}},
+	}
+	for _, a := range apps {
+		err = s.conn.Apps().Insert(a)
+		c.Assert(err, check.IsNil)
+	}
+	usages, err := PoolUsages(s.Pool)
+	c.Assert(err, check.IsNil)
+	c.Assert(usages, check.DeepEquals, []PoolUsage{
+		{Pool: s.Pool, Team: "team-a", Memory: 1024, CpuShare: 100, Units: 1},
+		{
+			Pool:     s.Pool,
+			Team:     "team-b",
+			Memory:   3584,
+			CpuShare: 350,
+			Units:    5,
+			Quota:    &provision.PoolQuota{Team: "team-b", Memory: 4096, Units: 10},
+		},
+		{Pool: s.Pool, Team: "team-c", Quota: &provision.PoolQuota{Team: "team-c", Units: 2}},
+	})
+}
+
+func (s *S) TestPoolUsagesPoolNotFound(c *check.C) {
+	_, err := PoolUsages("unknown")
+	c.Assert(err, check.Equals, provision.ErrPoolNotFound)
+}
+
+func (s *S) TestReserveUnitsPoolQuotaExceeded(c *check.C) {
+	err := provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: s.team.Name, Units: 5})
+	c.Assert(err, check.IsNil)
+	other := App{Name: "other", TeamOwner: s.team.Name, Pool: s.Pool, Quota: quota.Quota{Limit: -1, InUse: 3}}
+	err = s.conn.Apps().Insert(other)
+	c.Assert(err, check.IsNil)
+	a := App{Name: "together", TeamOwner: s.team.Name, Pool: s.Pool, Quota: quota.Unlimited}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = reserveUnits(&a, 2)
+	c.Assert(err, check.IsNil)
+	err = reserveUnits(&a, 1)
+	c.Assert(err, check.DeepEquals, &PoolQuotaExceededError{
+		Pool:      s.Pool,
+		Team:      s.team.Name,
+		Resource:  "units",
+		Limit:     5,
+		Requested: 6,
+	})
+	app, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.Quota.InUse, check.Equals, 2)
+}
+
+func (s *S) TestReserveUnitsPoolQuotaMemory(c *check.C) {
+	err := provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: s.team.Name, Memory: 3072})
+	c.Assert(err, check.IsNil)
+	a := App{Name: "together", TeamOwner: s.team.Name, Pool: s.Pool, Plan: s.defaultPlan, Quota: quota.Unlimited}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = reserveUnits(&a, 4)
+	c.Assert(err, check.ErrorMatches, "Quota of team .* in pool pool1 exceeded. Limit of memory: 3072. Requested: 4096.")
+	err = reserveUnits(&a, 3)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReserveUnitsPoolQuotaOtherTeam(c *check.C) {
+	err := provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: "other-team", Units: 1})
+	c.Assert(err, check.IsNil)
+	a := App{Name: "together", TeamOwner: s.team.Name, Pool: s.Pool, Quota: quota.Unlimited}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = reserveUnits(&a, 4)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestChangePlanPoolQuotaExceeded(c *check.C) {
+	err := provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: s.team.Name, CpuShare: 200})
+	c.Assert(err, check.IsNil)
+	plan := Plan{Name: "something", Router: "fake", CpuShare: 100, Memory: 268435456}
+	err = s.conn.Plans().Insert(plan)
+	c.Assert(err, check.IsNil)
+	a := App{
+		Name:      "my-test-app",
+		TeamOwner: s.team.Name,
+		Pool:      s.Pool,
+		Plan:      Plan{Router: "fake", Memory: 536870912, CpuShare: 50},
+		Quota:     quota.Quota{Limit: -1, InUse: 3},
+	}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.ChangePlan(plan.Name, new(bytes.Buffer))
+	c.Assert(err, check.DeepEquals, &PoolQuotaExceededError{
+		Pool:      s.Pool,
+		Team:      s.team.Name,
+		Resource:  "cpu share",
+		Limit:     200,
+		Requested: 300,
+	})
+	app, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.Plan.CpuShare, check.Equals, 50)
+}
+
+func (s *S) TestChangePoolQuotaExceeded(c *check.C) {
+	err := provision.AddPool(provision.AddPoolOptions{Name: "pool2", Public: true})
+	c.Assert(err, check.IsNil)
+	err = provision.SetPoolQuota("pool2", provision.PoolQuota{Team: s.team.Name, Units: 2})
+	c.Assert(err, check.IsNil)
+	a := App{Name: "my-test-app", TeamOwner: s.team.Name, Pool: s.Pool, Quota: quota.Quota{Limit: -1, InUse: 3}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.ChangePool("pool2")
+	c.Assert(err, check.FitsTypeOf, &PoolQuotaExceededError{})
+	app, err := GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(app.Pool, check.Equals, s.Pool)
+}
+
+func (s *S) TestPoolUsagesCountsUnitsNotReserved(c *check.C) {
+	a := App{Name: "deployed", TeamOwner: "team-b", Pool: s.Pool, Plan: s.defaultPlan, Quota: quota.Unlimited}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = s.provisioner.Provision(&a)
+	c.Assert(err, check.IsNil)
+	defer s.provisioner.Destroy(&a)
+	_, err = s.provisioner.AddUnits(&a, 3, "web", nil)
+	c.Assert(err, check.IsNil)
+	usages, err := PoolUsages(s.Pool)
+	c.Assert(err, check.IsNil)
+	c.Assert(usages, check.DeepEquals, []PoolUsage{
+		{Pool: s.Pool, Team: "team-b", Memory: 3072, CpuShare: 300, Units: 3},
+	})
+}
+
+func (s *S) TestSetQuotaInUsePoolQuotaExceeded(c *check.C) {
+	err := provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: s.team.Name, Units: 4})
+	c.Assert(err, check.IsNil)
+	a := App{Name: "together", TeamOwner: s.team.Name, Pool: s.Pool, Quota: quota.Quota{Limit: 10}}
+	err = s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.SetQuotaInUse(5)
+	c.Assert(err, check.FitsTypeOf, &PoolQuotaExceededError{})
+	err = a.SetQuotaInUse(4)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestCheckPoolQuota(c *check.C) {
+	err := provision.SetPoolQuota(s.Pool, provision.PoolQuota{Team: s.team.Name, Units: 4})
+	c.Assert(err, check.IsNil)
+	other := App{Name: "other", TeamOwner: s.team.Name, Pool: s.Pool, Quota: quota.Quota{Limit: -1, InUse: 3}}
+	err = s.conn.Apps().Insert(other)
+	c.Assert(err, check.IsNil)
+	a := provisiontest.NewFakeApp("deployed", "python", 0)
+	a.TeamOwner = s.team.Name
+	a.Pool = s.Pool
+	err = CheckPoolQuota(a, 1)
+	c.Assert(err, check.IsNil)
+	err = CheckPoolQuota(a, 2)
+	c.Assert(err, check.DeepEquals, &PoolQuotaExceededError{
+		Pool:      s.Pool,
+		Team:      s.team.Name,
+		Resource:  "units",
+		Limit:     4,
+		Requested: 5,
+	})
+}
+
+func (s *S) TestAcquirePoolQuotaLock(c *check.C) {
+	locked, err := acquirePoolQuotaLock(s.conn, "pool1/team", time.Second)
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	locked, err = acquirePoolQuotaLock(s.conn, "pool1/team", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, false)
+	locked, err = acquirePoolQuotaLock(s.conn, "pool1/other", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+	err = s.conn.Collection("pool_quota_locks").UpdateId("pool1/team", bson.M{"$set": bson.M{"until": time.Now().Add(-time.Second)}})
+	c.Assert(err, check.IsNil)
+	locked, err = acquirePoolQuotaLock(s.conn, "pool1/team", 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(locked, check.Equals, true)
+}
@@ -14,30 +14,32 @@ import (
 )
 
 func reserveUnits(app *App, quantity int) error {
-	app, err := checkAppLimit(app.Name, quantity)
-	if err != nil {
-		return err
-	}
-	conn, err := db.Conn()
-	if err != nil {
-		return err
-	}
-	defer conn.Close()
-	err = conn.Apps().Update(
-		bson.M{"name": app.Name, "quota.inuse": app.Quota.InUse},
-		bson.M{"$inc": bson.M{"quota.inuse": quantity}},
-	)
-	for err == mgo.ErrNotFound {
-		app, err = checkAppLimit(app.Name, quantity)
+	return withPoolQuotaLock(app.Pool, app.TeamOwner, func() error {
+		app, err := checkAppLimit(app.Name, quantity)
 		if err != nil {
 			return err
 		}
+		conn, err := db.Conn()
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
 		err = conn.Apps().Update(
 			bson.M{"name": app.Name, "quota.inuse": app.Quota.InUse},
 			bson.M{"$inc": bson.M{"quota.inuse": quantity}},
 		)
-	}
-	return err
+		for err == mgo.ErrNotFound {
+			app, err = checkAppLimit(app.Name, quantity)
+			if err != nil {
+				return err
+			}
+			err = conn.Apps().Update(
+				bson.M{"name": app.Name, "quota.inuse": app.Quota.InUse},
+				bson.M{"$inc": bson.M{"quota.inuse": quantity}},
+			)
+		}
+		return err
+	})
 }
 
 func checkAppLimit(name string, quantity int) (*App, error) {
@@ -51,6 +53,14 @@ func checkAppLimit(name string, quantity int) (*App, error) {
 			Requested: uint(quantity),
 		}
 	}
+	units, err := appUnitCount(app)
+	if err != nil {
+		return nil, err
+	}
+	err = checkPoolQuota(app, app.Pool, units+quantity, app.Plan)
+	if err != nil {
+		return nil, err
+	}
 	return app, nil
 }
 
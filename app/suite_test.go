@@ -104,6 +104,7 @@ func (s *S) SetUpSuite(c *check.C) {
 	err = json.Unmarshal(data, &s.zeroLock)
 	c.Assert(err, check.IsNil)
 	LogPubSubQueuePrefix = "pubsub:app-test:"
+	UnitEventPubSubQueuePrefix = "unit-events:app-test:"
 }
 
 func (s *S) TearDownSuite(c *check.C) {
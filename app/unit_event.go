@@ -0,0 +1,387 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	stderr "errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/queue"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Kinds of unit events published in the unit event stream.
+const (
+	UnitEventStarted   = "started"
+	UnitEventError     = "error"
+	UnitEventCrashed   = "crashed"
+	UnitEventCrashLoop = "crashloop"
+	UnitEventHealed    = "healed"
+	UnitEventMoved     = "moved"
+)
+
+var UnitEventPubSubQueuePrefix = "unit-events:"
+
+var (
+	ErrInvalidWebhook        = stderr.New("invalid webhook url, it must be an absolute http or https url")
+	ErrWebhookHostNotAllowed = stderr.New("invalid webhook url, the host must be a public address")
+	ErrWebhookAlreadyExists  = stderr.New("webhook already registered for this app")
+	ErrWebhookNotFound       = stderr.New("webhook not found")
+)
+
+const defaultWebhookWorkers = 10
+
+var webhookClient = &http.Client{
+	Timeout:   10 * time.Second,
+	Transport: &http.Transport{Dial: dialWebhook},
+}
+
+var (
+	webhookQueue     chan webhookRequest
+	webhookQueueOnce sync.Once
+)
+
+// privateNetworks are the networks webhooks can't be sent to, unless
+// webhooks:allow-private-networks is set, so apps can't use tsuru to reach
+// internal services.
+var privateNetworks = parseNetworks(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+)
+
+type webhookRequest struct {
+	hook string
+	data []byte
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// UnitEvent represents a transition in the lifecycle of a unit. Status
+// events (started, error, crashed and crashloop) carry the previous and the
+// new status of the unit, while healed and moved events carry the unit that
+// replaced it.
+type UnitEvent struct {
+	App            string    `json:"app"`
+	Unit           string    `json:"unit"`
+	Kind           string    `json:"kind"`
+	Status         string    `json:"status,omitempty"`
+	PreviousStatus string    `json:"previousStatus,omitempty"`
+	Host           string    `json:"host,omitempty"`
+	NewUnit        string    `json:"newUnit,omitempty"`
+	NewHost        string    `json:"newHost,omitempty"`
+	Time           time.Time `json:"time"`
+}
+
+type UnitEventListener struct {
+	C <-chan UnitEvent
+	q queue.PubSubQ
+}
+
+func unitEventQueueName(appName string) string {
+	return UnitEventPubSubQueuePrefix + appName
+}
+
+// NewUnitEventListener returns a listener that yields every unit event
+// published for the given app, until it's closed.
+func NewUnitEventListener(a *App) (*UnitEventListener, error) {
+	factory, err := queue.Factory()
+	if err != nil {
+		return nil, err
+	}
+	pubSubQ, err := factory.PubSub(unitEventQueueName(a.Name))
+	if err != nil {
+		return nil, err
+	}
+	subChan, err := pubSubQ.Sub()
+	if err != nil {
+		return nil, err
+	}
+	c := make(chan UnitEvent, 10)
+	go func() {
+		defer close(c)
+		for msg := range subChan {
+			var evt UnitEvent
+			err := json.Unmarshal(msg, &evt)
+			if err != nil {
+				log.Errorf("Unparsable unit event, ignoring: %s", string(msg))
+				continue
+			}
+			c <- evt
+		}
+	}()
+	return &UnitEventListener{C: c, q: pubSubQ}, nil
+}
+
+func (l *UnitEventListener) Close() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Recovered panic closing listener (possible double close): %#v", r)
+		}
+	}()
+	err = l.q.UnSub()
+	return
+}
+
+// UnitStatusEventKind returns the kind of the event that represents the
+// transition of a unit from one status to another, or an empty string if the
+// transition is not worth an event.
+func UnitStatusEventKind(from, to provision.Status) string {
+	if from == to {
+		return ""
+	}
+	switch to {
+	case provision.StatusStarted:
+		return UnitEventStarted
+	case provision.StatusCrashLoop:
+		return UnitEventCrashLoop
+	case provision.StatusError, provision.StatusStopped:
+		if from == provision.StatusStarted {
+			return UnitEventCrashed
+		}
+		if to == provision.StatusError {
+			return UnitEventError
+		}
+	}
+	return ""
+}
+
+// PublishUnitStatusChange publishes the event matching the transition of the
+// given unit from one status to another, if there's any.
+func PublishUnitStatusChange(appName, unitID string, from, to provision.Status) {
+	kind := UnitStatusEventKind(from, to)
+	if kind == "" {
+		return
+	}
+	PublishUnitEvent(UnitEvent{
+		App:            appName,
+		Unit:           unitID,
+		Kind:           kind,
+		Status:         to.String(),
+		PreviousStatus: from.String(),
+	})
+}
+
+// PublishUnitEvent publishes the given event in the unit event stream of the
+// app and forwards it to the webhooks registered in the app. Errors are only
+// logged, publishing events must never break the operation that triggered
+// them.
+func PublishUnitEvent(evt UnitEvent) {
+	if evt.Time.IsZero() {
+		evt.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(evt)
+	if err != nil {
+		log.Errorf("Error on unit event notify: %s", err)
+		return
+	}
+	factory, err := queue.Factory()
+	if err == nil {
+		var pubSubQ queue.PubSubQ
+		pubSubQ, err = factory.PubSub(unitEventQueueName(evt.App))
+		if err == nil {
+			err = pubSubQ.Pub(data)
+		}
+	}
+	if err != nil {
+		log.Errorf("Error on unit event notify: %s", err)
+	}
+	hooks, err := appWebhooks(evt.App)
+	if err != nil {
+		log.Errorf("Error on unit event notify: unable to get webhooks for app %q: %s", evt.App, err)
+		return
+	}
+	for _, hook := range hooks {
+		enqueueUnitEvent(hook, data)
+	}
+}
+
+// startWebhookWorkers starts the workers sending unit events to webhooks. The
+// number of workers is read from webhooks:workers, and events are discarded
+// while the queue is full, so slow webhooks can't pile up requests.
+func startWebhookWorkers() {
+	workers, err := config.GetInt("webhooks:workers")
+	if err != nil || workers <= 0 {
+		workers = defaultWebhookWorkers
+	}
+	webhookQueue = make(chan webhookRequest, workers*100)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for req := range webhookQueue {
+				sendUnitEvent(req.hook, req.data)
+			}
+		}()
+	}
+}
+
+func enqueueUnitEvent(hook string, data []byte) {
+	webhookQueueOnce.Do(startWebhookWorkers)
+	select {
+	case webhookQueue <- webhookRequest{hook: hook, data: data}:
+	default:
+		log.Errorf("Error sending unit event to webhook %s: too many pending events, discarding it", hook)
+	}
+}
+
+func webhookIPAllowed(ip net.IP) bool {
+	if allow, _ := config.GetBool("webhooks:allow-private-networks"); allow {
+		return true
+	}
+	if ip.IsUnspecified() || ip.IsLoopback() || ip.IsMulticast() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialWebhook connects to webhooks checking the addresses their hosts
+// resolve to, every time, as hosts may change their addresses after the
+// webhook is registered.
+func dialWebhook(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no addresses found for webhook host %s", host)
+	}
+	for _, ip := range ips {
+		if !webhookIPAllowed(ip) {
+			return nil, fmt.Errorf("webhook host %s resolves to %s, which is not a public address", host, ip)
+		}
+	}
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	return dialer.Dial(network, net.JoinHostPort(ips[0].String(), port))
+}
+
+func sendUnitEvent(hook string, data []byte) {
+	resp, err := webhookClient.Post(hook, "application/json", bytes.NewReader(data))
+	if err != nil {
+		log.Errorf("Error sending unit event to webhook %s: %s", hook, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		log.Errorf("Error sending unit event to webhook %s: invalid status code %d", hook, resp.StatusCode)
+	}
+}
+
+func appWebhooks(appName string) ([]string, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var a App
+	err = conn.Apps().Find(bson.M{"name": appName}).Select(bson.M{"webhooks": 1}).One(&a)
+	if err != nil {
+		return nil, err
+	}
+	return a.Webhooks, nil
+}
+
+// AddWebhook registers a URL that will receive, through POST requests, every
+// unit event of the app. Hosts in private networks are refused.
+func (app *App) AddWebhook(hook string) error {
+	u, err := url.Parse(hook)
+	if err != nil || !u.IsAbs() || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrInvalidWebhook
+	}
+	host := u.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		host = "127.0.0.1"
+	}
+	if ip := net.ParseIP(host); ip != nil && !webhookIPAllowed(ip) {
+		return ErrWebhookHostNotAllowed
+	}
+	for _, h := range app.Webhooks {
+		if h == hook {
+			return ErrWebhookAlreadyExists
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(
+		bson.M{"name": app.Name},
+		bson.M{"$addToSet": bson.M{"webhooks": hook}},
+	)
+	if err != nil {
+		return err
+	}
+	app.Webhooks = append(app.Webhooks, hook)
+	return nil
+}
+
+// RemoveWebhook unregisters a webhook from the app.
+func (app *App) RemoveWebhook(hook string) error {
+	index := -1
+	for i, h := range app.Webhooks {
+		if h == hook {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return ErrWebhookNotFound
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Apps().Update(
+		bson.M{"name": app.Name},
+		bson.M{"$pull": bson.M{"webhooks": hook}},
+	)
+	if err != nil {
+		return err
+	}
+	app.Webhooks = append(app.Webhooks[:index], app.Webhooks[index+1:]...)
+	return nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package app
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestUnitStatusEventKind(c *check.C) {
+	var tests = []struct {
+		from     provision.Status
+		to       provision.Status
+		expected string
+	}{
+		{provision.StatusStarting, provision.StatusStarted, UnitEventStarted},
+		{provision.StatusError, provision.StatusStarted, UnitEventStarted},
+		{provision.StatusStarting, provision.StatusError, UnitEventError},
+		{provision.StatusStarted, provision.StatusError, UnitEventCrashed},
+		{provision.StatusStarted, provision.StatusStopped, UnitEventCrashed},
+		{provision.StatusError, provision.StatusCrashLoop, UnitEventCrashLoop},
+		{provision.StatusStarted, provision.StatusStarted, ""},
+		{provision.StatusError, provision.StatusError, ""},
+		{provision.StatusCreated, provision.StatusStopped, ""},
+		{provision.StatusStarted, provision.StatusStarting, ""},
+	}
+	for _, t := range tests {
+		c.Check(UnitStatusEventKind(t.from, t.to), check.Equals, t.expected, check.Commentf("%s -> %s", t.from, t.to))
+	}
+}
+
+func (s *S) TestNewUnitEventListener(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	l, err := NewUnitEventListener(&a)
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	PublishUnitEvent(UnitEvent{App: "myapp", Unit: "u1", Kind: UnitEventMoved, NewUnit: "u2"})
+	select {
+	case evt := <-l.C:
+		c.Assert(evt.Unit, check.Equals, "u1")
+		c.Assert(evt.Kind, check.Equals, UnitEventMoved)
+		c.Assert(evt.NewUnit, check.Equals, "u2")
+		c.Assert(evt.Time.IsZero(), check.Equals, false)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for unit event")
+	}
+}
+
+func (s *S) TestUnitEventListenerClose(c *check.C) {
+	l, err := NewUnitEventListener(&App{Name: "myapp"})
+	c.Assert(err, check.IsNil)
+	err = l.Close()
+	c.Assert(err, check.IsNil)
+	_, ok := <-l.C
+	c.Assert(ok, check.Equals, false)
+}
+
+func (s *S) TestPublishUnitStatusChange(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	l, err := NewUnitEventListener(&a)
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	PublishUnitStatusChange("myapp", "u1", provision.StatusStarted, provision.StatusStarted)
+	PublishUnitStatusChange("myapp", "u1", provision.StatusStarted, provision.StatusError)
+	select {
+	case evt := <-l.C:
+		c.Assert(evt.Kind, check.Equals, UnitEventCrashed)
+		c.Assert(evt.Status, check.Equals, "error")
+		c.Assert(evt.PreviousStatus, check.Equals, "started")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for unit event")
+	}
+}
+
+func (s *S) TestPublishUnitEventSendsToWebhooks(c *check.C) {
+	received := make(chan UnitEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, check.Equals, "POST")
+		c.Check(r.Header.Get("Content-Type"), check.Equals, "application/json")
+		data, _ := ioutil.ReadAll(r.Body)
+		var evt UnitEvent
+		c.Check(json.Unmarshal(data, &evt), check.IsNil)
+		received <- evt
+	}))
+	defer server.Close()
+	config.Set("webhooks:allow-private-networks", true)
+	defer config.Unset("webhooks:allow-private-networks")
+	a := App{Name: "myapp", Webhooks: []string{server.URL}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	PublishUnitEvent(UnitEvent{App: "myapp", Unit: "u1", Kind: UnitEventHealed, NewUnit: "u2"})
+	select {
+	case evt := <-received:
+		c.Assert(evt.App, check.Equals, "myapp")
+		c.Assert(evt.Kind, check.Equals, UnitEventHealed)
+		c.Assert(evt.NewUnit, check.Equals, "u2")
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for webhook request")
+	}
+}
+
+func (s *S) TestAddWebhook(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.AddWebhook("http://hooks.example.com/tsuru")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Webhooks, check.DeepEquals, []string{"http://hooks.example.com/tsuru"})
+	dbApp, err := GetByName("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Webhooks, check.DeepEquals, []string{"http://hooks.example.com/tsuru"})
+	err = a.AddWebhook("http://hooks.example.com/tsuru")
+	c.Assert(err, check.Equals, ErrWebhookAlreadyExists)
+}
+
+func (s *S) TestAddWebhookInvalidURL(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	for _, hook := range []string{"", "/hooks", "ftp://hooks.example.com", "http://"} {
+		err = a.AddWebhook(hook)
+		c.Check(err, check.Equals, ErrInvalidWebhook, check.Commentf(hook))
+	}
+	c.Assert(a.Webhooks, check.HasLen, 0)
+}
+
+func (s *S) TestAddWebhookPrivateHost(c *check.C) {
+	a := App{Name: "myapp"}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	hooks := []string{
+		"http://localhost:8080/hooks",
+		"http://127.0.0.1/hooks",
+		"http://10.0.0.1/hooks",
+		"http://172.17.0.2:8080/hooks",
+		"http://192.168.1.10/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://[::1]/hooks",
+	}
+	for _, hook := range hooks {
+		err = a.AddWebhook(hook)
+		c.Check(err, check.Equals, ErrWebhookHostNotAllowed, check.Commentf(hook))
+	}
+	c.Assert(a.Webhooks, check.HasLen, 0)
+	config.Set("webhooks:allow-private-networks", true)
+	defer config.Unset("webhooks:allow-private-networks")
+	err = a.AddWebhook("http://10.0.0.1/hooks")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestSendUnitEventPrivateHost(c *check.C) {
+	requests := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- true
+	}))
+	defer server.Close()
+	_, err := webhookClient.Post(server.URL, "application/json", strings.NewReader("{}"))
+	c.Assert(err, check.ErrorMatches, ".*127.0.0.1, which is not a public address.*")
+	c.Assert(requests, check.HasLen, 0)
+}
+
+func (s *S) TestWebhookIPAllowed(c *check.C) {
+	c.Assert(webhookIPAllowed(net.ParseIP("8.8.8.8")), check.Equals, true)
+	c.Assert(webhookIPAllowed(net.ParseIP("2001:4860:4860::8888")), check.Equals, true)
+	c.Assert(webhookIPAllowed(net.ParseIP("10.1.2.3")), check.Equals, false)
+	c.Assert(webhookIPAllowed(net.ParseIP("0.0.0.0")), check.Equals, false)
+	c.Assert(webhookIPAllowed(net.ParseIP("fd00::1")), check.Equals, false)
+}
+
+func (s *S) TestRemoveWebhook(c *check.C) {
+	a := App{Name: "myapp", Webhooks: []string{"http://a.example.com", "http://b.example.com"}}
+	err := s.conn.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = a.RemoveWebhook("http://a.example.com")
+	c.Assert(err, check.IsNil)
+	c.Assert(a.Webhooks, check.DeepEquals, []string{"http://b.example.com"})
+	dbApp, err := GetByName("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Webhooks, check.DeepEquals, []string{"http://b.example.com"})
+	err = a.RemoveWebhook("http://a.example.com")
+	c.Assert(err, check.Equals, ErrWebhookNotFound)
+}
@@ -158,6 +158,22 @@ func (s *Storage) Quota() *storage.Collection {
 	return c
 }
 
+// Jobs returns the jobs collection from MongoDB.
+func (s *Storage) Jobs() *storage.Collection {
+	nameIndex := mgo.Index{Key: []string{"appname", "name"}, Unique: true}
+	c := s.Collection("jobs")
+	c.EnsureIndex(nameIndex)
+	return c
+}
+
+// JobRuns returns the job runs collection from MongoDB.
+func (s *Storage) JobRuns() *storage.Collection {
+	jobIndex := mgo.Index{Key: []string{"appname", "jobname", "-starttime"}}
+	c := s.Collection("job_runs")
+	c.EnsureIndex(jobIndex)
+	return c
+}
+
 var logCappedInfo = mgo.CollectionInfo{
 	Capped:       true,
 	MaxBytes:     200 * 5000,
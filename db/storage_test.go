@@ -180,6 +180,25 @@ func (s *S) TestPlans(c *check.C) {
 	c.Assert(plans, check.DeepEquals, plansc)
 }
 
+func (s *S) TestJobs(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	jobs := strg.Jobs()
+	jobsc := strg.Collection("jobs")
+	c.Assert(jobs, check.DeepEquals, jobsc)
+	c.Assert(jobs, HasUniqueIndex, []string{"appname", "name"})
+}
+
+func (s *S) TestJobRuns(c *check.C) {
+	strg, err := Conn()
+	c.Assert(err, check.IsNil)
+	defer strg.Close()
+	runs := strg.JobRuns()
+	runsc := strg.Collection("job_runs")
+	c.Assert(runs, check.DeepEquals, runsc)
+}
+
 func (s *S) TestServiceInstances(c *check.C) {
 	strg, err := Conn()
 	c.Assert(err, check.IsNil)
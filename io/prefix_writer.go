@@ -0,0 +1,37 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package io
+
+import (
+	"bytes"
+	"io"
+)
+
+// PrefixWriter writes the given prefix at the beginning of every line
+// written to the underlying writer.
+type PrefixWriter struct {
+	io.Writer
+	Prefix  string
+	midLine bool
+}
+
+func (w *PrefixWriter) Write(data []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !w.midLine {
+			buf.WriteString(w.Prefix)
+		}
+		buf.Write(line)
+		w.midLine = line[len(line)-1] != '\n'
+	}
+	_, err := w.Writer.Write(buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
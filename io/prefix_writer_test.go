@@ -0,0 +1,34 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package io
+
+import (
+	"bytes"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestPrefixWriter(c *check.C) {
+	var buf bytes.Buffer
+	w := PrefixWriter{Writer: &buf, Prefix: "[unit1] "}
+	n, err := w.Write([]byte("first line\nsecond "))
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 18)
+	n, err = w.Write([]byte("line\n"))
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 5)
+	n, err = w.Write([]byte("third line\n\nlast"))
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 16)
+	c.Assert(buf.String(), check.Equals, "[unit1] first line\n[unit1] second line\n[unit1] third line\n[unit1] \n[unit1] last")
+}
+
+func (s *S) TestPrefixWriterError(c *check.C) {
+	buf := errBuffer{err: bytes.ErrTooLarge}
+	w := PrefixWriter{Writer: &buf, Prefix: "[unit1] "}
+	n, err := w.Write([]byte("something\n"))
+	c.Assert(err, check.Equals, bytes.ErrTooLarge)
+	c.Assert(n, check.Equals, 0)
+}
@@ -26,6 +26,7 @@ type runContainerActionsArgs struct {
 	processName      string
 	imageID          string
 	commands         []string
+	entrypoint       bool
 	destinationHosts []string
 	writer           io.Writer
 	isDeploy         bool
@@ -119,6 +120,16 @@ var insertEmptyContainerInDB = action.Action{
 			Image:         args.imageID,
 			BuildingImage: args.buildingImage,
 		}
+		if !args.isDeploy {
+			yamlData, err := getImageTsuruYamlData(args.imageID)
+			if err != nil {
+				return nil, err
+			}
+			cont.Ports, err = processPorts(yamlData, args.processName)
+			if err != nil {
+				return nil, err
+			}
+		}
 		coll := args.provisioner.Collection()
 		defer coll.Close()
 		if err := coll.Insert(cont); err != nil {
@@ -163,6 +174,7 @@ var createContainer = action.Action{
 		err := cont.Create(&container.CreateArgs{
 			ImageID:          args.imageID,
 			Commands:         args.commands,
+			Entrypoint:       args.entrypoint,
 			App:              args.app,
 			Deploy:           args.isDeploy,
 			Provisioner:      args.provisioner,
@@ -207,8 +219,7 @@ var setNetworkInfo = action.Action{
 		if err != nil {
 			return nil, err
 		}
-		c.IP = info.IP
-		c.HostPort = info.HTTPHostPort
+		c.SetNetworkInfo(info)
 		return c, nil
 	},
 }
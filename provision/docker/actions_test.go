@@ -59,6 +59,42 @@ func (s *S) TestInsertEmptyContainerInDBForward(c *check.C) {
 	c.Assert(retrieved.Name, check.Equals, cont.Name)
 }
 
+func (s *S) TestInsertEmptyContainerInDBForwardWithPorts(c *check.C) {
+	customData := map[string]interface{}{
+		"ports": map[string]interface{}{
+			"web": []interface{}{
+				map[string]interface{}{"name": "metrics", "port": 9100},
+				map[string]interface{}{"name": "grpc", "port": 50051, "router": true},
+			},
+		},
+	}
+	err := saveImageCustomData("image-id", customData)
+	c.Assert(err, check.IsNil)
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	args := runContainerActionsArgs{
+		app:         app,
+		processName: "web",
+		imageID:     "image-id",
+		provisioner: s.p,
+	}
+	context := action.FWContext{Params: []interface{}{args}}
+	r, err := insertEmptyContainerInDB.Forward(context)
+	c.Assert(err, check.IsNil)
+	cont := r.(container.Container)
+	coll := s.p.Collection()
+	defer coll.Close()
+	defer coll.Remove(bson.M{"name": cont.Name})
+	expected := []container.ExposedPort{
+		{Name: "metrics", Port: 9100},
+		{Name: "grpc", Port: 50051, Routed: true},
+	}
+	c.Assert(cont.Ports, check.DeepEquals, expected)
+	var retrieved container.Container
+	err = coll.Find(bson.M{"name": cont.Name}).One(&retrieved)
+	c.Assert(err, check.IsNil)
+	c.Assert(retrieved.Ports, check.DeepEquals, expected)
+}
+
 func (s *S) TestInsertEmptyContainerInDBBackward(c *check.C) {
 	cont := container.Container{Name: "myName"}
 	coll := s.p.Collection()
@@ -464,7 +500,7 @@ func (s *S) TestProvisionAddUnitsToHostForward(c *check.C) {
 	defer coll.Close()
 	coll.Insert(container.Container{ID: "container-id", AppName: app.GetName(), Version: "container-version", Image: "tsuru/python"})
 	defer coll.RemoveAll(bson.M{"appname": app.GetName()})
-	imageId, err := appNewImageName(app.GetName())
+	imageId, err := appNewImageName(app.GetName(), app.GetPool())
 	c.Assert(err, check.IsNil)
 	err = s.newFakeImage(p, imageId, nil)
 	c.Assert(err, check.IsNil)
@@ -495,7 +531,7 @@ func (s *S) TestProvisionAddUnitsToHostForwardWithoutHost(c *check.C) {
 	p.Provision(app)
 	coll := p.Collection()
 	defer coll.Close()
-	imageId, err := appNewImageName(app.GetName())
+	imageId, err := appNewImageName(app.GetName(), app.GetPool())
 	c.Assert(err, check.IsNil)
 	err = s.newFakeImage(p, imageId, nil)
 	c.Assert(err, check.IsNil)
@@ -608,7 +644,7 @@ func (s *S) TestFollowLogsAndCommitForward(c *check.C) {
 	err := s.newFakeImage(s.p, "tsuru/python", nil)
 	c.Assert(err, check.IsNil)
 	app := provisiontest.NewFakeApp("mightyapp", "python", 1)
-	nextImgName, err := appNewImageName(app.GetName())
+	nextImgName, err := appNewImageName(app.GetName(), app.GetPool())
 	c.Assert(err, check.IsNil)
 	cont := container.Container{AppName: "mightyapp", ID: "myid123", BuildingImage: nextImgName}
 	err = cont.Create(&container.CreateArgs{
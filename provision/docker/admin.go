@@ -9,8 +9,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
+	"github.com/tsuru/tsuru/provision"
 	"launchpad.net/gnuflag"
 )
 
@@ -177,3 +180,152 @@ func (c *rebalanceContainersCmd) Flags() *gnuflag.FlagSet {
 	}
 	return c.fs
 }
+
+type poolQuotaSetCmd struct {
+	fs       *gnuflag.FlagSet
+	memory   int64
+	cpuShare int
+	units    int
+}
+
+func (c *poolQuotaSetCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "pool-quota-set",
+		Usage: "pool-quota-set <pool> <team> [-m/--memory <bytes>] [-c/--cpushare <shares>] [-u/--units <units>]",
+		Desc: `Sets the quota of a team in a pool, limiting the resources used by the apps
+owned by the team in the pool. Memory and CPU share are the sums of the plans
+of every unit. Limits not given, or set to 0, are unlimited.`,
+		MinArgs: 2,
+	}
+}
+
+func (c *poolQuotaSetCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL(fmt.Sprintf("/pool/%s/quota", context.Args[0]))
+	if err != nil {
+		return err
+	}
+	quota := map[string]interface{}{
+		"team":     context.Args[1],
+		"memory":   c.memory,
+		"cpushare": c.cpuShare,
+		"units":    c.units,
+	}
+	b, err := json.Marshal(quota)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Quota of team %s in pool %s successfully set.\n", context.Args[1], context.Args[0])
+	return nil
+}
+
+func (c *poolQuotaSetCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("pool-quota-set", gnuflag.ExitOnError)
+		desc := "Maximum memory, in bytes, used by the units of the team in the pool"
+		c.fs.Int64Var(&c.memory, "memory", 0, desc)
+		c.fs.Int64Var(&c.memory, "m", 0, desc)
+		desc = "Maximum CPU share used by the units of the team in the pool"
+		c.fs.IntVar(&c.cpuShare, "cpushare", 0, desc)
+		c.fs.IntVar(&c.cpuShare, "c", 0, desc)
+		desc = "Maximum number of units of the team in the pool"
+		c.fs.IntVar(&c.units, "units", 0, desc)
+		c.fs.IntVar(&c.units, "u", 0, desc)
+	}
+	return c.fs
+}
+
+type poolQuotaRemoveCmd struct{}
+
+func (c *poolQuotaRemoveCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "pool-quota-remove",
+		Usage:   "pool-quota-remove <pool> <team>",
+		Desc:    "Removes the quota of a team in a pool, so its apps are no longer limited in the pool.",
+		MinArgs: 2,
+	}
+}
+
+func (c *poolQuotaRemoveCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL(fmt.Sprintf("/pool/%s/quota/%s", context.Args[0], context.Args[1]))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("DELETE", url, nil)
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(request)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Quota of team %s in pool %s successfully removed.\n", context.Args[1], context.Args[0])
+	return nil
+}
+
+type poolUsageCmd struct{}
+
+func (c *poolUsageCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "pool-usage",
+		Usage: "pool-usage <pool>",
+		Desc: `Shows the resources used by the apps of each team in a pool, along with the
+quota of the team in the pool. Memory is shown in bytes.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *poolUsageCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL(fmt.Sprintf("/pool/%s/usage", context.Args[0]))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode == http.StatusNoContent {
+		fmt.Fprintf(context.Stdout, "No usage found in pool %s.\n", context.Args[0])
+		return nil
+	}
+	var usages []app.PoolUsage
+	err = json.NewDecoder(response.Body).Decode(&usages)
+	if err != nil {
+		return err
+	}
+	t := cmd.Table{Headers: cmd.Row([]string{"Team", "Units", "Memory", "CPU share"})}
+	for _, usage := range usages {
+		var quota provision.PoolQuota
+		if usage.Quota != nil {
+			quota = *usage.Quota
+		}
+		t.AddRow(cmd.Row([]string{
+			usage.Team,
+			formatUsage(int64(usage.Units), int64(quota.Units)),
+			formatUsage(usage.Memory, quota.Memory),
+			formatUsage(int64(usage.CpuShare), int64(quota.CpuShare)),
+		}))
+	}
+	context.Stdout.Write(t.Bytes())
+	return nil
+}
+
+func formatUsage(used, limit int64) string {
+	if limit <= 0 {
+		return strconv.FormatInt(used, 10)
+	}
+	return fmt.Sprintf("%d/%d", used, limit)
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/cmd/cmdtest"
@@ -264,3 +265,79 @@ func (s *S) TestFixContainersCmdInfo(c *check.C) {
 	info := command.Info()
 	c.Assert(*info, check.DeepEquals, expected)
 }
+
+func (s *S) TestPoolQuotaSetCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"pool1", "team1"}, Stdout: &buf, Stderr: &buf}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var quota map[string]interface{}
+			json.NewDecoder(req.Body).Decode(&quota)
+			expected := map[string]interface{}{"team": "team1", "memory": 2048.0, "cpushare": 0.0, "units": 5.0}
+			return req.URL.Path == "/pool/pool1/quota" && req.Method == "POST" && reflect.DeepEqual(quota, expected)
+		},
+	}
+	manager := cmd.NewManager("admin", "0.1", "admin-ver", &buf, &buf, nil, nil)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := poolQuotaSetCmd{}
+	err := command.Flags().Parse(true, []string{"-m", "2048", "--units", "5"})
+	c.Assert(err, check.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "Quota of team team1 in pool pool1 successfully set.\n")
+}
+
+func (s *S) TestPoolQuotaRemoveCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"pool1", "team1"}, Stdout: &buf, Stderr: &buf}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/pool/pool1/quota/team1" && req.Method == "DELETE"
+		},
+	}
+	manager := cmd.NewManager("admin", "0.1", "admin-ver", &buf, &buf, nil, nil)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := poolQuotaRemoveCmd{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "Quota of team team1 in pool pool1 successfully removed.\n")
+}
+
+func (s *S) TestPoolUsageCmdRun(c *check.C) {
+	result := `[{"Pool":"pool1","Team":"team1","Memory":1024,"CpuShare":200,"Units":2,"Quota":{"Team":"team1","Memory":4096,"CpuShare":0,"Units":4}},{"Pool":"pool1","Team":"team2","Memory":512,"CpuShare":0,"Units":1,"Quota":null}]`
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"pool1"}, Stdout: &buf, Stderr: &buf}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/pool/pool1/usage" && req.Method == "GET"
+		},
+	}
+	manager := cmd.NewManager("admin", "0.1", "admin-ver", &buf, &buf, nil, nil)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := poolUsageCmd{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `+-------+-------+-----------+-----------+
+| Team  | Units | Memory    | CPU share |
++-------+-------+-----------+-----------+
+| team1 | 2/4   | 1024/4096 | 200       |
+| team2 | 1     | 512       | 0         |
++-------+-------+-----------+-----------+
+`
+	c.Assert(buf.String(), check.Equals, expected)
+}
+
+func (s *S) TestPoolUsageCmdRunNoUsage(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"pool1"}, Stdout: &buf, Stderr: &buf}
+	trans := &cmdtest.Transport{Message: "", Status: http.StatusNoContent}
+	manager := cmd.NewManager("admin", "0.1", "admin-ver", &buf, &buf, nil, nil)
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, manager)
+	command := poolUsageCmd{}
+	err := command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "No usage found in pool pool1.\n")
+}
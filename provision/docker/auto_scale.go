@@ -34,10 +34,17 @@ type autoScaleConfig struct {
 	WaitTimeNewMachine  time.Duration
 	RunInterval         time.Duration
 	TotalMemoryMetadata string
+	TotalCPUMetadata    string
 	Enabled             bool
 	provisioner         *dockerProvisioner
 	done                chan bool
 	writer              io.Writer
+	// dryRun is set when simulating auto scale runs, scalers must not
+	// store any state.
+	dryRun bool
+	// cpuUsage overrides the CPU usage sampled from the nodes, used by
+	// tests.
+	cpuUsage func(node *cluster.Node) (float64, error)
 }
 
 type scalerResult struct {
@@ -65,6 +72,9 @@ func (a *autoScaleConfig) initialize() {
 	if a.TotalMemoryMetadata == "" {
 		a.TotalMemoryMetadata, _ = config.GetString("docker:scheduler:total-memory-metadata")
 	}
+	if a.TotalCPUMetadata == "" {
+		a.TotalCPUMetadata, _ = config.GetString("docker:scheduler:total-cpu-metadata")
+	}
 	if a.RunInterval == 0 {
 		a.RunInterval = time.Hour
 	}
@@ -77,9 +87,19 @@ func (a *autoScaleConfig) scalerForRule(rule *autoScaleRule) (autoScaler, error)
 	if rule.MaxContainerCount > 0 {
 		return &countScaler{autoScaleConfig: a, rule: rule}, nil
 	}
+	if rule.CPUScaleUpThreshold > 0 {
+		return &cpuScaler{autoScaleConfig: a, rule: rule}, nil
+	}
 	return &memoryScaler{autoScaleConfig: a, rule: rule}, nil
 }
 
+func (a *autoScaleConfig) nodeCPUUsage(node *cluster.Node) (float64, error) {
+	if a.cpuUsage != nil {
+		return a.cpuUsage(node)
+	}
+	return a.provisioner.nodeCPUUsage(node, a.TotalCPUMetadata)
+}
+
 func (a *autoScaleConfig) run() error {
 	a.initialize()
 	for {
@@ -141,6 +161,10 @@ func (a *autoScaleConfig) runScaler() (retErr error) {
 	clusterMap := map[string][]*cluster.Node{}
 	for i := range nodes {
 		node := &nodes[i]
+		if isCordoned(node) {
+			a.logDebug("skipped node %s, node is cordoned.", node.Address)
+			continue
+		}
 		if a.GroupByMetadata == "" {
 			clusterMap[""] = append(clusterMap[""], node)
 			continue
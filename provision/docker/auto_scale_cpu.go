@@ -0,0 +1,201 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// cpuScaler adds and removes nodes based on the CPU usage of the nodes in
+// the group, averaged over the window defined in the rule. Nodes are added
+// when the usage is above CPUScaleUpThreshold and removed when it's below
+// CPUScaleDownThreshold, respecting the cooldowns after the last scale
+// event.
+type cpuScaler struct {
+	*autoScaleConfig
+	rule *autoScaleRule
+}
+
+type cpuSample struct {
+	MetadataValue string
+	Time          time.Time
+	Usage         float64
+}
+
+func autoScaleCPUSampleCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(fmt.Sprintf("%s_auto_scale_cpu", name)), nil
+}
+
+// nodeCPUUsage returns the CPU usage of the units running in the node, as a
+// percentage of the CPUs of the node.
+func (p *dockerProvisioner) nodeCPUUsage(node *cluster.Node, TotalCPUMetadata string) (float64, error) {
+	containers, err := p.listRunningContainersByHost(urlToHost(node.Address))
+	if err != nil {
+		return 0, err
+	}
+	if len(containers) == 0 {
+		return 0, nil
+	}
+	var usage float64
+	for i := range containers {
+		metrics, err := p.containerMetrics(&containers[i])
+		if err != nil {
+			return 0, err
+		}
+		usage += metrics.CPUPercent
+	}
+	shares, err := nodeCPUShares(node, TotalCPUMetadata)
+	if err != nil {
+		return 0, err
+	}
+	if shares == 0 {
+		return 0, fmt.Errorf("no CPU information found for node %s", node.Address)
+	}
+	return usage / (float64(shares) / cpuSharesPerCore), nil
+}
+
+// sample stores the current CPU usage of the group and returns the average
+// usage in the window of the rule. In dry run mode the current usage is only
+// considered in the average, nothing is stored.
+func (a *cpuScaler) sample(groupMetadata string, nodes []*cluster.Node) (float64, error) {
+	var total float64
+	for _, node := range nodes {
+		usage, err := a.nodeCPUUsage(node)
+		if err != nil {
+			return 0, fmt.Errorf("unable to get CPU usage of node %s: %s", node.Address, err)
+		}
+		total += usage
+	}
+	coll, err := autoScaleCPUSampleCollection()
+	if err != nil {
+		return 0, err
+	}
+	defer coll.Close()
+	now := time.Now().UTC()
+	current := cpuSample{
+		MetadataValue: groupMetadata,
+		Time:          now,
+		Usage:         total / float64(len(nodes)),
+	}
+	windowStart := now.Add(-time.Duration(a.rule.CPUWindow) * time.Second)
+	if !a.dryRun {
+		err = coll.Insert(current)
+		if err != nil {
+			return 0, err
+		}
+		_, err = coll.RemoveAll(bson.M{"metadatavalue": groupMetadata, "time": bson.M{"$lt": windowStart}})
+		if err != nil {
+			return 0, err
+		}
+	}
+	var samples []cpuSample
+	err = coll.Find(bson.M{"metadatavalue": groupMetadata, "time": bson.M{"$gte": windowStart}}).All(&samples)
+	if err != nil {
+		return 0, err
+	}
+	if a.dryRun {
+		samples = append(samples, current)
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += s.Usage
+	}
+	return sum / float64(len(samples)), nil
+}
+
+// coolingDown reports whether the last successful add or remove event of the
+// group ended less than cooldown seconds ago.
+func (a *cpuScaler) coolingDown(groupMetadata string, cooldown int) (bool, error) {
+	if cooldown <= 0 {
+		return false, nil
+	}
+	coll, err := autoScaleCollection()
+	if err != nil {
+		return false, err
+	}
+	defer coll.Close()
+	var evt autoScaleEvent
+	err = coll.Find(bson.M{
+		"metadatavalue": groupMetadata,
+		"successful":    true,
+		"action":        bson.M{"$in": []string{scaleActionAdd, scaleActionRemove}},
+	}).Sort("-endtime").One(&evt)
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return time.Since(evt.EndTime) < time.Duration(cooldown)*time.Second, nil
+}
+
+func (a *cpuScaler) scale(groupMetadata string, nodes []*cluster.Node) (*scalerResult, error) {
+	usage, err := a.sample(groupMetadata, nodes)
+	if err != nil {
+		return nil, err
+	}
+	window := time.Duration(a.rule.CPUWindow) * time.Second
+	nodeCount := float64(len(nodes))
+	if usage > float64(a.rule.CPUScaleUpThreshold) {
+		cooling, err := a.coolingDown(groupMetadata, a.rule.ScaleUpCooldown)
+		if err != nil {
+			return nil, err
+		}
+		if cooling {
+			a.logDebug("would add nodes to %q, but it's cooling down", groupMetadata)
+			return nil, nil
+		}
+		nodesToAdd := int(math.Ceil(nodeCount*usage/float64(a.rule.CPUScaleUpThreshold))) - len(nodes)
+		if nodesToAdd < 1 {
+			nodesToAdd = 1
+		}
+		return &scalerResult{
+			toAdd:  nodesToAdd,
+			reason: fmt.Sprintf("average CPU usage in the last %s is %.2f%%, above %.2f%%", window, usage, a.rule.CPUScaleUpThreshold),
+		}, nil
+	}
+	if usage >= float64(a.rule.CPUScaleDownThreshold) || len(nodes) < 2 {
+		return nil, nil
+	}
+	// Removing a node must not take the usage of the remaining nodes above
+	// the scale up threshold.
+	if nodeCount*usage/(nodeCount-1) >= float64(a.rule.CPUScaleUpThreshold) {
+		return nil, nil
+	}
+	cooling, err := a.coolingDown(groupMetadata, a.rule.ScaleDownCooldown)
+	if err != nil {
+		return nil, err
+	}
+	if cooling {
+		a.logDebug("would remove nodes from %q, but it's cooling down", groupMetadata)
+		return nil, nil
+	}
+	chosenNodes := chooseNodeForRemoval(nodes, 1)
+	if len(chosenNodes) == 0 {
+		a.logDebug("would remove any node but can't due to metadata restrictions")
+		return nil, nil
+	}
+	return &scalerResult{
+		toRemove: chosenNodes,
+		reason:   fmt.Sprintf("average CPU usage in the last %s is %.2f%%, below %.2f%%", window, usage, a.rule.CPUScaleDownThreshold),
+	}, nil
+}
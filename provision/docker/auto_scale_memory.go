@@ -25,6 +25,12 @@ type nodeMemoryData struct {
 	containersMemory map[string]int64
 }
 
+type nodeCPUData struct {
+	maxShares int64
+	reserved  int64
+	available int64
+}
+
 func (a *memoryScaler) nodesMemoryData(nodes []*cluster.Node) (map[string]*nodeMemoryData, error) {
 	nodesMemoryData := make(map[string]*nodeMemoryData)
 	containersMap, err := a.provisioner.runningContainersByNode(nodes)
@@ -56,7 +62,60 @@ func (a *memoryScaler) nodesMemoryData(nodes []*cluster.Node) (map[string]*nodeM
 	return nodesMemoryData, nil
 }
 
-func (a *memoryScaler) chooseNodeForRemoval(maxPlanMemory int64, groupMetadata string, nodes []*cluster.Node) ([]cluster.Node, error) {
+// usesCPU reports whether the CPU reserved by the plans of the apps is also
+// taken into account, besides the reserved memory.
+func (a *memoryScaler) usesCPU() bool {
+	return a.rule.MaxCPURatio > 0
+}
+
+func (a *memoryScaler) nodesCPUData(nodes []*cluster.Node) (map[string]*nodeCPUData, error) {
+	nodesCPUData := make(map[string]*nodeCPUData)
+	containersMap, err := a.provisioner.runningContainersByNode(nodes)
+	if err != nil {
+		return nil, err
+	}
+	for _, node := range nodes {
+		totalShares, err := nodeCPUShares(node, a.TotalCPUMetadata)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the amount of CPUs in node %s: %s", node.Address, err)
+		}
+		if totalShares == 0 {
+			return nil, fmt.Errorf("no value found for CPU metadata (%s) in node %s", a.TotalCPUMetadata, node.Address)
+		}
+		data := &nodeCPUData{maxShares: int64(float64(a.rule.MaxCPURatio) * float64(totalShares))}
+		nodesCPUData[node.Address] = data
+		for _, cont := range containersMap[node.Address] {
+			a, err := app.GetByName(cont.AppName)
+			if err != nil {
+				return nil, fmt.Errorf("couldn't find container app (%s): %s", cont.AppName, err)
+			}
+			data.reserved += int64(a.Plan.CpuShare)
+		}
+		data.available = data.maxShares - data.reserved
+	}
+	return nodesCPUData, nil
+}
+
+func (a *memoryScaler) cpuNodesToRemove(maxPlanCPU int64, nodes []*cluster.Node) (int, error) {
+	cpuData, err := a.nodesCPUData(nodes)
+	if err != nil {
+		return 0, err
+	}
+	var totalReserved, totalShares int64
+	for _, node := range nodes {
+		data := cpuData[node.Address]
+		totalReserved += data.reserved
+		totalShares += data.maxShares
+	}
+	sharesPerNode := totalShares / int64(len(nodes))
+	if sharesPerNode == 0 {
+		return 0, nil
+	}
+	scaledMaxPlan := int64(float32(maxPlanCPU) * a.rule.ScaleDownRatio)
+	return len(nodes) - int(((totalReserved+scaledMaxPlan)/sharesPerNode)+1), nil
+}
+
+func (a *memoryScaler) chooseNodeForRemoval(maxPlanMemory, maxPlanCPU int64, groupMetadata string, nodes []*cluster.Node) ([]cluster.Node, error) {
 	memoryData, err := a.nodesMemoryData(nodes)
 	if err != nil {
 		return nil, err
@@ -73,6 +132,18 @@ func (a *memoryScaler) chooseNodeForRemoval(maxPlanMemory int64, groupMetadata s
 	if toRemoveCount <= 0 {
 		return nil, nil
 	}
+	if a.usesCPU() {
+		cpuToRemoveCount, err := a.cpuNodesToRemove(maxPlanCPU, nodes)
+		if err != nil {
+			return nil, err
+		}
+		if cpuToRemoveCount < toRemoveCount {
+			toRemoveCount = cpuToRemoveCount
+		}
+		if toRemoveCount <= 0 {
+			return nil, nil
+		}
+	}
 	chosenNodes := chooseNodeForRemoval(nodes, toRemoveCount)
 	if len(chosenNodes) == 0 {
 		return nil, nil
@@ -85,21 +156,29 @@ func (a *memoryScaler) scale(groupMetadata string, nodes []*cluster.Node) (*scal
 	if err != nil {
 		return nil, fmt.Errorf("couldn't list plans: %s", err)
 	}
-	var maxPlanMemory int64
+	var maxPlanMemory, maxPlanCPU int64
 	for _, plan := range plans {
 		if plan.Memory > maxPlanMemory {
 			maxPlanMemory = plan.Memory
 		}
+		if int64(plan.CpuShare) > maxPlanCPU {
+			maxPlanCPU = int64(plan.CpuShare)
+		}
 	}
-	if maxPlanMemory == 0 {
+	if maxPlanMemory == 0 || maxPlanCPU == 0 {
 		var defaultPlan *app.Plan
 		defaultPlan, err = app.DefaultPlan()
 		if err != nil {
 			return nil, fmt.Errorf("couldn't get default plan: %s", err)
 		}
-		maxPlanMemory = defaultPlan.Memory
+		if maxPlanMemory == 0 {
+			maxPlanMemory = defaultPlan.Memory
+		}
+		if maxPlanCPU == 0 {
+			maxPlanCPU = int64(defaultPlan.CpuShare)
+		}
 	}
-	chosenNodes, err := a.chooseNodeForRemoval(maxPlanMemory, groupMetadata, nodes)
+	chosenNodes, err := a.chooseNodeForRemoval(maxPlanMemory, maxPlanCPU, groupMetadata, nodes)
 	if err != nil {
 		return nil, fmt.Errorf("unable to choose node for removal: %s", err)
 	}
@@ -109,6 +188,21 @@ func (a *memoryScaler) scale(groupMetadata string, nodes []*cluster.Node) (*scal
 			reason:   fmt.Sprintf("containers can be distributed in only %d nodes", len(nodes)-len(chosenNodes)),
 		}, nil
 	}
+	result, err := a.memoryNodesToAdd(maxPlanMemory, nodes)
+	if err != nil || !a.usesCPU() {
+		return result, err
+	}
+	cpuResult, err := a.cpuNodesToAdd(maxPlanCPU, nodes)
+	if err != nil {
+		return nil, err
+	}
+	if cpuResult != nil && (result == nil || cpuResult.toAdd > result.toAdd) {
+		return cpuResult, nil
+	}
+	return result, nil
+}
+
+func (a *memoryScaler) memoryNodesToAdd(maxPlanMemory int64, nodes []*cluster.Node) (*scalerResult, error) {
 	memoryData, err := a.nodesMemoryData(nodes)
 	if err != nil {
 		return nil, err
@@ -139,3 +233,30 @@ func (a *memoryScaler) scale(groupMetadata string, nodes []*cluster.Node) (*scal
 		reason: fmt.Sprintf("can't add %d bytes to an existing node", maxPlanMemory),
 	}, nil
 }
+
+func (a *memoryScaler) cpuNodesToAdd(maxPlanCPU int64, nodes []*cluster.Node) (*scalerResult, error) {
+	cpuData, err := a.nodesCPUData(nodes)
+	if err != nil {
+		return nil, err
+	}
+	var totalReserved, totalShares int64
+	for _, node := range nodes {
+		data := cpuData[node.Address]
+		if maxPlanCPU > data.maxShares {
+			return nil, fmt.Errorf("aborting, impossible to fit max plan CPU of %d shares, node max available CPU is %d shares", maxPlanCPU, data.maxShares)
+		}
+		totalReserved += data.reserved
+		totalShares += data.maxShares
+		if data.available >= maxPlanCPU {
+			return nil, nil
+		}
+	}
+	nodesToAdd := int((totalReserved + maxPlanCPU) / totalShares)
+	if nodesToAdd == 0 {
+		return nil, nil
+	}
+	return &scalerResult{
+		toAdd:  nodesToAdd,
+		reason: fmt.Sprintf("can't add %d CPU shares to an existing node", maxPlanCPU),
+	}, nil
+}
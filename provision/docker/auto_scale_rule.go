@@ -21,7 +21,16 @@ type autoScaleRule struct {
 	ScaleDownRatio    float32
 	PreventRebalance  bool
 	MaxMemoryRatio    float32
-	Error             string `bson:"-"`
+	MaxCPURatio       float32
+	// CPUScaleUpThreshold and CPUScaleDownThreshold are percentages of the
+	// CPU usage of the nodes, averaged over CPUWindow seconds, used by the
+	// CPU based scaler. Cooldowns are in seconds.
+	CPUScaleUpThreshold   float32
+	CPUScaleDownThreshold float32
+	CPUWindow             int
+	ScaleUpCooldown       int
+	ScaleDownCooldown     int
+	Error                 string `bson:"-"`
 }
 
 type autoScaleRuleList []autoScaleRule
@@ -42,8 +51,22 @@ func (r *autoScaleRule) normalize() error {
 		maxMemoryRatio, _ := config.GetFloat("docker:scheduler:max-used-memory")
 		r.MaxMemoryRatio = float32(maxMemoryRatio)
 	}
+	if r.MaxCPURatio == 0.0 {
+		maxCPURatio, _ := config.GetFloat("docker:scheduler:max-used-cpu")
+		r.MaxCPURatio = float32(maxCPURatio)
+	}
+	if r.CPUScaleUpThreshold > 0 {
+		if r.CPUScaleUpThreshold > 100 || r.CPUScaleDownThreshold < 0 || r.CPUScaleDownThreshold >= r.CPUScaleUpThreshold {
+			err := fmt.Errorf("invalid rule, CPU thresholds must satisfy 0 <= scale down < scale up <= 100, got %f and %f", r.CPUScaleDownThreshold, r.CPUScaleUpThreshold)
+			r.Error = err.Error()
+			return err
+		}
+		if r.CPUWindow <= 0 {
+			r.CPUWindow = 300
+		}
+	}
 	TotalMemoryMetadata, _ := config.GetString("docker:scheduler:total-memory-metadata")
-	if r.Enabled && r.MaxContainerCount <= 0 && (TotalMemoryMetadata == "" || r.MaxMemoryRatio <= 0) {
+	if r.Enabled && r.MaxContainerCount <= 0 && r.CPUScaleUpThreshold <= 0 && (TotalMemoryMetadata == "" || r.MaxMemoryRatio <= 0) {
 		err := fmt.Errorf("invalid rule, either memory information or max container count must be set")
 		r.Error = err.Error()
 		return err
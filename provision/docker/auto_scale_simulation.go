@@ -0,0 +1,290 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/safe"
+	"gopkg.in/mgo.v2"
+)
+
+// autoScaleSimulationOptions describes the data used to simulate an auto
+// scale run for a group of nodes. Rule, Nodes and Containers are optional,
+// the stored rule and the current nodes and containers are used when they're
+// not set. CPUUsage maps node addresses to their CPU usage, and must be set
+// for CPU based rules when the nodes or containers are hypothetical.
+type autoScaleSimulationOptions struct {
+	MetadataValue string
+	Rule          *autoScaleRule
+	Nodes         []cluster.Node
+	Containers    []container.Container
+	CPUUsage      map[string]float64
+}
+
+// autoScaleSimulationResult holds what an auto scale run would do, using
+// the same actions and reasons recorded in auto scale events.
+type autoScaleSimulationResult struct {
+	MetadataValue string
+	Scaler        string
+	Action        string
+	Reason        string
+	ToAdd         int
+	ToRemove      []cluster.Node
+	Moves         []string
+}
+
+// simulationProvisioner returns a provisioner in dry mode, with its own
+// copy of the nodes and containers, so running the scalers and moving units
+// on it never touches the cluster.
+func (p *dockerProvisioner) simulationProvisioner(nodes []cluster.Node, containers []container.Container) (*dockerProvisioner, error) {
+	var err error
+	if nodes == nil {
+		nodes, err = p.Cluster().UnfilteredNodes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if containers == nil {
+		containers, err = p.listAllContainers()
+		if err != nil {
+			return nil, err
+		}
+	}
+	simProvisioner := &dockerProvisioner{
+		collectionName: "containers_dry_" + randomString(),
+		isDryMode:      true,
+		storage:        &cluster.MapStorage{},
+	}
+	simProvisioner.scheduler = &segregatedScheduler{
+		maxMemoryRatio:      p.scheduler.maxMemoryRatio,
+		TotalMemoryMetadata: p.scheduler.TotalMemoryMetadata,
+		maxCPURatio:         p.scheduler.maxCPURatio,
+		TotalCPUMetadata:    p.scheduler.TotalCPUMetadata,
+		provisioner:         simProvisioner,
+	}
+	simProvisioner.cluster, err = cluster.New(simProvisioner.scheduler, simProvisioner.storage, nodes...)
+	if err != nil {
+		return nil, err
+	}
+	simProvisioner.cluster.DryMode()
+	coll := simProvisioner.Collection()
+	defer coll.Close()
+	toInsert := make([]interface{}, len(containers))
+	for i := range containers {
+		toInsert[i] = containers[i]
+	}
+	if len(toInsert) > 0 {
+		err = coll.Insert(toInsert...)
+		if err != nil {
+			simProvisioner.stopDryMode()
+			return nil, err
+		}
+	}
+	return simProvisioner, nil
+}
+
+func scalerName(scaler autoScaler) string {
+	switch scaler.(type) {
+	case *countScaler:
+		return "count"
+	case *cpuScaler:
+		return "cpu"
+	}
+	return "memory"
+}
+
+func movesFromLog(log string) []string {
+	var moves []string
+	for _, line := range strings.Split(log, "\n") {
+		if strings.HasPrefix(line, "Would move unit") {
+			moves = append(moves, line)
+		}
+	}
+	return moves
+}
+
+// simulateRebalance moves the units in the nodes matching the filter, like
+// rebalanceContainersByFilter does, but in the simulation provisioner itself,
+// so the units can be counted after the moves.
+func (p *dockerProvisioner) simulateRebalance(metadataFilter map[string]string, w io.Writer) error {
+	var hostsFilter []string
+	if metadataFilter != nil {
+		nodes, err := p.Cluster().UnfilteredNodesForMetadata(metadataFilter)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			hostsFilter = append(hostsFilter, urlToHost(n.Address))
+		}
+	}
+	containers, err := p.listContainersByAppAndHost(nil, hostsFilter)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		return nil
+	}
+	p.scheduler.ignoredContainers = make([]string, len(containers))
+	for i := range containers {
+		p.scheduler.ignoredContainers[i] = containers[i].ID
+	}
+	return p.moveContainerList(containers, "", w)
+}
+
+// simulateAutoScale runs the auto scaler for a group of nodes and returns
+// what it would do: the nodes that would be added or removed and the units
+// that would be moved. Nothing is changed in the cluster or in the IaaS.
+func (p *dockerProvisioner) simulateAutoScale(opts autoScaleSimulationOptions) (*autoScaleSimulationResult, error) {
+	a := p.initAutoScaleConfig()
+	a.initialize()
+	a.dryRun = true
+	rule := opts.Rule
+	if rule != nil {
+		rule.MetadataFilter = opts.MetadataValue
+		err := rule.normalize()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		rule, err = autoScaleRuleForMetadata(opts.MetadataValue)
+		if err == mgo.ErrNotFound {
+			rule, err = autoScaleRuleForMetadata("")
+		}
+		if err == mgo.ErrNotFound {
+			return nil, fmt.Errorf("no auto scale rule for %s", opts.MetadataValue)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	simProvisioner, err := p.simulationProvisioner(opts.Nodes, opts.Containers)
+	if err != nil {
+		return nil, err
+	}
+	defer simProvisioner.stopDryMode()
+	a.provisioner = simProvisioner
+	if opts.CPUUsage != nil {
+		a.cpuUsage = func(node *cluster.Node) (float64, error) {
+			usage, ok := opts.CPUUsage[node.Address]
+			if !ok {
+				return 0, fmt.Errorf("no CPU usage informed for node %s", node.Address)
+			}
+			return usage, nil
+		}
+	}
+	allNodes, err := simProvisioner.Cluster().Nodes()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []*cluster.Node
+	for i := range allNodes {
+		node := &allNodes[i]
+		if isCordoned(node) {
+			continue
+		}
+		if a.GroupByMetadata != "" && node.Metadata[a.GroupByMetadata] != opts.MetadataValue {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes found for %q: %q", a.GroupByMetadata, opts.MetadataValue)
+	}
+	scaler, err := a.scalerForRule(rule)
+	if err != nil {
+		return nil, err
+	}
+	result := &autoScaleSimulationResult{
+		MetadataValue: opts.MetadataValue,
+		Scaler:        scalerName(scaler),
+	}
+	scalerResult, err := scaler.scale(opts.MetadataValue, nodes)
+	if err != nil {
+		return nil, err
+	}
+	var rebalanceFilter map[string]string
+	if a.GroupByMetadata != "" {
+		rebalanceFilter = map[string]string{a.GroupByMetadata: opts.MetadataValue}
+	}
+	buf := safe.NewBuffer(nil)
+	if scalerResult != nil && scalerResult.toAdd > 0 {
+		result.Action = scaleActionAdd
+		result.ToAdd = scalerResult.toAdd
+		result.Reason = fmt.Sprintf("%s, adding %d nodes", scalerResult.reason, scalerResult.toAdd)
+		if rule.PreventRebalance {
+			return result, nil
+		}
+		// The new nodes are represented by placeholders, which receive
+		// units in the simulated rebalance.
+		metadata, err := chooseMetadataFromNodes(nodes)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < scalerResult.toAdd; i++ {
+			err = simProvisioner.Cluster().Register(cluster.Node{
+				Address:  fmt.Sprintf("http://simulated-node-%d:2375", i+1),
+				Metadata: metadata,
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		err = simProvisioner.simulateRebalance(rebalanceFilter, buf)
+		if err != nil {
+			return nil, fmt.Errorf("unable to simulate rebalance: %s - log: %s", err, buf.String())
+		}
+		result.Moves = movesFromLog(buf.String())
+		return result, nil
+	}
+	if scalerResult != nil && len(scalerResult.toRemove) > 0 {
+		result.Action = scaleActionRemove
+		result.ToRemove = scalerResult.toRemove
+		result.Reason = fmt.Sprintf("%s, removing %d nodes", scalerResult.reason, len(scalerResult.toRemove))
+		nodeAddrs := make([]string, len(scalerResult.toRemove))
+		nodeHosts := make([]string, len(scalerResult.toRemove))
+		for i, node := range scalerResult.toRemove {
+			nodeAddrs[i] = node.Address
+			nodeHosts[i] = urlToHost(node.Address)
+		}
+		err = simProvisioner.Cluster().UnregisterNodes(nodeAddrs...)
+		if err != nil {
+			return nil, err
+		}
+		err = simProvisioner.moveContainersFromHosts(nodeHosts, "", buf)
+		if err != nil {
+			return nil, fmt.Errorf("unable to simulate moving units from removed nodes: %s - log: %s", err, buf.String())
+		}
+		result.Moves = movesFromLog(buf.String())
+		return result, nil
+	}
+	if rule.PreventRebalance {
+		return result, nil
+	}
+	_, gap, err := simProvisioner.containerGapInNodes(nodes)
+	if err != nil {
+		return nil, err
+	}
+	err = simProvisioner.simulateRebalance(rebalanceFilter, buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to simulate rebalance: %s - log: %s", err, buf.String())
+	}
+	_, gapAfter, err := simProvisioner.containerGapInNodes(nodes)
+	if err != nil {
+		return nil, err
+	}
+	if math.Abs((float64)(gap-gapAfter)) > 2.0 {
+		result.Action = scaleActionRebalance
+		result.Reason = fmt.Sprintf("gap is %d, after rebalance gap will be %d", gap, gapAfter)
+		result.Moves = movesFromLog(buf.String())
+	}
+	return result, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/config"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/check.v1"
+)
+
+func (s *AutoScaleSuite) TestSimulateAutoScale(c *check.C) {
+	config.Set("docker:auto-scale:group-by-metadata", "pool")
+	defer config.Unset("docker:auto-scale:group-by-metadata")
+	_, err := addContainersWithHost(&changeUnitsPipelineArgs{
+		toAdd:       map[string]*containersToAdd{"web": {Quantity: 4}},
+		app:         s.appInstance,
+		imageId:     s.imageId,
+		provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	result, err := s.p.simulateAutoScale(autoScaleSimulationOptions{MetadataValue: "pool1"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result.MetadataValue, check.Equals, "pool1")
+	c.Assert(result.Scaler, check.Equals, "count")
+	c.Assert(result.Action, check.Equals, "add")
+	c.Assert(result.ToAdd, check.Equals, 1)
+	c.Assert(result.Reason, check.Equals, "number of free slots is -2, adding 1 nodes")
+	c.Assert(result.Moves, check.HasLen, 4)
+	for _, move := range result.Moves {
+		c.Assert(move, check.Matches, `Would move unit .* for "myapp" from 127.0.0.1 -> .*`)
+	}
+	nodes, err := s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	evts, err := listAutoScaleEvents(0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+	containers, err := s.p.listContainersByHost("127.0.0.1")
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 4)
+}
+
+func (s *AutoScaleSuite) TestSimulateAutoScaleHypotheticalData(c *check.C) {
+	config.Set("docker:auto-scale:group-by-metadata", "pool")
+	defer config.Unset("docker:auto-scale:group-by-metadata")
+	metadata := map[string]string{"pool": "pool1", "iaas": "my-scale-iaas"}
+	result, err := s.p.simulateAutoScale(autoScaleSimulationOptions{
+		MetadataValue: "pool1",
+		Rule:          &autoScaleRule{Enabled: true, MaxContainerCount: 4},
+		Nodes: []cluster.Node{
+			{Address: "http://n1:2375", Metadata: metadata},
+			{Address: "http://n2:2375", Metadata: metadata},
+		},
+		Containers: []container.Container{},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Scaler, check.Equals, "count")
+	c.Assert(result.Action, check.Equals, "remove")
+	c.Assert(result.Reason, check.Equals, "number of free slots is 8, removing 1 nodes")
+	c.Assert(result.ToRemove, check.HasLen, 1)
+	c.Assert(result.Moves, check.HasLen, 0)
+	nodes, err := s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	evts, err := listAutoScaleEvents(0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
+func (s *AutoScaleSuite) TestSimulateAutoScaleCPUUsage(c *check.C) {
+	config.Set("docker:auto-scale:group-by-metadata", "pool")
+	defer config.Unset("docker:auto-scale:group-by-metadata")
+	nodes, err := s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	result, err := s.p.simulateAutoScale(autoScaleSimulationOptions{
+		MetadataValue: "pool1",
+		Rule: &autoScaleRule{
+			Enabled:               true,
+			PreventRebalance:      true,
+			CPUScaleUpThreshold:   50,
+			CPUScaleDownThreshold: 10,
+		},
+		CPUUsage: map[string]float64{nodes[0].Address: 90},
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Scaler, check.Equals, "cpu")
+	c.Assert(result.Action, check.Equals, "add")
+	c.Assert(result.ToAdd, check.Equals, 1)
+	c.Assert(result.Reason, check.Equals, "average CPU usage in the last 5m0s is 90.00%, above 50.00%, adding 1 nodes")
+	coll, err := autoScaleCPUSampleCollection()
+	c.Assert(err, check.IsNil)
+	defer coll.Close()
+	n, err := coll.Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestSimulateAutoScaleInvalidRule(c *check.C) {
+	_, err := s.p.simulateAutoScale(autoScaleSimulationOptions{
+		MetadataValue: "pool1",
+		Rule:          &autoScaleRule{Enabled: true, MaxContainerCount: 2, ScaleDownRatio: 0.5},
+	})
+	c.Assert(err, check.ErrorMatches, "invalid rule, scale down ratio needs to be greater than 1.0, got 0.500000")
+}
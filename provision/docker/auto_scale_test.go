@@ -22,6 +22,7 @@ import (
 	"github.com/tsuru/tsuru/provision/docker/dockertest"
 	"github.com/tsuru/tsuru/provision/provisiontest"
 	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
 )
 
 type AutoScaleSuite struct {
@@ -665,6 +666,203 @@ func (s *AutoScaleSuite) TestAutoScaleConfigRunMemoryBased(c *check.C) {
 	c.Assert(locked, check.Equals, true)
 }
 
+func (s *AutoScaleSuite) TestAutoScaleConfigRunCPUBased(c *check.C) {
+	config.Set("docker:scheduler:max-used-memory", 10)
+	config.Set("docker:scheduler:max-used-cpu", 1.0)
+	config.Unset("docker:auto-scale:max-container-count")
+	defer config.Unset("docker:scheduler:max-used-memory")
+	defer config.Unset("docker:scheduler:max-used-cpu")
+	config.Set("docker:scheduler:total-memory-metadata", "totalMem")
+	defer config.Unset("docker:scheduler:total-memory-metadata")
+	config.Set("docker:scheduler:total-cpu-metadata", "cpus")
+	defer config.Unset("docker:scheduler:total-cpu-metadata")
+	nodes, err := s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	nodes[0].Metadata["cpus"] = "0.05"
+	_, err = s.p.cluster.UpdateNode(nodes[0])
+	c.Assert(err, check.IsNil)
+	err = s.S.storage.Apps().Update(bson.M{"name": s.appInstance.GetName()}, bson.M{"$set": bson.M{"plan.cpushare": 10}})
+	c.Assert(err, check.IsNil)
+	_, err = addContainersWithHost(&changeUnitsPipelineArgs{
+		toAdd:       map[string]*containersToAdd{"web": {Quantity: 5}},
+		app:         s.appInstance,
+		imageId:     s.imageId,
+		provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	a := autoScaleConfig{
+		done:            make(chan bool),
+		provisioner:     s.p,
+		GroupByMetadata: "pool",
+	}
+	a.runOnce()
+	nodes, err = s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 2)
+	evts, err := listAutoScaleEvents(0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].MetadataValue, check.Equals, "pool1")
+	c.Assert(evts[0].Action, check.Equals, "add")
+	c.Assert(evts[0].Successful, check.Equals, true)
+	c.Assert(evts[0].Error, check.Equals, "")
+	c.Assert(evts[0].Reason, check.Equals, "can't add 10 CPU shares to an existing node, adding 1 nodes")
+}
+
+func (s *AutoScaleSuite) insertCPURule(c *check.C, rule autoScaleRule) {
+	config.Unset("docker:auto-scale:max-container-count")
+	coll, err := autoScaleRuleCollection()
+	c.Assert(err, check.IsNil)
+	defer coll.Close()
+	err = coll.Insert(rule)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *AutoScaleSuite) TestAutoScaleConfigRunCPUUsageBased(c *check.C) {
+	s.insertCPURule(c, autoScaleRule{
+		MetadataFilter:        "pool1",
+		Enabled:               true,
+		PreventRebalance:      true,
+		CPUScaleUpThreshold:   80,
+		CPUScaleDownThreshold: 20,
+	})
+	a := autoScaleConfig{
+		done:            make(chan bool),
+		provisioner:     s.p,
+		GroupByMetadata: "pool",
+		cpuUsage: func(node *cluster.Node) (float64, error) {
+			return 95, nil
+		},
+	}
+	a.runOnce()
+	nodes, err := s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 2)
+	evts, err := listAutoScaleEvents(0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].MetadataValue, check.Equals, "pool1")
+	c.Assert(evts[0].Action, check.Equals, "add")
+	c.Assert(evts[0].Successful, check.Equals, true)
+	c.Assert(evts[0].Error, check.Equals, "")
+	c.Assert(evts[0].Reason, check.Equals, "average CPU usage in the last 5m0s is 95.00%, above 80.00%, adding 1 nodes")
+}
+
+func (s *AutoScaleSuite) TestAutoScaleConfigRunCPUUsageBasedCooldown(c *check.C) {
+	s.insertCPURule(c, autoScaleRule{
+		MetadataFilter:        "pool1",
+		Enabled:               true,
+		PreventRebalance:      true,
+		CPUScaleUpThreshold:   80,
+		CPUScaleDownThreshold: 20,
+		ScaleUpCooldown:       600,
+	})
+	coll, err := autoScaleCollection()
+	c.Assert(err, check.IsNil)
+	defer coll.Close()
+	err = coll.Insert(autoScaleEvent{
+		ID:            bson.NewObjectId(),
+		MetadataValue: "pool1",
+		Action:        scaleActionAdd,
+		Successful:    true,
+		StartTime:     time.Now().UTC().Add(-2 * time.Minute),
+		EndTime:       time.Now().UTC().Add(-time.Minute),
+	})
+	c.Assert(err, check.IsNil)
+	a := autoScaleConfig{
+		done:            make(chan bool),
+		provisioner:     s.p,
+		GroupByMetadata: "pool",
+		cpuUsage: func(node *cluster.Node) (float64, error) {
+			return 95, nil
+		},
+	}
+	a.runOnce()
+	nodes, err := s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	evts, err := listAutoScaleEvents(0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+}
+
+func (s *AutoScaleSuite) TestAutoScaleConfigRunCPUUsageBasedScaleDown(c *check.C) {
+	s.insertCPURule(c, autoScaleRule{
+		MetadataFilter:        "pool1",
+		Enabled:               true,
+		PreventRebalance:      true,
+		CPUScaleUpThreshold:   80,
+		CPUScaleDownThreshold: 20,
+	})
+	otherUrl := fmt.Sprintf("http://localhost:%d/", dockertest.URLPort(s.node2.URL()))
+	node := cluster.Node{Address: otherUrl, Metadata: map[string]string{
+		"pool":     "pool1",
+		"iaas":     "my-scale-iaas",
+		"totalMem": "125000",
+	}}
+	err := s.p.cluster.Register(node)
+	c.Assert(err, check.IsNil)
+	a := autoScaleConfig{
+		done:            make(chan bool),
+		provisioner:     s.p,
+		GroupByMetadata: "pool",
+		cpuUsage: func(node *cluster.Node) (float64, error) {
+			return 10, nil
+		},
+	}
+	a.runOnce()
+	nodes, err := s.p.cluster.Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	evts, err := listAutoScaleEvents(0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 1)
+	c.Assert(evts[0].Action, check.Equals, "remove")
+	c.Assert(evts[0].Successful, check.Equals, true)
+	c.Assert(evts[0].Reason, check.Equals, "average CPU usage in the last 5m0s is 10.00%, below 20.00%, removing 1 nodes")
+}
+
+func (s *S) TestCPUScalerSampleAveragesWindow(c *check.C) {
+	coll, err := autoScaleCPUSampleCollection()
+	c.Assert(err, check.IsNil)
+	defer coll.Close()
+	now := time.Now().UTC()
+	err = coll.Insert(
+		cpuSample{MetadataValue: "pool1", Time: now.Add(-time.Minute), Usage: 40},
+		cpuSample{MetadataValue: "pool1", Time: now.Add(-time.Hour), Usage: 100},
+		cpuSample{MetadataValue: "pool2", Time: now.Add(-time.Minute), Usage: 100},
+	)
+	c.Assert(err, check.IsNil)
+	usages := map[string]float64{"http://n1:2375": 70, "http://n2:2375": 90}
+	scaler := &cpuScaler{
+		autoScaleConfig: &autoScaleConfig{
+			provisioner: s.p,
+			cpuUsage: func(node *cluster.Node) (float64, error) {
+				return usages[node.Address], nil
+			},
+		},
+		rule: &autoScaleRule{CPUWindow: 300},
+	}
+	usage, err := scaler.sample("pool1", []*cluster.Node{{Address: "http://n1:2375"}, {Address: "http://n2:2375"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(usage, check.Equals, 60.0)
+	n, err := coll.Find(bson.M{"metadatavalue": "pool1"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 2)
+}
+
+func (s *S) TestAutoScaleRuleNormalizeCPUThresholds(c *check.C) {
+	rule := autoScaleRule{Enabled: true, CPUScaleUpThreshold: 80, CPUScaleDownThreshold: 20}
+	err := rule.normalize()
+	c.Assert(err, check.IsNil)
+	c.Assert(rule.CPUWindow, check.Equals, 300)
+	rule = autoScaleRule{Enabled: true, CPUScaleUpThreshold: 50, CPUScaleDownThreshold: 60}
+	err = rule.normalize()
+	c.Assert(err, check.ErrorMatches, `invalid rule, CPU thresholds must satisfy 0 <= scale down < scale up <= 100, got 60.000000 and 50.000000`)
+	c.Assert(rule.Error, check.Equals, err.Error())
+}
+
 func (s *AutoScaleSuite) TestAutoScaleConfigRunMemoryBasedMultipleNodes(c *check.C) {
 	config.Set("docker:scheduler:max-used-memory", 0.8)
 	config.Unset("docker:auto-scale:max-container-count")
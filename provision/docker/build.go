@@ -0,0 +1,256 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	buildQueuePollInterval = time.Second
+	buildSlotTTL           = time.Minute
+)
+
+// buildNodeSlots stores the builds running in a build node, shared by every
+// tsuru API server. Each build holds a slot, renewing it while the build runs.
+// Slots not renewed before they expire belong to API servers that stopped in
+// the middle of a build, and are ignored.
+type buildNodeSlots struct {
+	Address string `bson:"_id"`
+	Slots   []buildSlotEntry
+}
+
+type buildSlotEntry struct {
+	ID    string
+	Until time.Time
+}
+
+// running returns the number of slots of the node that haven't expired.
+func (n *buildNodeSlots) running(now time.Time) int {
+	var running int
+	for _, slot := range n.Slots {
+		if slot.Until.After(now) {
+			running++
+		}
+	}
+	return running
+}
+
+// buildSlot is a slot reserved for a build in a build node. It must be
+// released after the build.
+type buildSlot struct {
+	Node string
+	ID   string
+	done chan bool
+}
+
+func buildSlotsCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(fmt.Sprintf("%s_build_slots", name)), nil
+}
+
+// tryAcquireBuildSlot reserves a slot in the node running fewer builds, or
+// returns nil if every node already runs maxBuilds builds. A zero maxBuilds
+// means no limit. Slots are added with a conditional update, so concurrent
+// builds in different API servers can't exceed the limit.
+func tryAcquireBuildSlot(nodes []cluster.Node, maxBuilds int) (*buildSlot, error) {
+	coll, err := buildSlotsCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	addresses := make([]string, len(nodes))
+	for i, node := range nodes {
+		addresses[i] = node.Address
+	}
+	var nodeSlots []buildNodeSlots
+	err = coll.Find(bson.M{"_id": bson.M{"$in": addresses}}).All(&nodeSlots)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	running := make(map[string]int, len(nodeSlots))
+	for i := range nodeSlots {
+		running[nodeSlots[i].Address] = nodeSlots[i].running(now)
+	}
+	tried := make(map[string]bool, len(addresses))
+	for {
+		var chosen string
+		minRunning := -1
+		for _, address := range addresses {
+			if tried[address] || (maxBuilds > 0 && running[address] >= maxBuilds) {
+				continue
+			}
+			if minRunning == -1 || running[address] < minRunning {
+				chosen = address
+				minRunning = running[address]
+			}
+		}
+		if chosen == "" {
+			return nil, nil
+		}
+		tried[chosen] = true
+		err = coll.Update(bson.M{"_id": chosen}, bson.M{"$pull": bson.M{"slots": bson.M{"until": bson.M{"$lte": now}}}})
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+		entry := buildSlotEntry{ID: randomString(), Until: now.Add(buildSlotTTL)}
+		query := bson.M{"_id": chosen}
+		if maxBuilds > 0 {
+			query[fmt.Sprintf("slots.%d", maxBuilds-1)] = bson.M{"$exists": false}
+		}
+		_, err = coll.Upsert(query, bson.M{"$push": bson.M{"slots": entry}})
+		if mgo.IsDup(err) {
+			// The node got full meanwhile.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &buildSlot{Node: chosen, ID: entry.ID}, nil
+	}
+}
+
+// renew extends the expiration of the slot.
+func (s *buildSlot) renew() error {
+	coll, err := buildSlotsCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.Update(
+		bson.M{"_id": s.Node, "slots.id": s.ID},
+		bson.M{"$set": bson.M{"slots.$.until": time.Now().UTC().Add(buildSlotTTL)}},
+	)
+}
+
+// keepAlive renews the slot periodically until it's released.
+func (s *buildSlot) keepAlive() {
+	s.done = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-s.done:
+				return
+			case <-time.After(buildSlotTTL / 3):
+			}
+			err := s.renew()
+			if err != nil {
+				log.Errorf("[build] unable to renew build slot in %s: %s", s.Node, err)
+			}
+		}
+	}()
+}
+
+// release frees the slot, so other builds may use it.
+func (s *buildSlot) release() {
+	if s.done != nil {
+		close(s.done)
+	}
+	coll, err := buildSlotsCollection()
+	if err != nil {
+		log.Errorf("[build] unable to release build slot in %s: %s", s.Node, err)
+		return
+	}
+	defer coll.Close()
+	err = coll.Update(bson.M{"_id": s.Node}, bson.M{"$pull": bson.M{"slots": bson.M{"id": s.ID}}})
+	if err != nil && err != mgo.ErrNotFound {
+		log.Errorf("[build] unable to release build slot in %s: %s", s.Node, err)
+	}
+}
+
+// buildPool returns the pool of the nodes dedicated to builds. Images built
+// in these nodes are only available to the other nodes through the registry,
+// so the build pool is ignored when no registry is configured.
+func buildPool() string {
+	pool, _ := config.GetString("docker:build:pool")
+	if pool == "" {
+		return ""
+	}
+	if registry, _ := config.GetString("docker:registry"); registry == "" {
+		log.Errorf("[build] ignoring build pool %q, builds in dedicated nodes require docker:registry", pool)
+		return ""
+	}
+	return pool
+}
+
+// acquireBuildNode reserves a slot in the build node where the next build
+// will run, waiting for a free slot while all build nodes are running the
+// maximum amount of builds. It returns nil when there's no build pool, meaning
+// the build is scheduled like any other container. The slot is kept alive
+// until it's released after the build.
+func (p *dockerProvisioner) acquireBuildNode(w io.Writer) (*buildSlot, error) {
+	pool := buildPool()
+	if pool == "" {
+		return nil, nil
+	}
+	maxBuilds, _ := config.GetInt("docker:build:max-builds-per-node")
+	timeout, _ := config.GetInt("docker:build:queue-timeout")
+	if timeout <= 0 {
+		timeout = 600
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	var waiting bool
+	for {
+		nodes, err := p.Cluster().NodesForMetadata(map[string]string{"pool": pool})
+		if err != nil {
+			return nil, err
+		}
+		nodes = schedulableNodes(nodes)
+		if len(nodes) == 0 {
+			return nil, fmt.Errorf("no nodes found in the build pool %q", pool)
+		}
+		slot, err := tryAcquireBuildSlot(nodes, maxBuilds)
+		if err != nil {
+			return nil, err
+		}
+		if slot != nil {
+			slot.keepAlive()
+			return slot, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for a free node in the build pool %q", pool)
+		}
+		if !waiting {
+			fmt.Fprintf(w, " ---> All build nodes are busy, waiting for a free node\n")
+			waiting = true
+		}
+		time.Sleep(buildQueuePollInterval)
+	}
+}
+
+// buildImageInNode builds the image in the given node and sends it to the
+// registry, as the other nodes can only get it from there.
+func (p *dockerProvisioner) buildImageInNode(address string, opts docker.BuildImageOptions) error {
+	client, err := docker.NewClient(address)
+	if err != nil {
+		return err
+	}
+	err = client.BuildImage(opts)
+	if err != nil {
+		return err
+	}
+	repository, tag := splitImageName(opts.Name)
+	pushOpts := docker.PushImageOptions{Name: repository, Tag: tag, OutputStream: opts.OutputStream}
+	return client.PushImage(pushOpts, registryForImage(opts.Name).Auth)
+}
@@ -0,0 +1,231 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/config"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router/routertest"
+	"github.com/tsuru/tsuru/safe"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// runningBuilds returns the amount of builds running in each build node.
+func runningBuilds(c *check.C) map[string]int {
+	coll, err := buildSlotsCollection()
+	c.Assert(err, check.IsNil)
+	defer coll.Close()
+	var nodeSlots []buildNodeSlots
+	err = coll.Find(nil).All(&nodeSlots)
+	c.Assert(err, check.IsNil)
+	now := time.Now().UTC()
+	running := make(map[string]int, len(nodeSlots))
+	for i := range nodeSlots {
+		if n := nodeSlots[i].running(now); n > 0 {
+			running[nodeSlots[i].Address] = n
+		}
+	}
+	return running
+}
+
+func (s *S) TestTryAcquireBuildSlot(c *check.C) {
+	nodes := []cluster.Node{{Address: "http://n1:2375"}, {Address: "http://n2:2375"}}
+	acquire := func(maxBuilds int) *buildSlot {
+		slot, err := tryAcquireBuildSlot(nodes, maxBuilds)
+		c.Assert(err, check.IsNil)
+		return slot
+	}
+	slot1 := acquire(1)
+	c.Assert(slot1.Node, check.Equals, "http://n1:2375")
+	slot2 := acquire(1)
+	c.Assert(slot2.Node, check.Equals, "http://n2:2375")
+	c.Assert(slot2.ID, check.Not(check.Equals), slot1.ID)
+	c.Assert(acquire(1), check.IsNil)
+	slot2.release()
+	c.Assert(acquire(1).Node, check.Equals, "http://n2:2375")
+	c.Assert(acquire(0).Node, check.Equals, "http://n1:2375")
+	c.Assert(runningBuilds(c), check.DeepEquals, map[string]int{"http://n1:2375": 2, "http://n2:2375": 1})
+}
+
+func (s *S) TestTryAcquireBuildSlotFullMeanwhile(c *check.C) {
+	nodes := []cluster.Node{{Address: "http://n1:2375"}}
+	slot, err := tryAcquireBuildSlot(nodes, 2)
+	c.Assert(err, check.IsNil)
+	c.Assert(slot.Node, check.Equals, "http://n1:2375")
+	coll, err := buildSlotsCollection()
+	c.Assert(err, check.IsNil)
+	defer coll.Close()
+	other := buildSlotEntry{ID: "other", Until: time.Now().UTC().Add(time.Minute)}
+	err = coll.UpdateId("http://n1:2375", bson.M{"$push": bson.M{"slots": other}})
+	c.Assert(err, check.IsNil)
+	slot, err = tryAcquireBuildSlot(nodes, 2)
+	c.Assert(err, check.IsNil)
+	c.Assert(slot, check.IsNil)
+	c.Assert(runningBuilds(c), check.DeepEquals, map[string]int{"http://n1:2375": 2})
+}
+
+func (s *S) TestTryAcquireBuildSlotIgnoresExpiredSlots(c *check.C) {
+	coll, err := buildSlotsCollection()
+	c.Assert(err, check.IsNil)
+	defer coll.Close()
+	leaked := buildNodeSlots{
+		Address: "http://n1:2375",
+		Slots:   []buildSlotEntry{{ID: "leaked", Until: time.Now().UTC().Add(-time.Second)}},
+	}
+	err = coll.Insert(leaked)
+	c.Assert(err, check.IsNil)
+	c.Assert(runningBuilds(c), check.HasLen, 0)
+	slot, err := tryAcquireBuildSlot([]cluster.Node{{Address: "http://n1:2375"}}, 1)
+	c.Assert(err, check.IsNil)
+	c.Assert(slot.Node, check.Equals, "http://n1:2375")
+	var nodeSlots buildNodeSlots
+	err = coll.FindId("http://n1:2375").One(&nodeSlots)
+	c.Assert(err, check.IsNil)
+	c.Assert(nodeSlots.Slots, check.HasLen, 1)
+	c.Assert(nodeSlots.Slots[0].ID, check.Equals, slot.ID)
+}
+
+func (s *S) TestBuildSlotRenew(c *check.C) {
+	oldTTL := buildSlotTTL
+	buildSlotTTL = 30 * time.Millisecond
+	defer func() { buildSlotTTL = oldTTL }()
+	slot, err := tryAcquireBuildSlot([]cluster.Node{{Address: "http://n1:2375"}}, 1)
+	c.Assert(err, check.IsNil)
+	slot.keepAlive()
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(runningBuilds(c), check.DeepEquals, map[string]int{"http://n1:2375": 1})
+	slot.release()
+	c.Assert(runningBuilds(c), check.HasLen, 0)
+}
+
+func (s *S) TestReleaseBuildSlotNotAcquired(c *check.C) {
+	slot := buildSlot{Node: "http://n1:2375", ID: "abc"}
+	slot.release()
+	c.Assert(runningBuilds(c), check.HasLen, 0)
+}
+
+func (s *S) TestAcquireBuildNodeWithoutBuildPool(c *check.C) {
+	slot, err := s.p.acquireBuildNode(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(slot, check.IsNil)
+}
+
+func (s *S) TestAcquireBuildNodeWithoutRegistry(c *check.C) {
+	config.Set("docker:build:pool", "build")
+	defer config.Unset("docker:build")
+	slot, err := s.p.acquireBuildNode(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(slot, check.IsNil)
+}
+
+func (s *S) TestAcquireBuildNodeNoNodes(c *check.C) {
+	config.Set("docker:registry", "localhost:3030")
+	defer config.Unset("docker:registry")
+	config.Set("docker:build:pool", "build")
+	defer config.Unset("docker:build")
+	_, err := s.p.acquireBuildNode(nil)
+	c.Assert(err, check.ErrorMatches, `no nodes found in the build pool "build"`)
+}
+
+func (s *S) TestAcquireBuildNodeWaitsForFreeNode(c *check.C) {
+	config.Set("docker:registry", "localhost:3030")
+	defer config.Unset("docker:registry")
+	config.Set("docker:build:pool", "build")
+	config.Set("docker:build:max-builds-per-node", 1)
+	defer config.Unset("docker:build")
+	oldInterval := buildQueuePollInterval
+	buildQueuePollInterval = 10 * time.Millisecond
+	defer func() { buildQueuePollInterval = oldInterval }()
+	var err error
+	s.p.cluster, err = cluster.New(nil, &cluster.MapStorage{},
+		cluster.Node{Address: "http://server1:1234", Metadata: map[string]string{"pool": "test-default"}},
+		cluster.Node{Address: "http://server2:1234", Metadata: map[string]string{"pool": "build"}},
+	)
+	c.Assert(err, check.IsNil)
+	slot, err := s.p.acquireBuildNode(nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(slot.Node, check.Equals, "http://server2:1234")
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		slot.release()
+	}()
+	buf := safe.NewBuffer(nil)
+	other, err := s.p.acquireBuildNode(buf)
+	c.Assert(err, check.IsNil)
+	defer other.release()
+	c.Assert(other.Node, check.Equals, "http://server2:1234")
+	c.Assert(buf.String(), check.Equals, " ---> All build nodes are busy, waiting for a free node\n")
+}
+
+func (s *S) TestProvisionerPlatformAddInBuildNode(c *check.C) {
+	var requests []*http.Request
+	buildServer, err := dtesting.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
+		requests = append(requests, r)
+	})
+	c.Assert(err, check.IsNil)
+	defer buildServer.Stop()
+	config.Set("docker:registry", "localhost:3030")
+	defer config.Unset("docker:registry")
+	config.Set("docker:build:pool", "build")
+	defer config.Unset("docker:build")
+	s.p.cluster, err = cluster.New(nil, &cluster.MapStorage{},
+		cluster.Node{Address: s.server.URL(), Metadata: map[string]string{"pool": "test-default"}},
+		cluster.Node{Address: buildServer.URL(), Metadata: map[string]string{"pool": "build"}},
+	)
+	c.Assert(err, check.IsNil)
+	args := map[string]string{"dockerfile": "http://localhost/Dockerfile"}
+	err = s.p.PlatformAdd("test", args, bytes.NewBuffer(nil))
+	c.Assert(err, check.IsNil)
+	c.Assert(requests, check.HasLen, 2)
+	c.Assert(requests[0].URL.Path, check.Equals, "/build")
+	c.Assert(requests[0].URL.Query().Get("t"), check.Equals, platformImageName("test"))
+	c.Assert(requests[1].URL.Path, check.Equals, "/images/localhost:3030/tsuru/test/push")
+	c.Assert(requests[1].URL.Query().Get("tag"), check.Equals, "latest")
+	c.Assert(runningBuilds(c), check.HasLen, 0)
+}
+
+func (s *S) TestArchiveDeployInBuildNode(c *check.C) {
+	var createRequests int
+	buildServer, err := dtesting.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
+		if r.URL.Path == "/containers/create" {
+			createRequests++
+		}
+	})
+	c.Assert(err, check.IsNil)
+	defer buildServer.Stop()
+	buildURL := strings.Replace(buildServer.URL(), "127.0.0.1", "localhost", 1)
+	config.Set("docker:registry", "localhost:3030")
+	defer config.Unset("docker:registry")
+	config.Set("docker:build:pool", "build")
+	defer config.Unset("docker:build")
+	s.p.cluster, err = cluster.New(nil, &cluster.MapStorage{},
+		cluster.Node{Address: s.server.URL(), Metadata: map[string]string{"pool": "test-default"}},
+		cluster.Node{Address: buildURL, Metadata: map[string]string{"pool": "build"}},
+	)
+	c.Assert(err, check.IsNil)
+	stopCh := s.stopContainers(buildURL, 1)
+	defer func() { <-stopCh }()
+	client, err := docker.NewClient(buildURL)
+	c.Assert(err, check.IsNil)
+	err = client.PullImage(docker.PullImageOptions{Repository: "localhost:3030/tsuru/python:latest"}, docker.AuthConfiguration{})
+	c.Assert(err, check.IsNil)
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	var buf bytes.Buffer
+	_, err = s.p.archiveDeploy(app, s.p.getBuildImage(app), "https://s3.amazonaws.com/wat/archive.tar.gz", &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(createRequests, check.Equals, 1)
+	c.Assert(runningBuilds(c), check.HasLen, 0)
+}
@@ -0,0 +1,439 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/action"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	tsuruErrors "github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/router"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	errCanaryImageInUse = errors.New("canary image must be different from the current app image")
+	errCanaryNoUnits    = errors.New("canary deploys require the app to have units, use a regular deploy instead")
+)
+
+type canaryDeploy struct {
+	AppName   string `bson:"_id"`
+	Image     string
+	OldImage  string
+	Weight    int
+	Built     bool
+	StartTime time.Time
+}
+
+func canaryCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(fmt.Sprintf("%s_canary", name)), nil
+}
+
+func getCanary(appName string) (*canaryDeploy, error) {
+	coll, err := canaryCollection()
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var cd canaryDeploy
+	err = coll.FindId(appName).One(&cd)
+	if err == mgo.ErrNotFound {
+		return nil, provision.ErrCanaryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cd, nil
+}
+
+func ensureNoCanary(appName string) error {
+	_, err := getCanary(appName)
+	if err == provision.ErrCanaryNotFound {
+		return nil
+	}
+	if err == nil {
+		return provision.ErrCanaryInProgress
+	}
+	return err
+}
+
+func insertCanary(cd *canaryDeploy) error {
+	coll, err := canaryCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.Insert(cd)
+	if mgo.IsDup(err) {
+		return provision.ErrCanaryInProgress
+	}
+	return err
+}
+
+func updateCanaryImage(appName, image string, built bool) error {
+	coll, err := canaryCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.UpdateId(appName, bson.M{"$set": bson.M{"image": image, "built": built}})
+}
+
+func removeCanary(appName string) error {
+	coll, err := canaryCollection()
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.RemoveId(appName)
+}
+
+// splitCanaryContainers separates the containers of an app in the ones
+// running the canary image and the remaining ones.
+func splitCanaryContainers(containers []container.Container, canaryImage string) ([]container.Container, []container.Container) {
+	var canary, old []container.Container
+	for _, c := range containers {
+		if c.Image == canaryImage {
+			canary = append(canary, c)
+		} else {
+			old = append(old, c)
+		}
+	}
+	return canary, old
+}
+
+// canaryRouteWeights calculates the weight of each route of canary and old
+// units so that the canary units receive the given percentage of the traffic
+// for the web process. Weights are kept as small as possible, as some routers
+// repeat the route for each unit of weight.
+func canaryRouteWeights(weight, canaryRoutes, oldRoutes int) (int, int) {
+	if weight <= 0 || canaryRoutes == 0 || oldRoutes == 0 {
+		return 1, 1
+	}
+	canaryWeight, oldWeight := weight*oldRoutes, (100-weight)*canaryRoutes
+	a, b := canaryWeight, oldWeight
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return canaryWeight / a, oldWeight / a
+}
+
+func (p *dockerProvisioner) setCanaryRouteWeights(a provision.App, cd *canaryDeploy, weight int) error {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	wr, ok := r.(router.WeightedRouter)
+	if !ok {
+		if weight > 0 {
+			return fmt.Errorf("router for app %q does not support weighted routes", a.GetName())
+		}
+		return nil
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	canaryWebProcess, err := getImageWebProcessName(cd.Image)
+	if err != nil {
+		log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
+	}
+	oldWebProcess, err := getImageWebProcessName(cd.OldImage)
+	if err != nil {
+		log.Errorf("[WARNING] cannot get the name of the web process: %s", err)
+	}
+	var canaryWeb, oldWeb []container.Container
+	canary, old := splitCanaryContainers(containers, cd.Image)
+	for _, c := range canary {
+		if c.ProcessName == canaryWebProcess {
+			canaryWeb = append(canaryWeb, c)
+		}
+	}
+	for _, c := range old {
+		if c.ProcessName == oldWebProcess {
+			oldWeb = append(oldWeb, c)
+		}
+	}
+	canaryWeight, oldWeight := canaryRouteWeights(weight, len(canaryWeb), len(oldWeb))
+	for _, c := range canaryWeb {
+		err = wr.SetRouteWeight(c.AppName, c.Address(), canaryWeight)
+		if err != nil && err != router.ErrRouteNotFound {
+			return err
+		}
+	}
+	for _, c := range oldWeb {
+		err = wr.SetRouteWeight(c.AppName, c.Address(), oldWeight)
+		if err != nil && err != router.ErrRouteNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+var setCanaryWeights = action.Action{
+	Name: "set-canary-weights",
+	Forward: func(ctx action.FWContext) (action.Result, error) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		cd := ctx.Params[1].(*canaryDeploy)
+		if cd.Weight > 0 {
+			writer := args.writer
+			if writer == nil {
+				writer = ioutil.Discard
+			}
+			fmt.Fprintf(writer, "\n---- Routing %d%% of the traffic to canary units ----\n", cd.Weight)
+		}
+		err := args.provisioner.setCanaryRouteWeights(args.app, cd, cd.Weight)
+		if err != nil {
+			return nil, err
+		}
+		return ctx.Previous, nil
+	},
+	Backward: func(ctx action.BWContext) {
+		args := ctx.Params[0].(changeUnitsPipelineArgs)
+		cd := ctx.Params[1].(*canaryDeploy)
+		err := args.provisioner.setCanaryRouteWeights(args.app, cd, 0)
+		if err != nil {
+			log.Errorf("[set-canary-weights:Backward] Error resetting route weights: %s", err)
+		}
+	},
+	OnError:   rollbackNotice,
+	MinParams: 2,
+}
+
+func (p *dockerProvisioner) buildCanaryImage(a provision.App, opts provision.CanaryOptions, w io.Writer) (string, bool, error) {
+	var (
+		imageId string
+		err     error
+	)
+	switch {
+	case opts.Version != "":
+		imageId, err = p.gitDeploy(a, opts.Version, w)
+	case opts.ArchiveURL != "":
+		imageId, err = p.archiveDeploy(a, p.getBuildImage(a), opts.ArchiveURL, w)
+	case opts.File != nil:
+		imageId, err = p.uploadDeploy(a, opts.File, w)
+	default:
+		var isValid bool
+		isValid, err = isValidAppImage(a.GetName(), opts.Image)
+		if err == nil && !isValid {
+			err = fmt.Errorf("invalid image for app %s: %s", a.GetName(), opts.Image)
+		}
+		return opts.Image, false, err
+	}
+	return imageId, true, err
+}
+
+func (p *dockerProvisioner) CanaryDeploy(a provision.App, opts provision.CanaryOptions, w io.Writer) (string, error) {
+	if opts.Weight < 0 || opts.Weight >= 100 {
+		return "", fmt.Errorf("invalid canary weight %d, it must be between 0 and 99", opts.Weight)
+	}
+	if opts.Units < 1 {
+		opts.Units = 1
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	if opts.Weight > 0 {
+		r, err := getRouterForApp(a)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := r.(router.WeightedRouter); !ok {
+			return "", fmt.Errorf("router for app %q does not support weighted routes", a.GetName())
+		}
+	}
+	count, err := p.getContainerCountForAppName(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	if count == 0 {
+		return "", errCanaryNoUnits
+	}
+	oldImage, err := appCurrentImageName(a.GetName())
+	if err != nil {
+		return "", err
+	}
+	cd := canaryDeploy{
+		AppName:   a.GetName(),
+		OldImage:  oldImage,
+		Weight:    opts.Weight,
+		StartTime: time.Now().UTC(),
+	}
+	err = insertCanary(&cd)
+	if err != nil {
+		return "", err
+	}
+	imageId, built, err := p.buildCanaryImage(a, opts, w)
+	if err == nil && imageId == oldImage {
+		err = errCanaryImageInUse
+	}
+	if err != nil {
+		removeCanary(a.GetName())
+		return "", err
+	}
+	cd.Image = imageId
+	cd.Built = built
+	// Canary units receive live traffic, so the release hooks of the new
+	// image must run before any of them is started.
+	yamlData, err := getImageTsuruYamlData(imageId)
+	if err == nil {
+		err = p.runReleaseHooks(a, imageId, yamlData.Hooks.Release, w)
+	}
+	if err == nil {
+		err = p.runCanaryPipeline(w, a, &cd, opts.Units, count)
+	}
+	if err == nil {
+		err = updateCanaryImage(a.GetName(), imageId, built)
+	}
+	if err != nil {
+		removeCanary(a.GetName())
+		if built {
+			p.cleanImage(a.GetName(), imageId)
+		}
+		return "", err
+	}
+	fmt.Fprintf(w, "\n---- Canary deployed, promote or abort it to finish the deploy ----\n")
+	return imageId, nil
+}
+
+func (p *dockerProvisioner) runCanaryPipeline(w io.Writer, a provision.App, cd *canaryDeploy, units, currentUnits int) error {
+	imageData, err := getImageCustomData(cd.Image)
+	if err != nil {
+		return err
+	}
+	toAdd := make(map[string]*containersToAdd, len(imageData.Processes))
+	for processName := range imageData.Processes {
+		toAdd[processName] = &containersToAdd{Quantity: units, Status: provision.StatusStarted}
+	}
+	if len(toAdd) == 0 {
+		toAdd[""] = &containersToAdd{Quantity: units, Status: provision.StatusStarted}
+	}
+	if quota := a.GetQuota(); quota.Unlimited() {
+		err = app.CheckPoolQuota(a, currentUnits+units*len(toAdd))
+	} else {
+		err = a.SetQuotaInUse(currentUnits + units*len(toAdd))
+	}
+	if err != nil {
+		return &tsuruErrors.CompositeError{
+			Base:    err,
+			Message: "Cannot start canary units",
+		}
+	}
+	args := changeUnitsPipelineArgs{
+		app:         a,
+		toAdd:       toAdd,
+		writer:      w,
+		imageId:     cd.Image,
+		provisioner: p,
+	}
+	pipeline := action.NewPipeline(
+		&provisionAddUnitsToHost,
+		&bindAndHealthcheck,
+		&addNewRoutes,
+		&setCanaryWeights,
+	)
+	return pipeline.Execute(args, cd)
+}
+
+func (p *dockerProvisioner) PromoteCanary(a provision.App, w io.Writer) error {
+	cd, err := getCanary(a.GetName())
+	if err != nil {
+		return err
+	}
+	if cd.Image == "" {
+		return provision.ErrCanaryNotFound
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	canary, old := splitCanaryContainers(containers, cd.Image)
+	imageData, err := getImageCustomData(cd.Image)
+	if err != nil {
+		return err
+	}
+	yamlData, err := getImageTsuruYamlData(cd.Image)
+	if err != nil {
+		return err
+	}
+	toAdd := getContainersToAdd(imageData, old)
+	applyUnitsSpec(toAdd, yamlData.Units)
+	for _, c := range canary {
+		if ct, ok := toAdd[c.ProcessName]; ok && ct.Quantity > 0 {
+			ct.Quantity--
+		}
+	}
+	err = p.setCanaryRouteWeights(a, cd, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n---- Promoting canary image %s ----\n", cd.Image)
+	err = p.replaceUnits(w, a, toAdd, old, cd.Image)
+	if err != nil {
+		p.setCanaryRouteWeights(a, cd, cd.Weight)
+		return err
+	}
+	return removeCanary(a.GetName())
+}
+
+func (p *dockerProvisioner) AbortCanary(a provision.App, w io.Writer) error {
+	cd, err := getCanary(a.GetName())
+	if err != nil {
+		return err
+	}
+	if cd.Image == "" {
+		return provision.ErrCanaryNotFound
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	canary, old := splitCanaryContainers(containers, cd.Image)
+	err = p.setCanaryRouteWeights(a, cd, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "\n---- Aborting canary image %s ----\n", cd.Image)
+	err = p.runRemoveUnitsPipeline(w, a, canary)
+	if err != nil {
+		return err
+	}
+	if quota := a.GetQuota(); !quota.Unlimited() {
+		err = a.SetQuotaInUse(len(old))
+		if err != nil {
+			log.Errorf("Ignored error updating quota for app %q: %s", a.GetName(), err)
+		}
+	}
+	if cd.Built {
+		p.cleanImage(a.GetName(), cd.Image)
+	}
+	return removeCanary(a.GetName())
+}
@@ -0,0 +1,229 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"net/http"
+
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/router/routertest"
+	"github.com/tsuru/tsuru/safe"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) setUpCanaryApp(c *check.C) *app.App {
+	err := s.newFakeImage(s.p, "tsuru/app-otherapp:v1", nil)
+	c.Assert(err, check.IsNil)
+	err = s.newFakeImage(s.p, "tsuru/app-otherapp:v2", nil)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("otherapp", "tsuru/app-otherapp:v2")
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("otherapp", "tsuru/app-otherapp:v1")
+	c.Assert(err, check.IsNil)
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "python",
+		Quota:    quota.Unlimited,
+	}
+	err = s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.p.Provision(&a)
+	_, err = s.p.ImageDeploy(&a, "tsuru/app-otherapp:v1", nil)
+	c.Assert(err, check.IsNil)
+	_, err = s.p.AddUnits(&a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	return &a
+}
+
+func (s *S) TestCanaryRouteWeights(c *check.C) {
+	canary, old := canaryRouteWeights(10, 1, 3)
+	c.Assert(canary, check.Equals, 1)
+	c.Assert(old, check.Equals, 3)
+	canary, old = canaryRouteWeights(25, 2, 3)
+	c.Assert(canary, check.Equals, 1)
+	c.Assert(old, check.Equals, 2)
+	canary, old = canaryRouteWeights(0, 1, 3)
+	c.Assert(canary, check.Equals, 1)
+	c.Assert(old, check.Equals, 1)
+	canary, old = canaryRouteWeights(50, 0, 3)
+	c.Assert(canary, check.Equals, 1)
+	c.Assert(old, check.Equals, 1)
+}
+
+func (s *S) TestCanaryDeploy(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	w := safe.NewBuffer(nil)
+	imageId, err := s.p.CanaryDeploy(a, provision.CanaryOptions{
+		Image:  "tsuru/app-otherapp:v2",
+		Units:  1,
+		Weight: 10,
+	}, w)
+	c.Assert(err, check.IsNil)
+	c.Assert(imageId, check.Equals, "tsuru/app-otherapp:v2")
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 4)
+	canary, old := splitCanaryContainers(containers, "tsuru/app-otherapp:v2")
+	c.Assert(canary, check.HasLen, 1)
+	c.Assert(old, check.HasLen, 3)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, canary[0].Address().String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.RouteWeight(canary[0].Address().String()), check.Equals, 1)
+	for _, cont := range old {
+		c.Assert(routertest.FakeRouter.RouteWeight(cont.Address().String()), check.Equals, 3)
+	}
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v1")
+	cd, err := getCanary(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(cd.Image, check.Equals, "tsuru/app-otherapp:v2")
+	c.Assert(cd.OldImage, check.Equals, "tsuru/app-otherapp:v1")
+	c.Assert(cd.Weight, check.Equals, 10)
+}
+
+func (s *S) TestCanaryDeployRunsReleaseHooks(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+		"hooks": map[string]interface{}{
+			"release": []string{"python manage.py migrate"},
+		},
+	}
+	err := s.newFakeImage(s.p, "tsuru/app-otherapp:v3", customData)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("otherapp", "tsuru/app-otherapp:v3")
+	c.Assert(err, check.IsNil)
+	w := safe.NewBuffer(nil)
+	_, err = s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v3"}, w)
+	c.Assert(err, check.IsNil)
+	c.Assert(w.String(), check.Matches, "(?s).*---- Running release hooks ----.*")
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	canary, _ := splitCanaryContainers(containers, "tsuru/app-otherapp:v3")
+	c.Assert(canary, check.HasLen, 1)
+}
+
+func (s *S) TestCanaryDeployReleaseHookFailure(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+		"hooks": map[string]interface{}{
+			"release": []string{"python manage.py migrate"},
+		},
+	}
+	err := s.newFakeImage(s.p, "tsuru/app-otherapp:v3", customData)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("otherapp", "tsuru/app-otherapp:v3")
+	c.Assert(err, check.IsNil)
+	s.server.CustomHandler("/containers/.*/wait", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"StatusCode":1}`))
+	}))
+	defer s.server.CustomHandler("/containers/.*/wait", s.server.DefaultHandler())
+	_, err = s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v3", Weight: 10}, nil)
+	c.Assert(err, check.ErrorMatches, "release hook failed with exit status 1, the deploy was aborted")
+	_, err = getCanary(a.Name)
+	c.Assert(err, check.Equals, provision.ErrCanaryNotFound)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	for _, cont := range containers {
+		c.Assert(cont.Image, check.Equals, "tsuru/app-otherapp:v1")
+	}
+}
+
+func (s *S) TestCanaryDeployAlreadyInProgress(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	_, err := s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v2"}, nil)
+	c.Assert(err, check.IsNil)
+	_, err = s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v2"}, nil)
+	c.Assert(err, check.Equals, provision.ErrCanaryInProgress)
+	_, err = s.p.ImageDeploy(a, "tsuru/app-otherapp:v2", nil)
+	c.Assert(err, check.Equals, provision.ErrCanaryInProgress)
+	err = s.p.Restart(a, "", nil)
+	c.Assert(err, check.Equals, provision.ErrCanaryInProgress)
+}
+
+func (s *S) TestCanaryDeploySameImage(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	_, err := s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v1"}, nil)
+	c.Assert(err, check.Equals, errCanaryImageInUse)
+	_, err = getCanary(a.Name)
+	c.Assert(err, check.Equals, provision.ErrCanaryNotFound)
+}
+
+func (s *S) TestCanaryDeployInvalidWeight(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	_, err := s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v2", Weight: 100}, nil)
+	c.Assert(err, check.ErrorMatches, "invalid canary weight 100, it must be between 0 and 99")
+}
+
+func (s *S) TestPromoteCanary(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	_, err := s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v2", Weight: 10}, nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.PromoteCanary(a, nil)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	for _, cont := range containers {
+		c.Assert(cont.Image, check.Equals, "tsuru/app-otherapp:v2")
+		c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, true)
+		c.Assert(routertest.FakeRouter.RouteWeight(cont.Address().String()), check.Equals, 1)
+	}
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v2")
+	_, err = getCanary(a.Name)
+	c.Assert(err, check.Equals, provision.ErrCanaryNotFound)
+}
+
+func (s *S) TestAbortCanary(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	_, err := s.p.CanaryDeploy(a, provision.CanaryOptions{Image: "tsuru/app-otherapp:v2", Weight: 10}, nil)
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	canary, _ := splitCanaryContainers(containers, "tsuru/app-otherapp:v2")
+	c.Assert(canary, check.HasLen, 1)
+	err = s.p.AbortCanary(a, nil)
+	c.Assert(err, check.IsNil)
+	containers, err = s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	for _, cont := range containers {
+		c.Assert(cont.Image, check.Equals, "tsuru/app-otherapp:v1")
+		c.Assert(routertest.FakeRouter.RouteWeight(cont.Address().String()), check.Equals, 1)
+	}
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, canary[0].Address().String()), check.Equals, false)
+	currentImage, err := appCurrentImageName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, "tsuru/app-otherapp:v1")
+	_, err = getCanary(a.Name)
+	c.Assert(err, check.Equals, provision.ErrCanaryNotFound)
+}
+
+func (s *S) TestAbortCanaryNotFound(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	err := s.p.AbortCanary(a, nil)
+	c.Assert(err, check.Equals, provision.ErrCanaryNotFound)
+	err = s.p.PromoteCanary(a, nil)
+	c.Assert(err, check.Equals, provision.ErrCanaryNotFound)
+}
@@ -9,14 +9,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
 	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/provision"
 	"launchpad.net/gnuflag"
 )
 
@@ -203,6 +206,130 @@ func (c *removeNodeFromSchedulerCmd) Flags() *gnuflag.FlagSet {
 	return c.fs
 }
 
+type cordonNodeCmd struct{}
+
+func (cordonNodeCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-node-cordon",
+		Usage: "docker-node-cordon <address>",
+		Desc: `Marks a node as unschedulable. The units running in the node are kept, but
+no new units will be created in it and node auto scaling will ignore it.`,
+		MinArgs: 1,
+	}
+}
+
+func (cordonNodeCmd) Run(ctx *cmd.Context, client *cmd.Client) error {
+	err := callNodeMaintenance(client, "cordon", map[string]string{"address": ctx.Args[0]})
+	if err != nil {
+		return err
+	}
+	ctx.Stdout.Write([]byte("Node successfully cordoned.\n"))
+	return nil
+}
+
+type uncordonNodeCmd struct{}
+
+func (uncordonNodeCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:    "docker-node-uncordon",
+		Usage:   "docker-node-uncordon <address>",
+		Desc:    "Marks a cordoned or drained node as schedulable again.",
+		MinArgs: 1,
+	}
+}
+
+func (uncordonNodeCmd) Run(ctx *cmd.Context, client *cmd.Client) error {
+	err := callNodeMaintenance(client, "uncordon", map[string]string{"address": ctx.Args[0]})
+	if err != nil {
+		return err
+	}
+	ctx.Stdout.Write([]byte("Node successfully uncordoned.\n"))
+	return nil
+}
+
+func callNodeMaintenance(client *cmd.Client, action string, params map[string]string) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL("/docker/node/" + action)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	_, err = client.Do(req)
+	return err
+}
+
+type drainNodeCmd struct {
+	cmd.ConfirmationCommand
+	fs        *gnuflag.FlagSet
+	batchSize int
+}
+
+func (drainNodeCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-node-drain",
+		Usage: "docker-node-drain <address> [-b/--batch-size 1] [-y]",
+		Desc: `Cordons a node and moves all its units to other nodes, in batches of
+--batch-size units. Units are replaced before being removed, so apps keep
+being routed during the drain. The node is kept cordoned after the drain, use
+docker-node-uncordon to make it schedulable again.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *drainNodeCmd) Run(ctx *cmd.Context, client *cmd.Client) error {
+	if !c.Confirm(ctx, fmt.Sprintf("Are you sure you want to drain %q?", ctx.Args[0])) {
+		return nil
+	}
+	ctx.RawOutput()
+	params := map[string]string{"address": ctx.Args[0]}
+	if c.batchSize > 0 {
+		params["batch"] = strconv.Itoa(c.batchSize)
+	}
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL("/docker/node/drain")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	w := tsuruIo.NewStreamWriter(ctx.Stdout, nil)
+	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(w, response.Body) {
+	}
+	if err != nil {
+		return err
+	}
+	unparsed := w.Remaining()
+	if len(unparsed) > 0 {
+		return fmt.Errorf("unparsed message error: %s", string(unparsed))
+	}
+	return nil
+}
+
+func (c *drainNodeCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = c.ConfirmationCommand.Flags()
+		msg := "The amount of units moved at the same time."
+		c.fs.IntVar(&c.batchSize, "batch-size", 1, msg)
+		c.fs.IntVar(&c.batchSize, "b", 1, msg)
+	}
+	return c.fs
+}
+
 type listNodesInTheSchedulerCmd struct {
 	fs     *gnuflag.FlagSet
 	filter cmd.MapFlag
@@ -282,6 +409,9 @@ func (c *listNodesInTheSchedulerCmd) Run(ctx *cmd.Context, client *cmd.Client) e
 			for key, value := range metadata {
 				result = append(result, fmt.Sprintf("%s=%s", key, value.(string)))
 			}
+			if metadata[cordonedMetadata] == "true" {
+				status += " (cordoned)"
+			}
 		}
 		sort.Strings(result)
 		m, ok := machineMap[urlToHost(addr)]
@@ -406,6 +536,196 @@ func (c *autoScaleRunCmd) Run(context *cmd.Context, client *cmd.Client) error {
 	return nil
 }
 
+type autoScaleSimulateCmd struct {
+	fs          *gnuflag.FlagSet
+	filterValue string
+	dataFile    string
+}
+
+func (c *autoScaleSimulateCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-autoscale-simulate",
+		Usage: "docker-autoscale-simulate [-f/--filter-value metadata-filter-value] [-i/--input simulation.json]",
+		Desc: `Simulates a node auto scale run for the nodes matching the filter value,
+showing the nodes that would be added or removed and the units that would be
+moved. Nothing is changed in the cluster or in the IaaS.
+
+The input file is a JSON object that may override the auto scale rule and the
+current nodes and containers, with the keys "Rule", "Nodes", "Containers"
+and "CPUUsage".`,
+	}
+}
+
+func (c *autoScaleSimulateCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	var opts autoScaleSimulationOptions
+	if c.dataFile != "" {
+		data, err := ioutil.ReadFile(c.dataFile)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(data, &opts)
+		if err != nil {
+			return fmt.Errorf("unable to parse %s: %s", c.dataFile, err)
+		}
+	}
+	opts.MetadataValue = c.filterValue
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL("/docker/autoscale/simulate")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var result autoScaleSimulationResult
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(context.Stdout, "Scaler: %s\n", result.Scaler)
+	if result.Action == "" {
+		fmt.Fprintf(context.Stdout, "Nothing to do for %q.\n", result.MetadataValue)
+		return nil
+	}
+	fmt.Fprintf(context.Stdout, "Action: %s\nReason: %s\n", result.Action, result.Reason)
+	if len(result.ToRemove) > 0 {
+		fmt.Fprintln(context.Stdout, "Nodes to remove:")
+		for _, node := range result.ToRemove {
+			fmt.Fprintf(context.Stdout, "  %s\n", node.Address)
+		}
+	}
+	if len(result.Moves) > 0 {
+		fmt.Fprintln(context.Stdout, "Units to move:")
+		for _, move := range result.Moves {
+			fmt.Fprintf(context.Stdout, "  %s\n", move)
+		}
+	}
+	return nil
+}
+
+func (c *autoScaleSimulateCmd) Flags() *gnuflag.FlagSet {
+	if c.fs == nil {
+		c.fs = gnuflag.NewFlagSet("autoscale-simulate", gnuflag.ExitOnError)
+		c.fs.StringVar(&c.filterValue, "filter-value", "", "The value of the metadata used to group the nodes.")
+		c.fs.StringVar(&c.filterValue, "f", "", "The value of the metadata used to group the nodes.")
+		c.fs.StringVar(&c.dataFile, "input", "", "A JSON file overriding the rule, the nodes and the containers used in the simulation.")
+		c.fs.StringVar(&c.dataFile, "i", "", "A JSON file overriding the rule, the nodes and the containers used in the simulation.")
+	}
+	return c.fs
+}
+
+type imageGCReportCmd struct{}
+
+func (c *imageGCReportCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-image-gc-report",
+		Usage: "docker-image-gc-report",
+		Desc: `Lists the images that would be removed by the image garbage collector, in
+the docker nodes and in the registry, according to the configured retention
+policy. Nothing is removed by this command.`,
+	}
+}
+
+func (c *imageGCReportCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL("/docker/image-gc")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var report imageGCReport
+	err = json.NewDecoder(response.Body).Decode(&report)
+	if err != nil {
+		return err
+	}
+	if len(report.Images) == 0 {
+		fmt.Fprintln(context.Stdout, "No images to remove.")
+	} else {
+		t := cmd.Table{Headers: cmd.Row([]string{"Node", "Image", "Reason"})}
+		for _, entry := range report.Images {
+			node := entry.Node
+			if node == "" {
+				node = "registry"
+			}
+			t.AddRow(cmd.Row([]string{node, entry.Image, entry.Reason}))
+		}
+		t.Sort()
+		context.Stdout.Write(t.Bytes())
+	}
+	for _, msg := range report.Errors {
+		fmt.Fprintf(context.Stderr, "Error: %s\n", msg)
+	}
+	return nil
+}
+
+type reconcileReportCmd struct{}
+
+func (c *reconcileReportCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-reconciler-report",
+		Usage: "docker-reconciler-report",
+		Desc: `Lists the differences between the containers registered in tsuru and the
+containers running in the docker nodes, along with the action the reconciler
+takes for each of them. Differences without an action are only reported and
+must be fixed manually. Nothing is changed by this command.`,
+	}
+}
+
+func (c *reconcileReportCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	url, err := cmd.GetURL("/docker/reconciler")
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	var report reconcileReport
+	err = json.NewDecoder(response.Body).Decode(&report)
+	if err != nil {
+		return err
+	}
+	if len(report.Entries) == 0 {
+		fmt.Fprintln(context.Stdout, "No differences found.")
+	} else {
+		t := cmd.Table{Headers: cmd.Row([]string{"Node", "Container", "App", "Drift", "Detail", "Action"})}
+		for _, entry := range report.Entries {
+			action := entry.Action
+			if action == "" {
+				action = "none"
+			}
+			t.AddRow(cmd.Row([]string{entry.Node, entry.Container, entry.App, entry.Drift, entry.Detail, action}))
+		}
+		t.Sort()
+		context.Stdout.Write(t.Bytes())
+	}
+	for _, msg := range report.Errors {
+		fmt.Fprintf(context.Stderr, "Error: %s\n", msg)
+	}
+	return nil
+}
+
 type autoScaleInfoCmd struct{}
 
 func (c *autoScaleInfoCmd) Info() *cmd.Info {
@@ -488,6 +808,7 @@ func (c *autoScaleInfoCmd) render(context *cmd.Context, config *autoScaleConfig,
 		"Filter value",
 		"Max container count",
 		"Max memory ratio",
+		"Max CPU ratio",
 		"Scale down ratio",
 		"Rebalance on scale",
 		"Enabled",
@@ -498,6 +819,7 @@ func (c *autoScaleInfoCmd) render(context *cmd.Context, config *autoScaleConfig,
 			rule.MetadataFilter,
 			strconv.Itoa(rule.MaxContainerCount),
 			strconv.FormatFloat(float64(rule.MaxMemoryRatio), 'f', 4, 32),
+			strconv.FormatFloat(float64(rule.MaxCPURatio), 'f', 4, 32),
 			strconv.FormatFloat(float64(rule.ScaleDownRatio), 'f', 4, 32),
 			strconv.FormatBool(!rule.PreventRebalance),
 			strconv.FormatBool(rule.Enabled),
@@ -512,27 +834,39 @@ type autoScaleSetRuleCmd struct {
 	filterValue       string
 	maxContainerCount int
 	maxMemoryRatio    float64
+	maxCPURatio       float64
 	scaleDownRatio    float64
 	rebalanceOnScale  bool
 	enabled           bool
+	cpuScaleUp        float64
+	cpuScaleDown      float64
+	cpuWindow         int
+	scaleUpCooldown   int
+	scaleDownCooldown int
 }
 
 func (c *autoScaleSetRuleCmd) Info() *cmd.Info {
 	return &cmd.Info{
 		Name:  "docker-autoscale-rule-set",
-		Usage: "docker-autoscale-rule-set [-f/--filter-value metadata-filter-value] [-c/--max-container-count 0] [-m/--max-memory-ratio 0.9] [-d/--scale-down-ratio 1.33] [-r/--rebalance-on-scale false] [-e/--enabled true]",
-		Desc:  "Creates or update an auto-scale rule. Using resources limitation (amount of container or memory usage).",
+		Usage: "docker-autoscale-rule-set [-f/--filter-value metadata-filter-value] [-c/--max-container-count 0] [-m/--max-memory-ratio 0.9] [-u/--max-cpu-ratio 0.9] [-d/--scale-down-ratio 1.33] [-r/--rebalance-on-scale false] [-e/--enabled true] [--cpu-scale-up 80 --cpu-scale-down 20] [--cpu-window 300] [--scale-up-cooldown 0] [--scale-down-cooldown 0]",
+		Desc:  "Creates or update an auto-scale rule. Using resources limitation (amount of container, memory or CPU usage).",
 	}
 }
 
 func (c *autoScaleSetRuleCmd) Run(context *cmd.Context, client *cmd.Client) error {
 	rule := autoScaleRule{
-		MetadataFilter:    c.filterValue,
-		MaxContainerCount: c.maxContainerCount,
-		MaxMemoryRatio:    float32(c.maxMemoryRatio),
-		ScaleDownRatio:    float32(c.scaleDownRatio),
-		PreventRebalance:  !c.rebalanceOnScale,
-		Enabled:           c.enabled,
+		MetadataFilter:        c.filterValue,
+		MaxContainerCount:     c.maxContainerCount,
+		MaxMemoryRatio:        float32(c.maxMemoryRatio),
+		MaxCPURatio:           float32(c.maxCPURatio),
+		ScaleDownRatio:        float32(c.scaleDownRatio),
+		PreventRebalance:      !c.rebalanceOnScale,
+		Enabled:               c.enabled,
+		CPUScaleUpThreshold:   float32(c.cpuScaleUp),
+		CPUScaleDownThreshold: float32(c.cpuScaleDown),
+		CPUWindow:             c.cpuWindow,
+		ScaleUpCooldown:       c.scaleUpCooldown,
+		ScaleDownCooldown:     c.scaleDownCooldown,
 	}
 	data, err := json.Marshal(rule)
 	if err != nil {
@@ -564,12 +898,19 @@ func (c *autoScaleSetRuleCmd) Flags() *gnuflag.FlagSet {
 		c.fs.IntVar(&c.maxContainerCount, "c", 0, "The maximum amount of containers on every node. Might be zero, which means no maximum value. Whenever this value is reached, tsuru will trigger a new auto scale event.")
 		c.fs.Float64Var(&c.maxMemoryRatio, "max-memory-ratio", .0, "The maximum memory usage per node. 0 means no limit, 1 means 100%. It is fine to use values greater than 1, which means that tsuru will overcommit memory in Docker nodes. Keep in mind that container count has higher precedence than memory ratio, so if --max-container-count is defined, the value of --max-memory-ratio will be ignored.")
 		c.fs.Float64Var(&c.maxMemoryRatio, "m", .0, "The maximum memory usage per node. 0 means no limit, 1 means 100%. It is fine to use values greater than 1, which means that tsuru will overcommit memory in Docker nodes. Keep in mind that container count has higher precedence than memory ratio, so if --max-container-count is defined, the value of --max-memory-ratio will be ignored.")
+		c.fs.Float64Var(&c.maxCPURatio, "max-cpu-ratio", .0, "The maximum CPU usage per node, based on the CPU share of the plans of the apps. 0 means no limit, 1 means 100%. It's only considered along with the memory usage, so it will be ignored if --max-container-count is defined.")
+		c.fs.Float64Var(&c.maxCPURatio, "u", .0, "The maximum CPU usage per node, based on the CPU share of the plans of the apps. 0 means no limit, 1 means 100%. It's only considered along with the memory usage, so it will be ignored if --max-container-count is defined.")
 		c.fs.Float64Var(&c.scaleDownRatio, "scale-down-ratio", 1.33, "The ratio for triggering an scale down event. The default value is 1.33, which mean that whenever it gets one third of the resource utilization (memory ratio or container count).")
 		c.fs.Float64Var(&c.scaleDownRatio, "d", 1.33, "The ratio for triggering an scale down event. The default value is 1.33, which mean that whenever it gets one third of the resource utilization (memory ratio or container count).")
 		c.fs.BoolVar(&c.rebalanceOnScale, "rebalance-on-scale", true, "A boolean flag indicating whether containers should be rebalanced after running an scale. The default behavior is to always rebalance the containers.")
 		c.fs.BoolVar(&c.rebalanceOnScale, "r", true, "A boolean flag indicating whether containers should be rebalanced after running an scale. The default behavior is to always rebalance the containers.")
 		c.fs.BoolVar(&c.enabled, "enabled", true, "A boolean flag indicating whether the rule should be enabled or disabled")
 		c.fs.BoolVar(&c.enabled, "e", true, "A boolean flag indicating whether the rule should be enabled or disabled")
+		c.fs.Float64Var(&c.cpuScaleUp, "cpu-scale-up", .0, "The average CPU usage of the nodes, in percent, above which new nodes are added. Setting it makes tsuru scale nodes based on the actual CPU usage, instead of the memory usage. Keep in mind that container count has higher precedence than CPU usage.")
+		c.fs.Float64Var(&c.cpuScaleDown, "cpu-scale-down", .0, "The average CPU usage of the nodes, in percent, below which nodes are removed. It must be lower than --cpu-scale-up.")
+		c.fs.IntVar(&c.cpuWindow, "cpu-window", 0, "The window, in seconds, in which the CPU usage of the nodes is averaged. The default value is 300.")
+		c.fs.IntVar(&c.scaleUpCooldown, "scale-up-cooldown", 0, "The time, in seconds, to wait after an auto scale event before adding nodes based on the CPU usage.")
+		c.fs.IntVar(&c.scaleDownCooldown, "scale-down-cooldown", 0, "The time, in seconds, to wait after an auto scale event before removing nodes based on the CPU usage.")
 	}
 	return c.fs
 }
@@ -611,3 +952,259 @@ func (c *autoScaleDeleteRuleCmd) Run(context *cmd.Context, client *cmd.Client) e
 	fmt.Fprintln(context.Stdout, "Rule successfully removed.")
 	return nil
 }
+
+type canaryPromoteCmd struct {
+	cmd.GuessingCommand
+}
+
+func (c *canaryPromoteCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-canary-promote",
+		Usage: "docker-canary-promote [-a/--app appname]",
+		Desc: `Promotes the canary deploy in progress for the app, replacing all the old
+units with units running the canary image.`,
+	}
+}
+
+func (c *canaryPromoteCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	return runCanaryAction(context, client, appName, "promote")
+}
+
+type canaryAbortCmd struct {
+	cmd.GuessingCommand
+}
+
+func (c *canaryAbortCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-canary-abort",
+		Usage: "docker-canary-abort [-a/--app appname]",
+		Desc: `Aborts the canary deploy in progress for the app, removing the canary units
+and routing all the traffic back to the old units.`,
+	}
+}
+
+func (c *canaryAbortCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	return runCanaryAction(context, client, appName, "abort")
+}
+
+func runCanaryAction(context *cmd.Context, client *cmd.Client, appName, action string) error {
+	context.RawOutput()
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/deploy/canary/%s", appName, action))
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest("POST", url, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	w := tsuruIo.NewStreamWriter(context.Stdout, nil)
+	for n := int64(1); n > 0 && err == nil; n, err = io.Copy(w, response.Body) {
+	}
+	if err != nil {
+		return err
+	}
+	unparsed := w.Remaining()
+	if len(unparsed) > 0 {
+		return fmt.Errorf("unparsed message error: %s", string(unparsed))
+	}
+	return nil
+}
+
+type unitsMetricsCmd struct {
+	cmd.GuessingCommand
+}
+
+func (c *unitsMetricsCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-units-metrics",
+		Usage: "docker-units-metrics [-a/--app appname]",
+		Desc: `Shows the current CPU, memory and network usage of each unit of the app.
+Units are listed from the most to the least CPU consuming.`,
+	}
+}
+
+func (c *unitsMetricsCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/units/metrics", appName))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var metrics []provision.UnitMetrics
+	err = json.NewDecoder(resp.Body).Decode(&metrics)
+	if err != nil {
+		return err
+	}
+	sort.Sort(unitMetricsByCPU(metrics))
+	t := cmd.Table{Headers: cmd.Row([]string{"Unit", "Process", "CPU", "Memory", "Network (rx/tx)"})}
+	for _, m := range metrics {
+		id := m.ID
+		if len(id) > 12 {
+			id = id[:12]
+		}
+		memory := formatBytes(m.MemoryUsage)
+		if m.MemoryLimit > 0 {
+			memory = fmt.Sprintf("%s / %s", memory, formatBytes(m.MemoryLimit))
+		}
+		t.AddRow(cmd.Row([]string{
+			id,
+			m.ProcessName,
+			fmt.Sprintf("%.2f%%", m.CPUPercent),
+			memory,
+			fmt.Sprintf("%s / %s", formatBytes(m.NetworkRx), formatBytes(m.NetworkTx)),
+		}))
+	}
+	context.Stdout.Write(t.Bytes())
+	return nil
+}
+
+type unitMetricsByCPU []provision.UnitMetrics
+
+func (l unitMetricsByCPU) Len() int           { return len(l) }
+func (l unitMetricsByCPU) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l unitMetricsByCPU) Less(i, j int) bool { return l[i].CPUPercent > l[j].CPUPercent }
+
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+type jobListCmd struct {
+	cmd.GuessingCommand
+}
+
+func (c *jobListCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-job-list",
+		Usage: "docker-job-list [-a/--app appname]",
+		Desc: `Lists the jobs of the app, with their schedule and the time of their next
+run. Jobs declared in the tsuru.yaml file of the app are listed with the "yaml"
+source.`,
+	}
+}
+
+func (c *jobListCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/jobs", appName))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var jobs []app.Job
+	err = json.NewDecoder(resp.Body).Decode(&jobs)
+	if err != nil {
+		return err
+	}
+	t := cmd.Table{Headers: cmd.Row([]string{"Name", "Schedule", "Command", "Source", "Next run"})}
+	for _, job := range jobs {
+		source := "api"
+		if job.FromYaml {
+			source = "yaml"
+		}
+		t.AddRow(cmd.Row([]string{
+			job.Name,
+			job.Schedule,
+			job.Command,
+			source,
+			job.NextRun.Local().Format(time.Stamp),
+		}))
+	}
+	context.Stdout.Write(t.Bytes())
+	return nil
+}
+
+type jobRunsCmd struct {
+	cmd.GuessingCommand
+}
+
+func (c *jobRunsCmd) Info() *cmd.Info {
+	return &cmd.Info{
+		Name:  "docker-job-runs",
+		Usage: "docker-job-runs <job> [-a/--app appname]",
+		Desc: `Lists the latest runs of a job of the app, most recent first, with their exit
+status and output.`,
+		MinArgs: 1,
+	}
+}
+
+func (c *jobRunsCmd) Run(context *cmd.Context, client *cmd.Client) error {
+	appName, err := c.Guess()
+	if err != nil {
+		return err
+	}
+	url, err := cmd.GetURL(fmt.Sprintf("/apps/%s/jobs/%s/runs", appName, context.Args[0]))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var runs []app.JobRun
+	err = json.NewDecoder(resp.Body).Decode(&runs)
+	if err != nil {
+		return err
+	}
+	t := cmd.Table{Headers: cmd.Row([]string{"Start", "Duration", "Exit status", "Output"})}
+	for _, run := range runs {
+		output := strings.TrimRight(run.Output, "\n")
+		if run.Error != "" {
+			output = strings.TrimLeft(output+"\n"+run.Error, "\n")
+		}
+		t.AddRow(cmd.Row([]string{
+			run.StartTime.Local().Format(time.Stamp),
+			run.EndTime.Sub(run.StartTime).String(),
+			strconv.Itoa(run.ExitStatus),
+			output,
+		}))
+	}
+	context.Stdout.Write(t.Bytes())
+	return nil
+}
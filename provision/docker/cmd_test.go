@@ -10,13 +10,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/cmd"
 	"github.com/tsuru/tsuru/cmd/cmdtest"
 	tsuruIo "github.com/tsuru/tsuru/io"
+	"github.com/tsuru/tsuru/provision"
 	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
 )
 
 func (s *S) TestAddNodeToTheSchedulerCmdInfo(c *check.C) {
@@ -225,6 +229,32 @@ func (s *S) TestListNodesInTheSchedulerCmdRun(c *check.C) {
 	c.Assert(buf.String(), check.Equals, expected)
 }
 
+func (s *S) TestListNodesInTheSchedulerCmdRunCordoned(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Stdout: &buf}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: `{
+	"nodes": [
+		{"Address": "http://localhost1:8080", "Status": "ready", "Metadata": {"cordoned": "true"}}
+	]
+}`, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/docker/node"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	err := (&listNodesInTheSchedulerCmd{}).Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `+------------------------+---------+------------------+---------------+
+| Address                | IaaS ID | Status           | Metadata      |
++------------------------+---------+------------------+---------------+
+| http://localhost1:8080 |         | ready (cordoned) | cordoned=true |
++------------------------+---------+------------------+---------------+
+`
+	c.Assert(buf.String(), check.Equals, expected)
+}
+
 func (s *S) TestListNodesInTheSchedulerCmdRunWithFilters(c *check.C) {
 	var buf bytes.Buffer
 	context := cmd.Context{Stdout: &buf}
@@ -390,6 +420,66 @@ func (s *S) TestUpdateNodeToDisableCmdRun(c *check.C) {
 	c.Assert(buf.String(), check.Equals, "Node successfully updated.\n")
 }
 
+func (s *S) TestCordonNodeCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"http://localhost:1111"}, Stdout: &buf}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var params map[string]string
+			err := json.NewDecoder(req.Body).Decode(&params)
+			c.Assert(err, check.IsNil)
+			c.Assert(params, check.DeepEquals, map[string]string{"address": "http://localhost:1111"})
+			return req.URL.Path == "/docker/node/cordon" && req.Method == "POST"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	err := cordonNodeCmd{}.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "Node successfully cordoned.\n")
+}
+
+func (s *S) TestUncordonNodeCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"http://localhost:1111"}, Stdout: &buf}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/docker/node/uncordon" && req.Method == "POST"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	err := uncordonNodeCmd{}.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "Node successfully uncordoned.\n")
+}
+
+func (s *S) TestDrainNodeCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Args: []string{"http://localhost:1111"}, Stdout: &buf}
+	msg, _ := json.Marshal(tsuruIo.SimpleJsonMessage{Message: "drained"})
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(msg), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var params map[string]string
+			err := json.NewDecoder(req.Body).Decode(&params)
+			c.Assert(err, check.IsNil)
+			c.Assert(params, check.DeepEquals, map[string]string{"address": "http://localhost:1111", "batch": "3"})
+			return req.URL.Path == "/docker/node/drain" && req.Method == "POST"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := drainNodeCmd{}
+	err := cm.Flags().Parse(true, []string{"-y", "-b", "3"})
+	c.Assert(err, check.IsNil)
+	err = cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "drained")
+}
+
 func (s *S) TestAutoScaleRunCmdRun(c *check.C) {
 	var stdout, stderr bytes.Buffer
 	msg, _ := json.Marshal(tsuruIo.SimpleJsonMessage{Message: "progress msg"})
@@ -413,6 +503,139 @@ func (s *S) TestAutoScaleRunCmdRun(c *check.C) {
 	c.Assert(stdout.String(), check.Equals, "progress msg")
 }
 
+func (s *S) TestAutoScaleSimulateCmdRun(c *check.C) {
+	file, err := ioutil.TempFile("", "simulation")
+	c.Assert(err, check.IsNil)
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(`{"Rule": {"Enabled": true, "MaxContainerCount": 4}}`)
+	c.Assert(err, check.IsNil)
+	file.Close()
+	result := `{"MetadataValue":"pool1","Scaler":"count","Action":"add","Reason":"number of free slots is -2, adding 1 nodes","ToAdd":1,"Moves":["Would move unit 1 -> 3 for \"myapp\" from h1 -> h2"]}`
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			var opts autoScaleSimulationOptions
+			err := json.NewDecoder(req.Body).Decode(&opts)
+			c.Assert(err, check.IsNil)
+			c.Assert(opts.MetadataValue, check.Equals, "pool1")
+			c.Assert(opts.Rule, check.DeepEquals, &autoScaleRule{Enabled: true, MaxContainerCount: 4})
+			return req.URL.Path == "/docker/autoscale/simulate" && req.Method == "POST"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := autoScaleSimulateCmd{}
+	err = cm.Flags().Parse(true, []string{"-f", "pool1", "-i", file.Name()})
+	c.Assert(err, check.IsNil)
+	err = cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `Scaler: count
+Action: add
+Reason: number of free slots is -2, adding 1 nodes
+Units to move:
+  Would move unit 1 -> 3 for "myapp" from h1 -> h2
+`
+	c.Assert(stdout.String(), check.Equals, expected)
+}
+
+func (s *S) TestAutoScaleSimulateCmdRunNothingToDo(c *check.C) {
+	result := `{"MetadataValue":"pool1","Scaler":"memory"}`
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/docker/autoscale/simulate" && req.Method == "POST"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := autoScaleSimulateCmd{}
+	err := cm.Flags().Parse(true, []string{"-f", "pool1"})
+	c.Assert(err, check.IsNil)
+	err = cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "Scaler: memory\nNothing to do for \"pool1\".\n")
+}
+
+func (s *S) TestImageGCReportCmdRun(c *check.C) {
+	result := `{"DryRun":true,"Images":[{"Node":"http://n1:2375","Image":"tsuru/app-myapp:v1","Reason":"old app image"},{"Node":"","Image":"localhost:5000/tsuru/app-gone:v2","Reason":"app removed"}],"Errors":["unable to list images in http://n2:2375: timeout"]}`
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/docker/image-gc" && req.Method == "GET"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := imageGCReportCmd{}
+	err := cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `+----------------+----------------------------------+---------------+
+| Node           | Image                            | Reason        |
++----------------+----------------------------------+---------------+
+| http://n1:2375 | tsuru/app-myapp:v1               | old app image |
+| registry       | localhost:5000/tsuru/app-gone:v2 | app removed   |
++----------------+----------------------------------+---------------+
+`
+	c.Assert(stdout.String(), check.Equals, expected)
+	c.Assert(stderr.String(), check.Equals, "Error: unable to list images in http://n2:2375: timeout\n")
+}
+
+func (s *S) TestImageGCReportCmdRunNoImages(c *check.C) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout}
+	trans := &cmdtest.Transport{Message: `{"DryRun":true}`, Status: http.StatusOK}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := imageGCReportCmd{}
+	err := cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No images to remove.\n")
+}
+
+func (s *S) TestReconcileReportCmdRun(c *check.C) {
+	result := `{"DryRun":true,"Entries":[{"Node":"http://n1:2375","Container":"c1","App":"myapp","Drift":"status drift","Detail":"not running","Action":""},{"Node":"http://n1:2375","Container":"c2","App":"","Drift":"orphan container","Detail":"not found in the database","Action":"remove"}],"Errors":["unable to list containers in http://n2:2375: timeout"]}`
+	var stdout, stderr bytes.Buffer
+	context := cmd.Context{Stdout: &stdout, Stderr: &stderr}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: result, Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/docker/reconciler" && req.Method == "GET"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := reconcileReportCmd{}
+	err := cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `+----------------+-----------+-------+------------------+---------------------------+--------+
+| Node           | Container | App   | Drift            | Detail                    | Action |
++----------------+-----------+-------+------------------+---------------------------+--------+
+| http://n1:2375 | c1        | myapp | status drift     | not running               | none   |
+| http://n1:2375 | c2        |       | orphan container | not found in the database | remove |
++----------------+-----------+-------+------------------+---------------------------+--------+
+`
+	c.Assert(stdout.String(), check.Equals, expected)
+	c.Assert(stderr.String(), check.Equals, "Error: unable to list containers in http://n2:2375: timeout\n")
+}
+
+func (s *S) TestReconcileReportCmdRunNoDifferences(c *check.C) {
+	var stdout bytes.Buffer
+	context := cmd.Context{Stdout: &stdout}
+	trans := &cmdtest.Transport{Message: `{"DryRun":true}`, Status: http.StatusOK}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := reconcileReportCmd{}
+	err := cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "No differences found.\n")
+}
+
 func (s *S) TestAutoScaleInfoCmdRun(c *check.C) {
 	var calls int
 	config := `{"GroupByMetadata":"pool","Enabled":true}`
@@ -431,6 +654,7 @@ func (s *S) TestAutoScaleInfoCmdRun(c *check.C) {
 		"ScaleDownRatio":1.33,
 		"PreventRebalance":false,
 		"MaxMemoryRatio":1.20,
+		"MaxCPURatio":0.8,
 		"Error": ""
 	},
 	{
@@ -472,13 +696,13 @@ func (s *S) TestAutoScaleInfoCmdRun(c *check.C) {
 	expected := `Metadata filter: pool
 
 Rules:
-+--------------+---------------------+------------------+------------------+--------------------+---------+
-| Filter value | Max container count | Max memory ratio | Scale down ratio | Rebalance on scale | Enabled |
-+--------------+---------------------+------------------+------------------+--------------------+---------+
-| pool1        | 6                   | 1.2000           | 1.3300           | true               | true    |
-| pool2        | 13                  | 0.9000           | 1.3300           | false              | true    |
-| pool3        | 50                  | 1.2000           | 1.3300           | true               | false   |
-+--------------+---------------------+------------------+------------------+--------------------+---------+
++--------------+---------------------+------------------+---------------+------------------+--------------------+---------+
+| Filter value | Max container count | Max memory ratio | Max CPU ratio | Scale down ratio | Rebalance on scale | Enabled |
++--------------+---------------------+------------------+---------------+------------------+--------------------+---------+
+| pool1        | 6                   | 1.2000           | 0.8000        | 1.3300           | true               | true    |
+| pool2        | 13                  | 0.9000           | 0.0000        | 1.3300           | false              | true    |
+| pool3        | 50                  | 1.2000           | 0.0000        | 1.3300           | true               | false   |
++--------------+---------------------+------------------+---------------+------------------+--------------------+---------+
 `
 	c.Assert(buf.String(), check.Equals, expected)
 	c.Assert(calls, check.Equals, 2)
@@ -519,6 +743,7 @@ func (s *S) TestAutoScaleSetRuleCmdRun(c *check.C) {
 				Enabled:           true,
 				MaxContainerCount: 10,
 				MaxMemoryRatio:    1.2342,
+				MaxCPURatio:       0.9,
 				ScaleDownRatio:    1.33,
 				PreventRebalance:  false,
 			})
@@ -530,7 +755,43 @@ func (s *S) TestAutoScaleSetRuleCmdRun(c *check.C) {
 	var manager cmd.Manager
 	client := cmd.NewClient(&http.Client{Transport: &transport}, nil, &manager)
 	var command autoScaleSetRuleCmd
-	flags := []string{"-f", "pool1", "-c", "10", "-m", "1.2342"}
+	flags := []string{"-f", "pool1", "-c", "10", "-m", "1.2342", "-u", "0.9"}
+	err := command.Flags().Parse(true, flags)
+	c.Assert(err, check.IsNil)
+	err = command.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(called, check.Equals, true)
+	c.Assert(buf.String(), check.Equals, "Rule successfully defined.\n")
+}
+
+func (s *S) TestAutoScaleSetRuleCmdRunCPUThresholds(c *check.C) {
+	var called bool
+	transport := cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: "", Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			called = true
+			var rule autoScaleRule
+			err := json.NewDecoder(req.Body).Decode(&rule)
+			c.Assert(err, check.IsNil)
+			c.Assert(rule, check.DeepEquals, autoScaleRule{
+				MetadataFilter:        "pool1",
+				Enabled:               true,
+				ScaleDownRatio:        1.33,
+				CPUScaleUpThreshold:   75,
+				CPUScaleDownThreshold: 15,
+				CPUWindow:             120,
+				ScaleUpCooldown:       60,
+				ScaleDownCooldown:     600,
+			})
+			return req.Method == "POST" && req.URL.Path == "/docker/autoscale/rules"
+		},
+	}
+	var buf bytes.Buffer
+	context := cmd.Context{Stdout: &buf}
+	var manager cmd.Manager
+	client := cmd.NewClient(&http.Client{Transport: &transport}, nil, &manager)
+	var command autoScaleSetRuleCmd
+	flags := []string{"-f", "pool1", "--cpu-scale-up", "75", "--cpu-scale-down", "15", "--cpu-window", "120", "--scale-up-cooldown", "60", "--scale-down-cooldown", "600"}
 	err := command.Flags().Parse(true, flags)
 	c.Assert(err, check.IsNil)
 	err = command.Run(&context, client)
@@ -622,3 +883,145 @@ func (s *S) TestAutoScaleDeleteCmdRunDefaultAskForConfirmation(c *check.C) {
 	c.Assert(called, check.Equals, true)
 	c.Assert(buf.String(), check.Equals, "Are you sure you want to remove the default rule? (y/n) Rule successfully removed.\n")
 }
+
+func (s *S) TestCanaryPromoteCmdRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	msg, _ := json.Marshal(tsuruIo.SimpleJsonMessage{Message: "promoted"})
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(msg), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/deploy/canary/promote" && req.Method == "POST"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := canaryPromoteCmd{}
+	cm.Flags().Parse(true, []string{"-a", "myapp"})
+	err := cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "promoted")
+}
+
+func (s *S) TestCanaryAbortCmdRun(c *check.C) {
+	var stdout, stderr bytes.Buffer
+	msg, _ := json.Marshal(tsuruIo.SimpleJsonMessage{Message: "aborted"})
+	context := cmd.Context{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(msg), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/deploy/canary/abort" && req.Method == "POST"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := canaryAbortCmd{}
+	cm.Flags().Parse(true, []string{"-a", "myapp"})
+	err := cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(stdout.String(), check.Equals, "aborted")
+}
+
+func (s *S) TestUnitsMetricsCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Stdout: &buf}
+	metrics := []provision.UnitMetrics{
+		{ID: "abcdef0123456789", ProcessName: "web", CPUPercent: 12.5, MemoryUsage: 104857600, MemoryLimit: 536870912, NetworkRx: 2048, NetworkTx: 512},
+		{ID: "0123", ProcessName: "worker", CPUPercent: 50, MemoryUsage: 1024},
+	}
+	data, err := json.Marshal(metrics)
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(data), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/units/metrics" && req.Method == "GET"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := unitsMetricsCmd{}
+	cm.Flags().Parse(true, []string{"-a", "myapp"})
+	err = cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	expected := `+--------------+---------+--------+-----------------------+-----------------+
+| Unit         | Process | CPU    | Memory                | Network (rx/tx) |
++--------------+---------+--------+-----------------------+-----------------+
+| 0123         | worker  | 50.00% | 1.0 KiB               | 0 B / 0 B       |
+| abcdef012345 | web     | 12.50% | 100.0 MiB / 512.0 MiB | 2.0 KiB / 512 B |
++--------------+---------+--------+-----------------------+-----------------+
+`
+	c.Assert(buf.String(), check.Equals, expected)
+}
+
+func (s *S) TestJobListCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Stdout: &buf}
+	nextRun := time.Date(2015, 10, 14, 10, 40, 0, 0, time.UTC)
+	jobs := []app.Job{
+		{Name: "cleanup", AppName: "myapp", Schedule: "*/10 * * * *", Command: "python cleanup.py", NextRun: nextRun},
+		{Name: "report", AppName: "myapp", Schedule: "0 3 * * *", Command: "python report.py", FromYaml: true, NextRun: nextRun},
+	}
+	data, err := json.Marshal(jobs)
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(data), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/jobs" && req.Method == "GET"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := jobListCmd{}
+	cm.Flags().Parse(true, []string{"-a", "myapp"})
+	err = cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	next := nextRun.Local().Format(time.Stamp)
+	expected := fmt.Sprintf(`+---------+--------------+-------------------+--------+-----------------+
+| Name    | Schedule     | Command           | Source | Next run        |
++---------+--------------+-------------------+--------+-----------------+
+| cleanup | */10 * * * * | python cleanup.py | api    | %s |
+| report  | 0 3 * * *    | python report.py  | yaml   | %s |
++---------+--------------+-------------------+--------+-----------------+
+`, next, next)
+	c.Assert(buf.String(), check.Equals, expected)
+}
+
+func (s *S) TestJobRunsCmdRun(c *check.C) {
+	var buf bytes.Buffer
+	context := cmd.Context{Stdout: &buf, Args: []string{"cleanup"}}
+	start := time.Date(2015, 10, 14, 10, 40, 0, 0, time.UTC)
+	runs := []app.JobRun{
+		{ID: bson.NewObjectId(), AppName: "myapp", JobName: "cleanup", StartTime: start, EndTime: start.Add(3 * time.Second), Output: "done\n"},
+		{ID: bson.NewObjectId(), AppName: "myapp", JobName: "cleanup", StartTime: start, EndTime: start.Add(time.Minute), ExitStatus: 1, Output: "starting\n", Error: "timeout"},
+	}
+	data, err := json.Marshal(runs)
+	c.Assert(err, check.IsNil)
+	trans := &cmdtest.ConditionalTransport{
+		Transport: cmdtest.Transport{Message: string(data), Status: http.StatusOK},
+		CondFunc: func(req *http.Request) bool {
+			return req.URL.Path == "/apps/myapp/jobs/cleanup/runs" && req.Method == "GET"
+		},
+	}
+	manager := cmd.Manager{}
+	client := cmd.NewClient(&http.Client{Transport: trans}, nil, &manager)
+	cm := jobRunsCmd{}
+	cm.Flags().Parse(true, []string{"-a", "myapp"})
+	err = cm.Run(&context, client)
+	c.Assert(err, check.IsNil)
+	started := start.Local().Format(time.Stamp)
+	expected := fmt.Sprintf(`+-----------------+----------+-------------+----------+
+| Start           | Duration | Exit status | Output   |
++-----------------+----------+-------------+----------+
+| %s | 3s       | 0           | done     |
+| %s | 1m0s     | 1           | starting |
+|                 |          |             | timeout  |
++-----------------+----------+-------------+----------+
+`, started, started)
+	c.Assert(buf.String(), check.Equals, expected)
+}
@@ -101,6 +101,13 @@ func runLeanContainerCmds(processName, imageId string, app provision.App) ([]str
 	if before != "" {
 		before += " && "
 	}
+	dockerfile, err := isDockerfileImage(imageId)
+	if err != nil {
+		return nil, "", err
+	}
+	if dockerfile {
+		return []string{"/bin/sh", "-c", before + "exec " + processCmd}, processName, nil
+	}
 	return []string{
 		"/bin/bash",
 		"-lc",
@@ -89,6 +89,22 @@ func (s *S) TestRunLeanContainersCmd(c *check.C) {
 	c.Assert(cmds, check.DeepEquals, expected)
 }
 
+func (s *S) TestRunLeanContainersCmdDockerfileImage(c *check.C) {
+	imageId := "tsuru/app-sample"
+	customData := map[string]interface{}{
+		"dockerfile": true,
+		"processes": map[string]interface{}{
+			"web": "/usr/bin/server --port 8888",
+		},
+	}
+	err := saveImageCustomData(imageId, customData)
+	c.Assert(err, check.IsNil)
+	cmds, process, err := runLeanContainerCmds("web", imageId, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(process, check.Equals, "web")
+	c.Assert(cmds, check.DeepEquals, []string{"/bin/sh", "-c", "exec /usr/bin/server --port 8888"})
+}
+
 func (s *S) TestRunLeanContainersCmdHooks(c *check.C) {
 	imageId := "tsuru/app-sample"
 	customData := map[string]interface{}{
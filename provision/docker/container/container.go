@@ -36,6 +36,12 @@ type DockerProvisioner interface {
 	PushImage(name, tag string) error
 }
 
+// MirrorPuller is a DockerProvisioner able to send the image to a node from
+// the mirrors of its registry, before a container is created in the node.
+type MirrorPuller interface {
+	PullFromMirrors(nodeAddress, imageName string) error
+}
+
 type Container struct {
 	ID                      string
 	AppName                 string
@@ -54,9 +60,28 @@ type Container struct {
 	LastStatusUpdate        time.Time
 	LastSuccessStatusUpdate time.Time
 	LockedUntil             time.Time
+	Ports                   []ExposedPort
+	RestartCount            int
+	LastExitCode            int
+	LastCrash               time.Time
+	CrashReason             string
+	CrashLogs               []string
+	BackoffUntil            time.Time
+	RouteRemoved            bool
 	Routable                bool `bson:"-"`
 }
 
+// ExposedPort is a named port exposed by the container, besides the one in
+// the PORT environment variable. HostPort is the port bound to it in the
+// docker node. When Routed is set, the router sends requests to this port
+// instead of the default one.
+type ExposedPort struct {
+	Name     string
+	Port     int
+	HostPort string
+	Routed   bool
+}
+
 func (c *Container) ShortID() string {
 	if len(c.ID) > 10 {
 		return c.ID[:10]
@@ -69,6 +94,33 @@ func (c *Container) Available() bool {
 		c.Status == provision.StatusStarting.String()
 }
 
+// routedPort returns the port in the container that receives requests from
+// the router.
+func (c *Container) routedPort() (string, error) {
+	for _, p := range c.Ports {
+		if p.Routed {
+			return strconv.Itoa(p.Port), nil
+		}
+	}
+	return getPort()
+}
+
+// exposedPorts returns every port exposed by the container, starting with
+// the default one.
+func (c *Container) exposedPorts() ([]docker.Port, error) {
+	port, err := getPort()
+	if err != nil {
+		return nil, err
+	}
+	ports := []docker.Port{docker.Port(port + "/tcp")}
+	for _, p := range c.Ports {
+		if exposed := docker.Port(fmt.Sprintf("%d/tcp", p.Port)); exposed != ports[0] {
+			ports = append(ports, exposed)
+		}
+	}
+	return ports, nil
+}
+
 func (c *Container) Address() *url.URL {
 	return &url.URL{
 		Scheme: "http",
@@ -77,8 +129,11 @@ func (c *Container) Address() *url.URL {
 }
 
 type CreateArgs struct {
-	ImageID          string
-	Commands         []string
+	ImageID  string
+	Commands []string
+	// Entrypoint makes the first command the entrypoint of the container,
+	// replacing the entrypoint defined in the image.
+	Entrypoint       bool
 	App              provision.App
 	Deploy           bool
 	Provisioner      DockerProvisioner
@@ -96,8 +151,13 @@ func (c *Container) Create(args *CreateArgs) error {
 	securityOpts, _ := config.GetList("docker:security-opts")
 	var exposedPorts map[docker.Port]struct{}
 	if !args.Deploy {
-		exposedPorts = map[docker.Port]struct{}{
-			docker.Port(port + "/tcp"): {},
+		ports, err := c.exposedPorts()
+		if err != nil {
+			return err
+		}
+		exposedPorts = make(map[docker.Port]struct{}, len(ports))
+		for _, p := range ports {
+			exposedPorts[p] = struct{}{}
 		}
 	}
 	config := docker.Config{
@@ -113,6 +173,10 @@ func (c *Container) Create(args *CreateArgs) error {
 		CPUShares:    int64(args.App.GetCpuShare()),
 		SecurityOpts: securityOpts,
 	}
+	if args.Entrypoint && len(args.Commands) > 0 {
+		config.Entrypoint = args.Commands[:1]
+		config.Cmd = args.Commands[1:]
+	}
 	c.addEnvsToConfig(args, port, &config)
 	opts := docker.CreateContainerOptions{Name: c.Name, Config: &config}
 	var nodeList []string
@@ -123,6 +187,12 @@ func (c *Container) Create(args *CreateArgs) error {
 			return err
 		}
 		nodeList = []string{nodeName}
+		if puller, ok := args.Provisioner.(MirrorPuller); ok {
+			err = puller.PullFromMirrors(nodeName, args.ImageID)
+			if err != nil {
+				log.Errorf("error pulling image %s from mirrors in %s, falling back to the registry: %s", args.ImageID, nodeName, err)
+			}
+		}
 	}
 	schedulerOpts := []string{args.App.GetName(), args.ProcessName}
 	addr, cont, err := args.Provisioner.Cluster().CreateContainerSchedulerOpts(opts, schedulerOpts, nodeList...)
@@ -180,14 +250,18 @@ func (c *Container) user() string {
 	return user
 }
 
+// NetworkInfo holds the address of the container in the docker node.
+// HTTPHostPort is bound to the routed port, while HostPorts maps the names of
+// the other exposed ports to their host ports.
 type NetworkInfo struct {
 	HTTPHostPort string
 	IP           string
+	HostPorts    map[string]string
 }
 
 func (c *Container) NetworkInfo(p DockerProvisioner) (NetworkInfo, error) {
 	var netInfo NetworkInfo
-	port, err := getPort()
+	port, err := c.routedPort()
 	if err != nil {
 		return netInfo, err
 	}
@@ -197,17 +271,36 @@ func (c *Container) NetworkInfo(p DockerProvisioner) (NetworkInfo, error) {
 	}
 	if dockerContainer.NetworkSettings != nil {
 		netInfo.IP = dockerContainer.NetworkSettings.IPAddress
-		httpPort := docker.Port(port + "/tcp")
-		for _, port := range dockerContainer.NetworkSettings.Ports[httpPort] {
-			if port.HostPort != "" && port.HostIP != "" {
-				netInfo.HTTPHostPort = port.HostPort
-				break
+		netInfo.HTTPHostPort = hostPort(dockerContainer.NetworkSettings.Ports, port)
+		if len(c.Ports) > 0 {
+			netInfo.HostPorts = make(map[string]string, len(c.Ports))
+			for _, exposed := range c.Ports {
+				netInfo.HostPorts[exposed.Name] = hostPort(dockerContainer.NetworkSettings.Ports, strconv.Itoa(exposed.Port))
 			}
 		}
 	}
 	return netInfo, err
 }
 
+func hostPort(bindings map[docker.Port][]docker.PortBinding, port string) string {
+	for _, binding := range bindings[docker.Port(port+"/tcp")] {
+		if binding.HostPort != "" && binding.HostIP != "" {
+			return binding.HostPort
+		}
+	}
+	return ""
+}
+
+// SetNetworkInfo updates the container with the addresses in the given
+// network info.
+func (c *Container) SetNetworkInfo(info NetworkInfo) {
+	c.IP = info.IP
+	c.HostPort = info.HTTPHostPort
+	for i := range c.Ports {
+		c.Ports[i].HostPort = info.HostPorts[c.Ports[i].Name]
+	}
+}
+
 func (c *Container) SetStatus(p DockerProvisioner, status string, updateDB bool) error {
 	c.Status = status
 	c.LastStatusUpdate = time.Now().In(time.UTC)
@@ -310,6 +403,10 @@ func (e *execErr) Error() string {
 	return fmt.Sprintf("unexpected exit code: %d", e.code)
 }
 
+func (e *execErr) ExitStatus() int {
+	return e.code
+}
+
 func (c *Container) Exec(p DockerProvisioner, stdout, stderr io.Writer, cmd string, args ...string) error {
 	cmds := []string{"/bin/bash", "-lc", cmd}
 	cmds = append(cmds, args...)
@@ -406,7 +503,7 @@ type StartArgs struct {
 }
 
 func (c *Container) Start(args *StartArgs) error {
-	port, err := getPort()
+	ports, err := c.exposedPorts()
 	if err != nil {
 		return err
 	}
@@ -421,8 +518,9 @@ func (c *Container) Start(args *StartArgs) error {
 	}
 	if !args.Deploy {
 		hostConfig.RestartPolicy = docker.AlwaysRestart()
-		hostConfig.PortBindings = map[docker.Port][]docker.PortBinding{
-			docker.Port(port + "/tcp"): {{HostIP: "", HostPort: ""}},
+		hostConfig.PortBindings = make(map[docker.Port][]docker.PortBinding, len(ports))
+		for _, p := range ports {
+			hostConfig.PortBindings[p] = []docker.PortBinding{{HostIP: "", HostPort: ""}}
 		}
 		hostConfig.LogConfig = docker.LogConfig{
 			Type: "syslog",
@@ -470,10 +568,13 @@ func (c *Container) Start(args *StartArgs) error {
 }
 
 func (c *Container) startWithPortSearch(p DockerProvisioner, hostConfig *docker.HostConfig) error {
-	intenalPort, err := getPort()
+	intenalPort, err := c.routedPort()
 	if err != nil {
 		return err
 	}
+	if hostConfig.PortBindings == nil {
+		hostConfig.PortBindings = make(map[docker.Port][]docker.PortBinding)
+	}
 	retries := 0
 	rand.Seed(time.Now().UTC().UnixNano())
 	for port := portRangeStart; port <= portRangeEnd; {
@@ -491,9 +592,7 @@ func (c *Container) startWithPortSearch(p DockerProvisioner, hostConfig *docker.
 		if port > portRangeEnd {
 			break
 		}
-		hostConfig.PortBindings = map[docker.Port][]docker.PortBinding{
-			docker.Port(intenalPort + "/tcp"): {{HostIP: "", HostPort: portStr}},
-		}
+		hostConfig.PortBindings[docker.Port(intenalPort+"/tcp")] = []docker.PortBinding{{HostIP: "", HostPort: portStr}}
 		randN := rand.Uint32()
 		err = p.Cluster().StartContainer(c.ID, hostConfig)
 		if err != nil {
@@ -553,6 +652,25 @@ func (c *Container) AsUnit(a provision.App) provision.Unit {
 	if cType == "" {
 		cType = a.GetPlatform()
 	}
+	var ports []provision.UnitPort
+	for _, p := range c.Ports {
+		ports = append(ports, provision.UnitPort{
+			Name:     p.Name,
+			Port:     p.Port,
+			HostPort: p.HostPort,
+			Routed:   p.Routed,
+		})
+	}
+	var crashLoop *provision.UnitCrashLoop
+	if status == provision.StatusCrashLoop {
+		crashLoop = &provision.UnitCrashLoop{
+			Restarts:     c.RestartCount,
+			LastExitCode: c.LastExitCode,
+			Reason:       c.CrashReason,
+			LastLogs:     c.CrashLogs,
+			RetryAt:      c.BackoffUntil,
+		}
+	}
 	return provision.Unit{
 		ID:          c.ID,
 		AppName:     a.GetName(),
@@ -561,6 +679,8 @@ func (c *Container) AsUnit(a provision.App) provision.Unit {
 		Status:      status,
 		ProcessName: c.ProcessName,
 		Address:     c.Address(),
+		Ports:       ports,
+		CrashLoop:   crashLoop,
 	}
 }
 
@@ -122,6 +122,29 @@ func (s *S) TestContainerCreate(c *check.C) {
 	})
 }
 
+func (s *S) TestContainerCreateReplacesEntrypoint(c *check.C) {
+	app := provisiontest.NewFakeApp("app-name", "brainfuck", 1)
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	img := "tsuru/brainfuck:latest"
+	s.p.Cluster().PullImage(docker.PullImageOptions{Repository: img}, docker.AuthConfiguration{})
+	cont := Container{Name: "myName", AppName: app.GetName(), Type: app.GetPlatform(), Status: "created"}
+	err := cont.Create(&CreateArgs{
+		App:         app,
+		ImageID:     img,
+		Commands:    []string{"/bin/sh", "-c", "exec ./server"},
+		Entrypoint:  true,
+		Provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(&cont)
+	dcli, _ := docker.NewClient(s.server.URL())
+	container, err := dcli.InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(container.Config.Entrypoint, check.DeepEquals, []string{"/bin/sh"})
+	c.Assert(container.Config.Cmd, check.DeepEquals, []string{"-c", "exec ./server"})
+}
+
 func (s *S) TestContainerCreateSecurityOptions(c *check.C) {
 	config.Set("docker:security-opts", []string{"label:type:svirt_apache", "ptrace peer=@unsecure"})
 	defer config.Unset("docker:security-opts")
@@ -179,6 +202,37 @@ func (s *S) TestContainerCreateAllocatesPort(c *check.C) {
 	c.Assert(info.HTTPHostPort, check.Not(check.Equals), "")
 }
 
+func (s *S) TestContainerCreateExposesPorts(c *check.C) {
+	app := provisiontest.NewFakeApp("app-name", "brainfuck", 1)
+	img := "tsuru/brainfuck:latest"
+	s.p.Cluster().PullImage(docker.PullImageOptions{Repository: img}, docker.AuthConfiguration{})
+	cont := Container{
+		Name:    "myName",
+		AppName: app.GetName(),
+		Type:    app.GetPlatform(),
+		Status:  "created",
+		Ports: []ExposedPort{
+			{Name: "metrics", Port: 9100},
+			{Name: "http", Port: 8888},
+		},
+	}
+	err := cont.Create(&CreateArgs{
+		App:         app,
+		ImageID:     img,
+		Commands:    []string{"docker", "run"},
+		Provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(&cont)
+	dcli, _ := docker.NewClient(s.server.URL())
+	container, err := dcli.InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(container.Config.ExposedPorts, check.DeepEquals, map[docker.Port]struct{}{
+		"8888/tcp": {},
+		"9100/tcp": {},
+	})
+}
+
 func (s *S) TestContainerCreateDoesNotAlocatesPortForDeploy(c *check.C) {
 	app := provisiontest.NewFakeApp("app-name", "brainfuck", 1)
 	app.Memory = 15
@@ -302,6 +356,51 @@ func (s *S) TestContainerNetworkInfo(c *check.C) {
 	c.Assert(info.HTTPHostPort, check.Equals, "")
 }
 
+func (s *S) TestContainerNetworkInfoWithPorts(c *check.C) {
+	inspectOut := `{
+	"NetworkSettings": {
+		"IpAddress": "10.10.10.10",
+		"IpPrefixLen": 8,
+		"Gateway": "10.65.41.1",
+		"Ports": {
+			"8888/tcp": [{"HostIp": "0.0.0.0", "HostPort": "49153"}],
+			"9100/tcp": [{"HostIp": "0.0.0.0", "HostPort": "49154"}],
+			"50051/tcp": [{"HostIp": "0.0.0.0", "HostPort": "49155"}]
+		}
+	}
+}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/containers/") {
+			w.Write([]byte(inspectOut))
+		}
+	}))
+	defer server.Close()
+	var storage cluster.MapStorage
+	storage.StoreContainer("c-01", server.URL)
+	p, err := newFakeDockerProvisioner(server.URL)
+	c.Assert(err, check.IsNil)
+	p.cluster, err = cluster.New(nil, &storage,
+		cluster.Node{Address: server.URL},
+	)
+	c.Assert(err, check.IsNil)
+	container := Container{ID: "c-01", Ports: []ExposedPort{
+		{Name: "metrics", Port: 9100},
+		{Name: "grpc", Port: 50051, Routed: true},
+	}}
+	info, err := container.NetworkInfo(p)
+	c.Assert(err, check.IsNil)
+	c.Assert(info.IP, check.Equals, "10.10.10.10")
+	c.Assert(info.HTTPHostPort, check.Equals, "49155")
+	c.Assert(info.HostPorts, check.DeepEquals, map[string]string{"metrics": "49154", "grpc": "49155"})
+	container.SetNetworkInfo(info)
+	c.Assert(container.IP, check.Equals, "10.10.10.10")
+	c.Assert(container.HostPort, check.Equals, "49155")
+	c.Assert(container.Ports, check.DeepEquals, []ExposedPort{
+		{Name: "metrics", Port: 9100, HostPort: "49154"},
+		{Name: "grpc", Port: 50051, HostPort: "49155", Routed: true},
+	})
+}
+
 func (s *S) TestContainerSetStatus(c *check.C) {
 	update := time.Date(1989, 2, 2, 14, 59, 32, 0, time.UTC).In(time.UTC)
 	container := Container{ID: "something-300", LastStatusUpdate: update}
@@ -640,6 +739,25 @@ func (s *S) TestContainerStart(c *check.C) {
 	c.Assert(cont.Status, check.Equals, "starting")
 }
 
+func (s *S) TestContainerStartWithPorts(c *check.C) {
+	cont, err := s.newContainer(newContainerOpts{}, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(cont)
+	cont.Ports = []ExposedPort{{Name: "metrics", Port: 9100}}
+	client, err := docker.NewClient(s.server.URL())
+	c.Assert(err, check.IsNil)
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	err = cont.Start(&StartArgs{Provisioner: s.p, App: app})
+	c.Assert(err, check.IsNil)
+	dockerContainer, err := client.InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	expectedPortBindings := map[docker.Port][]docker.PortBinding{
+		"8888/tcp": {{HostIP: "", HostPort: ""}},
+		"9100/tcp": {{HostIP: "", HostPort: ""}},
+	}
+	c.Assert(dockerContainer.HostConfig.PortBindings, check.DeepEquals, expectedPortBindings)
+}
+
 func (s *S) TestContainerStartDeployContainer(c *check.C) {
 	cont, err := s.newContainer(newContainerOpts{}, nil)
 	c.Assert(err, check.IsNil)
@@ -767,6 +885,55 @@ func (s *S) TestContainerAsUnit(c *check.C) {
 	c.Assert(got, check.DeepEquals, expected)
 }
 
+func (s *S) TestContainerAsUnitWithPorts(c *check.C) {
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	container := Container{
+		ID:          "c-id",
+		HostAddr:    "192.168.50.4",
+		HostPort:    "49155",
+		ProcessName: "web",
+		Ports: []ExposedPort{
+			{Name: "metrics", Port: 9100, HostPort: "49154"},
+			{Name: "grpc", Port: 50051, HostPort: "49155", Routed: true},
+		},
+	}
+	got := container.AsUnit(app)
+	c.Assert(got.Address, check.DeepEquals, &url.URL{Scheme: "http", Host: "192.168.50.4:49155"})
+	c.Assert(got.Ports, check.DeepEquals, []provision.UnitPort{
+		{Name: "metrics", Port: 9100, HostPort: "49154"},
+		{Name: "grpc", Port: 50051, HostPort: "49155", Routed: true},
+	})
+}
+
+func (s *S) TestContainerAsUnitCrashLoop(c *check.C) {
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	retryAt := time.Now().Add(time.Minute)
+	container := Container{
+		ID:           "c-id",
+		HostAddr:     "192.168.50.4",
+		HostPort:     "8080",
+		ProcessName:  "web",
+		Status:       provision.StatusCrashLoop.String(),
+		RestartCount: 5,
+		LastExitCode: 2,
+		CrashReason:  "exited with code 2",
+		CrashLogs:    []string{"starting server", "unable to bind port"},
+		BackoffUntil: retryAt,
+	}
+	got := container.AsUnit(app)
+	c.Assert(got.Status, check.Equals, provision.StatusCrashLoop)
+	c.Assert(got.CrashLoop, check.DeepEquals, &provision.UnitCrashLoop{
+		Restarts:     5,
+		LastExitCode: 2,
+		Reason:       "exited with code 2",
+		LastLogs:     []string{"starting server", "unable to bind port"},
+		RetryAt:      retryAt,
+	})
+	container.Status = provision.StatusStarted.String()
+	got = container.AsUnit(app)
+	c.Assert(got.CrashLoop, check.IsNil)
+}
+
 func (s *S) TestSafeAttachWaitContainer(c *check.C) {
 	cont, err := s.newContainer(newContainerOpts{}, nil)
 	c.Assert(err, check.IsNil)
@@ -10,8 +10,10 @@ import (
 	"io"
 	"io/ioutil"
 	"math"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app"
@@ -138,6 +140,151 @@ func (p *dockerProvisioner) runCreateUnitsPipeline(w io.Writer, a provision.App,
 	return pipeline.Result().([]container.Container), nil
 }
 
+func (p *dockerProvisioner) runRemoveUnitsPipeline(w io.Writer, a provision.App, toRemoveContainers []container.Container) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	args := changeUnitsPipelineArgs{
+		app:         a,
+		toRemove:    toRemoveContainers,
+		writer:      w,
+		provisioner: p,
+	}
+	pipeline := action.NewPipeline(
+		&removeOldRoutes,
+		&provisionRemoveOldUnits,
+		&provisionUnbindOldUnits,
+	)
+	return pipeline.Execute(args)
+}
+
+// replaceUnits replaces the given containers with new units running imageId,
+// honoring the rolling options of the app. When the app has no rolling
+// options, all units are replaced at once.
+func (p *dockerProvisioner) replaceUnits(w io.Writer, a provision.App, toAdd map[string]*containersToAdd, toRemoveContainers []container.Container, imageId string) error {
+	opts := a.GetRollingOptions()
+	if !opts.Enabled() {
+		_, err := p.runReplaceUnitsPipeline(w, a, toAdd, toRemoveContainers, imageId)
+		return err
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	batchSize := opts.MaxSurge + opts.MaxUnavailable
+	processNames := make([]string, 0, len(toAdd))
+	for processName := range toAdd {
+		processNames = append(processNames, processName)
+	}
+	sort.Strings(processNames)
+	var newUnits []string
+	for _, processName := range processNames {
+		for i := 0; i < toAdd[processName].Quantity; i++ {
+			newUnits = append(newUnits, processName)
+		}
+	}
+	oldUnits := make([]container.Container, len(toRemoveContainers))
+	copy(oldUnits, toRemoveContainers)
+	sort.Sort(containerByProcessList(oldUnits))
+	total := len(newUnits)
+	if len(oldUnits) > total {
+		total = len(oldUnits)
+	}
+	batches := (total + batchSize - 1) / batchSize
+	previousImage, _ := appCurrentImageName(a.GetName())
+	var created []container.Container
+	removed := make(map[string]*containersToAdd)
+	for i := 0; i < batches; i++ {
+		if i > 0 && opts.Pause > 0 {
+			fmt.Fprintf(w, "\n---- Waiting %s before next batch ----\n", opts.Pause)
+			time.Sleep(opts.Pause)
+		}
+		fmt.Fprintf(w, "\n---- Replacing units, batch %d of %d ----\n", i+1, batches)
+		batchAdd := make(map[string]*containersToAdd)
+		for _, processName := range newUnits[minInt(i*batchSize, len(newUnits)):minInt((i+1)*batchSize, len(newUnits))] {
+			if _, ok := batchAdd[processName]; !ok {
+				batchAdd[processName] = &containersToAdd{Status: toAdd[processName].Status}
+			}
+			batchAdd[processName].Quantity++
+		}
+		batchRemove := oldUnits[minInt(i*batchSize, len(oldUnits)):minInt((i+1)*batchSize, len(oldUnits))]
+		unavailable := minInt(opts.MaxUnavailable, len(batchRemove))
+		if unavailable > 0 {
+			err := p.runRemoveUnitsPipeline(w, a, batchRemove[:unavailable])
+			if err != nil {
+				fmt.Fprintf(w, "\n---- Aborting, %d of %d batches completed ----\n", i, batches)
+				p.rollbackReplaceUnits(w, a, created, removed, imageId, previousImage)
+				return err
+			}
+			countUnits(removed, batchRemove[:unavailable])
+		}
+		newContainers, err := p.runReplaceUnitsPipeline(w, a, batchAdd, batchRemove[unavailable:], imageId)
+		if err != nil {
+			fmt.Fprintf(w, "\n---- Aborting, %d of %d batches completed ----\n", i, batches)
+			p.rollbackReplaceUnits(w, a, created, removed, imageId, previousImage)
+			return err
+		}
+		created = append(created, newContainers...)
+		countUnits(removed, batchRemove[unavailable:])
+	}
+	return nil
+}
+
+// countUnits adds the given containers to the units of each process, keeping
+// stopped units stopped.
+func countUnits(units map[string]*containersToAdd, containers []container.Container) {
+	for _, c := range containers {
+		ct, ok := units[c.ProcessName]
+		if !ok {
+			ct = &containersToAdd{}
+			if c.Status == provision.StatusStopped.String() {
+				ct.Status = provision.StatusStopped
+			}
+			units[c.ProcessName] = ct
+		}
+		ct.Quantity++
+	}
+}
+
+// rollbackReplaceUnits restores the units removed by an aborted rolling
+// replacement, running the previous image of the app, and removes the units
+// created by the completed batches. The new image is removed from the image
+// history of the app, so it's not used by the restored units nor kept as the
+// current image.
+func (p *dockerProvisioner) rollbackReplaceUnits(w io.Writer, a provision.App, created []container.Container, removed map[string]*containersToAdd, imageId, previousImage string) {
+	if len(created) == 0 && len(removed) == 0 {
+		return
+	}
+	if previousImage == "" {
+		fmt.Fprintf(w, "\n---- Unable to roll back, no previous image found ----\n")
+		return
+	}
+	fmt.Fprintf(w, "\n---- Rolling back to %s ----\n", previousImage)
+	if previousImage != imageId {
+		err := pullAppImageNames(a.GetName(), []string{imageId})
+		if err != nil {
+			log.Errorf("[deploy] unable to remove image %s from the images of app %q: %s", imageId, a.GetName(), err)
+		}
+	}
+	_, err := p.runReplaceUnitsPipeline(w, a, removed, created, previousImage)
+	if err != nil {
+		fmt.Fprintf(w, "\n---- Rollback failed: %s ----\n", err)
+		log.Errorf("[deploy] unable to roll back units of app %q: %s", a.GetName(), err)
+	}
+}
+
+type containerByProcessList []container.Container
+
+func (l containerByProcessList) Len() int           { return len(l) }
+func (l containerByProcessList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l containerByProcessList) Less(i, j int) bool { return l[i].ProcessName < l[j].ProcessName }
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
 func (p *dockerProvisioner) MoveOneContainer(c container.Container, toHost string, errors chan error, wg *sync.WaitGroup, writer io.Writer, locker container.AppLocker) container.Container {
 	if wg != nil {
 		defer wg.Done()
@@ -190,6 +337,23 @@ func (p *dockerProvisioner) MoveOneContainer(c container.Container, toHost strin
 	return addedContainers[0]
 }
 
+// publishUnitMoved publishes the event of a unit moved to another container.
+// It's not published by MoveOneContainer, as the healer publishes its own
+// event for the containers it moves.
+func (p *dockerProvisioner) publishUnitMoved(c, added container.Container) {
+	if p.isDryMode || added.ID == "" {
+		return
+	}
+	app.PublishUnitEvent(app.UnitEvent{
+		App:     c.AppName,
+		Unit:    c.ID,
+		Kind:    app.UnitEventMoved,
+		Host:    c.HostAddr,
+		NewUnit: added.ID,
+		NewHost: added.HostAddr,
+	})
+}
+
 func (p *dockerProvisioner) moveContainer(contId string, toHost string, writer io.Writer) (container.Container, error) {
 	cont, err := p.GetContainer(contId)
 	if err != nil {
@@ -201,6 +365,7 @@ func (p *dockerProvisioner) moveContainer(contId string, toHost string, writer i
 	locker := &appLocker{}
 	createdContainer := p.MoveOneContainer(*cont, toHost, moveErrors, &wg, writer, locker)
 	close(moveErrors)
+	p.publishUnitMoved(*cont, createdContainer)
 	return createdContainer, p.HandleMoveErrors(moveErrors, writer)
 }
 
@@ -210,7 +375,11 @@ func (p *dockerProvisioner) moveContainerList(containers []container.Container,
 	wg := sync.WaitGroup{}
 	wg.Add(len(containers))
 	for _, c := range containers {
-		go p.MoveOneContainer(c, toHost, moveErrors, &wg, writer, locker)
+		go func(c container.Container) {
+			defer wg.Done()
+			createdContainer := p.MoveOneContainer(c, toHost, moveErrors, nil, writer, locker)
+			p.publishUnitMoved(c, createdContainer)
+		}(c)
 	}
 	go func() {
 		wg.Wait()
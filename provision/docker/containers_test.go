@@ -5,11 +5,15 @@
 package docker
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
+	"github.com/fsouza/go-dockerclient"
 	dtesting "github.com/fsouza/go-dockerclient/testing"
 	"github.com/tsuru/docker-cluster/cluster"
 	"github.com/tsuru/tsuru/action"
@@ -172,6 +176,49 @@ func (s *S) TestMoveContainer(c *check.C) {
 	c.Assert(serviceBodies[1], check.Matches, ".*unit-host=localhost")
 }
 
+func (s *S) TestMoveContainerPublishesMovedEvent(c *check.C) {
+	err := s.newFakeImage(s.p, "tsuru/app-myapp", nil)
+	c.Assert(err, check.IsNil)
+	appInstance := provisiontest.NewFakeApp("myapp", "python", 0)
+	defer s.p.Destroy(appInstance)
+	s.p.Provision(appInstance)
+	imageId, err := appCurrentImageName(appInstance.GetName())
+	c.Assert(err, check.IsNil)
+	conts, err := addContainersWithHost(&changeUnitsPipelineArgs{
+		toHost:      "127.0.0.1",
+		toAdd:       map[string]*containersToAdd{"web": {Quantity: 1}},
+		app:         appInstance,
+		imageId:     imageId,
+		provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	appStruct := &app.App{Name: appInstance.GetName()}
+	err = s.storage.Apps().Insert(appStruct)
+	c.Assert(err, check.IsNil)
+	l, err := app.NewUnitEventListener(appStruct)
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	moveErrors := make(chan error, 1)
+	moved := s.p.MoveOneContainer(conts[0], "", moveErrors, nil, ioutil.Discard, &appLocker{})
+	close(moveErrors)
+	c.Assert(<-moveErrors, check.IsNil)
+	select {
+	case evt := <-l.C:
+		c.Fatalf("unexpected unit event: %#v", evt)
+	case <-time.After(500 * time.Millisecond):
+	}
+	created, err := s.p.moveContainer(moved.ID, "", ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	select {
+	case evt := <-l.C:
+		c.Assert(evt.Kind, check.Equals, app.UnitEventMoved)
+		c.Assert(evt.Unit, check.Equals, moved.ID)
+		c.Assert(evt.NewUnit, check.Equals, created.ID)
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for unit event")
+	}
+}
+
 func (s *S) TestRebalanceContainers(c *check.C) {
 	p, err := s.startMultipleServersCluster()
 	c.Assert(err, check.IsNil)
@@ -475,3 +522,111 @@ func (s *S) TestRebalanceContainersDry(c *check.C) {
 	c.Assert(routes, check.DeepEquals, beforeRoutes)
 	c.Assert(serviceCalled, check.Equals, false)
 }
+
+func (s *S) TestReplaceUnitsRolling(c *check.C) {
+	a := provisiontest.NewFakeApp("almah", "static", 1)
+	a.Rolling = &provision.RollingOptions{MaxSurge: 1, MaxUnavailable: 1}
+	var oldContainers []container.Container
+	for i := 0; i < 3; i++ {
+		cont, err := s.newContainer(&newContainerOpts{
+			AppName:     a.GetName(),
+			ProcessName: "web",
+			Image:       "tsuru/app-" + a.GetName(),
+		}, nil)
+		c.Assert(err, check.IsNil)
+		defer s.removeTestContainer(cont)
+		oldContainers = append(oldContainers, *cont)
+	}
+	buf := safe.NewBuffer(nil)
+	toAdd := map[string]*containersToAdd{"web": {Quantity: 3}}
+	err := s.p.replaceUnits(buf, a, toAdd, oldContainers, "tsuru/app-"+a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*batch 1 of 2.*batch 2 of 2.*`)
+	containers, err := s.p.listContainersByApp(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	for _, cont := range containers {
+		for _, old := range oldContainers {
+			c.Assert(cont.ID, check.Not(check.Equals), old.ID)
+		}
+	}
+}
+
+func (s *S) TestReplaceUnitsRollingAbortsOnFailure(c *check.C) {
+	a := provisiontest.NewFakeApp("almah", "static", 1)
+	a.Rolling = &provision.RollingOptions{MaxSurge: 1}
+	var oldContainers []container.Container
+	for i := 0; i < 2; i++ {
+		cont, err := s.newContainer(&newContainerOpts{
+			AppName:     a.GetName(),
+			ProcessName: "web",
+			Image:       "tsuru/app-" + a.GetName(),
+		}, nil)
+		c.Assert(err, check.IsNil)
+		defer s.removeTestContainer(cont)
+		oldContainers = append(oldContainers, *cont)
+	}
+	s.server.PrepareFailure("create-error", "/containers/create")
+	defer s.server.ResetFailure("create-error")
+	buf := safe.NewBuffer(nil)
+	toAdd := map[string]*containersToAdd{"web": {Quantity: 2}}
+	err := s.p.replaceUnits(buf, a, toAdd, oldContainers, "tsuru/app-"+a.GetName())
+	c.Assert(err, check.NotNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*Aborting, 0 of 2 batches completed.*`)
+	containers, err := s.p.listContainersByApp(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 2)
+}
+
+func (s *S) TestReplaceUnitsRollingRollsBackOnFailure(c *check.C) {
+	a := provisiontest.NewFakeApp("almah", "static", 1)
+	a.Rolling = &provision.RollingOptions{MaxSurge: 1}
+	oldImage := "tsuru/app-almah:v1"
+	newImage := "tsuru/app-almah:v2"
+	err := appendAppImageName(a.GetName(), oldImage)
+	c.Assert(err, check.IsNil)
+	var oldContainers []container.Container
+	for i := 0; i < 2; i++ {
+		cont, err := s.newContainer(&newContainerOpts{
+			AppName:     a.GetName(),
+			ProcessName: "web",
+			Image:       oldImage,
+		}, nil)
+		c.Assert(err, check.IsNil)
+		defer s.removeTestContainer(cont)
+		oldContainers = append(oldContainers, *cont)
+	}
+	err = s.newFakeImage(s.p, newImage, nil)
+	c.Assert(err, check.IsNil)
+	var newCreated int
+	s.server.CustomHandler("/containers/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(data))
+		var config docker.Config
+		json.Unmarshal(data, &config)
+		if config.Image == newImage {
+			newCreated++
+			if newCreated == 2 {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		s.server.DefaultHandler().ServeHTTP(w, r)
+	}))
+	defer s.server.CustomHandler("/containers/create", s.server.DefaultHandler())
+	buf := safe.NewBuffer(nil)
+	toAdd := map[string]*containersToAdd{"web": {Quantity: 2}}
+	err = s.p.replaceUnits(buf, a, toAdd, oldContainers, newImage)
+	c.Assert(err, check.NotNil)
+	c.Assert(buf.String(), check.Matches, `(?s).*Aborting, 1 of 2 batches completed.*Rolling back to tsuru/app-almah:v1.*`)
+	containers, err := s.p.listContainersByApp(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 2)
+	for _, cont := range containers {
+		c.Assert(cont.Image, check.Equals, oldImage)
+	}
+	currentImage, err := appCurrentImageName(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(currentImage, check.Equals, oldImage)
+	c.Assert(s.p.imageInUse(a.GetName(), newImage), check.Equals, false)
+}
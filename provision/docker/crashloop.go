@@ -0,0 +1,298 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const crashLoopLogLines = 10
+
+// crashLoopPolicy defines when a unit is in a crash loop. Crashes less than
+// Window apart are consecutive, and Threshold consecutive crashes put the
+// unit in the crashloop status. Units in crash loop are started again after
+// a backoff period, starting at MinBackoff and doubling on every new crash,
+// up to MaxBackoff.
+type crashLoopPolicy struct {
+	Threshold  int
+	Window     time.Duration
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+func crashLoopPolicyFromConfig() crashLoopPolicy {
+	threshold, _ := config.GetInt("docker:crashloop:threshold")
+	if threshold <= 0 {
+		threshold = 5
+	}
+	window, _ := config.GetInt("docker:crashloop:window")
+	if window <= 0 {
+		window = 300
+	}
+	minBackoff, _ := config.GetInt("docker:crashloop:min-backoff")
+	if minBackoff <= 0 {
+		minBackoff = 10
+	}
+	maxBackoff, _ := config.GetInt("docker:crashloop:max-backoff")
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff * 30
+	}
+	return crashLoopPolicy{
+		Threshold:  threshold,
+		Window:     time.Duration(window) * time.Second,
+		MinBackoff: time.Duration(minBackoff) * time.Second,
+		MaxBackoff: time.Duration(maxBackoff) * time.Second,
+	}
+}
+
+// backoff returns how long a unit with the given number of consecutive
+// crashes is kept stopped.
+func (p crashLoopPolicy) backoff(restarts int) time.Duration {
+	backoff := p.MinBackoff
+	for i := p.Threshold; i < restarts && backoff < p.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+func crashReason(state docker.State) string {
+	if state.OOMKilled {
+		return "killed for exceeding the memory limit"
+	}
+	if state.Error != "" {
+		return state.Error
+	}
+	return fmt.Sprintf("exited with code %d", state.ExitCode)
+}
+
+// registerCrash records a crash of the container, reported as a transition
+// to the error status. Crashes are counted from the latest crash, or from
+// the end of the latest backoff period, so a unit crashing again right after
+// being started from a crash loop goes back to it with a longer backoff.
+func (p *dockerProvisioner) registerCrash(cont *container.Container, now time.Time) error {
+	policy := crashLoopPolicyFromConfig()
+	since := cont.LastCrash
+	if cont.BackoffUntil.After(since) {
+		since = cont.BackoffUntil
+	}
+	if now.Sub(since) > policy.Window {
+		cont.RestartCount = 0
+	}
+	cont.RestartCount++
+	cont.LastCrash = now
+	var reason string
+	dockerContainer, err := p.Cluster().InspectContainer(cont.ID)
+	if err != nil {
+		log.Errorf("[crashloop] unable to inspect container %s: %s", cont.ID, err)
+	} else {
+		cont.LastExitCode = dockerContainer.State.ExitCode
+		reason = crashReason(dockerContainer.State)
+	}
+	update := bson.M{
+		"restartcount": cont.RestartCount,
+		"lastexitcode": cont.LastExitCode,
+		"lastcrash":    cont.LastCrash,
+	}
+	inCrashLoop := cont.RestartCount >= policy.Threshold
+	if inCrashLoop {
+		cont.CrashReason = reason
+		cont.CrashLogs = lastUnitLogs(cont)
+		cont.BackoffUntil = now.Add(policy.backoff(cont.RestartCount))
+		update["crashreason"] = cont.CrashReason
+		update["crashlogs"] = cont.CrashLogs
+		update["backoffuntil"] = cont.BackoffUntil
+		if !cont.RouteRemoved {
+			cont.RouteRemoved = true
+			update["routeremoved"] = true
+		}
+	}
+	coll := p.Collection()
+	defer coll.Close()
+	err = coll.Update(bson.M{"id": cont.ID}, bson.M{"$set": update})
+	if err != nil || !inCrashLoop {
+		return err
+	}
+	return p.enterCrashLoop(cont)
+}
+
+// lastUnitLogs returns the last lines logged by the unit, ignoring errors as
+// logs are only informative.
+func lastUnitLogs(cont *container.Container) []string {
+	a, err := app.GetByName(cont.AppName)
+	if err != nil {
+		return nil
+	}
+	logs, err := a.LastLogs(crashLoopLogLines, app.Applog{Unit: cont.ID})
+	if err != nil {
+		log.Errorf("[crashloop] unable to get logs of container %s: %s", cont.ID, err)
+		return nil
+	}
+	lines := make([]string, len(logs))
+	for i, l := range logs {
+		lines[i] = l.Message
+	}
+	return lines
+}
+
+// enterCrashLoop removes the container from the router and stops it, so it
+// stops flapping until its backoff period expires.
+func (p *dockerProvisioner) enterCrashLoop(cont *container.Container) error {
+	log.Errorf("[crashloop] container %s of app %s crashed %d times, last reason: %s. Retrying at %s.",
+		cont.ID, cont.AppName, cont.RestartCount, cont.CrashReason, cont.BackoffUntil)
+	err := p.RemoveContainerRoute(*cont)
+	if err != nil {
+		log.Errorf("[crashloop] unable to remove route of container %s: %s", cont.ID, err)
+	}
+	err = p.Cluster().StopContainer(cont.ID, 10)
+	if err != nil {
+		log.Errorf("[crashloop] unable to stop container %s: %s", cont.ID, err)
+	}
+	previousStatus := provision.Status(cont.Status)
+	err = cont.SetStatus(p, provision.StatusCrashLoop.String(), true)
+	if err != nil {
+		return err
+	}
+	app.PublishUnitStatusChange(cont.AppName, cont.ID, previousStatus, provision.StatusCrashLoop)
+	return nil
+}
+
+// restartCrashLoopContainers starts again the containers in crash loop whose
+// backoff period expired. Their routes are only restored when they report
+// they started, by restoreCrashLoopRoute.
+func (p *dockerProvisioner) restartCrashLoopContainers(now time.Time) error {
+	containers, err := p.ListContainers(bson.M{
+		"status":       provision.StatusCrashLoop.String(),
+		"backoffuntil": bson.M{"$lte": now},
+	})
+	if err != nil {
+		return err
+	}
+	for i := range containers {
+		cont := &containers[i]
+		a, err := app.GetByName(cont.AppName)
+		if err != nil {
+			log.Errorf("[crashloop] unable to get app %s: %s", cont.AppName, err)
+			continue
+		}
+		log.Debugf("[crashloop] starting container %s of app %s after backoff", cont.ID, cont.AppName)
+		err = cont.Start(&container.StartArgs{Provisioner: p, App: a})
+		if err != nil {
+			log.Errorf("[crashloop] unable to start container %s: %s", cont.ID, err)
+			continue
+		}
+		err = cont.SetStatus(p, provision.StatusStarting.String(), true)
+		if err != nil {
+			log.Errorf("[crashloop] unable to update status of container %s: %s", cont.ID, err)
+			continue
+		}
+		app.PublishUnitStatusChange(cont.AppName, cont.ID, provision.StatusCrashLoop, provision.StatusStarting)
+		info, err := cont.NetworkInfo(p)
+		if err != nil {
+			log.Errorf("[crashloop] unable to get network info of container %s: %s", cont.ID, err)
+			continue
+		}
+		cont.SetNetworkInfo(info)
+		coll := p.Collection()
+		err = coll.Update(bson.M{"id": cont.ID}, cont)
+		coll.Close()
+		if err != nil {
+			log.Errorf("[crashloop] unable to update network info of container %s: %s", cont.ID, err)
+		}
+	}
+	return nil
+}
+
+// restoreCrashLoopRoute adds back to the router the container removed from it
+// when it entered a crash loop, once it reports it started. Only containers
+// running the web process are routed.
+func (p *dockerProvisioner) restoreCrashLoopRoute(cont *container.Container) error {
+	if !cont.RouteRemoved || cont.Status != provision.StatusStarted.String() {
+		return nil
+	}
+	webProcessName, err := getImageWebProcessName(cont.Image)
+	if err != nil {
+		log.Errorf("[crashloop] cannot get the name of the web process: %s", err)
+	}
+	if cont.ProcessName == webProcessName {
+		err = p.AddContainerRoute(*cont)
+		if err != nil {
+			return err
+		}
+	}
+	cont.RouteRemoved = false
+	coll := p.Collection()
+	defer coll.Close()
+	return coll.Update(bson.M{"id": cont.ID}, bson.M{"$set": bson.M{"routeremoved": false}})
+}
+
+// crashLoopChecker periodically starts the units in crash loop whose backoff
+// period expired. Only the API server holding the crash loop lease runs it.
+type crashLoopChecker struct {
+	provisioner *dockerProvisioner
+	interval    time.Duration
+	owner       string
+	done        chan bool
+}
+
+const crashLoopLeaseID = "crash-loop"
+
+func (p *dockerProvisioner) initCrashLoopChecker() *crashLoopChecker {
+	interval, _ := config.GetInt("docker:crashloop:check-interval")
+	if interval <= 0 {
+		interval = 10
+	}
+	return &crashLoopChecker{
+		provisioner: p,
+		interval:    time.Duration(interval) * time.Second,
+		owner:       randomString(),
+		done:        make(chan bool),
+	}
+}
+
+func (c *crashLoopChecker) run() {
+	for {
+		c.runOnce()
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+func (c *crashLoopChecker) runOnce() {
+	acquired, err := acquireLease(crashLoopLeaseID, c.owner, 2*c.interval)
+	if err != nil {
+		log.Errorf("[crashloop] unable to acquire lease: %s", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	err = c.provisioner.restartCrashLoopContainers(time.Now().UTC())
+	if err != nil {
+		log.Errorf("[crashloop] unable to restart containers: %s", err)
+	}
+}
+
+func (c *crashLoopChecker) Shutdown() {
+	c.done <- true
+}
+
+func (c *crashLoopChecker) String() string {
+	return "crash loop checker"
+}
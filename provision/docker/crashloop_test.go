@@ -0,0 +1,223 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestCrashLoopPolicyFromConfig(c *check.C) {
+	policy := crashLoopPolicyFromConfig()
+	c.Assert(policy, check.DeepEquals, crashLoopPolicy{
+		Threshold:  5,
+		Window:     5 * time.Minute,
+		MinBackoff: 10 * time.Second,
+		MaxBackoff: 5 * time.Minute,
+	})
+	config.Set("docker:crashloop:threshold", 3)
+	config.Set("docker:crashloop:window", 60)
+	config.Set("docker:crashloop:min-backoff", 5)
+	config.Set("docker:crashloop:max-backoff", 40)
+	defer config.Unset("docker:crashloop")
+	policy = crashLoopPolicyFromConfig()
+	c.Assert(policy, check.DeepEquals, crashLoopPolicy{
+		Threshold:  3,
+		Window:     time.Minute,
+		MinBackoff: 5 * time.Second,
+		MaxBackoff: 40 * time.Second,
+	})
+}
+
+func (s *S) TestCrashLoopPolicyBackoff(c *check.C) {
+	policy := crashLoopPolicy{Threshold: 3, MinBackoff: 10 * time.Second, MaxBackoff: time.Minute}
+	c.Assert(policy.backoff(3), check.Equals, 10*time.Second)
+	c.Assert(policy.backoff(4), check.Equals, 20*time.Second)
+	c.Assert(policy.backoff(5), check.Equals, 40*time.Second)
+	c.Assert(policy.backoff(6), check.Equals, time.Minute)
+	c.Assert(policy.backoff(20), check.Equals, time.Minute)
+}
+
+func (s *S) TestCrashReason(c *check.C) {
+	c.Assert(crashReason(docker.State{ExitCode: 2}), check.Equals, "exited with code 2")
+	c.Assert(crashReason(docker.State{ExitCode: 1, Error: "exec format error"}), check.Equals, "exec format error")
+	c.Assert(crashReason(docker.State{ExitCode: 137, OOMKilled: true}), check.Equals, "killed for exceeding the memory limit")
+}
+
+func (s *S) TestProvisionerSetUnitStatusCrashLoop(c *check.C) {
+	config.Set("docker:crashloop:threshold", 2)
+	defer config.Unset("docker:crashloop")
+	a := app.App{Name: "someapp"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	opts := newContainerOpts{Status: provision.StatusStarted.String(), AppName: a.Name}
+	cont, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(cont)
+	err = a.Log("unable to bind to port", "app", cont.ID)
+	c.Assert(err, check.IsNil)
+	unit := provision.Unit{ID: cont.ID, AppName: a.Name}
+	err = s.p.SetUnitStatus(unit, provision.StatusError)
+	c.Assert(err, check.IsNil)
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Status, check.Equals, provision.StatusError.String())
+	c.Assert(cont.RestartCount, check.Equals, 1)
+	err = s.p.SetUnitStatus(unit, provision.StatusStarting)
+	c.Assert(err, check.IsNil)
+	err = s.p.SetUnitStatus(unit, provision.StatusError)
+	c.Assert(err, check.IsNil)
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Status, check.Equals, provision.StatusCrashLoop.String())
+	c.Assert(cont.RestartCount, check.Equals, 2)
+	c.Assert(cont.CrashReason, check.Equals, "exited with code 0")
+	c.Assert(cont.CrashLogs, check.DeepEquals, []string{"unable to bind to port"})
+	c.Assert(cont.BackoffUntil.After(time.Now()), check.Equals, true)
+	c.Assert(cont.RouteRemoved, check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, false)
+	err = s.p.SetUnitStatus(unit, provision.StatusStarted)
+	c.Assert(err, check.IsNil)
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Status, check.Equals, provision.StatusCrashLoop.String())
+}
+
+func (s *S) TestRegisterCrashResetsCountAfterWindow(c *check.C) {
+	opts := newContainerOpts{Status: provision.StatusError.String(), AppName: "someapp"}
+	cont, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(cont)
+	now := time.Now().UTC()
+	cont.RestartCount = 4
+	cont.LastCrash = now.Add(-10 * time.Minute)
+	err = s.p.registerCrash(cont, now)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.RestartCount, check.Equals, 1)
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.RestartCount, check.Equals, 1)
+	c.Assert(cont.Status, check.Equals, provision.StatusError.String())
+}
+
+func (s *S) TestRestartCrashLoopContainers(c *check.C) {
+	a := app.App{Name: "someapp"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	opts := newContainerOpts{Status: provision.StatusCrashLoop.String(), AppName: a.Name}
+	expired, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(expired)
+	waiting, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(waiting)
+	now := time.Now().UTC()
+	coll := s.p.Collection()
+	defer coll.Close()
+	err = coll.Update(bson.M{"id": expired.ID}, bson.M{"$set": bson.M{"backoffuntil": now.Add(-time.Second)}})
+	c.Assert(err, check.IsNil)
+	err = coll.Update(bson.M{"id": waiting.ID}, bson.M{"$set": bson.M{"backoffuntil": now.Add(time.Minute)}})
+	c.Assert(err, check.IsNil)
+	err = s.p.restartCrashLoopContainers(now)
+	c.Assert(err, check.IsNil)
+	cont, err := s.p.GetContainer(expired.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Status, check.Equals, provision.StatusStarting.String())
+	dockerContainer, err := s.p.Cluster().InspectContainer(expired.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dockerContainer.State.Running, check.Equals, true)
+	cont, err = s.p.GetContainer(waiting.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Status, check.Equals, provision.StatusCrashLoop.String())
+}
+
+func (s *S) TestRestartCrashLoopContainersRestoresRouteOfWebUnitsOnStart(c *check.C) {
+	a := app.App{Name: "someapp"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{"web": "python web.py", "worker": "python worker.py"},
+	}
+	opts := newContainerOpts{
+		Status:          provision.StatusCrashLoop.String(),
+		AppName:         a.Name,
+		Image:           "tsuru/app-" + a.Name,
+		ImageCustomData: customData,
+		ProcessName:     "web",
+	}
+	web, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(web)
+	opts.ProcessName = "worker"
+	worker, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(worker)
+	coll := s.p.Collection()
+	defer coll.Close()
+	for _, cont := range []*container.Container{web, worker} {
+		routertest.FakeRouter.RemoveRoute(a.Name, cont.Address())
+		err = coll.Update(bson.M{"id": cont.ID}, bson.M{"$set": bson.M{
+			"backoffuntil": time.Now().UTC().Add(-time.Second),
+			"routeremoved": true,
+		}})
+		c.Assert(err, check.IsNil)
+	}
+	err = s.p.restartCrashLoopContainers(time.Now().UTC())
+	c.Assert(err, check.IsNil)
+	web, err = s.p.GetContainer(web.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(web.Status, check.Equals, provision.StatusStarting.String())
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, web.Address().String()), check.Equals, false)
+	worker, err = s.p.GetContainer(worker.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, worker.Address().String()), check.Equals, false)
+	for _, cont := range []*container.Container{web, worker} {
+		err = s.p.SetUnitStatus(provision.Unit{ID: cont.ID, AppName: a.Name}, provision.StatusStarted)
+		c.Assert(err, check.IsNil)
+	}
+	web, err = s.p.GetContainer(web.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(web.RouteRemoved, check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, web.Address().String()), check.Equals, true)
+	worker, err = s.p.GetContainer(worker.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(worker.RouteRemoved, check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, worker.Address().String()), check.Equals, false)
+}
+
+func (s *S) TestCrashLoopCheckerRunOnceWithoutLease(c *check.C) {
+	a := app.App{Name: "someapp"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	opts := newContainerOpts{Status: provision.StatusCrashLoop.String(), AppName: a.Name}
+	cont, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(cont)
+	coll := s.p.Collection()
+	defer coll.Close()
+	err = coll.Update(bson.M{"id": cont.ID}, bson.M{"$set": bson.M{"backoffuntil": time.Now().UTC().Add(-time.Second)}})
+	c.Assert(err, check.IsNil)
+	acquired, err := acquireLease(crashLoopLeaseID, "other-server", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	checker := crashLoopChecker{provisioner: s.p, interval: time.Minute, owner: "this-server"}
+	checker.runOnce()
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Status, check.Equals, provision.StatusCrashLoop.String())
+	checker.owner = "other-server"
+	checker.runOnce()
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(cont.Status, check.Equals, provision.StatusStarting.String())
+}
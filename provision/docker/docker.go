@@ -95,7 +95,7 @@ func (p *dockerProvisioner) deployPipeline(app provision.App, imageId string, co
 		&followLogsAndCommit,
 	}
 	pipeline := action.NewPipeline(actions...)
-	buildingImage, err := appNewImageName(app.GetName())
+	buildingImage, err := appNewImageName(app.GetName(), app.GetPool())
 	if err != nil {
 		return "", log.WrapError(fmt.Errorf("error getting new image name for app %s", app.GetName()))
 	}
@@ -108,6 +108,14 @@ func (p *dockerProvisioner) deployPipeline(app provision.App, imageId string, co
 		buildingImage: buildingImage,
 		provisioner:   p,
 	}
+	slot, err := p.acquireBuildNode(w)
+	if err != nil {
+		return "", err
+	}
+	if slot != nil {
+		defer slot.release()
+		args.destinationHosts = []string{urlToHost(slot.Node)}
+	}
 	err = pipeline.Execute(args)
 	if err != nil {
 		log.Errorf("error on execute deploy pipeline for app %s - %s", app.GetName(), err)
@@ -121,6 +129,10 @@ func (p *dockerProvisioner) start(oldContainer *container.Container, app provisi
 	if err != nil {
 		return nil, err
 	}
+	dockerfile, err := isDockerfileImage(imageId)
+	if err != nil {
+		return nil, err
+	}
 	var actions []*action.Action
 	if oldContainer != nil && oldContainer.Status == provision.StatusStopped.String() {
 		actions = []*action.Action{
@@ -145,6 +157,7 @@ func (p *dockerProvisioner) start(oldContainer *container.Container, app provisi
 		processName:      processName,
 		imageID:          imageId,
 		commands:         commands,
+		entrypoint:       dockerfile,
 		destinationHosts: destinationHosts,
 		provisioner:      p,
 	}
@@ -160,26 +173,24 @@ func (p *dockerProvisioner) start(oldContainer *container.Container, app provisi
 	return &c, nil
 }
 
-// PushImage sends the given image to the registry server defined in the
-// configuration file.
+// PushImage sends the given image to the registry server in its name, using
+// the credentials of that registry defined in the configuration file.
 func (p *dockerProvisioner) PushImage(name, tag string) error {
-	if _, err := config.GetString("docker:registry"); err == nil {
-		var buf safe.Buffer
-		pushOpts := docker.PushImageOptions{Name: name, Tag: tag, OutputStream: &buf}
-		err = p.Cluster().PushImage(pushOpts, p.RegistryAuthConfig())
-		if err != nil {
-			log.Errorf("[docker] Failed to push image %q (%s): %s", name, err, buf.String())
-			return err
-		}
+	registry := registryForImage(name)
+	if registry.Address == "" {
+		return nil
+	}
+	var buf safe.Buffer
+	pushOpts := docker.PushImageOptions{Name: name, Tag: tag, OutputStream: &buf}
+	err := p.Cluster().PushImage(pushOpts, registry.Auth)
+	if err != nil {
+		log.Errorf("[docker] Failed to push image %q (%s): %s", name, err, buf.String())
+		return err
 	}
 	return nil
 }
 
+// RegistryAuthConfig returns the credentials of the default registry.
 func (p *dockerProvisioner) RegistryAuthConfig() docker.AuthConfiguration {
-	var authConfig docker.AuthConfiguration
-	authConfig.Email, _ = config.GetString("docker:registry-auth:email")
-	authConfig.Username, _ = config.GetString("docker:registry-auth:username")
-	authConfig.Password, _ = config.GetString("docker:registry-auth:password")
-	authConfig.ServerAddress, _ = config.GetString("docker:registry")
-	return authConfig
+	return defaultRegistry().Auth
 }
@@ -215,6 +215,33 @@ func (s *S) TestGetImageWithRegistry(c *check.C) {
 	c.Assert(img, check.Equals, expected)
 }
 
+func (s *S) TestGetImageWithPoolRegistry(c *check.C) {
+	config.Set("docker:registry", "localhost:3030")
+	config.Set("docker:registries:pool1:address", "localhost:4040")
+	defer config.Unset("docker:registry")
+	defer config.Unset("docker:registries")
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	app.Pool = "pool1"
+	img := s.p.getBuildImage(app)
+	c.Assert(img, check.Equals, "localhost:4040/tsuru/python:latest")
+}
+
+func (s *S) TestGetImageAppInOtherPoolRegistry(c *check.C) {
+	config.Set("docker:registry", "localhost:3030")
+	config.Set("docker:registries:pool1:address", "localhost:4040")
+	defer config.Unset("docker:registry")
+	defer config.Unset("docker:registries")
+	a := &app.App{Name: "app1", Platform: "python", Deploys: 3, Pool: "pool1"}
+	err := appendAppImageName(a.Name, "localhost:3030/tsuru/app-app1:v3")
+	c.Assert(err, check.IsNil)
+	img := s.p.getBuildImage(a)
+	c.Assert(img, check.Equals, "localhost:4040/tsuru/python:latest")
+	err = appendAppImageName(a.Name, "localhost:4040/tsuru/app-app1:v4")
+	c.Assert(err, check.IsNil)
+	img = s.p.getBuildImage(a)
+	c.Assert(img, check.Equals, "localhost:4040/tsuru/app-app1:v4")
+}
+
 func (s *S) TestGitDeploy(c *check.C) {
 	stopCh := s.stopContainers(s.server.URL(), 1)
 	defer func() { <-stopCh }()
@@ -463,6 +490,44 @@ func (s *S) TestPushImageAuth(c *check.C) {
 	c.Assert(providedAuth.Password, check.Equals, "mypassword")
 }
 
+func (s *S) TestPushImagePoolRegistryAuth(c *check.C) {
+	var requests []*http.Request
+	server, err := testing.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
+		requests = append(requests, r)
+	})
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	config.Set("docker:registry", "localhost:3030")
+	config.Set("docker:registry-auth:username", "myuser")
+	config.Set("docker:registries:pool1:address", "localhost:4040")
+	config.Set("docker:registries:pool1:auth:username", "pooluser")
+	config.Set("docker:registries:pool1:auth:password", "poolpassword")
+	defer config.Unset("docker:registry")
+	defer config.Unset("docker:registry-auth")
+	defer config.Unset("docker:registries")
+	var p dockerProvisioner
+	err = p.Initialize()
+	c.Assert(err, check.IsNil)
+	p.cluster, err = cluster.New(nil, &cluster.MapStorage{},
+		cluster.Node{Address: server.URL()})
+	c.Assert(err, check.IsNil)
+	err = s.newFakeImage(&p, "localhost:4040/base/img", nil)
+	c.Assert(err, check.IsNil)
+	err = p.PushImage("localhost:4040/base/img", "")
+	c.Assert(err, check.IsNil)
+	c.Assert(requests, check.HasLen, 3)
+	c.Assert(requests[2].URL.Path, check.Equals, "/images/localhost:4040/base/img/push")
+	auth := requests[2].Header.Get("X-Registry-Auth")
+	var providedAuth docker.AuthConfiguration
+	data, err := base64.StdEncoding.DecodeString(auth)
+	c.Assert(err, check.IsNil)
+	err = json.Unmarshal(data, &providedAuth)
+	c.Assert(err, check.IsNil)
+	c.Assert(providedAuth.ServerAddress, check.Equals, "localhost:4040")
+	c.Assert(providedAuth.Username, check.Equals, "pooluser")
+	c.Assert(providedAuth.Password, check.Equals, "poolpassword")
+}
+
 func (s *S) TestPushImageNoRegistry(c *check.C) {
 	var request *http.Request
 	server, err := testing.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
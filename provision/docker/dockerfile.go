@@ -0,0 +1,232 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/yaml.v1"
+)
+
+// appSources holds the files from the app sources that are relevant to
+// deploys from a Dockerfile.
+type appSources struct {
+	hasDockerfile bool
+	procfile      string
+	tsuruYaml     string
+}
+
+// dockerfileDeployEnabled reports whether apps in the given pool may be
+// deployed from the Dockerfile in their sources. It's enabled per pool, in
+// the docker:dockerfile-deploy:pools setting.
+func dockerfileDeployEnabled(pool string) bool {
+	if pool == "" {
+		return false
+	}
+	pools, _ := config.GetList("docker:dockerfile-deploy:pools")
+	for _, p := range pools {
+		if p == pool {
+			return true
+		}
+	}
+	return false
+}
+
+// readAppSources looks for the Dockerfile, the Procfile and the tsuru.yaml
+// files in the root of the given archive, which may be gzipped or not.
+func readAppSources(archive []byte) (*appSources, error) {
+	var r io.Reader
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err == nil {
+		defer gzipReader.Close()
+		r = gzipReader
+	} else {
+		r = bytes.NewReader(archive)
+	}
+	var sources appSources
+	tarReader := tar.NewReader(r)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid archive: %s", err)
+		}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
+			continue
+		}
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		switch name {
+		case "Dockerfile":
+			sources.hasDockerfile = true
+		case "Procfile", "tsuru.yaml", "tsuru.yml":
+			data, err := ioutil.ReadAll(tarReader)
+			if err != nil {
+				return nil, fmt.Errorf("invalid archive: %s", err)
+			}
+			if name == "Procfile" {
+				sources.procfile = string(data)
+			} else {
+				sources.tsuruYaml = string(data)
+			}
+		}
+	}
+	return &sources, nil
+}
+
+// downloadArchive returns the contents of the archive in the given URL.
+func downloadArchive(archiveURL string) ([]byte, error) {
+	resp, err := http.Get(archiveURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download archive %s: unexpected status code %d", archiveURL, resp.StatusCode)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// stringKeys converts the maps decoded from YAML, which have interface{}
+// keys, to maps with string keys, as stored in the image custom data.
+func stringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = stringKeys(item)
+		}
+		return result
+	case []interface{}:
+		for i := range v {
+			v[i] = stringKeys(v[i])
+		}
+	}
+	return value
+}
+
+// dockerfileCustomData returns the custom data of an image built from a
+// Dockerfile, the same data sent by tsuru_unit_agent in regular deploys. When
+// there's no Procfile, the web process runs the command of the image, which
+// is inspected with the given function. The entrypoint of the image is part
+// of that command, as units of these images replace the entrypoint. Exec form
+// arguments are quoted, as the command runs through the shell.
+func dockerfileCustomData(sources *appSources, imageName string, inspectImage func(string) (*docker.Image, error)) (map[string]interface{}, error) {
+	customData := make(map[string]interface{})
+	if sources.tsuruYaml != "" {
+		var yamlData map[interface{}]interface{}
+		err := yaml.Unmarshal([]byte(sources.tsuruYaml), &yamlData)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tsuru.yaml: %s", err)
+		}
+		customData = stringKeys(yamlData).(map[string]interface{})
+	}
+	customData["dockerfile"] = true
+	if sources.procfile != "" {
+		customData["procfile"] = sources.procfile
+		return customData, nil
+	}
+	image, err := inspectImage(imageName)
+	if err != nil {
+		return nil, err
+	}
+	var cmd []string
+	if image.Config != nil {
+		cmd = append(cmd, image.Config.Entrypoint...)
+		cmd = append(cmd, image.Config.Cmd...)
+	}
+	if len(cmd) == 0 {
+		return nil, errors.New("no Procfile found and the Dockerfile does not define a command")
+	}
+	words := make([]string, len(cmd))
+	for i, arg := range cmd {
+		words[i] = shellQuote(arg)
+	}
+	customData["processes"] = map[string]interface{}{"web": strings.Join(words, " ")}
+	return customData, nil
+}
+
+// shellQuote quotes the given argument so the shell reads it as a single
+// word, keeping arguments that don't need quotes untouched.
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789@%+=:,./_-") == "" {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// isDockerfileImage checks whether the given image was built from a
+// Dockerfile. These images may not have bash and may define an entrypoint.
+func isDockerfileImage(imageName string) (bool, error) {
+	data, err := getImageCustomData(imageName)
+	if err != nil {
+		return false, err
+	}
+	built, _ := data.CustomData["dockerfile"].(bool)
+	return built, nil
+}
+
+// dockerfileDeploy builds the app image from the Dockerfile in the given
+// archive, instead of running the deploy in a platform image. The Procfile
+// and tsuru.yaml in the archive are saved as the image custom data, so the
+// rest of the deploy is the same as in regular deploys.
+func (p *dockerProvisioner) dockerfileDeploy(app provision.App, archive []byte, sources *appSources, w io.Writer) (string, error) {
+	buildingImage, err := appNewImageName(app.GetName(), app.GetPool())
+	if err != nil {
+		return "", fmt.Errorf("error getting new image name for app %s", app.GetName())
+	}
+	fmt.Fprintf(w, "\n---- Building application image from Dockerfile ----\n")
+	buildOptions := docker.BuildImageOptions{
+		Name:           buildingImage,
+		RmTmpContainer: true,
+		InputStream:    bytes.NewReader(archive),
+		OutputStream:   w,
+	}
+	slot, err := p.acquireBuildNode(w)
+	if err != nil {
+		return "", err
+	}
+	inspectImage := p.Cluster().InspectImage
+	if slot != nil {
+		defer slot.release()
+		var client *docker.Client
+		client, err = docker.NewClient(slot.Node)
+		if err != nil {
+			return "", err
+		}
+		inspectImage = client.InspectImage
+		err = p.buildImageInNode(slot.Node, buildOptions)
+	} else {
+		err = p.Cluster().BuildImage(buildOptions)
+		if err == nil {
+			err = p.PushImage(splitImageName(buildingImage))
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	customData, err := dockerfileCustomData(sources, buildingImage, inspectImage)
+	if err != nil {
+		return "", err
+	}
+	err = saveImageCustomData(buildingImage, customData)
+	if err != nil {
+		return "", err
+	}
+	return buildingImage, nil
+}
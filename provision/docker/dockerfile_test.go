@@ -0,0 +1,275 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/config"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/quota"
+	"github.com/tsuru/tsuru/router/routertest"
+	"github.com/tsuru/tsuru/safe"
+	"gopkg.in/check.v1"
+)
+
+func makeArchive(c *check.C, files map[string]string, compress bool) []byte {
+	var buf bytes.Buffer
+	var gzipWriter *gzip.Writer
+	var w io.Writer = &buf
+	if compress {
+		gzipWriter = gzip.NewWriter(&buf)
+		w = gzipWriter
+	}
+	tarWriter := tar.NewWriter(w)
+	for name, content := range files {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		c.Assert(err, check.IsNil)
+		_, err = tarWriter.Write([]byte(content))
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(tarWriter.Close(), check.IsNil)
+	if compress {
+		c.Assert(gzipWriter.Close(), check.IsNil)
+	}
+	return buf.Bytes()
+}
+
+func (s *S) TestDockerfileDeployEnabled(c *check.C) {
+	config.Set("docker:dockerfile-deploy:pools", []interface{}{"pool1", "pool2"})
+	defer config.Unset("docker:dockerfile-deploy")
+	c.Assert(dockerfileDeployEnabled("pool1"), check.Equals, true)
+	c.Assert(dockerfileDeployEnabled("pool2"), check.Equals, true)
+	c.Assert(dockerfileDeployEnabled("pool3"), check.Equals, false)
+	c.Assert(dockerfileDeployEnabled(""), check.Equals, false)
+}
+
+func (s *S) TestDockerfileDeployEnabledNotConfigured(c *check.C) {
+	c.Assert(dockerfileDeployEnabled("pool1"), check.Equals, false)
+}
+
+func (s *S) TestReadAppSources(c *check.C) {
+	archive := makeArchive(c, map[string]string{
+		"Dockerfile":    "FROM busybox",
+		"./Procfile":    "web: ./server",
+		"tsuru.yaml":    "hooks:\n  build:\n    - make",
+		"src/Procfile":  "worker: ./worker",
+		"src/server.go": "package main",
+	}, true)
+	sources, err := readAppSources(archive)
+	c.Assert(err, check.IsNil)
+	c.Assert(sources, check.DeepEquals, &appSources{
+		hasDockerfile: true,
+		procfile:      "web: ./server",
+		tsuruYaml:     "hooks:\n  build:\n    - make",
+	})
+}
+
+func (s *S) TestReadAppSourcesUncompressed(c *check.C) {
+	archive := makeArchive(c, map[string]string{"Dockerfile": "FROM busybox"}, false)
+	sources, err := readAppSources(archive)
+	c.Assert(err, check.IsNil)
+	c.Assert(sources, check.DeepEquals, &appSources{hasDockerfile: true})
+}
+
+func (s *S) TestReadAppSourcesWithoutDockerfile(c *check.C) {
+	archive := makeArchive(c, map[string]string{"src/Dockerfile": "FROM busybox", "tsuru.yml": "{}"}, true)
+	sources, err := readAppSources(archive)
+	c.Assert(err, check.IsNil)
+	c.Assert(sources, check.DeepEquals, &appSources{tsuruYaml: "{}"})
+}
+
+func (s *S) TestDockerfileCustomData(c *check.C) {
+	sources := &appSources{
+		hasDockerfile: true,
+		procfile:      "web: ./server",
+		tsuruYaml:     "hooks:\n  restart:\n    before:\n      - ./migrate\nhealthcheck:\n  path: /healthcheck",
+	}
+	inspect := func(string) (*docker.Image, error) {
+		return nil, errors.New("should not inspect the image")
+	}
+	customData, err := dockerfileCustomData(sources, "tsuru/app-myapp:v1", inspect)
+	c.Assert(err, check.IsNil)
+	c.Assert(customData, check.DeepEquals, map[string]interface{}{
+		"procfile": "web: ./server",
+		"hooks": map[string]interface{}{
+			"restart": map[string]interface{}{
+				"before": []interface{}{"./migrate"},
+			},
+		},
+		"healthcheck": map[string]interface{}{"path": "/healthcheck"},
+		"dockerfile":  true,
+	})
+}
+
+func (s *S) TestDockerfileCustomDataWithoutProcfile(c *check.C) {
+	inspect := func(name string) (*docker.Image, error) {
+		c.Assert(name, check.Equals, "tsuru/app-myapp:v1")
+		return &docker.Image{Config: &docker.Config{
+			Entrypoint: []string{"/usr/bin/server"},
+			Cmd:        []string{"--port", "8888"},
+		}}, nil
+	}
+	customData, err := dockerfileCustomData(&appSources{hasDockerfile: true}, "tsuru/app-myapp:v1", inspect)
+	c.Assert(err, check.IsNil)
+	c.Assert(customData, check.DeepEquals, map[string]interface{}{
+		"processes":  map[string]interface{}{"web": "/usr/bin/server --port 8888"},
+		"dockerfile": true,
+	})
+}
+
+func (s *S) TestDockerfileCustomDataQuotesArguments(c *check.C) {
+	inspect := func(string) (*docker.Image, error) {
+		return &docker.Image{Config: &docker.Config{
+			Cmd: []string{"sh", "-c", "./migrate && ./server --name 'my app'", ""},
+		}}, nil
+	}
+	customData, err := dockerfileCustomData(&appSources{hasDockerfile: true}, "tsuru/app-myapp:v1", inspect)
+	c.Assert(err, check.IsNil)
+	processes := customData["processes"].(map[string]interface{})
+	c.Assert(processes["web"], check.Equals, `sh -c './migrate && ./server --name '\''my app'\''' ''`)
+}
+
+func (s *S) TestShellQuote(c *check.C) {
+	c.Assert(shellQuote("/usr/bin/server"), check.Equals, "/usr/bin/server")
+	c.Assert(shellQuote("--port=8888"), check.Equals, "--port=8888")
+	c.Assert(shellQuote("a && b"), check.Equals, "'a && b'")
+	c.Assert(shellQuote("it's"), check.Equals, `'it'\''s'`)
+	c.Assert(shellQuote(""), check.Equals, "''")
+	c.Assert(shellQuote("$HOME"), check.Equals, "'$HOME'")
+}
+
+func (s *S) TestStartDockerfileImageReplacesEntrypoint(c *check.C) {
+	inspect := func(string) (*docker.Image, error) {
+		return &docker.Image{Config: &docker.Config{
+			Entrypoint: []string{"/usr/bin/server"},
+			Cmd:        []string{"--port", "8888"},
+		}}, nil
+	}
+	customData, err := dockerfileCustomData(&appSources{hasDockerfile: true}, "tsuru/app-myapp:v1", inspect)
+	c.Assert(err, check.IsNil)
+	err = s.newFakeImage(s.p, "tsuru/app-myapp:v1", customData)
+	c.Assert(err, check.IsNil)
+	app := provisiontest.NewFakeApp("myapp", "python", 1)
+	routertest.FakeRouter.AddBackend(app.GetName())
+	defer routertest.FakeRouter.RemoveBackend(app.GetName())
+	cont, err := s.p.start(&container.Container{ProcessName: "web"}, app, "tsuru/app-myapp:v1", new(bytes.Buffer))
+	c.Assert(err, check.IsNil)
+	defer cont.Remove(s.p)
+	dockerContainer, err := s.p.Cluster().InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dockerContainer.Config.Entrypoint, check.DeepEquals, []string{"/bin/sh"})
+	c.Assert(dockerContainer.Config.Cmd, check.DeepEquals, []string{"-c", "exec /usr/bin/server --port 8888"})
+}
+
+func (s *S) TestDockerfileCustomDataWithoutCommand(c *check.C) {
+	inspect := func(string) (*docker.Image, error) {
+		return &docker.Image{Config: &docker.Config{}}, nil
+	}
+	_, err := dockerfileCustomData(&appSources{hasDockerfile: true}, "tsuru/app-myapp:v1", inspect)
+	c.Assert(err, check.ErrorMatches, "no Procfile found and the Dockerfile does not define a command")
+}
+
+func (s *S) TestDockerfileCustomDataInvalidTsuruYaml(c *check.C) {
+	sources := &appSources{hasDockerfile: true, procfile: "web: ./server", tsuruYaml: "hooks: ["}
+	_, err := dockerfileCustomData(sources, "tsuru/app-myapp:v1", nil)
+	c.Assert(err, check.ErrorMatches, "invalid tsuru.yaml: .*")
+}
+
+func (s *S) TestProvisionerUploadDeployWithDockerfile(c *check.C) {
+	var buildRequests []*http.Request
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
+		if r.URL.Path == "/build" {
+			buildRequests = append(buildRequests, r)
+		}
+	})
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	s.p.cluster, err = cluster.New(nil, &cluster.MapStorage{},
+		cluster.Node{Address: server.URL(), Metadata: map[string]string{"pool": "test-default"}},
+	)
+	c.Assert(err, check.IsNil)
+	config.Set("docker:dockerfile-deploy:pools", []interface{}{"test-default"})
+	defer config.Unset("docker:dockerfile-deploy")
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "python",
+		Pool:     "test-default",
+		Quota:    quota.Unlimited,
+	}
+	err = s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.p.Provision(&a)
+	defer s.p.Destroy(&a)
+	archive := makeArchive(c, map[string]string{
+		"Dockerfile": "FROM busybox\nCMD [\"./server\"]",
+		"Procfile":   "web: ./server",
+	}, false)
+	w := safe.NewBuffer(make([]byte, 2048))
+	err = app.Deploy(app.DeployOptions{
+		App:          &a,
+		File:         ioutil.NopCloser(bytes.NewReader(archive)),
+		OutputStream: w,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(buildRequests, check.HasLen, 1)
+	c.Assert(buildRequests[0].URL.Query().Get("t"), check.Equals, "tsuru/app-otherapp:v1")
+	c.Assert(w.String(), check.Matches, "(?s).*---- Building application image from Dockerfile ----.*")
+	imageData, err := getImageCustomData("tsuru/app-otherapp:v1")
+	c.Assert(err, check.IsNil)
+	c.Assert(imageData.Processes, check.DeepEquals, map[string]string{"web": "./server"})
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+}
+
+func (s *S) TestProvisionerUploadDeployWithDockerfileDisabledInPool(c *check.C) {
+	stopCh := s.stopContainers(s.server.URL(), 2)
+	defer func() { <-stopCh }()
+	err := s.newFakeImage(s.p, "tsuru/python:latest", nil)
+	c.Assert(err, check.IsNil)
+	config.Set("docker:dockerfile-deploy:pools", []interface{}{"other-pool"})
+	defer config.Unset("docker:dockerfile-deploy")
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "python",
+		Pool:     "test-default",
+		Quota:    quota.Unlimited,
+	}
+	err = s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.p.Provision(&a)
+	defer s.p.Destroy(&a)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+	}
+	err = saveImageCustomData("tsuru/app-"+a.Name+":v1", customData)
+	c.Assert(err, check.IsNil)
+	archive := makeArchive(c, map[string]string{"Dockerfile": "FROM busybox"}, true)
+	w := safe.NewBuffer(make([]byte, 2048))
+	err = app.Deploy(app.DeployOptions{
+		App:          &a,
+		File:         ioutil.NopCloser(bytes.NewReader(archive)),
+		OutputStream: w,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(w.String(), check.Not(check.Matches), "(?s).*Building application image from Dockerfile.*")
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+}
@@ -32,7 +32,7 @@ func (p *dockerProvisioner) checkContainer(container *container.Container) error
 		if err != nil {
 			return err
 		}
-		if info.HTTPHostPort != container.HostPort || info.IP != container.IP {
+		if info.HTTPHostPort != container.HostPort || info.IP != container.IP || hostPortsChanged(container, info) {
 			err = p.fixContainer(container, info)
 			if err != nil {
 				log.Errorf("error on fix container hostport for [container %s]", container.ID)
@@ -43,6 +43,15 @@ func (p *dockerProvisioner) checkContainer(container *container.Container) error
 	return nil
 }
 
+func hostPortsChanged(container *container.Container, info container.NetworkInfo) bool {
+	for _, p := range container.Ports {
+		if info.HostPorts[p.Name] != p.HostPort {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *dockerProvisioner) fixContainer(container *container.Container, info container.NetworkInfo) error {
 	if info.HTTPHostPort == "" {
 		return nil
@@ -59,8 +68,7 @@ func (p *dockerProvisioner) fixContainer(container *container.Container, info co
 	if err != nil && err != router.ErrRouteNotFound {
 		return err
 	}
-	container.IP = info.IP
-	container.HostPort = info.HTTPHostPort
+	container.SetNetworkInfo(info)
 	err = r.AddRoute(container.AppName, container.Address())
 	if err != nil && err != router.ErrRouteExists {
 		return err
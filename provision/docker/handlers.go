@@ -37,6 +37,9 @@ func init() {
 	api.RegisterHandler("/docker/node", "POST", api.AdminRequiredHandler(addNodeHandler))
 	api.RegisterHandler("/docker/node", "PUT", api.AdminRequiredHandler(updateNodeHandler))
 	api.RegisterHandler("/docker/node", "DELETE", api.AdminRequiredHandler(removeNodeHandler))
+	api.RegisterHandler("/docker/node/cordon", "POST", api.AdminRequiredHandler(cordonNodeHandler))
+	api.RegisterHandler("/docker/node/uncordon", "POST", api.AdminRequiredHandler(uncordonNodeHandler))
+	api.RegisterHandler("/docker/node/drain", "POST", api.AdminRequiredHandler(drainNodeHandler))
 	api.RegisterHandler("/docker/container/{id}/move", "POST", api.AdminRequiredHandler(moveContainerHandler))
 	api.RegisterHandler("/docker/containers/move", "POST", api.AdminRequiredHandler(moveContainersHandler))
 	api.RegisterHandler("/docker/containers/rebalance", "POST", api.AdminRequiredHandler(rebalanceContainersHandler))
@@ -45,6 +48,9 @@ func init() {
 	api.RegisterHandler("/docker/autoscale", "GET", api.AdminRequiredHandler(autoScaleHistoryHandler))
 	api.RegisterHandler("/docker/autoscale/config", "GET", api.AdminRequiredHandler(autoScaleGetConfig))
 	api.RegisterHandler("/docker/autoscale/run", "POST", api.AdminRequiredHandler(autoScaleRunHandler))
+	api.RegisterHandler("/docker/autoscale/simulate", "POST", api.AdminRequiredHandler(autoScaleSimulateHandler))
+	api.RegisterHandler("/docker/image-gc", "GET", api.AdminRequiredHandler(imageGCReportHandler))
+	api.RegisterHandler("/docker/reconciler", "GET", api.AdminRequiredHandler(reconcileReportHandler))
 	api.RegisterHandler("/docker/autoscale/rules", "GET", api.AdminRequiredHandler(autoScaleListRules))
 	api.RegisterHandler("/docker/autoscale/rules", "POST", api.AdminRequiredHandler(autoScaleSetRule))
 	api.RegisterHandler("/docker/autoscale/rules/", "DELETE", api.AdminRequiredHandler(autoScaleDeleteRule))
@@ -234,6 +240,59 @@ func updateNodeHandler(w http.ResponseWriter, r *http.Request, t auth.Token) err
 	return err
 }
 
+func cordonNodeHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	return setNodeCordoned(r, true)
+}
+
+func uncordonNodeHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	return setNodeCordoned(r, false)
+}
+
+func setNodeCordoned(r *http.Request, cordoned bool) error {
+	params, err := unmarshal(r.Body)
+	if err != nil {
+		return err
+	}
+	address := params["address"]
+	if address == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "address is required"}
+	}
+	err = mainDockerProvisioner.setNodeCordoned(address, cordoned)
+	if err == errNodeNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	return err
+}
+
+func drainNodeHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	params, err := unmarshal(r.Body)
+	if err != nil {
+		return err
+	}
+	address := params["address"]
+	if address == "" {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: "address is required"}
+	}
+	var batchSize int
+	if params["batch"] != "" {
+		batchSize, err = strconv.Atoi(params["batch"])
+		if err != nil || batchSize < 1 {
+			return &errors.HTTP{Code: http.StatusBadRequest, Message: "batch must be a positive integer"}
+		}
+	}
+	writer := &tsuruIo.SimpleJsonMessageEncoderWriter{
+		Encoder: json.NewEncoder(w),
+	}
+	err = mainDockerProvisioner.drainNode(address, batchSize, writer)
+	if err == errNodeNotFound {
+		return &errors.HTTP{Code: http.StatusNotFound, Message: err.Error()}
+	}
+	if err != nil {
+		fmt.Fprintf(writer, "Error trying to drain node: %s\n", err.Error())
+	}
+	return nil
+}
+
 func fixContainersHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	err := mainDockerProvisioner.fixContainers()
 	if err != nil {
@@ -381,6 +440,45 @@ func autoScaleRunHandler(w http.ResponseWriter, r *http.Request, t auth.Token) e
 	return nil
 }
 
+func autoScaleSimulateHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	var opts autoScaleSimulationOptions
+	err := json.NewDecoder(r.Body).Decode(&opts)
+	if err != nil {
+		return &errors.HTTP{
+			Code:    http.StatusBadRequest,
+			Message: fmt.Sprintf("unable to parse body as json: %s", err),
+		}
+	}
+	result, err := mainDockerProvisioner.simulateAutoScale(opts)
+	if err != nil {
+		return &errors.HTTP{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(result)
+}
+
+// imageGCReportHandler runs the image garbage collector in dry run mode,
+// returning the images that would be removed.
+func imageGCReportHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	report, err := mainDockerProvisioner.collectImages(imageGCPolicyFromConfig(), true)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(report)
+}
+
+// reconcileReportHandler runs the containers reconciler in dry run mode,
+// returning the differences between the database and the docker nodes.
+func reconcileReportHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
+	report, err := mainDockerProvisioner.reconcile(reconcileMinAge(), true)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(report)
+}
+
 func bsEnvSetHandler(w http.ResponseWriter, r *http.Request, t auth.Token) error {
 	var requestConfig bs.Config
 	err := json.NewDecoder(r.Body).Decode(&requestConfig)
@@ -1005,6 +1005,87 @@ func (s *HandlersSuite) TestUpdateNodeHandler(c *check.C) {
 	})
 }
 
+func (s *HandlersSuite) TestCordonNodeHandler(c *check.C) {
+	mainDockerProvisioner.cluster, _ = cluster.New(&segregatedScheduler{}, &cluster.MapStorage{},
+		cluster.Node{Address: "localhost:1999", Metadata: map[string]string{"pool": "pool1"}},
+	)
+	b := bytes.NewBufferString(`{"address": "localhost:1999"}`)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/node/cordon", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	nodes, err := mainDockerProvisioner.Cluster().Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(nodes[0].Metadata, check.DeepEquals, map[string]string{"pool": "pool1", "cordoned": "true"})
+	b = bytes.NewBufferString(`{"address": "localhost:1999"}`)
+	recorder = httptest.NewRecorder()
+	request, err = http.NewRequest("POST", "/docker/node/uncordon", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	nodes, err = mainDockerProvisioner.Cluster().Nodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes[0].Metadata, check.DeepEquals, map[string]string{"pool": "pool1"})
+}
+
+func (s *HandlersSuite) TestCordonNodeHandlerNotFound(c *check.C) {
+	mainDockerProvisioner.cluster, _ = cluster.New(&segregatedScheduler{}, &cluster.MapStorage{})
+	b := bytes.NewBufferString(`{"address": "localhost:1999"}`)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/node/cordon", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *HandlersSuite) TestDrainNodeHandler(c *check.C) {
+	mainDockerProvisioner.cluster, _ = cluster.New(&segregatedScheduler{}, &cluster.MapStorage{},
+		cluster.Node{Address: "http://localhost:1999"},
+	)
+	b := bytes.NewBufferString(`{"address": "http://localhost:1999", "batch": "2"}`)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/node/drain", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Body.String(), check.Equals, `{"Message":"No units to move in localhost\n"}`+"\n")
+	nodes, err := mainDockerProvisioner.Cluster().UnfilteredNodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(isCordoned(&nodes[0]), check.Equals, true)
+}
+
+func (s *HandlersSuite) TestDrainNodeHandlerNodeNotFound(c *check.C) {
+	mainDockerProvisioner.cluster, _ = cluster.New(&segregatedScheduler{}, &cluster.MapStorage{})
+	b := bytes.NewBufferString(`{"address": "http://localhost:1999"}`)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/node/drain", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
+
+func (s *HandlersSuite) TestDrainNodeHandlerInvalidBatch(c *check.C) {
+	b := bytes.NewBufferString(`{"address": "http://localhost:1999", "batch": "x"}`)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/node/drain", b)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+}
+
 func (s *HandlersSuite) TestUpdateNodeHandlerNoAddress(c *check.C) {
 	mainDockerProvisioner.cluster, _ = cluster.New(&segregatedScheduler{}, &cluster.MapStorage{},
 		cluster.Node{Address: "localhost:1999", Metadata: map[string]string{
@@ -1076,6 +1157,90 @@ func (s *HandlersSuite) TestAutoScaleRunHandler(c *check.C) {
 	})
 }
 
+func (s *HandlersSuite) TestAutoScaleSimulateHandler(c *check.C) {
+	mainDockerProvisioner.scheduler = &segregatedScheduler{provisioner: mainDockerProvisioner}
+	defer func() { mainDockerProvisioner.scheduler = nil }()
+	config.Set("docker:auto-scale:group-by-metadata", "pool")
+	defer config.Unset("docker:auto-scale:group-by-metadata")
+	metadata := map[string]string{"pool": "pool1", "iaas": "my-iaas"}
+	opts := autoScaleSimulationOptions{
+		MetadataValue: "pool1",
+		Rule:          &autoScaleRule{Enabled: true, MaxContainerCount: 4},
+		Nodes: []cluster.Node{
+			{Address: "http://n1:2375", Metadata: metadata},
+			{Address: "http://n2:2375", Metadata: metadata},
+		},
+		Containers: []container.Container{},
+	}
+	data, err := json.Marshal(opts)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/autoscale/simulate", bytes.NewBuffer(data))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var result autoScaleSimulationResult
+	err = json.NewDecoder(recorder.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Scaler, check.Equals, "count")
+	c.Assert(result.Action, check.Equals, "remove")
+	c.Assert(result.Reason, check.Equals, "number of free slots is 8, removing 1 nodes")
+	c.Assert(result.ToRemove, check.HasLen, 1)
+	evts, err := listAutoScaleEvents(0, 0)
+	c.Assert(err, check.IsNil)
+	c.Assert(evts, check.HasLen, 0)
+}
+
+func (s *HandlersSuite) TestAutoScaleSimulateHandlerInvalidRule(c *check.C) {
+	opts := autoScaleSimulationOptions{
+		MetadataValue: "pool1",
+		Rule:          &autoScaleRule{Enabled: true, MaxContainerCount: 4, ScaleDownRatio: 0.9},
+	}
+	data, err := json.Marshal(opts)
+	c.Assert(err, check.IsNil)
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("POST", "/docker/autoscale/simulate", bytes.NewBuffer(data))
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusBadRequest)
+	c.Assert(recorder.Body.String(), check.Matches, "(?s).*invalid rule, scale down ratio needs to be greater than 1.0, got 0.9.*")
+}
+
+func (s *HandlersSuite) TestImageGCReportHandler(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/docker/image-gc", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var report imageGCReport
+	err = json.NewDecoder(recorder.Body).Decode(&report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report, check.DeepEquals, imageGCReport{DryRun: true})
+}
+
+func (s *HandlersSuite) TestReconcileReportHandler(c *check.C) {
+	recorder := httptest.NewRecorder()
+	request, err := http.NewRequest("GET", "/docker/reconciler", nil)
+	c.Assert(err, check.IsNil)
+	request.Header.Set("Authorization", "bearer "+s.token.GetValue())
+	server := api.RunServer(true)
+	server.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusOK)
+	c.Assert(recorder.Header().Get("Content-Type"), check.Equals, "application/json")
+	var report reconcileReport
+	err = json.NewDecoder(recorder.Body).Decode(&report)
+	c.Assert(err, check.IsNil)
+	c.Assert(report, check.DeepEquals, reconcileReport{DryRun: true})
+}
+
 type bsEnvList []bs.Env
 
 func (l bsEnvList) Len() int           { return len(l) }
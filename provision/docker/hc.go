@@ -12,7 +12,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/hc"
 )
 
@@ -23,11 +22,34 @@ func init() {
 	hc.AddChecker("docker", healthCheckDocker)
 }
 
+// healthCheckDockerRegistry checks every configured registry, including the
+// registries of the pools and their mirrors.
 func healthCheckDockerRegistry() error {
-	registry, _ := config.GetString("docker:registry")
-	if registry == "" {
+	var addresses []string
+	for _, r := range allRegistries() {
+		addresses = append(addresses, r.Address)
+		addresses = append(addresses, r.Mirrors...)
+	}
+	if len(addresses) == 0 {
 		return hc.ErrDisabledComponent
 	}
+	if len(addresses) == 1 {
+		return pingDockerRegistry(addresses[0])
+	}
+	var errs []string
+	for _, address := range addresses {
+		err := pingDockerRegistry(address)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", address, err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func pingDockerRegistry(registry string) error {
 	if !httpRegexp.MatchString(registry) {
 		registry = "http://" + registry
 	}
@@ -94,6 +94,36 @@ func (s *S) TestHealthCheckDockerRegistryFailure(c *check.C) {
 	c.Assert(err.Error(), check.Equals, "unexpected status - not pong")
 }
 
+func (s *S) TestHealthCheckDockerRegistryPoolRegistriesAndMirrors(c *check.C) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("not pong"))
+	}))
+	defer failingServer.Close()
+	if old, err := config.Get("docker:registry"); err == nil {
+		defer config.Set("docker:registry", old)
+	} else {
+		defer config.Unset("docker:registry")
+	}
+	defer config.Unset("docker:registries")
+	config.Set("docker:registry", server.URL)
+	config.Set("docker:registries:pool1:address", server.URL+"/")
+	config.Set("docker:registries:pool1:mirrors", []interface{}{server.URL})
+	err := healthCheckDockerRegistry()
+	c.Assert(err, check.IsNil)
+	c.Assert(paths, check.DeepEquals, []string{"/v2/", "/v2/", "/v2/"})
+	config.Set("docker:registries:pool1:mirrors", []interface{}{failingServer.URL})
+	err = healthCheckDockerRegistry()
+	c.Assert(err, check.NotNil)
+	c.Assert(err.Error(), check.Equals, failingServer.URL+": unexpected status - not pong")
+}
+
 func (s *S) TestHealthCheckDockerRegistryUnconfigured(c *check.C) {
 	if old, err := config.Get("docker:registry"); err == nil {
 		defer config.Set("docker:registry", old)
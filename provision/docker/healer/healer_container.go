@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/docker/container"
@@ -59,15 +60,28 @@ func (h *ContainerHealer) String() string {
 }
 
 func (h *ContainerHealer) healContainer(cont container.Container) (container.Container, error) {
+	return moveContainer(h.provisioner, cont, h.locker)
+}
+
+func moveContainer(p DockerProvisioner, cont container.Container, locker AppLocker) (container.Container, error) {
 	var buf bytes.Buffer
 	moveErrors := make(chan error, 1)
-	createdContainer := h.provisioner.MoveOneContainer(cont, "", moveErrors, nil, &buf, h.locker)
+	createdContainer := p.MoveOneContainer(cont, "", moveErrors, nil, &buf, locker)
 	close(moveErrors)
-	err := h.provisioner.HandleMoveErrors(moveErrors, &buf)
+	err := p.HandleMoveErrors(moveErrors, &buf)
 	if err != nil {
 		err = fmt.Errorf("Error trying to heal containers %s: couldn't move container: %s - %s", cont.ID, err.Error(), buf.String())
-	}
-	return createdContainer, err
+		return createdContainer, err
+	}
+	app.PublishUnitEvent(app.UnitEvent{
+		App:     cont.AppName,
+		Unit:    cont.ID,
+		Kind:    app.UnitEventHealed,
+		Host:    cont.HostAddr,
+		NewUnit: createdContainer.ID,
+		NewHost: createdContainer.HostAddr,
+	})
+	return createdContainer, nil
 }
 
 func (h *ContainerHealer) isRunning(cont container.Container) (bool, error) {
@@ -145,6 +159,6 @@ func listUnresponsiveContainers(p DockerProvisioner, maxUnresponsiveTime time.Du
 	return p.ListContainers(bson.M{
 		"lastsuccessstatusupdate": bson.M{"$lt": now.Add(-maxUnresponsiveTime)},
 		"hostport":                bson.M{"$ne": ""},
-		"status":                  bson.M{"$ne": provision.StatusStopped.String()},
+		"status":                  bson.M{"$nin": []string{provision.StatusStopped.String(), provision.StatusCrashLoop.String()}},
 	})
 }
@@ -0,0 +1,229 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package healer
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultLivenessThreshold = 3
+
+// LivenessProvisioner is a DockerProvisioner able to run the healthcheck
+// declared in the tsuru.yaml of the apps against running containers.
+type LivenessProvisioner interface {
+	DockerProvisioner
+	ContainerWebProcessName(cont container.Container) (string, error)
+	ContainerHealthcheck(cont container.Container) (provision.TsuruYamlHealthcheck, error)
+	CheckContainerLiveness(cont container.Container) error
+	RemoveContainerRoute(cont container.Container) error
+	AddContainerRoute(cont container.Container) error
+}
+
+// LivenessHealer continuously checks the healthcheck of web containers,
+// replacing the ones that fail it more times in a row than the threshold
+// configured in the app's tsuru.yaml.
+type LivenessHealer struct {
+	provisioner LivenessProvisioner
+	interval    time.Duration
+	done        chan bool
+	locker      AppLocker
+	mut         sync.Mutex
+	failures    map[string]int
+	lastCheck   map[string]time.Time
+}
+
+type LivenessHealerArgs struct {
+	Provisioner LivenessProvisioner
+	Interval    time.Duration
+	Done        chan bool
+	Locker      AppLocker
+}
+
+func NewLivenessHealer(args LivenessHealerArgs) *LivenessHealer {
+	return &LivenessHealer{
+		provisioner: args.Provisioner,
+		interval:    args.Interval,
+		done:        args.Done,
+		locker:      args.Locker,
+		failures:    make(map[string]int),
+		lastCheck:   make(map[string]time.Time),
+	}
+}
+
+func (h *LivenessHealer) RunLivenessHealer() {
+	for {
+		h.runLivenessHealerOnce()
+		select {
+		case <-h.done:
+			return
+		case <-time.After(h.interval):
+		}
+	}
+}
+
+func (h *LivenessHealer) Shutdown() {
+	h.done <- true
+}
+
+func (h *LivenessHealer) String() string {
+	return "liveness healer"
+}
+
+// shouldCheck returns whether the container is due to a new liveness check,
+// based on the interval declared in its healthcheck.
+func (h *LivenessHealer) shouldCheck(cont container.Container, hc provision.TsuruYamlHealthcheck) bool {
+	if hc.Path == "" || hc.Interval <= 0 {
+		return false
+	}
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	now := time.Now()
+	if last, ok := h.lastCheck[cont.ID]; ok && now.Sub(last) < time.Duration(hc.Interval)*time.Second {
+		return false
+	}
+	h.lastCheck[cont.ID] = now
+	return true
+}
+
+// registerResult stores the result of a liveness check, returning the number
+// of consecutive failures of the container.
+func (h *LivenessHealer) registerResult(cont container.Container, checkErr error) int {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if checkErr == nil {
+		delete(h.failures, cont.ID)
+		return 0
+	}
+	h.failures[cont.ID]++
+	return h.failures[cont.ID]
+}
+
+func (h *LivenessHealer) forget(id string) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	delete(h.failures, id)
+	delete(h.lastCheck, id)
+}
+
+func (h *LivenessHealer) checkContainer(cont container.Container) error {
+	// The healthcheck in tsuru.yaml is only meaningful for the web process,
+	// like in deploys, other processes are never probed.
+	webProcessName, err := h.provisioner.ContainerWebProcessName(cont)
+	if err != nil {
+		return fmt.Errorf("Liveness healing: couldn't get web process for container %s: %s", cont.ID, err.Error())
+	}
+	if cont.ProcessName != webProcessName {
+		return nil
+	}
+	hc, err := h.provisioner.ContainerHealthcheck(cont)
+	if err != nil {
+		return fmt.Errorf("Liveness healing: couldn't get healthcheck for container %s: %s", cont.ID, err.Error())
+	}
+	if !h.shouldCheck(cont, hc) {
+		return nil
+	}
+	checkErr := h.provisioner.CheckContainerLiveness(cont)
+	failures := h.registerResult(cont, checkErr)
+	if checkErr == nil {
+		return nil
+	}
+	threshold := hc.Threshold
+	if threshold <= 0 {
+		threshold = defaultLivenessThreshold
+	}
+	log.Errorf("Liveness healing: container %s failed liveness check (%d/%d): %s", cont.ID, failures, threshold, checkErr.Error())
+	if failures < threshold {
+		return nil
+	}
+	return h.healContainer(cont)
+}
+
+func (h *LivenessHealer) healContainer(cont container.Container) error {
+	healingCounter, err := healingCountFor("container", cont.ID, consecutiveHealingsTimeframe)
+	if err != nil {
+		return fmt.Errorf("Liveness healing: couldn't verify number of previous healings for %s: %s", cont.ID, err.Error())
+	}
+	if healingCounter > consecutiveHealingsLimitInTimeframe {
+		return fmt.Errorf("Liveness healing: number of healings for container %s in the last %d minutes exceeds limit of %d: %d",
+			cont.ID, consecutiveHealingsTimeframe/time.Minute, consecutiveHealingsLimitInTimeframe, healingCounter)
+	}
+	locked := h.locker.Lock(cont.AppName)
+	if !locked {
+		return fmt.Errorf("Liveness healing: unable to heal %s couldn't lock app %s", cont.ID, cont.AppName)
+	}
+	defer h.locker.Unlock(cont.AppName)
+	// Sanity check, now we have a lock, let's find out if the container still exists
+	_, err = h.provisioner.GetContainer(cont.ID)
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			h.forget(cont.ID)
+			return nil
+		}
+		return fmt.Errorf("Liveness healing: unable to heal %s couldn't verify it still exists.", cont.ID)
+	}
+	log.Errorf("Initiating healing process for container %s, failing liveness checks.", cont.ID)
+	evt, err := NewHealingEvent(cont)
+	if err != nil {
+		return fmt.Errorf("Error trying to insert container healing event, healing aborted: %s", err.Error())
+	}
+	healErr := h.provisioner.RemoveContainerRoute(cont)
+	var newCont container.Container
+	if healErr == nil {
+		newCont, healErr = moveContainer(h.provisioner, cont, h.locker)
+		if healErr != nil {
+			// The container is kept when it can't be replaced, so it must
+			// keep receiving requests.
+			err = h.provisioner.AddContainerRoute(cont)
+			if err != nil {
+				log.Errorf("Liveness healing: unable to restore the route of container %s: %s", cont.ID, err.Error())
+			}
+		}
+	}
+	if healErr != nil {
+		healErr = fmt.Errorf("Error healing container %s: %s", cont.ID, healErr.Error())
+	} else {
+		h.forget(cont.ID)
+	}
+	err = evt.Update(newCont, healErr)
+	if err != nil {
+		log.Errorf("Error trying to update containers healing event: %s", err.Error())
+	}
+	return healErr
+}
+
+func (h *LivenessHealer) runLivenessHealerOnce() {
+	containers, err := h.provisioner.ListContainers(bson.M{
+		"hostport": bson.M{"$ne": ""},
+		"status":   provision.StatusStarted.String(),
+	})
+	if err != nil {
+		log.Errorf("Liveness healing: couldn't list containers: %s", err.Error())
+		return
+	}
+	running := make(map[string]bool, len(containers))
+	for _, cont := range containers {
+		running[cont.ID] = true
+		err := h.checkContainer(cont)
+		if err != nil {
+			log.Errorf(err.Error())
+		}
+	}
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	for id := range h.lastCheck {
+		if !running[id] {
+			delete(h.failures, id)
+			delete(h.lastCheck, id)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package healer
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/docker/dockertest"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"gopkg.in/check.v1"
+)
+
+type livenessProvisioner struct {
+	*dockertest.FakeDockerProvisioner
+	mut         sync.Mutex
+	healthcheck provision.TsuruYamlHealthcheck
+	failing     map[string]bool
+	checks      map[string]int
+	unrouted    []string
+	rerouted    []string
+}
+
+func newLivenessProvisioner(p *dockertest.FakeDockerProvisioner, hc provision.TsuruYamlHealthcheck) *livenessProvisioner {
+	return &livenessProvisioner{
+		FakeDockerProvisioner: p,
+		healthcheck:           hc,
+		failing:               make(map[string]bool),
+		checks:                make(map[string]int),
+	}
+}
+
+func (p *livenessProvisioner) ContainerWebProcessName(cont container.Container) (string, error) {
+	return "web", nil
+}
+
+func (p *livenessProvisioner) ContainerHealthcheck(cont container.Container) (provision.TsuruYamlHealthcheck, error) {
+	return p.healthcheck, nil
+}
+
+func (p *livenessProvisioner) CheckContainerLiveness(cont container.Container) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.checks[cont.ID]++
+	if p.failing[cont.ID] {
+		return errors.New("healthcheck fail")
+	}
+	return nil
+}
+
+func (p *livenessProvisioner) RemoveContainerRoute(cont container.Container) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.unrouted = append(p.unrouted, cont.ID)
+	return nil
+}
+
+func (p *livenessProvisioner) AddContainerRoute(cont container.Container) error {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	p.rerouted = append(p.rerouted, cont.ID)
+	return nil
+}
+
+func (s *S) startLivenessContainers(c *check.C, hc provision.TsuruYamlHealthcheck) (*livenessProvisioner, []container.Container) {
+	return s.startLivenessProcessContainers(c, hc, map[string]int{"web": 2})
+}
+
+func (s *S) startLivenessProcessContainers(c *check.C, hc provision.TsuruYamlHealthcheck, amount map[string]int) (*livenessProvisioner, []container.Container) {
+	p, err := dockertest.StartMultipleServersCluster()
+	c.Assert(err, check.IsNil)
+	app := provisiontest.NewFakeApp("myapp", "python", 0)
+	containers, err := p.StartContainers(dockertest.StartContainersArgs{
+		Endpoint:  p.Servers()[0].URL(),
+		App:       app,
+		Amount:    amount,
+		Image:     "tsuru/python",
+		PullImage: true,
+	})
+	c.Assert(err, check.IsNil)
+	for i := range containers {
+		containers[i].HostPort = "8080"
+		containers[i].Status = provision.StatusStarted.String()
+	}
+	return newLivenessProvisioner(p, hc), containers
+}
+
+func (s *S) TestRunLivenessHealerOnceReplacesAfterThreshold(c *check.C) {
+	p, containers := s.startLivenessContainers(c, provision.TsuruYamlHealthcheck{Path: "/", Interval: 1, Threshold: 2})
+	defer p.Destroy()
+	p.failing[containers[1].ID] = true
+	healer := NewLivenessHealer(LivenessHealerArgs{Provisioner: p, Locker: dockertest.NewFakeLocker()})
+	p.PrepareListResult(containers, nil)
+	healer.runLivenessHealerOnce()
+	c.Assert(p.Movings(), check.HasLen, 0)
+	c.Assert(p.unrouted, check.HasLen, 0)
+	healer.lastCheck = make(map[string]time.Time)
+	p.PrepareListResult(containers, nil)
+	healer.runLivenessHealerOnce()
+	c.Assert(p.unrouted, check.DeepEquals, []string{containers[1].ID})
+	c.Assert(p.rerouted, check.HasLen, 0)
+	expected := []dockertest.ContainerMoving{
+		{ContainerID: containers[1].ID, HostFrom: containers[1].HostAddr, HostTo: ""},
+	}
+	c.Assert(p.Movings(), check.DeepEquals, expected)
+	healingColl, err := healingCollection()
+	c.Assert(err, check.IsNil)
+	defer healingColl.Close()
+	var events []HealingEvent
+	err = healingColl.Find(nil).All(&events)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].Action, check.Equals, "container-healing")
+	c.Assert(events[0].FailingContainer.ID, check.Equals, containers[1].ID)
+	c.Assert(events[0].Successful, check.Equals, true)
+	c.Assert(events[0].EndTime.IsZero(), check.Equals, false)
+}
+
+func (s *S) TestRunLivenessHealerOnceRestoresRouteOnFailure(c *check.C) {
+	p, containers := s.startLivenessContainers(c, provision.TsuruYamlHealthcheck{Path: "/", Interval: 1, Threshold: 1})
+	defer p.Destroy()
+	p.failing[containers[1].ID] = true
+	p.FailMove(errors.New("no nodes available"))
+	healer := NewLivenessHealer(LivenessHealerArgs{Provisioner: p, Locker: dockertest.NewFakeLocker()})
+	p.PrepareListResult(containers, nil)
+	healer.runLivenessHealerOnce()
+	c.Assert(p.Movings(), check.HasLen, 0)
+	c.Assert(p.unrouted, check.DeepEquals, []string{containers[1].ID})
+	c.Assert(p.rerouted, check.DeepEquals, []string{containers[1].ID})
+	healingColl, err := healingCollection()
+	c.Assert(err, check.IsNil)
+	defer healingColl.Close()
+	var events []HealingEvent
+	err = healingColl.Find(nil).All(&events)
+	c.Assert(err, check.IsNil)
+	c.Assert(events, check.HasLen, 1)
+	c.Assert(events[0].Successful, check.Equals, false)
+}
+
+func (s *S) TestRunLivenessHealerOnceIgnoresNonWebProcesses(c *check.C) {
+	p, containers := s.startLivenessProcessContainers(c, provision.TsuruYamlHealthcheck{Path: "/", Interval: 1, Threshold: 1},
+		map[string]int{"web": 1, "worker": 1})
+	defer p.Destroy()
+	var worker container.Container
+	for _, cont := range containers {
+		p.failing[cont.ID] = cont.ProcessName == "worker"
+		if cont.ProcessName == "worker" {
+			worker = cont
+		}
+	}
+	c.Assert(worker.ID, check.Not(check.Equals), "")
+	healer := NewLivenessHealer(LivenessHealerArgs{Provisioner: p, Locker: dockertest.NewFakeLocker()})
+	for i := 0; i < 3; i++ {
+		healer.lastCheck = make(map[string]time.Time)
+		p.PrepareListResult(containers, nil)
+		healer.runLivenessHealerOnce()
+	}
+	c.Assert(p.checks[worker.ID], check.Equals, 0)
+	c.Assert(p.checks, check.HasLen, 1)
+	c.Assert(p.unrouted, check.HasLen, 0)
+	c.Assert(p.Movings(), check.HasLen, 0)
+}
+
+func (s *S) TestRunLivenessHealerOnceResetsFailuresOnSuccess(c *check.C) {
+	p, containers := s.startLivenessContainers(c, provision.TsuruYamlHealthcheck{Path: "/", Interval: 1, Threshold: 2})
+	defer p.Destroy()
+	p.failing[containers[0].ID] = true
+	healer := NewLivenessHealer(LivenessHealerArgs{Provisioner: p, Locker: dockertest.NewFakeLocker()})
+	p.PrepareListResult(containers, nil)
+	healer.runLivenessHealerOnce()
+	c.Assert(healer.failures[containers[0].ID], check.Equals, 1)
+	p.failing[containers[0].ID] = false
+	healer.lastCheck = make(map[string]time.Time)
+	p.PrepareListResult(containers, nil)
+	healer.runLivenessHealerOnce()
+	_, ok := healer.failures[containers[0].ID]
+	c.Assert(ok, check.Equals, false)
+	c.Assert(p.Movings(), check.HasLen, 0)
+}
+
+func (s *S) TestRunLivenessHealerOnceRespectsInterval(c *check.C) {
+	p, containers := s.startLivenessContainers(c, provision.TsuruYamlHealthcheck{Path: "/", Interval: 60})
+	defer p.Destroy()
+	healer := NewLivenessHealer(LivenessHealerArgs{Provisioner: p, Locker: dockertest.NewFakeLocker()})
+	p.PrepareListResult(containers, nil)
+	healer.runLivenessHealerOnce()
+	p.PrepareListResult(containers, nil)
+	healer.runLivenessHealerOnce()
+	c.Assert(p.checks[containers[0].ID], check.Equals, 1)
+	c.Assert(p.checks[containers[1].ID], check.Equals, 1)
+}
+
+func (s *S) TestRunLivenessHealerOnceWithoutInterval(c *check.C) {
+	p, containers := s.startLivenessContainers(c, provision.TsuruYamlHealthcheck{Path: "/"})
+	defer p.Destroy()
+	p.failing[containers[0].ID] = true
+	healer := NewLivenessHealer(LivenessHealerArgs{Provisioner: p, Locker: dockertest.NewFakeLocker()})
+	for i := 0; i < 5; i++ {
+		p.PrepareListResult(containers, nil)
+		healer.runLivenessHealerOnce()
+	}
+	c.Assert(p.checks, check.HasLen, 0)
+	c.Assert(p.Movings(), check.HasLen, 0)
+}
@@ -15,7 +15,10 @@ import (
 	"time"
 
 	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/router"
 )
 
 func clientWithTimeout(dialTimeout time.Duration) *http.Client {
@@ -34,35 +37,82 @@ func clientWithTimeout(dialTimeout time.Duration) *http.Client {
 
 var timeoutHttpClient = clientWithTimeout(5 * time.Second)
 
-func runHealthcheck(cont *container.Container, w io.Writer) error {
-	yamlData, err := getImageTsuruYamlData(cont.Image)
-	if err != nil {
-		return err
+type healthcheckProbe struct {
+	url     string
+	method  string
+	status  int
+	match   string
+	matchRE *regexp.Regexp
+}
+
+// newHealthcheckProbe returns a probe for the given healthcheck settings,
+// pointing to the container. It returns nil if no healthcheck path is set.
+func newHealthcheckProbe(cont *container.Container, hc provision.TsuruYamlHealthcheck) (*healthcheckProbe, error) {
+	if hc.Path == "" {
+		return nil, nil
 	}
-	path := yamlData.Healthcheck.Path
-	method := yamlData.Healthcheck.Method
-	match := yamlData.Healthcheck.Match
-	status := yamlData.Healthcheck.Status
-	allowedFailures := yamlData.Healthcheck.AllowedFailures
-	if path == "" {
-		return nil
+	probe := healthcheckProbe{
+		method: hc.Method,
+		status: hc.Status,
+		match:  hc.Match,
+	}
+	path := strings.TrimSpace(strings.TrimLeft(hc.Path, "/"))
+	if probe.method == "" {
+		probe.method = "get"
+	}
+	probe.method = strings.ToUpper(probe.method)
+	if probe.status == 0 && probe.match == "" {
+		probe.status = 200
 	}
-	path = strings.TrimSpace(strings.TrimLeft(path, "/"))
-	if method == "" {
-		method = "get"
+	if probe.match != "" {
+		probe.match = "(?s)" + probe.match
+		var err error
+		probe.matchRE, err = regexp.Compile(probe.match)
+		if err != nil {
+			return nil, err
+		}
+	}
+	probe.url = fmt.Sprintf("http://%s:%s/%s", cont.HostAddr, cont.HostPort, path)
+	return &probe, nil
+}
+
+// run makes a single request to the healthcheck, returning whether the
+// request reached the app and the error describing the failure, if any.
+func (p *healthcheckProbe) run(cont *container.Container) (bool, error) {
+	req, err := http.NewRequest(p.method, p.url, nil)
+	if err != nil {
+		return false, err
+	}
+	rsp, err := timeoutHttpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("healthcheck fail(%s): %s", cont.ShortID(), err.Error())
 	}
-	method = strings.ToUpper(method)
-	if status == 0 && match == "" {
-		status = 200
+	defer rsp.Body.Close()
+	if p.status != 0 && rsp.StatusCode != p.status {
+		return true, fmt.Errorf("healthcheck fail(%s): wrong status code, expected %d, got: %d", cont.ShortID(), p.status, rsp.StatusCode)
 	}
-	var matchRE *regexp.Regexp
-	if match != "" {
-		match = "(?s)" + match
-		matchRE, err = regexp.Compile(match)
+	if p.matchRE != nil {
+		result, err := ioutil.ReadAll(rsp.Body)
 		if err != nil {
-			return err
+			return true, err
+		}
+		if !p.matchRE.Match(result) {
+			return true, fmt.Errorf("healthcheck fail(%s): unexpected result, expected %q, got: %s", cont.ShortID(), p.match, string(result))
 		}
 	}
+	return true, nil
+}
+
+func runHealthcheck(cont *container.Container, w io.Writer) error {
+	yamlData, err := getImageTsuruYamlData(cont.Image)
+	if err != nil {
+		return err
+	}
+	probe, err := newHealthcheckProbe(cont, yamlData.Healthcheck)
+	if err != nil || probe == nil {
+		return err
+	}
+	allowedFailures := yamlData.Healthcheck.AllowedFailures
 	maxWaitTime, _ := config.GetInt("docker:healthcheck:max-time")
 	if maxWaitTime == 0 {
 		maxWaitTime = 120
@@ -70,36 +120,13 @@ func runHealthcheck(cont *container.Container, w io.Writer) error {
 	maxWaitTime = maxWaitTime * int(time.Second)
 	sleepTime := 3 * time.Second
 	startedTime := time.Now()
-	url := fmt.Sprintf("http://%s:%s/%s", cont.HostAddr, cont.HostPort, path)
 	for {
-		var lastError error = nil
-		req, err := http.NewRequest(method, url, nil)
-		if err != nil {
-			return err
-		}
-		rsp, err := timeoutHttpClient.Do(req)
-		if err != nil {
-			lastError = fmt.Errorf("healthcheck fail(%s): %s", cont.ShortID(), err.Error())
-		} else {
-			defer rsp.Body.Close()
-			if status != 0 && rsp.StatusCode != status {
-				lastError = fmt.Errorf("healthcheck fail(%s): wrong status code, expected %d, got: %d", cont.ShortID(), status, rsp.StatusCode)
-			} else if matchRE != nil {
-				result, err := ioutil.ReadAll(rsp.Body)
-
-				if err != nil {
-					lastError = err
-				}
-				if !matchRE.Match(result) {
-					lastError = fmt.Errorf("healthcheck fail(%s): unexpected result, expected %q, got: %s", cont.ShortID(), match, string(result))
-				}
-			}
-			if lastError != nil {
-				if allowedFailures == 0 {
-					return lastError
-				}
-				allowedFailures--
+		reached, lastError := probe.run(cont)
+		if lastError != nil && reached {
+			if allowedFailures == 0 {
+				return lastError
 			}
+			allowedFailures--
 		}
 		if lastError == nil {
 			fmt.Fprintf(w, " ---> healthcheck successful(%s)\n", cont.ShortID())
@@ -112,3 +139,70 @@ func runHealthcheck(cont *container.Container, w io.Writer) error {
 		time.Sleep(sleepTime)
 	}
 }
+
+// ContainerWebProcessName returns the name of the process that receives
+// requests in the image used by the container.
+func (p *dockerProvisioner) ContainerWebProcessName(cont container.Container) (string, error) {
+	return getImageWebProcessName(cont.Image)
+}
+
+// ContainerHealthcheck returns the healthcheck settings declared in the
+// tsuru.yaml of the image used by the container.
+func (p *dockerProvisioner) ContainerHealthcheck(cont container.Container) (provision.TsuruYamlHealthcheck, error) {
+	yamlData, err := getImageTsuruYamlData(cont.Image)
+	if err != nil {
+		return provision.TsuruYamlHealthcheck{}, err
+	}
+	return yamlData.Healthcheck, nil
+}
+
+// CheckContainerLiveness makes a single request to the healthcheck of the
+// container, returning an error if it fails. Containers without a healthcheck
+// are always considered alive.
+func (p *dockerProvisioner) CheckContainerLiveness(cont container.Container) error {
+	hc, err := p.ContainerHealthcheck(cont)
+	if err != nil {
+		return err
+	}
+	probe, err := newHealthcheckProbe(&cont, hc)
+	if err != nil || probe == nil {
+		return err
+	}
+	_, err = probe.run(&cont)
+	return err
+}
+
+// RemoveContainerRoute removes the container from the router of its app, so
+// it stops receiving requests.
+func (p *dockerProvisioner) RemoveContainerRoute(cont container.Container) error {
+	a, err := app.GetByName(cont.AppName)
+	if err != nil {
+		return err
+	}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = r.RemoveRoute(cont.AppName, cont.Address())
+	if err != nil && err != router.ErrRouteNotFound {
+		return err
+	}
+	return nil
+}
+
+// AddContainerRoute adds the container back to the router of its app.
+func (p *dockerProvisioner) AddContainerRoute(cont container.Container) error {
+	a, err := app.GetByName(cont.AppName)
+	if err != nil {
+		return err
+	}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = r.AddRoute(cont.AppName, cont.Address())
+	if err != nil && err != router.ErrRouteExists {
+		return err
+	}
+	return nil
+}
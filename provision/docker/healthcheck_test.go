@@ -16,6 +16,7 @@ import (
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/app"
 	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/router/routertest"
 	"gopkg.in/check.v1"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -297,3 +298,79 @@ func (s *S) TestHealthcheckSuccessfulWithAllowedFailures(c *check.C) {
 	c.Assert(requests[2].Method, check.Equals, "GET")
 	c.Assert(requests[2].URL.Path, check.Equals, "/x/y")
 }
+
+func (s *S) TestCheckContainerLiveness(c *check.C) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if len(requests) > 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	imageName := "tsuru/app"
+	customData := map[string]interface{}{
+		"healthcheck": map[string]interface{}{
+			"path":      "/x/y",
+			"interval":  10,
+			"threshold": 2,
+		},
+	}
+	err := saveImageCustomData(imageName, customData)
+	c.Assert(err, check.IsNil)
+	url, _ := url.Parse(server.URL)
+	host, port, _ := net.SplitHostPort(url.Host)
+	cont := container.Container{AppName: "myapp1", HostAddr: host, HostPort: port, Image: imageName}
+	hc, err := s.p.ContainerHealthcheck(cont)
+	c.Assert(err, check.IsNil)
+	c.Assert(hc.Interval, check.Equals, 10)
+	c.Assert(hc.Threshold, check.Equals, 2)
+	err = s.p.CheckContainerLiveness(cont)
+	c.Assert(err, check.IsNil)
+	err = s.p.CheckContainerLiveness(cont)
+	c.Assert(err, check.ErrorMatches, "healthcheck fail.*wrong status code, expected 200, got: 500")
+	c.Assert(requests, check.HasLen, 2)
+	c.Assert(requests[0].URL.Path, check.Equals, "/x/y")
+	c.Assert(requests[0].Method, check.Equals, "GET")
+}
+
+func (s *S) TestContainerWebProcessName(c *check.C) {
+	imageName := "tsuru/app"
+	customData := map[string]interface{}{
+		"procfile": "web: python app.py\nworker: python worker.py",
+	}
+	err := saveImageCustomData(imageName, customData)
+	c.Assert(err, check.IsNil)
+	name, err := s.p.ContainerWebProcessName(container.Container{Image: imageName})
+	c.Assert(err, check.IsNil)
+	c.Assert(name, check.Equals, "web")
+}
+
+func (s *S) TestCheckContainerLivenessNoHealthcheck(c *check.C) {
+	imageName := "tsuru/app"
+	err := saveImageCustomData(imageName, map[string]interface{}{})
+	c.Assert(err, check.IsNil)
+	cont := container.Container{AppName: "myapp1", HostAddr: "127.0.0.1", HostPort: "1", Image: imageName}
+	err = s.p.CheckContainerLiveness(cont)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestRemoveAndAddContainerRoute(c *check.C) {
+	a := &app.App{Name: "myapp1"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	err = routertest.FakeRouter.AddBackend(a.Name)
+	c.Assert(err, check.IsNil)
+	defer routertest.FakeRouter.RemoveBackend(a.Name)
+	cont := container.Container{AppName: a.Name, HostAddr: "10.0.0.1", HostPort: "8080"}
+	err = s.p.AddContainerRoute(cont)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, true)
+	err = s.p.AddContainerRoute(cont)
+	c.Assert(err, check.IsNil)
+	err = s.p.RemoveContainerRoute(cont)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, false)
+	err = s.p.RemoveContainerRoute(cont)
+	c.Assert(err, check.IsNil)
+}
@@ -0,0 +1,393 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+)
+
+// idleChecker periodically puts to sleep apps with an idle policy whose
+// router reports no requests in the configured window. Sleeping apps have
+// all their units stopped and their backend pointing to the wake up endpoint,
+// served by wakeUpHandler.
+type idleChecker struct {
+	provisioner *dockerProvisioner
+	interval    time.Duration
+	wakeUpURL   *url.URL
+	done        chan bool
+}
+
+func (p *dockerProvisioner) initIdleChecker() (*idleChecker, error) {
+	interval, _ := config.GetInt("docker:idle:check-interval")
+	if interval <= 0 {
+		return nil, nil
+	}
+	wakeUpURL, err := getWakeUpURL()
+	if err != nil {
+		return nil, err
+	}
+	return &idleChecker{
+		provisioner: p,
+		interval:    time.Duration(interval) * time.Second,
+		wakeUpURL:   wakeUpURL,
+		done:        make(chan bool),
+	}, nil
+}
+
+func getWakeUpURL() (*url.URL, error) {
+	rawURL, err := config.GetString("docker:idle:wake-up-address")
+	if err != nil {
+		return nil, err
+	}
+	wakeUpURL, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if wakeUpURL.Host == "" {
+		return nil, fmt.Errorf("invalid wake up address %q", rawURL)
+	}
+	return wakeUpURL, nil
+}
+
+func (c *idleChecker) run() {
+	for {
+		c.runOnce()
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.interval):
+		}
+	}
+}
+
+func (c *idleChecker) runOnce() {
+	apps, err := app.IdleCandidates()
+	if err != nil {
+		log.Errorf("[idle checker] unable to list apps: %s", err)
+		return
+	}
+	now := time.Now().UTC()
+	for i := range apps {
+		a := &apps[i]
+		idle, err := c.provisioner.isIdle(a, now)
+		if err != nil {
+			log.Errorf("[idle checker] unable to check if app %q is idle: %s", a.Name, err)
+			continue
+		}
+		if !idle {
+			continue
+		}
+		log.Debugf("[idle checker] putting app %q to sleep", a.Name)
+		err = c.provisioner.sleepApp(a, c.wakeUpURL)
+		if err != nil {
+			log.Errorf("[idle checker] unable to put app %q to sleep: %s", a.Name, err)
+		}
+	}
+}
+
+// serveWakeUp starts the wake up endpoint in the given address.
+func (c *idleChecker) serveWakeUp(addr string) {
+	if addr == "" {
+		_, port, err := net.SplitHostPort(c.wakeUpURL.Host)
+		if err != nil {
+			port = "80"
+		}
+		addr = ":" + port
+	}
+	handler := &wakeUpHandler{provisioner: c.provisioner, wakeUpURL: c.wakeUpURL}
+	err := http.ListenAndServe(addr, handler)
+	if err != nil {
+		log.Errorf("[wake up] unable to listen on %s: %s", addr, err)
+	}
+}
+
+func (c *idleChecker) Shutdown() {
+	c.done <- true
+}
+
+func (c *idleChecker) String() string {
+	return "idle apps checker"
+}
+
+// isIdle reports whether the app has started units and its router didn't
+// see any request within its idle window. It fails for apps using routers
+// unable to report traffic.
+func (p *dockerProvisioner) isIdle(a *app.App, now time.Time) (bool, error) {
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return false, err
+	}
+	reporter, ok := r.(router.TrafficReporter)
+	if !ok {
+		return false, fmt.Errorf("router of app %q does not report traffic", a.Name)
+	}
+	last, err := reporter.LastRequest(a.Name)
+	if err != nil {
+		return false, err
+	}
+	if a.Idle.Since.After(last) {
+		last = a.Idle.Since
+	}
+	if now.Sub(last) < time.Duration(a.Idle.Window)*time.Minute {
+		return false, nil
+	}
+	containers, err := p.listContainersByApp(a.Name)
+	if err != nil {
+		return false, err
+	}
+	for _, cont := range containers {
+		if cont.Status == provision.StatusStarted.String() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func appWebProcessName(appName string) (string, error) {
+	imageId, err := appCurrentImageName(appName)
+	if err != nil {
+		return "", err
+	}
+	return getImageWebProcessName(imageId)
+}
+
+// sleepApp points the backend of the app to the wake up endpoint and stops
+// all its units.
+func (p *dockerProvisioner) sleepApp(a *app.App, wakeUpURL *url.URL) error {
+	locked, err := app.AcquireApplicationLock(a.Name, app.InternalAppName, "sleep")
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("unable to lock app %q", a.Name)
+	}
+	defer app.ReleaseApplicationLock(a.Name)
+	webProcessName, err := appWebProcessName(a.Name)
+	if err != nil {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.Name)
+	if err != nil {
+		return err
+	}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	err = r.AddRoute(a.Name, wakeUpURL)
+	if err != nil && err != router.ErrRouteExists {
+		return err
+	}
+	for _, cont := range containers {
+		if cont.ProcessName != webProcessName {
+			continue
+		}
+		err = r.RemoveRoute(a.Name, cont.Address())
+		if err != nil && err != router.ErrRouteNotFound {
+			return err
+		}
+	}
+	err = app.SetSleeping(a.Name, true)
+	if err != nil {
+		return err
+	}
+	return p.Stop(a, "")
+}
+
+// wakeUpApp starts the units of a sleeping app, waits for their healthcheck
+// and routes the app back to them.
+func (p *dockerProvisioner) wakeUpApp(a *app.App, wakeUpURL *url.URL) error {
+	locked, err := app.AcquireApplicationLock(a.Name, app.InternalAppName, "wake-up")
+	if err != nil {
+		return err
+	}
+	if !locked {
+		return fmt.Errorf("unable to lock app %q", a.Name)
+	}
+	defer app.ReleaseApplicationLock(a.Name)
+	webProcessName, err := appWebProcessName(a.Name)
+	if err != nil {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.Name)
+	if err != nil {
+		return err
+	}
+	for _, cont := range containers {
+		if cont.Status == provision.StatusStopped.String() {
+			err = p.startContainers(a, "")
+			if err != nil {
+				return err
+			}
+			containers, err = p.listContainersByApp(a.Name)
+			if err != nil {
+				return err
+			}
+			break
+		}
+	}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	for i := range containers {
+		cont := &containers[i]
+		if cont.ProcessName != webProcessName {
+			continue
+		}
+		err = runHealthcheck(cont, ioutil.Discard)
+		if err != nil {
+			return err
+		}
+		err = r.AddRoute(a.Name, cont.Address())
+		if err != nil && err != router.ErrRouteExists {
+			return err
+		}
+	}
+	err = r.RemoveRoute(a.Name, wakeUpURL)
+	if err != nil && err != router.ErrRouteNotFound {
+		return err
+	}
+	return app.SetSleeping(a.Name, false)
+}
+
+// ensureAwake routes a sleeping app back to its units, after a deploy
+// replaces them or after they're manually started or restarted.
+func (p *dockerProvisioner) ensureAwake(a provision.App) error {
+	dbApp, err := app.GetByName(a.GetName())
+	if err != nil || !dbApp.Idle.Sleeping {
+		return err
+	}
+	wakeUpURL, err := getWakeUpURL()
+	if err != nil {
+		return err
+	}
+	webProcessName, err := appWebProcessName(a.GetName())
+	if err != nil {
+		return err
+	}
+	containers, err := p.listContainersByApp(a.GetName())
+	if err != nil {
+		return err
+	}
+	r, err := getRouterForApp(a)
+	if err != nil {
+		return err
+	}
+	for _, cont := range containers {
+		if cont.ProcessName != webProcessName || cont.Status == provision.StatusStopped.String() {
+			continue
+		}
+		err = r.AddRoute(a.GetName(), cont.Address())
+		if err != nil && err != router.ErrRouteExists {
+			return err
+		}
+	}
+	err = r.RemoveRoute(a.GetName(), wakeUpURL)
+	if err != nil && err != router.ErrRouteNotFound {
+		return err
+	}
+	return app.SetSleeping(a.GetName(), false)
+}
+
+// wakeUpHandler serves the requests routed to sleeping apps. It finds the
+// app by the Host header, wakes it up and redirects the client to the same
+// URL, which is now routed to the units of the app.
+type wakeUpHandler struct {
+	provisioner *dockerProvisioner
+	wakeUpURL   *url.URL
+	mut         sync.Mutex
+	waking      map[string]chan error
+}
+
+func (h *wakeUpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, err := h.sleepingAppByHost(r.Host)
+	if err != nil {
+		log.Errorf("[wake up] unable to find app for host %q: %s", r.Host, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if a == nil {
+		http.Error(w, fmt.Sprintf("no sleeping app for host %q", r.Host), http.StatusNotFound)
+		return
+	}
+	err = h.wakeUp(a)
+	if err != nil {
+		log.Errorf("[wake up] unable to wake up app %q: %s", a.Name, err)
+		http.Error(w, fmt.Sprintf("unable to wake up app %q", a.Name), http.StatusServiceUnavailable)
+		return
+	}
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusTemporaryRedirect)
+}
+
+// wakeUp wakes up the app, making concurrent requests to the same app wait
+// for a single wake up.
+func (h *wakeUpHandler) wakeUp(a *app.App) error {
+	h.mut.Lock()
+	if h.waking == nil {
+		h.waking = make(map[string]chan error)
+	}
+	if ch, ok := h.waking[a.Name]; ok {
+		h.mut.Unlock()
+		err := <-ch
+		ch <- err
+		return err
+	}
+	ch := make(chan error, 1)
+	h.waking[a.Name] = ch
+	h.mut.Unlock()
+	err := h.provisioner.wakeUpApp(a, h.wakeUpURL)
+	h.mut.Lock()
+	delete(h.waking, a.Name)
+	h.mut.Unlock()
+	ch <- err
+	return err
+}
+
+func (h *wakeUpHandler) sleepingAppByHost(host string) (*app.App, error) {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	apps, err := app.SleepingApps()
+	if err != nil {
+		return nil, err
+	}
+	for i := range apps {
+		a := &apps[i]
+		for _, cname := range a.CName {
+			if strings.EqualFold(cname, host) {
+				return a, nil
+			}
+		}
+		r, err := getRouterForApp(a)
+		if err != nil {
+			return nil, err
+		}
+		addr, err := r.Addr(a.Name)
+		if err != nil {
+			continue
+		}
+		if hostname, _, err := net.SplitHostPort(addr); err == nil {
+			addr = hostname
+		}
+		if strings.EqualFold(addr, host) {
+			return a, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,177 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) newIdleApp(c *check.C, idle app.IdlePolicy) *app.App {
+	a := &app.App{Name: "sleepy", Platform: "python", Idle: idle}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	return a
+}
+
+func (s *S) newIdleContainer(c *check.C, a *app.App, status provision.Status) *container.Container {
+	cont, err := s.newContainer(&newContainerOpts{
+		AppName:         a.Name,
+		Status:          status.String(),
+		Image:           "tsuru/app-" + a.Name,
+		ImageCustomData: map[string]interface{}{"processes": map[string]interface{}{"web": "python web.py"}},
+		ProcessName:     "web",
+	}, nil)
+	c.Assert(err, check.IsNil)
+	return cont
+}
+
+func (s *S) TestIsIdle(c *check.C) {
+	now := time.Now().UTC()
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10, Since: now.Add(-time.Hour)})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont := s.newIdleContainer(c, a, provision.StatusStarted)
+	defer s.removeTestContainer(cont)
+	routertest.FakeRouter.SetLastRequest(a.Name, now.Add(-20*time.Minute))
+	idle, err := s.p.isIdle(a, now)
+	c.Assert(err, check.IsNil)
+	c.Assert(idle, check.Equals, true)
+	routertest.FakeRouter.SetLastRequest(a.Name, now.Add(-5*time.Minute))
+	idle, err = s.p.isIdle(a, now)
+	c.Assert(err, check.IsNil)
+	c.Assert(idle, check.Equals, false)
+}
+
+func (s *S) TestIsIdleRecentPolicy(c *check.C) {
+	now := time.Now().UTC()
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10, Since: now.Add(-time.Minute)})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont := s.newIdleContainer(c, a, provision.StatusStarted)
+	defer s.removeTestContainer(cont)
+	idle, err := s.p.isIdle(a, now)
+	c.Assert(err, check.IsNil)
+	c.Assert(idle, check.Equals, false)
+}
+
+func (s *S) TestIsIdleWithoutStartedUnits(c *check.C) {
+	now := time.Now().UTC()
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10, Since: now.Add(-time.Hour)})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont := s.newIdleContainer(c, a, provision.StatusStopped)
+	defer s.removeTestContainer(cont)
+	idle, err := s.p.isIdle(a, now)
+	c.Assert(err, check.IsNil)
+	c.Assert(idle, check.Equals, false)
+}
+
+func (s *S) TestSleepApp(c *check.C) {
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont := s.newIdleContainer(c, a, provision.StatusStarted)
+	defer s.removeTestContainer(cont)
+	dcli, err := docker.NewClient(s.server.URL())
+	c.Assert(err, check.IsNil)
+	err = dcli.StartContainer(cont.ID, nil)
+	c.Assert(err, check.IsNil)
+	wakeUpURL, _ := url.Parse("http://10.0.0.1:8081")
+	err = s.p.sleepApp(a, wakeUpURL)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, wakeUpURL.String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, false)
+	dockerContainer, err := dcli.InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dockerContainer.State.Running, check.Equals, false)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Sleeping, check.Equals, true)
+	c.Assert(dbApp.Lock.Locked, check.Equals, false)
+}
+
+func (s *S) TestWakeUpApp(c *check.C) {
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10, Sleeping: true})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont := s.newIdleContainer(c, a, provision.StatusStopped)
+	defer s.removeTestContainer(cont)
+	wakeUpURL, _ := url.Parse("http://10.0.0.1:8081")
+	routertest.FakeRouter.RemoveRoute(a.Name, cont.Address())
+	routertest.FakeRouter.AddRoute(a.Name, wakeUpURL)
+	err := s.p.wakeUpApp(a, wakeUpURL)
+	c.Assert(err, check.IsNil)
+	dcli, err := docker.NewClient(s.server.URL())
+	c.Assert(err, check.IsNil)
+	dockerContainer, err := dcli.InspectContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dockerContainer.State.Running, check.Equals, true)
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, wakeUpURL.String()), check.Equals, false)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Sleeping, check.Equals, false)
+}
+
+func (s *S) TestStartWakesUpSleepingApp(c *check.C) {
+	config.Set("docker:idle:wake-up-address", "http://10.0.0.1:8081")
+	defer config.Unset("docker:idle")
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10, Sleeping: true})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont := s.newIdleContainer(c, a, provision.StatusStopped)
+	defer s.removeTestContainer(cont)
+	wakeUpURL, _ := url.Parse("http://10.0.0.1:8081")
+	routertest.FakeRouter.RemoveRoute(a.Name, cont.Address())
+	routertest.FakeRouter.AddRoute(a.Name, wakeUpURL)
+	err := s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	cont, err = s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, cont.Address().String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(a.Name, wakeUpURL.String()), check.Equals, false)
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Sleeping, check.Equals, false)
+}
+
+func (s *S) TestWakeUpHandler(c *check.C) {
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10, Sleeping: true})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	cont := s.newIdleContainer(c, a, provision.StatusStopped)
+	defer s.removeTestContainer(cont)
+	wakeUpURL, _ := url.Parse("http://10.0.0.1:8081")
+	handler := &wakeUpHandler{provisioner: s.p, wakeUpURL: wakeUpURL}
+	request, err := http.NewRequest("POST", "/some/path?x=1", nil)
+	c.Assert(err, check.IsNil)
+	request.Host = "sleepy.fakerouter.com:80"
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusTemporaryRedirect)
+	c.Assert(recorder.Header().Get("Location"), check.Equals, "/some/path?x=1")
+	dbApp, err := app.GetByName(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Idle.Sleeping, check.Equals, false)
+}
+
+func (s *S) TestWakeUpHandlerUnknownHost(c *check.C) {
+	a := s.newIdleApp(c, app.IdlePolicy{Window: 10, Sleeping: true})
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	handler := &wakeUpHandler{provisioner: s.p}
+	request, err := http.NewRequest("GET", "/", nil)
+	c.Assert(err, check.IsNil)
+	request.Host = "other.fakerouter.com"
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	c.Assert(recorder.Code, check.Equals, http.StatusNotFound)
+}
@@ -7,7 +7,6 @@ package docker
 import (
 	"errors"
 	"fmt"
-	"strings"
 
 	"github.com/fsouza/go-dockerclient"
 	"github.com/tsuru/config"
@@ -84,12 +83,17 @@ func MigrateImages() error {
 // * the deploy number is multiple of 10.
 // in all other cases the app image name will be returne.
 func (p *dockerProvisioner) getBuildImage(app provision.App) string {
+	platformImage := poolPlatformImageName(app.GetPool(), app.GetPlatform())
 	if p.usePlatformImage(app) {
-		return platformImageName(app.GetPlatform())
+		return platformImage
 	}
 	appImageName, err := appCurrentImageName(app.GetName())
 	if err != nil {
-		return platformImageName(app.GetPlatform())
+		return platformImage
+	}
+	// The app may have been moved to a pool with another registry.
+	if registryForImage(appImageName).Address != poolRegistry(app.GetPool()).Address {
+		return platformImage
 	}
 	return appImageName
 }
@@ -208,7 +212,13 @@ func appBasicImageName(appName string) string {
 	return fmt.Sprintf("%s/app-%s", basicImageName(), appName)
 }
 
-func appNewImageName(appName string) (string, error) {
+// appPoolBasicImageName returns the name of the images of the app in the
+// registry of the given pool.
+func appPoolBasicImageName(appName, pool string) string {
+	return fmt.Sprintf("%s/app-%s", poolRegistry(pool).imagePrefix(), appName)
+}
+
+func appNewImageName(appName, pool string) (string, error) {
 	coll, err := appImagesColl()
 	if err != nil {
 		return "", err
@@ -224,7 +234,7 @@ func appNewImageName(appName string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return fmt.Sprintf("%s:v%d", appBasicImageName(appName), imgs.Count), nil
+	return fmt.Sprintf("%s:v%d", appPoolBasicImageName(appName, pool), imgs.Count), nil
 }
 
 func appCurrentImageName(appName string) (string, error) {
@@ -321,7 +331,7 @@ func deleteAllAppImageNames(appName string) error {
 		return err
 	}
 	defer dataColl.Close()
-	_, err = dataColl.RemoveAll(bson.M{"_id": bson.RegEx{Pattern: appBasicImageName(appName)}})
+	_, err = dataColl.RemoveAll(bson.M{"_id": bson.RegEx{Pattern: "/app-" + appName + "(:|$)"}})
 	if err != nil {
 		return err
 	}
@@ -355,15 +365,14 @@ func platformImageName(platformName string) string {
 	return fmt.Sprintf("%s/%s:latest", basicImageName(), platformName)
 }
 
+// poolPlatformImageName returns the name of the platform image in the
+// registry of the given pool.
+func poolPlatformImageName(pool, platformName string) string {
+	return fmt.Sprintf("%s/%s:latest", poolRegistry(pool).imagePrefix(), platformName)
+}
+
 func basicImageName() string {
-	parts := make([]string, 0, 2)
-	registry, _ := config.GetString("docker:registry")
-	if registry != "" {
-		parts = append(parts, registry)
-	}
-	repoNamespace, _ := config.GetString("docker:repository-namespace")
-	parts = append(parts, repoNamespace)
-	return strings.Join(parts, "/")
+	return defaultRegistry().imagePrefix()
 }
 
 func (p *dockerProvisioner) usePlatformImage(app provision.App) bool {
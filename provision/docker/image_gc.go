@@ -0,0 +1,420 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/log"
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	gcReasonDangling   = "dangling image"
+	gcReasonOldImage   = "old app image"
+	gcReasonAppRemoved = "app removed"
+)
+
+// imageGCPolicy defines which images are removed by the image garbage
+// collector. App images are kept while they're among the last KeepAppImages
+// images of the app or are used by a unit. No image created less than MinAge
+// ago is ever removed, so images of in progress deploys are preserved.
+type imageGCPolicy struct {
+	MinAge         time.Duration
+	KeepAppImages  int
+	RemoveDangling bool
+}
+
+// imageGCEntry is an image removed, or that would be removed in a dry run,
+// from a node or from the registry.
+type imageGCEntry struct {
+	Node   string
+	Image  string
+	Reason string
+	Error  string
+}
+
+type imageGCReport struct {
+	DryRun bool
+	Images []imageGCEntry
+	Errors []string
+}
+
+// imageGC periodically removes unused images from the docker nodes and from
+// the registry, according to the configured policy. Only the API server
+// holding the image gc lease runs it.
+type imageGC struct {
+	provisioner *dockerProvisioner
+	policy      imageGCPolicy
+	interval    time.Duration
+	owner       string
+	done        chan bool
+}
+
+const imageGCLeaseID = "image-gc"
+
+func imageGCPolicyFromConfig() imageGCPolicy {
+	minAge, err := config.GetInt("docker:image-gc:min-age")
+	if err != nil {
+		minAge = 3600
+	}
+	keep, _ := config.GetInt("docker:image-gc:keep-app-images")
+	if keep <= 0 {
+		keep = imageHistorySize()
+	}
+	removeDangling, err := config.GetBool("docker:image-gc:remove-dangling")
+	if err != nil {
+		removeDangling = true
+	}
+	return imageGCPolicy{
+		MinAge:         time.Duration(minAge) * time.Second,
+		KeepAppImages:  keep,
+		RemoveDangling: removeDangling,
+	}
+}
+
+func (p *dockerProvisioner) initImageGC() *imageGC {
+	interval, _ := config.GetInt("docker:image-gc:interval")
+	if interval <= 0 {
+		return nil
+	}
+	return &imageGC{
+		provisioner: p,
+		policy:      imageGCPolicyFromConfig(),
+		interval:    time.Duration(interval) * time.Second,
+		owner:       randomString(),
+		done:        make(chan bool),
+	}
+}
+
+func (g *imageGC) run() {
+	for {
+		g.runOnce()
+		select {
+		case <-g.done:
+			return
+		case <-time.After(g.interval):
+		}
+	}
+}
+
+func (g *imageGC) runOnce() {
+	acquired, err := acquireLease(imageGCLeaseID, g.owner, 2*g.interval)
+	if err != nil {
+		log.Errorf("[image gc] unable to acquire lease: %s", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	report, err := g.provisioner.collectImages(g.policy, false)
+	if err != nil {
+		log.Errorf("[image gc] unable to collect images: %s", err)
+		return
+	}
+	log.Debugf("[image gc] %d images removed, %d errors", len(report.Images), len(report.Errors))
+}
+
+func (g *imageGC) Shutdown() {
+	g.done <- true
+}
+
+func (g *imageGC) String() string {
+	return "image garbage collector"
+}
+
+// imageClassifier decides whether an image must be removed, based on the
+// existing apps, their image history and the units using them.
+type imageClassifier struct {
+	appPrefixes []string
+	apps        map[string]bool
+	inUse       map[string]bool
+}
+
+func (p *dockerProvisioner) newImageClassifier(policy imageGCPolicy) (*imageClassifier, error) {
+	apps, err := app.List(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := imageClassifier{
+		appPrefixes: []string{appBasicImageName("")},
+		apps:        make(map[string]bool, len(apps)),
+		inUse:       make(map[string]bool),
+	}
+	for _, r := range allRegistries() {
+		if prefix := r.imagePrefix() + "/app-"; prefix != c.appPrefixes[0] {
+			c.appPrefixes = append(c.appPrefixes, prefix)
+		}
+	}
+	for _, a := range apps {
+		c.apps[a.Name] = true
+		images, err := listAppImages(a.Name)
+		if err != nil && err != mgo.ErrNotFound {
+			return nil, err
+		}
+		if len(images) > policy.KeepAppImages {
+			images = images[len(images)-policy.KeepAppImages:]
+		}
+		for _, img := range images {
+			c.inUse[img] = true
+		}
+	}
+	containers, err := p.listAllContainers()
+	if err != nil {
+		return nil, err
+	}
+	for _, cont := range containers {
+		c.inUse[cont.Image] = true
+	}
+	return &c, nil
+}
+
+// appName returns the name of the app of the given image, or an empty string
+// if it's not an app image in any of the registries.
+func (c *imageClassifier) appName(name string) string {
+	for _, prefix := range c.appPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return strings.SplitN(strings.TrimPrefix(name, prefix), ":", 2)[0]
+		}
+	}
+	return ""
+}
+
+// reason returns why the image with the given name must be removed, or an
+// empty string if it must be kept. Only app images are considered, images
+// from other repositories, including platform images, are always kept.
+func (c *imageClassifier) reason(name string) string {
+	appName := c.appName(name)
+	if appName == "" {
+		return ""
+	}
+	if c.inUse[name] {
+		return ""
+	}
+	if !c.apps[appName] {
+		return gcReasonAppRemoved
+	}
+	return gcReasonOldImage
+}
+
+func isDanglingImage(img *docker.APIImages) bool {
+	for _, tag := range img.RepoTags {
+		if tag != "<none>:<none>" {
+			return false
+		}
+	}
+	return true
+}
+
+// collectImages inventories the images in every docker node and in the
+// registry, and removes the unused ones according to the policy. In dry run
+// mode nothing is removed, the report lists the images that would be.
+func (p *dockerProvisioner) collectImages(policy imageGCPolicy, dryRun bool) (*imageGCReport, error) {
+	classifier, err := p.newImageClassifier(policy)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := p.Cluster().UnfilteredNodes()
+	if err != nil {
+		return nil, err
+	}
+	report := imageGCReport{DryRun: dryRun}
+	removed := make(map[string][]string)
+	for _, node := range nodes {
+		client, err := docker.NewClient(node.Address)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", node.Address, err))
+			continue
+		}
+		images, err := client.ListImages(docker.ListImagesOptions{})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("unable to list images in %s: %s", node.Address, err))
+			continue
+		}
+		for i := range images {
+			img := &images[i]
+			if time.Since(time.Unix(img.Created, 0)) < policy.MinAge {
+				continue
+			}
+			var toRemove []imageGCEntry
+			if isDanglingImage(img) {
+				if policy.RemoveDangling {
+					toRemove = append(toRemove, imageGCEntry{Node: node.Address, Image: img.ID, Reason: gcReasonDangling})
+				}
+			} else {
+				for _, tag := range img.RepoTags {
+					if reason := classifier.reason(tag); reason != "" {
+						toRemove = append(toRemove, imageGCEntry{Node: node.Address, Image: tag, Reason: reason})
+					}
+				}
+			}
+			for _, entry := range toRemove {
+				if !dryRun {
+					err = client.RemoveImage(entry.Image)
+					if err != nil {
+						entry.Error = err.Error()
+					} else if entry.Reason == gcReasonOldImage {
+						removed[entry.Image] = append(removed[entry.Image], node.Address)
+					}
+				}
+				report.Images = append(report.Images, entry)
+			}
+		}
+	}
+	registryImages, err := listRegistryImages()
+	if err != nil {
+		report.Errors = append(report.Errors, fmt.Sprintf("unable to list images in the registry: %s", err))
+	}
+	for _, img := range registryImages {
+		name := img.name()
+		reason := classifier.reason(name)
+		if reason == "" {
+			continue
+		}
+		if policy.MinAge > 0 {
+			created, err := img.created()
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("unable to get the creation time of %s: %s", name, err))
+				continue
+			}
+			if time.Since(created) < policy.MinAge {
+				continue
+			}
+		}
+		entry := imageGCEntry{Image: name, Reason: reason}
+		if !dryRun {
+			err = p.Cluster().RemoveFromRegistry(name)
+			if err != nil {
+				entry.Error = err.Error()
+			}
+		}
+		report.Images = append(report.Images, entry)
+	}
+	if !dryRun {
+		for name := range removed {
+			err = pullAppImageNames(classifier.appName(name), []string{name})
+			if err != nil && err != mgo.ErrNotFound {
+				report.Errors = append(report.Errors, fmt.Sprintf("unable to remove %s from the app images: %s", name, err))
+			}
+		}
+	}
+	return &report, nil
+}
+
+// registryImage is a tag of an app image stored in a registry.
+type registryImage struct {
+	registry registry
+	repo     string
+	tag      string
+}
+
+func (img registryImage) name() string {
+	return fmt.Sprintf("%s/%s:%s", img.registry.Address, img.repo, img.tag)
+}
+
+// created returns when the image was created, according to the history in
+// its manifest, whose first entry is the topmost layer of the image.
+func (img registryImage) created() (time.Time, error) {
+	var manifest struct {
+		History []struct {
+			V1Compatibility string
+		}
+	}
+	url := img.registry.apiURL(fmt.Sprintf("%s/manifests/%s", img.repo, img.tag))
+	err := img.registry.getJSON(url, &manifest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(manifest.History) == 0 {
+		return time.Time{}, fmt.Errorf("no history in the manifest of %s", img.name())
+	}
+	var layer struct {
+		Created time.Time
+	}
+	err = json.Unmarshal([]byte(manifest.History[0].V1Compatibility), &layer)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return layer.Created, nil
+}
+
+// listRegistryImages returns the app images stored in the registries, using
+// the catalog of the registry API v2. It returns no images when no registry
+// is configured.
+func listRegistryImages() ([]registryImage, error) {
+	var images []registryImage
+	for _, r := range allRegistries() {
+		registryImages, err := r.listAppImages()
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, registryImages...)
+	}
+	return images, nil
+}
+
+func (r registry) listAppImages() ([]registryImage, error) {
+	var catalog struct {
+		Repositories []string
+	}
+	err := r.getJSON(r.apiURL("_catalog"), &catalog)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.TrimPrefix(r.imagePrefix()+"/app-", r.Address+"/")
+	var images []registryImage
+	for _, repo := range catalog.Repositories {
+		if !strings.HasPrefix(repo, prefix) {
+			continue
+		}
+		var tags struct {
+			Tags []string
+		}
+		err = r.getJSON(r.apiURL(repo+"/tags/list"), &tags)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range tags.Tags {
+			images = append(images, registryImage{registry: r, repo: repo, tag: tag})
+		}
+	}
+	return images, nil
+}
+
+// apiURL returns the URL of the given path in the registry API v2, using the
+// scheme defined in docker:registry-scheme.
+func (r registry) apiURL(path string) string {
+	scheme, _ := config.GetString("docker:registry-scheme")
+	if scheme == "" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s", scheme, r.Address, path)
+}
+
+func (r registry) getJSON(url string, result interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if r.Auth.Username != "" {
+		req.SetBasicAuth(r.Auth.Username, r.Auth.Password)
+	}
+	resp, err := timeoutHttpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code from %s: %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
@@ -0,0 +1,218 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type imageGCEntryList []imageGCEntry
+
+func (l imageGCEntryList) Len() int           { return len(l) }
+func (l imageGCEntryList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+func (l imageGCEntryList) Less(i, j int) bool { return l[i].Image < l[j].Image }
+
+func (s *S) TestCollectImages(c *check.C) {
+	err := s.storage.Apps().Insert(app.App{Name: "myapp"})
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": "myapp"})
+	var images []string
+	for i := 1; i <= 3; i++ {
+		name := fmt.Sprintf("%s:v%d", appBasicImageName("myapp"), i)
+		err = appendAppImageName("myapp", name)
+		c.Assert(err, check.IsNil)
+		err = s.newFakeImage(s.p, name, nil)
+		c.Assert(err, check.IsNil)
+		images = append(images, name)
+	}
+	removedAppImage := appBasicImageName("removedapp") + ":v1"
+	err = s.newFakeImage(s.p, removedAppImage, nil)
+	c.Assert(err, check.IsNil)
+	err = s.newFakeImage(s.p, platformImageName("python"), nil)
+	c.Assert(err, check.IsNil)
+	policy := imageGCPolicy{KeepAppImages: 2}
+	report, err := s.p.collectImages(policy, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DryRun, check.Equals, true)
+	c.Assert(report.Errors, check.HasLen, 0)
+	sort.Sort(imageGCEntryList(report.Images))
+	c.Assert(report.Images, check.DeepEquals, []imageGCEntry{
+		{Node: s.server.URL(), Image: images[0], Reason: "old app image"},
+		{Node: s.server.URL(), Image: removedAppImage, Reason: "app removed"},
+	})
+	client, err := docker.NewClient(s.server.URL())
+	c.Assert(err, check.IsNil)
+	nodeImages, err := client.ListImages(docker.ListImagesOptions{})
+	c.Assert(err, check.IsNil)
+	c.Assert(nodeImages, check.HasLen, 5)
+	report, err = s.p.collectImages(policy, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DryRun, check.Equals, false)
+	c.Assert(report.Images, check.HasLen, 2)
+	nodeImages, err = client.ListImages(docker.ListImagesOptions{})
+	c.Assert(err, check.IsNil)
+	var tags []string
+	for _, img := range nodeImages {
+		tags = append(tags, img.RepoTags...)
+	}
+	sort.Strings(tags)
+	c.Assert(tags, check.DeepEquals, []string{images[1], images[2], platformImageName("python")})
+	appImages, err := listAppImages("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(appImages, check.DeepEquals, images[1:])
+}
+
+func (s *S) TestCollectImagesKeepsImagesInUse(c *check.C) {
+	err := s.storage.Apps().Insert(app.App{Name: "myapp"})
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": "myapp"})
+	var images []string
+	for i := 1; i <= 2; i++ {
+		name := fmt.Sprintf("%s:v%d", appBasicImageName("myapp"), i)
+		err = appendAppImageName("myapp", name)
+		c.Assert(err, check.IsNil)
+		err = s.newFakeImage(s.p, name, nil)
+		c.Assert(err, check.IsNil)
+		images = append(images, name)
+	}
+	coll := s.p.Collection()
+	defer coll.Close()
+	err = coll.Insert(container.Container{ID: "c1", AppName: "myapp", Image: images[0]})
+	c.Assert(err, check.IsNil)
+	report, err := s.p.collectImages(imageGCPolicy{KeepAppImages: 1}, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Images, check.HasLen, 0)
+}
+
+func (s *S) TestCollectImagesRegistry(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			fmt.Fprint(w, `{"repositories":["tsuru/app-myapp","tsuru/app-removedapp","tsuru/python"]}`)
+		case "/v2/tsuru/app-myapp/tags/list":
+			fmt.Fprint(w, `{"name":"tsuru/app-myapp","tags":["v1","v2"]}`)
+		case "/v2/tsuru/app-removedapp/tags/list":
+			fmt.Fprint(w, `{"name":"tsuru/app-removedapp","tags":["v3"]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+	config.Set("docker:registry", registry)
+	defer config.Unset("docker:registry")
+	err := s.storage.Apps().Insert(app.App{Name: "myapp"})
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": "myapp"})
+	err = appendAppImageName("myapp", appBasicImageName("myapp")+":v1")
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("myapp", appBasicImageName("myapp")+":v2")
+	c.Assert(err, check.IsNil)
+	report, err := s.p.collectImages(imageGCPolicy{KeepAppImages: 1}, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Errors, check.HasLen, 0)
+	sort.Sort(imageGCEntryList(report.Images))
+	c.Assert(report.Images, check.DeepEquals, []imageGCEntry{
+		{Image: registry + "/tsuru/app-myapp:v1", Reason: "old app image"},
+		{Image: registry + "/tsuru/app-removedapp:v3", Reason: "app removed"},
+	})
+}
+
+func (s *S) TestCollectImagesRegistryMinAge(c *check.C) {
+	manifest := func(created time.Time) string {
+		layer, _ := json.Marshal(map[string]interface{}{"created": created})
+		data, _ := json.Marshal(map[string]interface{}{
+			"history": []map[string]string{{"v1Compatibility": string(layer)}},
+		})
+		return string(data)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/_catalog":
+			fmt.Fprint(w, `{"repositories":["tsuru/app-myapp"]}`)
+		case "/v2/tsuru/app-myapp/tags/list":
+			fmt.Fprint(w, `{"name":"tsuru/app-myapp","tags":["v1","v2","v3"]}`)
+		case "/v2/tsuru/app-myapp/manifests/v1":
+			fmt.Fprint(w, manifest(time.Now().Add(-2*time.Hour)))
+		case "/v2/tsuru/app-myapp/manifests/v2":
+			fmt.Fprint(w, manifest(time.Now().Add(-time.Minute)))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	registry := strings.TrimPrefix(server.URL, "http://")
+	config.Set("docker:registry", registry)
+	defer config.Unset("docker:registry")
+	err := s.storage.Apps().Insert(app.App{Name: "myapp"})
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": "myapp"})
+	err = appendAppImageName("myapp", appBasicImageName("myapp")+":v3")
+	c.Assert(err, check.IsNil)
+	report, err := s.p.collectImages(imageGCPolicy{KeepAppImages: 1, MinAge: time.Hour}, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Errors, check.HasLen, 0)
+	c.Assert(report.Images, check.DeepEquals, []imageGCEntry{
+		{Image: registry + "/tsuru/app-myapp:v1", Reason: "old app image"},
+	})
+}
+
+func (s *S) TestImageGCRunOnceWithoutLease(c *check.C) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"repositories":[]}`)
+	}))
+	defer server.Close()
+	config.Set("docker:registry", strings.TrimPrefix(server.URL, "http://"))
+	defer config.Unset("docker:registry")
+	acquired, err := acquireLease(imageGCLeaseID, "other-server", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	gc := imageGC{provisioner: s.p, interval: time.Minute, owner: "this-server"}
+	gc.runOnce()
+	c.Assert(requests, check.Equals, 0)
+	gc.owner = "other-server"
+	gc.runOnce()
+	c.Assert(requests, check.Equals, 1)
+}
+
+func (s *S) TestRegistryAPIURL(c *check.C) {
+	r := registry{Address: "localhost:5000"}
+	c.Assert(r.apiURL("_catalog"), check.Equals, "http://localhost:5000/v2/_catalog")
+	config.Set("docker:registry-scheme", "https")
+	defer config.Unset("docker:registry-scheme")
+	c.Assert(r.apiURL("tsuru/app-myapp/tags/list"), check.Equals, "https://localhost:5000/v2/tsuru/app-myapp/tags/list")
+}
+
+func (s *S) TestIsDanglingImage(c *check.C) {
+	c.Assert(isDanglingImage(&docker.APIImages{}), check.Equals, true)
+	c.Assert(isDanglingImage(&docker.APIImages{RepoTags: []string{"<none>:<none>"}}), check.Equals, true)
+	c.Assert(isDanglingImage(&docker.APIImages{RepoTags: []string{"tsuru/python:latest"}}), check.Equals, false)
+}
+
+func (s *S) TestImageGCPolicyFromConfig(c *check.C) {
+	policy := imageGCPolicyFromConfig()
+	c.Assert(policy, check.DeepEquals, imageGCPolicy{MinAge: time.Hour, KeepAppImages: 10, RemoveDangling: true})
+	config.Set("docker:image-gc:min-age", 0)
+	config.Set("docker:image-gc:keep-app-images", 3)
+	config.Set("docker:image-gc:remove-dangling", false)
+	defer config.Unset("docker:image-gc")
+	policy = imageGCPolicyFromConfig()
+	c.Assert(policy, check.DeepEquals, imageGCPolicy{KeepAppImages: 3})
+}
@@ -171,13 +171,13 @@ func (s *S) TestUsePlatformImage(c *check.C) {
 }
 
 func (s *S) TestAppNewImageName(c *check.C) {
-	img1, err := appNewImageName("myapp")
+	img1, err := appNewImageName("myapp", "")
 	c.Assert(err, check.IsNil)
 	c.Assert(img1, check.Equals, "tsuru/app-myapp:v1")
-	img2, err := appNewImageName("myapp")
+	img2, err := appNewImageName("myapp", "")
 	c.Assert(err, check.IsNil)
 	c.Assert(img2, check.Equals, "tsuru/app-myapp:v2")
-	img3, err := appNewImageName("myapp")
+	img3, err := appNewImageName("myapp", "")
 	c.Assert(err, check.IsNil)
 	c.Assert(img3, check.Equals, "tsuru/app-myapp:v3")
 }
@@ -185,17 +185,30 @@ func (s *S) TestAppNewImageName(c *check.C) {
 func (s *S) TestAppNewImageNameWithRegistry(c *check.C) {
 	config.Set("docker:registry", "localhost:3030")
 	defer config.Unset("docker:registry")
-	img1, err := appNewImageName("myapp")
+	img1, err := appNewImageName("myapp", "")
 	c.Assert(err, check.IsNil)
 	c.Assert(img1, check.Equals, "localhost:3030/tsuru/app-myapp:v1")
-	img2, err := appNewImageName("myapp")
+	img2, err := appNewImageName("myapp", "")
 	c.Assert(err, check.IsNil)
 	c.Assert(img2, check.Equals, "localhost:3030/tsuru/app-myapp:v2")
-	img3, err := appNewImageName("myapp")
+	img3, err := appNewImageName("myapp", "")
 	c.Assert(err, check.IsNil)
 	c.Assert(img3, check.Equals, "localhost:3030/tsuru/app-myapp:v3")
 }
 
+func (s *S) TestAppNewImageNameWithPoolRegistry(c *check.C) {
+	config.Set("docker:registry", "localhost:3030")
+	config.Set("docker:registries:pool1:address", "localhost:4040")
+	defer config.Unset("docker:registry")
+	defer config.Unset("docker:registries")
+	img1, err := appNewImageName("myapp", "pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(img1, check.Equals, "localhost:4040/tsuru/app-myapp:v1")
+	img2, err := appNewImageName("myapp", "pool2")
+	c.Assert(err, check.IsNil)
+	c.Assert(img2, check.Equals, "localhost:3030/tsuru/app-myapp:v2")
+}
+
 func (s *S) TestAppCurrentImageNameWithoutImage(c *check.C) {
 	img1, err := appCurrentImageName("myapp")
 	c.Assert(err, check.IsNil)
@@ -260,6 +273,15 @@ func (s *S) TestPlatformImageName(c *check.C) {
 	c.Assert(platName, check.Equals, "localhost:3030/tsuru/ruby:latest")
 }
 
+func (s *S) TestPoolPlatformImageName(c *check.C) {
+	config.Set("docker:registry", "localhost:3030")
+	config.Set("docker:registries:pool1:address", "localhost:4040")
+	defer config.Unset("docker:registry")
+	defer config.Unset("docker:registries")
+	c.Assert(poolPlatformImageName("pool1", "python"), check.Equals, "localhost:4040/tsuru/python:latest")
+	c.Assert(poolPlatformImageName("pool2", "python"), check.Equals, "localhost:3030/tsuru/python:latest")
+}
+
 func (s *S) TestDeleteAllAppImageNames(c *check.C) {
 	err := appendAppImageName("myapp", "tsuru/app-myapp:v1")
 	c.Assert(err, check.IsNil)
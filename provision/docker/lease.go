@@ -0,0 +1,62 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// lease grants a periodic task to a single API server, so tasks changing the
+// nodes aren't run concurrently by every API server.
+type lease struct {
+	ID    string `bson:"_id"`
+	Owner string
+	Until time.Time
+}
+
+func leaseCollection() (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("docker:collection")
+	if err != nil {
+		return nil, err
+	}
+	return conn.Collection(fmt.Sprintf("%s_leases", name)), nil
+}
+
+// acquireLease takes or renews the lease with the given id, which is granted
+// to a single owner until it expires.
+func acquireLease(id, owner string, duration time.Duration) (bool, error) {
+	coll, err := leaseCollection()
+	if err != nil {
+		return false, err
+	}
+	defer coll.Close()
+	now := time.Now().UTC()
+	l := lease{ID: id, Owner: owner, Until: now.Add(duration)}
+	err = coll.Update(bson.M{
+		"_id": id,
+		"$or": []bson.M{{"owner": owner}, {"until": bson.M{"$lt": now}}},
+	}, l)
+	if err == mgo.ErrNotFound {
+		err = coll.Insert(l)
+		if mgo.IsDup(err) {
+			return false, nil
+		}
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
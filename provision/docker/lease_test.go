@@ -0,0 +1,38 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"time"
+
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestAcquireLease(c *check.C) {
+	acquired, err := acquireLease("task", "server1", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	acquired, err = acquireLease("task", "server2", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, false)
+	acquired, err = acquireLease("task", "server1", -time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	acquired, err = acquireLease("task", "server2", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	acquired, err = acquireLease("task", "server1", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, false)
+}
+
+func (s *S) TestAcquireLeaseIndependentTasks(c *check.C) {
+	acquired, err := acquireLease("task1", "server1", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+	acquired, err = acquireLease("task2", "server2", time.Minute)
+	c.Assert(err, check.IsNil)
+	c.Assert(acquired, check.Equals, true)
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+)
+
+const statsTimeout = 10 * time.Second
+
+func (p *dockerProvisioner) UnitsMetrics(app provision.App) ([]provision.UnitMetrics, error) {
+	containers, err := p.listContainersByApp(app.GetName())
+	if err != nil {
+		return nil, err
+	}
+	metrics := make([]provision.UnitMetrics, len(containers))
+	errs := make(chan error, len(containers))
+	var wg sync.WaitGroup
+	for i := range containers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m, err := p.containerMetrics(&containers[i])
+			if err != nil {
+				errs <- err
+				return
+			}
+			metrics[i] = m
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+func (p *dockerProvisioner) containerMetrics(c *container.Container) (provision.UnitMetrics, error) {
+	metrics := provision.UnitMetrics{ID: c.ID, ProcessName: c.ProcessName}
+	addr, err := p.hostToNodeAddress(c.HostAddr)
+	if err != nil {
+		return metrics, err
+	}
+	client, err := docker.NewClient(addr)
+	if err != nil {
+		return metrics, err
+	}
+	statsCh := make(chan *docker.Stats, 1)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- client.Stats(docker.StatsOptions{
+			ID:      c.ID,
+			Stats:   statsCh,
+			Stream:  false,
+			Timeout: statsTimeout,
+		})
+	}()
+	stats, ok := <-statsCh
+	if !ok || stats == nil {
+		return metrics, <-errCh
+	}
+	fillUnitMetrics(&metrics, stats)
+	return metrics, nil
+}
+
+// fillUnitMetrics converts the stats returned by the Docker API to unit
+// metrics. The CPU usage is calculated based on the difference between the
+// current sample and the previous one, the same way the docker client does,
+// so it's left empty when Docker doesn't report the previous sample.
+func fillUnitMetrics(metrics *provision.UnitMetrics, stats *docker.Stats) {
+	metrics.MemoryUsage = stats.MemoryStats.Usage
+	metrics.MemoryLimit = stats.MemoryStats.Limit
+	metrics.NetworkRx = stats.Network.RxBytes
+	metrics.NetworkTx = stats.Network.TxBytes
+	if stats.PreCPUStats.SystemCPUUsage == 0 {
+		return
+	}
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemCPUUsage) - float64(stats.PreCPUStats.SystemCPUUsage)
+	if cpuDelta > 0 && systemDelta > 0 {
+		cpus := float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+		if cpus == 0 {
+			cpus = 1
+		}
+		metrics.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+}
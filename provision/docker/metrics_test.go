@@ -0,0 +1,46 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestFillUnitMetrics(c *check.C) {
+	var stats docker.Stats
+	stats.CPUStats.CPUUsage.TotalUsage = 300
+	stats.CPUStats.CPUUsage.PercpuUsage = []uint64{150, 150}
+	stats.CPUStats.SystemCPUUsage = 2000
+	stats.PreCPUStats.CPUUsage.TotalUsage = 100
+	stats.PreCPUStats.SystemCPUUsage = 1000
+	stats.MemoryStats.Usage = 1024
+	stats.MemoryStats.Limit = 4096
+	stats.Network.RxBytes = 10
+	stats.Network.TxBytes = 20
+	metrics := provision.UnitMetrics{ID: "abc", ProcessName: "web"}
+	fillUnitMetrics(&metrics, &stats)
+	c.Assert(metrics, check.DeepEquals, provision.UnitMetrics{
+		ID:          "abc",
+		ProcessName: "web",
+		CPUPercent:  40,
+		MemoryUsage: 1024,
+		MemoryLimit: 4096,
+		NetworkRx:   10,
+		NetworkTx:   20,
+	})
+}
+
+func (s *S) TestFillUnitMetricsWithoutPreviousSample(c *check.C) {
+	var stats docker.Stats
+	stats.CPUStats.CPUUsage.TotalUsage = 300
+	stats.CPUStats.SystemCPUUsage = 2000
+	stats.MemoryStats.Usage = 1024
+	var metrics provision.UnitMetrics
+	fillUnitMetrics(&metrics, &stats)
+	c.Assert(metrics.CPUPercent, check.Equals, 0.0)
+	c.Assert(metrics.MemoryUsage, check.Equals, uint64(1024))
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tsuru/docker-cluster/cluster"
+)
+
+// cordonedMetadata is the node metadata that marks a node as unschedulable.
+// Cordoned nodes keep running their units, but the scheduler never places new
+// units in them and the auto scaler ignores them.
+const cordonedMetadata = "cordoned"
+
+var errNodeNotFound = errors.New("node not found")
+
+func isCordoned(node *cluster.Node) bool {
+	return node.Metadata[cordonedMetadata] == "true"
+}
+
+// schedulableNodes returns the given nodes, except for the cordoned ones.
+func schedulableNodes(nodes []cluster.Node) []cluster.Node {
+	result := make([]cluster.Node, 0, len(nodes))
+	for i := range nodes {
+		if !isCordoned(&nodes[i]) {
+			result = append(result, nodes[i])
+		}
+	}
+	return result
+}
+
+// setNodeCordoned marks the node with the given address as unschedulable, or
+// as schedulable again.
+func (p *dockerProvisioner) setNodeCordoned(address string, cordoned bool) error {
+	nodes, err := p.Cluster().UnfilteredNodes()
+	if err != nil {
+		return err
+	}
+	var found bool
+	for i := range nodes {
+		if nodes[i].Address == address {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errNodeNotFound
+	}
+	var value string
+	if cordoned {
+		value = "true"
+	}
+	node := cluster.Node{Address: address, Metadata: map[string]string{cordonedMetadata: value}}
+	_, err = p.Cluster().UpdateNode(node)
+	return err
+}
+
+// drainNode cordons the node with the given address and moves all its units
+// to other nodes, in batches of batchSize units. Each unit is replaced before
+// being removed, so the routes of the apps are never left empty. The node is
+// kept cordoned, it must be uncordoned to receive units again.
+func (p *dockerProvisioner) drainNode(address string, batchSize int, w io.Writer) error {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	err := p.setNodeCordoned(address, true)
+	if err != nil {
+		return err
+	}
+	host := urlToHost(address)
+	containers, err := p.listContainersByHost(host)
+	if err != nil {
+		return err
+	}
+	if len(containers) == 0 {
+		fmt.Fprintf(w, "No units to move in %s\n", host)
+		return nil
+	}
+	batches := (len(containers) + batchSize - 1) / batchSize
+	for i := 0; i < batches; i++ {
+		batch := containers[i*batchSize : minInt((i+1)*batchSize, len(containers))]
+		fmt.Fprintf(w, "\n---- Draining %s, batch %d of %d ----\n", host, i+1, batches)
+		err = p.moveContainerList(batch, "", w)
+		if err != nil {
+			fmt.Fprintf(w, "\n---- Aborting, %d of %d batches completed ----\n", i, batches)
+			return err
+		}
+	}
+	fmt.Fprintf(w, "\nNode %s drained, %d units moved.\n", host, len(containers))
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/safe"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestSchedulableNodes(c *check.C) {
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234", Metadata: map[string]string{"cordoned": "true"}},
+		{Address: "http://server2:1234", Metadata: map[string]string{"cordoned": "false"}},
+		{Address: "http://server3:1234"},
+	}
+	c.Assert(schedulableNodes(nodes), check.DeepEquals, nodes[1:])
+}
+
+func (s *S) TestSetNodeCordoned(c *check.C) {
+	var err error
+	s.p.cluster, err = cluster.New(nil, &cluster.MapStorage{},
+		cluster.Node{Address: "http://server1:1234", Metadata: map[string]string{"pool": "pool1"}},
+	)
+	c.Assert(err, check.IsNil)
+	err = s.p.setNodeCordoned("http://server1:1234", true)
+	c.Assert(err, check.IsNil)
+	nodes, err := s.p.Cluster().UnfilteredNodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes, check.HasLen, 1)
+	c.Assert(nodes[0].Metadata, check.DeepEquals, map[string]string{"pool": "pool1", "cordoned": "true"})
+	err = s.p.setNodeCordoned("http://server1:1234", false)
+	c.Assert(err, check.IsNil)
+	nodes, err = s.p.Cluster().UnfilteredNodes()
+	c.Assert(err, check.IsNil)
+	c.Assert(nodes[0].Metadata, check.DeepEquals, map[string]string{"pool": "pool1"})
+}
+
+func (s *S) TestSetNodeCordonedNotFound(c *check.C) {
+	err := s.p.setNodeCordoned("http://unknown:1234", true)
+	c.Assert(err, check.Equals, errNodeNotFound)
+}
+
+func (s *S) TestSchedulerScheduleSkipsCordonedNodes(c *check.C) {
+	a := app.App{Name: "skyrim", Pool: "test-default"}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": a.Name})
+	sched := segregatedScheduler{provisioner: s.p}
+	clusterInstance, err := cluster.New(&sched, &cluster.MapStorage{},
+		cluster.Node{Address: "http://server1:1234", Metadata: map[string]string{"pool": "test-default", "cordoned": "true"}},
+		cluster.Node{Address: "http://server2:1234", Metadata: map[string]string{"pool": "test-default"}},
+	)
+	c.Assert(err, check.IsNil)
+	s.p.cluster = clusterInstance
+	for i := 0; i < 3; i++ {
+		node, err := sched.Schedule(clusterInstance, docker.CreateContainerOptions{}, []string{a.Name, "web"})
+		c.Assert(err, check.IsNil)
+		c.Assert(node.Address, check.Equals, "http://server2:1234")
+	}
+	err = s.p.setNodeCordoned("http://server2:1234", true)
+	c.Assert(err, check.IsNil)
+	_, err = sched.Schedule(clusterInstance, docker.CreateContainerOptions{}, []string{a.Name, "web"})
+	c.Assert(err, check.ErrorMatches, `no schedulable nodes found for app "skyrim": all nodes are cordoned`)
+}
+
+func (s *S) TestDrainNode(c *check.C) {
+	otherServer, err := dtesting.NewServer("localhost:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer otherServer.Stop()
+	otherURL := strings.Replace(otherServer.URL(), "127.0.0.1", "localhost", 1)
+	p := &dockerProvisioner{}
+	err = p.Initialize()
+	c.Assert(err, check.IsNil)
+	p.storage = &cluster.MapStorage{}
+	p.scheduler = &segregatedScheduler{provisioner: p}
+	p.cluster, err = cluster.New(p.scheduler, p.storage,
+		cluster.Node{Address: s.server.URL(), Metadata: map[string]string{"pool": "test-default"}},
+		cluster.Node{Address: otherURL, Metadata: map[string]string{"pool": "test-default"}},
+	)
+	c.Assert(err, check.IsNil)
+	err = s.newFakeImage(p, "tsuru/app-myapp", nil)
+	c.Assert(err, check.IsNil)
+	appInstance := provisiontest.NewFakeApp("myapp", "python", 0)
+	defer p.Destroy(appInstance)
+	p.Provision(appInstance)
+	imageId, err := appCurrentImageName(appInstance.GetName())
+	c.Assert(err, check.IsNil)
+	_, err = addContainersWithHost(&changeUnitsPipelineArgs{
+		toHost:      "localhost",
+		toAdd:       map[string]*containersToAdd{"web": {Quantity: 3}},
+		app:         appInstance,
+		imageId:     imageId,
+		provisioner: p,
+	})
+	c.Assert(err, check.IsNil)
+	appStruct := &app.App{Name: appInstance.GetName()}
+	err = s.storage.Apps().Insert(appStruct)
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": appStruct.Name})
+	buf := safe.NewBuffer(nil)
+	err = p.drainNode(otherURL, 2, buf)
+	c.Assert(err, check.IsNil)
+	containers, err := p.listContainersByHost("localhost")
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+	containers, err = p.listContainersByHost("127.0.0.1")
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	c.Assert(buf.String(), check.Matches, `(?s).*Draining localhost, batch 1 of 2.*Draining localhost, batch 2 of 2.*Node localhost drained, 3 units moved.*`)
+	nodes, err := p.Cluster().UnfilteredNodes()
+	c.Assert(err, check.IsNil)
+	for _, node := range nodes {
+		c.Assert(isCordoned(&node), check.Equals, node.Address == otherURL)
+	}
+}
+
+func (s *S) TestDrainNodeWithoutUnits(c *check.C) {
+	var err error
+	s.p.cluster, err = cluster.New(nil, &cluster.MapStorage{},
+		cluster.Node{Address: "http://server1:1234"},
+	)
+	c.Assert(err, check.IsNil)
+	buf := safe.NewBuffer(nil)
+	err = s.p.drainNode("http://server1:1234", 1, buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Equals, "No units to move in server1\n")
+}
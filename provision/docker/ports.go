@@ -0,0 +1,45 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+)
+
+// processPorts returns the ports declared in the ports section of tsuru.yaml
+// for the given process. Each port must have an unique name, and at most one
+// of them may be exposed through the router.
+func processPorts(yamlData provision.TsuruYamlData, process string) ([]container.ExposedPort, error) {
+	declared := yamlData.Ports[process]
+	if len(declared) == 0 {
+		return nil, nil
+	}
+	ports := make([]container.ExposedPort, 0, len(declared))
+	names := make(map[string]bool, len(declared))
+	var routed string
+	for _, p := range declared {
+		if p.Name == "" {
+			return nil, fmt.Errorf("invalid ports for process %q: every port must have a name", process)
+		}
+		if names[p.Name] {
+			return nil, fmt.Errorf("invalid ports for process %q: duplicated port %q", process, p.Name)
+		}
+		if p.Port < 1 || p.Port > 65535 {
+			return nil, fmt.Errorf("invalid ports for process %q: invalid number for port %q: %d", process, p.Name, p.Port)
+		}
+		if p.Router {
+			if routed != "" {
+				return nil, fmt.Errorf("invalid ports for process %q: only one port may be exposed through the router, found %q and %q", process, routed, p.Name)
+			}
+			routed = p.Name
+		}
+		names[p.Name] = true
+		ports = append(ports, container.ExposedPort{Name: p.Name, Port: p.Port, Routed: p.Router})
+	}
+	return ports, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/check.v1"
+)
+
+func (s *S) TestProcessPorts(c *check.C) {
+	yamlData := provision.TsuruYamlData{
+		Ports: map[string][]provision.TsuruYamlPort{
+			"web": {
+				{Name: "metrics", Port: 9100},
+				{Name: "grpc", Port: 50051, Router: true},
+			},
+		},
+	}
+	ports, err := processPorts(yamlData, "web")
+	c.Assert(err, check.IsNil)
+	c.Assert(ports, check.DeepEquals, []container.ExposedPort{
+		{Name: "metrics", Port: 9100},
+		{Name: "grpc", Port: 50051, Routed: true},
+	})
+	ports, err = processPorts(yamlData, "worker")
+	c.Assert(err, check.IsNil)
+	c.Assert(ports, check.IsNil)
+}
+
+func (s *S) TestProcessPortsInvalid(c *check.C) {
+	tests := []struct {
+		ports []provision.TsuruYamlPort
+		err   string
+	}{
+		{
+			[]provision.TsuruYamlPort{{Port: 9100}},
+			`invalid ports for process "web": every port must have a name`,
+		},
+		{
+			[]provision.TsuruYamlPort{{Name: "metrics", Port: 9100}, {Name: "metrics", Port: 9101}},
+			`invalid ports for process "web": duplicated port "metrics"`,
+		},
+		{
+			[]provision.TsuruYamlPort{{Name: "metrics", Port: 70000}},
+			`invalid ports for process "web": invalid number for port "metrics": 70000`,
+		},
+		{
+			[]provision.TsuruYamlPort{{Name: "http", Port: 8080, Router: true}, {Name: "grpc", Port: 50051, Router: true}},
+			`invalid ports for process "web": only one port may be exposed through the router, found "http" and "grpc"`,
+		},
+	}
+	for _, t := range tests {
+		yamlData := provision.TsuruYamlData{Ports: map[string][]provision.TsuruYamlPort{"web": t.ports}}
+		_, err := processPorts(yamlData, "web")
+		c.Check(err, check.ErrorMatches, t.err)
+	}
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/tsuru/tsuru/db"
 	"github.com/tsuru/tsuru/db/storage"
 	"github.com/tsuru/tsuru/errors"
+	tsuruIo "github.com/tsuru/tsuru/io"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/provision/docker/bs"
@@ -85,9 +86,13 @@ func (p *dockerProvisioner) initDockerCluster() error {
 	var nodes []cluster.Node
 	TotalMemoryMetadata, _ := config.GetString("docker:scheduler:total-memory-metadata")
 	maxUsedMemory, _ := config.GetFloat("docker:scheduler:max-used-memory")
+	TotalCPUMetadata, _ := config.GetString("docker:scheduler:total-cpu-metadata")
+	maxUsedCPU, _ := config.GetFloat("docker:scheduler:max-used-cpu")
 	p.scheduler = &segregatedScheduler{
 		maxMemoryRatio:      float32(maxUsedMemory),
 		TotalMemoryMetadata: TotalMemoryMetadata,
+		maxCPURatio:         float32(maxUsedCPU),
+		TotalCPUMetadata:    TotalCPUMetadata,
 		provisioner:         p,
 	}
 	p.cluster, err = cluster.New(p.scheduler, p.storage, nodes...)
@@ -129,10 +134,44 @@ func (p *dockerProvisioner) initDockerCluster() error {
 		shutdown.Register(contHealerInst)
 		go contHealerInst.RunContainerHealer()
 	}
+	livenessSeconds, _ := config.GetInt("docker:healing:liveness-check-interval")
+	if livenessSeconds > 0 {
+		livenessHealer := healer.NewLivenessHealer(healer.LivenessHealerArgs{
+			Provisioner: p,
+			Interval:    time.Duration(livenessSeconds) * time.Second,
+			Done:        make(chan bool),
+			Locker:      &appLocker{},
+		})
+		shutdown.Register(livenessHealer)
+		go livenessHealer.RunLivenessHealer()
+	}
 	activeMonitoring, _ := config.GetInt("docker:healing:active-monitoring-interval")
 	if activeMonitoring > 0 {
 		p.cluster.StartActiveMonitoring(time.Duration(activeMonitoring) * time.Second)
 	}
+	idle, err := p.initIdleChecker()
+	if err != nil {
+		return err
+	}
+	if idle != nil {
+		shutdown.Register(idle)
+		go idle.run()
+		wakeUpListen, _ := config.GetString("docker:idle:wake-up-listen")
+		go idle.serveWakeUp(wakeUpListen)
+	}
+	crashLoop := p.initCrashLoopChecker()
+	shutdown.Register(crashLoop)
+	go crashLoop.run()
+	gc := p.initImageGC()
+	if gc != nil {
+		shutdown.Register(gc)
+		go gc.run()
+	}
+	rec := p.initReconciler()
+	if rec != nil {
+		shutdown.Register(rec)
+		go rec.run()
+	}
 	autoScale := p.initAutoScaleConfig()
 	if autoScale.Enabled {
 		shutdown.Register(autoScale)
@@ -147,9 +186,11 @@ func (p *dockerProvisioner) initAutoScaleConfig() *autoScaleConfig {
 	GroupByMetadata, _ := config.GetString("docker:auto-scale:group-by-metadata")
 	runInterval, _ := config.GetInt("docker:auto-scale:run-interval")
 	TotalMemoryMetadata, _ := config.GetString("docker:scheduler:total-memory-metadata")
+	TotalCPUMetadata, _ := config.GetString("docker:scheduler:total-cpu-metadata")
 	return &autoScaleConfig{
 		GroupByMetadata:     GroupByMetadata,
 		TotalMemoryMetadata: TotalMemoryMetadata,
+		TotalCPUMetadata:    TotalCPUMetadata,
 		WaitTimeNewMachine:  time.Duration(waitSecondsNewMachine) * time.Second,
 		RunInterval:         time.Duration(runInterval) * time.Second,
 		Enabled:             enabled,
@@ -168,6 +209,8 @@ func (p *dockerProvisioner) cloneProvisioner(ignoredContainers []container.Conta
 	overridenProvisioner.scheduler = &segregatedScheduler{
 		maxMemoryRatio:      p.scheduler.maxMemoryRatio,
 		TotalMemoryMetadata: p.scheduler.TotalMemoryMetadata,
+		maxCPURatio:         p.scheduler.maxCPURatio,
+		TotalCPUMetadata:    p.scheduler.TotalCPUMetadata,
 		provisioner:         &overridenProvisioner,
 		ignoredContainers:   containerIds,
 	}
@@ -201,6 +244,8 @@ func (p *dockerProvisioner) dryMode(ignoredContainers []container.Container) (*d
 	overridenProvisioner.scheduler = &segregatedScheduler{
 		maxMemoryRatio:      p.scheduler.maxMemoryRatio,
 		TotalMemoryMetadata: p.scheduler.TotalMemoryMetadata,
+		maxCPURatio:         p.scheduler.maxCPURatio,
+		TotalCPUMetadata:    p.scheduler.TotalCPUMetadata,
 		provisioner:         overridenProvisioner,
 		ignoredContainers:   containerIds,
 	}
@@ -266,6 +311,9 @@ func (p *dockerProvisioner) Provision(app provision.App) error {
 }
 
 func (p *dockerProvisioner) Restart(a provision.App, process string, w io.Writer) error {
+	if err := ensureNoCanary(a.GetName()); err != nil {
+		return err
+	}
 	containers, err := p.listContainersByProcess(a.GetName(), process)
 	if err != nil {
 		return err
@@ -286,11 +334,30 @@ func (p *dockerProvisioner) Restart(a provision.App, process string, w io.Writer
 		toAdd[c.ProcessName].Quantity++
 		toAdd[c.ProcessName].Status = provision.StatusStarted
 	}
-	_, err = p.runReplaceUnitsPipeline(writer, a, toAdd, containers, imageId)
-	return err
+	err = p.replaceUnits(writer, a, toAdd, containers, imageId)
+	if err != nil {
+		return err
+	}
+	err = p.ensureAwake(a)
+	if err != nil {
+		log.Errorf("[restart] unable to route app %q back to its units: %s", a.GetName(), err)
+	}
+	return nil
 }
 
 func (p *dockerProvisioner) Start(app provision.App, process string) error {
+	err := p.startContainers(app, process)
+	if err != nil {
+		return err
+	}
+	err = p.ensureAwake(app)
+	if err != nil {
+		log.Errorf("[start] unable to route app %q back to its units: %s", app.GetName(), err)
+	}
+	return nil
+}
+
+func (p *dockerProvisioner) startContainers(app provision.App, process string) error {
 	containers, err := p.listContainersByProcess(app.GetName(), process)
 	if err != nil {
 		return stderr.New(fmt.Sprintf("Got error while getting app containers: %s", err))
@@ -354,7 +421,20 @@ func (p *dockerProvisioner) GitDeploy(app provision.App, version string, w io.Wr
 }
 
 func (p *dockerProvisioner) ArchiveDeploy(app provision.App, archiveURL string, w io.Writer) (string, error) {
-	imageId, err := p.archiveDeploy(app, p.getBuildImage(app), archiveURL, w)
+	var (
+		imageId string
+		err     error
+	)
+	if dockerfileDeployEnabled(app.GetPool()) {
+		var archive []byte
+		archive, err = downloadArchive(archiveURL)
+		if err != nil {
+			return "", err
+		}
+		imageId, err = p.deployArchiveContents(app, archive, w)
+	} else {
+		imageId, err = p.archiveDeploy(app, p.getBuildImage(app), archiveURL, w)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -362,6 +442,41 @@ func (p *dockerProvisioner) ArchiveDeploy(app provision.App, archiveURL string,
 }
 
 func (p *dockerProvisioner) UploadDeploy(app provision.App, archiveFile io.ReadCloser, w io.Writer) (string, error) {
+	var (
+		imageId string
+		err     error
+	)
+	if dockerfileDeployEnabled(app.GetPool()) {
+		var archive []byte
+		archive, err = ioutil.ReadAll(archiveFile)
+		archiveFile.Close()
+		if err != nil {
+			return "", err
+		}
+		imageId, err = p.deployArchiveContents(app, archive, w)
+	} else {
+		imageId, err = p.uploadDeploy(app, archiveFile, w)
+	}
+	if err != nil {
+		return "", err
+	}
+	return imageId, p.deployAndClean(app, imageId, w)
+}
+
+// deployArchiveContents builds the app image from the Dockerfile in the
+// archive, when there's one, or from the platform image otherwise.
+func (p *dockerProvisioner) deployArchiveContents(app provision.App, archive []byte, w io.Writer) (string, error) {
+	sources, err := readAppSources(archive)
+	if err != nil {
+		return "", err
+	}
+	if sources.hasDockerfile {
+		return p.dockerfileDeploy(app, archive, sources, w)
+	}
+	return p.uploadDeploy(app, ioutil.NopCloser(bytes.NewReader(archive)), w)
+}
+
+func (p *dockerProvisioner) uploadDeploy(app provision.App, archiveFile io.ReadCloser, w io.Writer) (string, error) {
 	defer archiveFile.Close()
 	filePath := "/home/application/archive.tar.gz"
 	user, err := config.GetString("docker:user")
@@ -413,22 +528,41 @@ func (p *dockerProvisioner) UploadDeploy(app provision.App, archiveFile io.ReadC
 	if err != nil {
 		return "", err
 	}
-	imageId, err := p.archiveDeploy(app, image.ID, "file://"+filePath, w)
-	if err != nil {
-		return "", err
-	}
-	return imageId, p.deployAndClean(app, imageId, w)
+	return p.archiveDeploy(app, image.ID, "file://"+filePath, w)
 }
 
+// deployAndClean deploys the image, removing it when the deploy fails. The
+// image is kept if any unit is still running it, which happens when a rolling
+// replacement could not be rolled back.
 func (p *dockerProvisioner) deployAndClean(a provision.App, imageId string, w io.Writer) error {
 	err := p.deploy(a, imageId, w)
 	if err != nil {
-		p.cleanImage(a.GetName(), imageId)
+		if p.imageInUse(a.GetName(), imageId) {
+			log.Errorf("[deploy] keeping image %s of app %q, it's used by units", imageId, a.GetName())
+		} else {
+			p.cleanImage(a.GetName(), imageId)
+		}
 	}
 	return err
 }
 
+func (p *dockerProvisioner) imageInUse(appName, imageId string) bool {
+	containers, err := p.listContainersByApp(appName)
+	if err != nil {
+		return true
+	}
+	for _, c := range containers {
+		if c.Image == imageId {
+			return true
+		}
+	}
+	return false
+}
+
 func (p *dockerProvisioner) deploy(a provision.App, imageId string, w io.Writer) error {
+	if err := ensureNoCanary(a.GetName()); err != nil {
+		return err
+	}
 	containers, err := p.listContainersByApp(a.GetName())
 	if err != nil {
 		return err
@@ -437,6 +571,18 @@ func (p *dockerProvisioner) deploy(a provision.App, imageId string, w io.Writer)
 	if err != nil {
 		return err
 	}
+	yamlData, err := getImageTsuruYamlData(imageId)
+	if err != nil {
+		return err
+	}
+	err = app.ValidateYamlJobs(a.GetName(), yamlData.Jobs)
+	if err != nil {
+		return err
+	}
+	err = p.runReleaseHooks(a, imageId, yamlData.Hooks.Release, w)
+	if err != nil {
+		return err
+	}
 	if len(containers) == 0 {
 		toAdd := make(map[string]*containersToAdd, len(imageData.Processes))
 		for processName := range imageData.Processes {
@@ -447,29 +593,45 @@ func (p *dockerProvisioner) deploy(a provision.App, imageId string, w io.Writer)
 			}
 			toAdd[processName].Quantity++
 		}
+		applyUnitsSpec(toAdd, yamlData.Units)
 		if err := setQuota(a, toAdd); err != nil {
 			return err
 		}
 		_, err = p.runCreateUnitsPipeline(w, a, toAdd, imageId)
 	} else {
 		toAdd := getContainersToAdd(imageData, containers)
+		applyUnitsSpec(toAdd, yamlData.Units)
 		if err := setQuota(a, toAdd); err != nil {
 			return err
 		}
-		_, err = p.runReplaceUnitsPipeline(w, a, toAdd, containers, imageId)
+		err = p.replaceUnits(w, a, toAdd, containers, imageId)
 	}
-	return err
+	if err != nil {
+		return err
+	}
+	err = p.ensureAwake(a)
+	if err != nil {
+		log.Errorf("[deploy] unable to route app %q back to its units: %s", a.GetName(), err)
+	}
+	err = app.SyncYamlJobs(a.GetName(), yamlData.Jobs)
+	if err != nil {
+		log.Errorf("[deploy] unable to save jobs of app %q: %s", a.GetName(), err)
+		fmt.Fprintf(w, "\n---- Unable to save the jobs declared in tsuru.yaml: %s ----\n", err)
+	}
+	return nil
 }
 
-func setQuota(app provision.App, toAdd map[string]*containersToAdd) error {
-	if quota := app.GetQuota(); quota.Unlimited() {
-		return nil
-	}
+func setQuota(a provision.App, toAdd map[string]*containersToAdd) error {
 	var total int
 	for _, ct := range toAdd {
 		total += ct.Quantity
 	}
-	err := app.SetQuotaInUse(total)
+	var err error
+	if quota := a.GetQuota(); quota.Unlimited() {
+		err = app.CheckPoolQuota(a, total)
+	} else {
+		err = a.SetQuotaInUse(total)
+	}
 	if err != nil {
 		return &errors.CompositeError{
 			Base:    err,
@@ -504,6 +666,27 @@ func getContainersToAdd(data ImageMetadata, oldContainers []container.Container)
 	return processMap
 }
 
+// applyUnitsSpec converges the number of units of each process to the units
+// declared in the units section of tsuru.yaml. Processes not present in toAdd
+// are ignored.
+func applyUnitsSpec(toAdd map[string]*containersToAdd, units map[string]provision.TsuruYamlUnits) {
+	for name, spec := range units {
+		ct, ok := toAdd[name]
+		if !ok {
+			continue
+		}
+		if spec.Count > 0 {
+			ct.Quantity = spec.Count
+		}
+		if spec.Max > 0 && ct.Quantity > spec.Max {
+			ct.Quantity = spec.Max
+		}
+		if ct.Quantity < spec.Min {
+			ct.Quantity = spec.Min
+		}
+	}
+}
+
 func (p *dockerProvisioner) Destroy(app provision.App) error {
 	containers, err := p.listContainersByApp(app.GetName())
 	if err != nil {
@@ -545,6 +728,12 @@ func (p *dockerProvisioner) Destroy(app provision.App) error {
 	if err != nil {
 		log.Errorf("Failed to remove image names from storage for app %s: %s", app.GetName(), err.Error())
 	}
+	if cd, err := getCanary(app.GetName()); err == nil {
+		if cd.Built {
+			p.cleanImage(app.GetName(), cd.Image)
+		}
+		removeCanary(app.GetName())
+	}
 	r, err := getRouterForApp(app)
 	if err != nil {
 		log.Errorf("Failed to get router: %s", err.Error())
@@ -587,6 +776,64 @@ func (p *dockerProvisioner) runRestartAfterHooks(cont *container.Container, w io
 	return nil
 }
 
+// runReleaseHooks runs the release hooks once, in a new container created
+// from the image being deployed, before any unit is replaced. A failure in the
+// hooks aborts the deploy, keeping the old units untouched.
+func (p *dockerProvisioner) runReleaseHooks(a provision.App, imageId string, cmds []string, w io.Writer) error {
+	if len(cmds) == 0 {
+		return nil
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	fmt.Fprintf(w, "\n---- Running release hooks ----\n")
+	user, err := config.GetString("docker:user")
+	if err != nil {
+		user, _ = config.GetString("docker:ssh:user")
+	}
+	envs := make([]string, 0, len(a.Envs()))
+	for _, envData := range a.Envs() {
+		envs = append(envs, fmt.Sprintf("%s=%s", envData.Name, envData.Value))
+	}
+	options := docker.CreateContainerOptions{
+		Config: &docker.Config{
+			AttachStdout: true,
+			AttachStderr: true,
+			User:         user,
+			Image:        imageId,
+			Env:          envs,
+			Cmd:          []string{"/bin/bash", "-lc", strings.Join(cmds, " && ")},
+		},
+	}
+	cluster := p.Cluster()
+	_, cont, err := cluster.CreateContainerSchedulerOpts(options, []string{a.GetName(), ""})
+	if err != nil {
+		return err
+	}
+	defer cluster.RemoveContainer(docker.RemoveContainerOptions{ID: cont.ID, Force: true})
+	err = cluster.StartContainer(cont.ID, nil)
+	if err != nil {
+		return err
+	}
+	opts := docker.AttachToContainerOptions{
+		Container:    cont.ID,
+		OutputStream: w,
+		ErrorStream:  w,
+		Logs:         true,
+		Stream:       true,
+		Stdout:       true,
+		Stderr:       true,
+	}
+	status, err := container.SafeAttachWaitContainer(p, opts)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("release hook failed with exit status %d, the deploy was aborted", status)
+	}
+	return nil
+}
+
 func addContainersWithHost(args *changeUnitsPipelineArgs) ([]container.Container, error) {
 	a := args.app
 	w := args.writer
@@ -752,16 +999,28 @@ func (p *dockerProvisioner) SetUnitStatus(unit provision.Unit, status provision.
 	if err != nil {
 		return err
 	}
-	if cont.Status == provision.StatusBuilding.String() {
+	if cont.Status == provision.StatusBuilding.String() || cont.Status == provision.StatusCrashLoop.String() {
 		return nil
 	}
 	if unit.AppName != "" && cont.AppName != unit.AppName {
 		return stderr.New("wrong app name")
 	}
+	previousStatus := provision.Status(cont.Status)
 	err = cont.SetStatus(p, status.String(), true)
 	if err != nil {
 		return err
 	}
+	app.PublishUnitStatusChange(cont.AppName, cont.ID, previousStatus, status)
+	if status == provision.StatusError && previousStatus != provision.StatusError {
+		err = p.registerCrash(cont, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+	}
+	err = p.restoreCrashLoopRoute(cont)
+	if err != nil {
+		return err
+	}
 	return p.checkContainer(cont)
 }
 
@@ -794,6 +1053,86 @@ func (p *dockerProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provisi
 	return nil
 }
 
+// ExecuteCommandInUnits runs the command in the units of the app matching the
+// filter, one unit at a time, prefixing each line of the output with the ID
+// of the unit.
+func (p *dockerProvisioner) ExecuteCommandInUnits(stdout, stderr io.Writer, app provision.App, filter provision.RunFilter, cmd string, args ...string) error {
+	containers, err := p.listRunnableContainersByApp(app.GetName())
+	if err != nil {
+		return err
+	}
+	containers, err = filterContainersToRun(containers, filter)
+	if err != nil {
+		return err
+	}
+	unitsErr := provision.UnitsCommandError{Total: len(containers)}
+	for _, c := range containers {
+		prefix := fmt.Sprintf("[%s] ", c.ShortID())
+		unitStdout := &tsuruIo.PrefixWriter{Writer: stdout, Prefix: prefix}
+		unitStderr := &tsuruIo.PrefixWriter{Writer: stderr, Prefix: prefix}
+		err = c.Exec(p, unitStdout, unitStderr, cmd, args...)
+		if err == nil {
+			continue
+		}
+		failure := provision.UnitCommandFailure{Unit: c.ShortID(), ExitCode: -1, Err: err.Error()}
+		if exitErr, ok := err.(interface {
+			ExitStatus() int
+		}); ok {
+			failure.ExitCode = exitErr.ExitStatus()
+		}
+		unitsErr.Failures = append(unitsErr.Failures, failure)
+	}
+	if len(unitsErr.Failures) > 0 {
+		return &unitsErr
+	}
+	return nil
+}
+
+// filterContainersToRun returns the containers matching the filter. Every
+// unit in the filter must match exactly one container, and the process in the
+// filter must match at least one of them.
+func filterContainersToRun(containers []container.Container, filter provision.RunFilter) ([]container.Container, error) {
+	var result []container.Container
+	if len(filter.Units) > 0 {
+		for _, unit := range filter.Units {
+			var matches []container.Container
+			for _, c := range containers {
+				if strings.HasPrefix(c.ID, unit) {
+					matches = append(matches, c)
+				}
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("unit %q not found", unit)
+			}
+			if len(matches) > 1 {
+				return nil, fmt.Errorf("unit %q matches more than one unit", unit)
+			}
+			result = append(result, matches[0])
+		}
+	} else {
+		result = containers
+	}
+	if filter.Process != "" {
+		var processContainers []container.Container
+		for _, c := range result {
+			if c.ProcessName == filter.Process {
+				processContainers = append(processContainers, c)
+			}
+		}
+		if len(result) > 0 && len(processContainers) == 0 {
+			return nil, provision.InvalidProcessError{Msg: fmt.Sprintf("no units found for process %q", filter.Process)}
+		}
+		result = processContainers
+	}
+	if len(result) == 0 {
+		return nil, provision.ErrEmptyApp
+	}
+	if filter.Once {
+		result = result[:1]
+	}
+	return result, nil
+}
+
 func (p *dockerProvisioner) SetCName(app provision.App, cname string) error {
 	r, err := getRouterForApp(app)
 	if err != nil {
@@ -821,14 +1160,28 @@ func (p *dockerProvisioner) AdminCommands() []cmd.Command {
 		fixContainersCmd{},
 		&healer.ListHealingHistoryCmd{},
 		&autoScaleRunCmd{},
+		&autoScaleSimulateCmd{},
+		&imageGCReportCmd{},
+		&reconcileReportCmd{},
+		&poolQuotaSetCmd{},
+		&poolQuotaRemoveCmd{},
+		&poolUsageCmd{},
 		&listAutoScaleHistoryCmd{},
 		&autoScaleInfoCmd{},
 		&autoScaleSetRuleCmd{},
 		&autoScaleDeleteRuleCmd{},
 		&updateNodeToSchedulerCmd{},
+		cordonNodeCmd{},
+		uncordonNodeCmd{},
+		&drainNodeCmd{},
 		&bs.EnvSetCmd{},
 		&bs.InfoCmd{},
 		&bs.UpgradeCmd{},
+		&canaryPromoteCmd{},
+		&canaryAbortCmd{},
+		&unitsMetricsCmd{},
+		&jobListCmd{},
+		&jobRunsCmd{},
 	}
 }
 
@@ -858,23 +1211,44 @@ func (p *dockerProvisioner) PlatformAdd(name string, args map[string]string, w i
 		InputStream:    nil,
 		OutputStream:   w,
 	}
-	err := cluster.BuildImage(buildOptions)
+	slot, err := p.acquireBuildNode(w)
+	if err != nil {
+		return err
+	}
+	if slot != nil {
+		defer slot.release()
+		err = p.buildImageInNode(slot.Node, buildOptions)
+		if err != nil {
+			return err
+		}
+		client, err := docker.NewClient(slot.Node)
+		if err != nil {
+			return err
+		}
+		return pushPlatformToPoolRegistries(client, name, w)
+	}
+	err = cluster.BuildImage(buildOptions)
+	if err != nil {
+		return err
+	}
+	err = p.PushImage(splitImageName(imageName))
 	if err != nil {
 		return err
 	}
+	return pushPlatformToPoolRegistries(cluster, name, w)
+}
+
+// splitImageName returns the repository and the tag of the given image
+// name, the tag defaults to latest.
+func splitImageName(imageName string) (string, string) {
 	parts := strings.Split(imageName, ":")
-	var tag string
 	if len(parts) > 2 {
-		imageName = strings.Join(parts[:len(parts)-1], ":")
-		tag = parts[len(parts)-1]
-	} else if len(parts) > 1 {
-		imageName = parts[0]
-		tag = parts[1]
-	} else {
-		imageName = parts[0]
-		tag = "latest"
+		return strings.Join(parts[:len(parts)-1], ":"), parts[len(parts)-1]
+	}
+	if len(parts) > 1 {
+		return parts[0], parts[1]
 	}
-	return p.PushImage(imageName, tag)
+	return parts[0], "latest"
 }
 
 func (p *dockerProvisioner) PlatformUpdate(name string, args map[string]string, w io.Writer) error {
@@ -939,6 +1313,10 @@ func (p *dockerProvisioner) RegisterUnit(unit provision.Unit, customData map[str
 	if err != nil {
 		return err
 	}
+	err = p.restoreCrashLoopRoute(cont)
+	if err != nil {
+		return err
+	}
 	return p.checkContainer(cont)
 }
 
@@ -154,12 +154,6 @@ func (s *S) TestProvisionerRestartStoppedContainer(c *check.C) {
 	c.Assert(dbConts[0].HostPort, check.Equals, expectedPort)
 }
 
-type containerByProcessList []container.Container
-
-func (l containerByProcessList) Len() int           { return len(l) }
-func (l containerByProcessList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
-func (l containerByProcessList) Less(i, j int) bool { return l[i].ProcessName < l[j].ProcessName }
-
 func (s *S) TestProvisionerRestartProcess(c *check.C) {
 	app := provisiontest.NewFakeApp("almah", "static", 1)
 	customData := map[string]interface{}{
@@ -286,6 +280,145 @@ func (s *S) TestDeploy(c *check.C) {
 	c.Assert(app.Quota, check.DeepEquals, quota.Quota{Limit: 10, InUse: 1})
 }
 
+func (s *S) TestDeployWithUnitsSpec(c *check.C) {
+	stopCh := s.stopContainers(s.server.URL(), 3)
+	defer func() { <-stopCh }()
+	err := s.newFakeImage(s.p, "tsuru/python:latest", nil)
+	c.Assert(err, check.IsNil)
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "python",
+		Quota:    quota.Unlimited,
+	}
+	err = s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	repository.Manager().CreateRepository(a.Name, nil)
+	s.p.Provision(&a)
+	defer s.p.Destroy(&a)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web":    "python myapp.py",
+			"worker": "python worker.py",
+		},
+		"units": map[string]interface{}{
+			"web":    map[string]interface{}{"count": 2},
+			"worker": map[string]interface{}{"min": 1},
+			"cron":   map[string]interface{}{"count": 3},
+		},
+	}
+	err = saveImageCustomData("tsuru/app-"+a.Name+":v1", customData)
+	c.Assert(err, check.IsNil)
+	err = app.Deploy(app.DeployOptions{
+		App:          &a,
+		Version:      "master",
+		Commit:       "123",
+		OutputStream: ioutil.Discard,
+	})
+	c.Assert(err, check.IsNil)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	counts := map[string]int{}
+	for _, cont := range containers {
+		counts[cont.ProcessName]++
+	}
+	c.Assert(counts, check.DeepEquals, map[string]int{"web": 2, "worker": 1})
+}
+
+func (s *S) TestDeploySyncsYamlJobs(c *check.C) {
+	stopCh := s.stopContainers(s.server.URL(), 1)
+	defer func() { <-stopCh }()
+	err := s.newFakeImage(s.p, "tsuru/python:latest", nil)
+	c.Assert(err, check.IsNil)
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "python",
+		Quota:    quota.Unlimited,
+	}
+	err = s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	repository.Manager().CreateRepository(a.Name, nil)
+	s.p.Provision(&a)
+	defer s.p.Destroy(&a)
+	defer s.storage.Jobs().RemoveAll(nil)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+		"jobs": map[string]interface{}{
+			"cleanup": map[string]interface{}{
+				"schedule": "*/5 * * * *",
+				"command":  "python cleanup.py",
+			},
+		},
+	}
+	err = saveImageCustomData("tsuru/app-"+a.Name+":v1", customData)
+	c.Assert(err, check.IsNil)
+	err = app.Deploy(app.DeployOptions{
+		App:          &a,
+		Version:      "master",
+		Commit:       "123",
+		OutputStream: ioutil.Discard,
+	})
+	c.Assert(err, check.IsNil)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 1)
+	c.Assert(jobs[0].Name, check.Equals, "cleanup")
+	c.Assert(jobs[0].Schedule, check.Equals, "*/5 * * * *")
+	c.Assert(jobs[0].Command, check.Equals, "python cleanup.py")
+	c.Assert(jobs[0].FromYaml, check.Equals, true)
+}
+
+func (s *S) TestDeployInvalidYamlJobsCreatesNoUnits(c *check.C) {
+	a := app.App{Name: "otherapp", Platform: "python", Quota: quota.Unlimited}
+	err := s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	defer s.storage.Jobs().RemoveAll(nil)
+	imageId := "tsuru/app-" + a.Name + ":v1"
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+		"jobs": map[string]interface{}{
+			"cleanup": map[string]interface{}{
+				"schedule": "every five minutes",
+				"command":  "python cleanup.py",
+			},
+		},
+	}
+	err = saveImageCustomData(imageId, customData)
+	c.Assert(err, check.IsNil)
+	err = s.p.deploy(&a, imageId, ioutil.Discard)
+	c.Assert(err, check.ErrorMatches, `invalid job "cleanup" in tsuru.yaml: .*`)
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+	jobs, err := a.Jobs()
+	c.Assert(err, check.IsNil)
+	c.Assert(jobs, check.HasLen, 0)
+}
+
+func (s *S) TestApplyUnitsSpec(c *check.C) {
+	toAdd := map[string]*containersToAdd{
+		"web":    {Quantity: 1},
+		"worker": {Quantity: 5},
+		"api":    {Quantity: 1},
+		"other":  {Quantity: 2},
+	}
+	applyUnitsSpec(toAdd, map[string]provision.TsuruYamlUnits{
+		"web":     {Count: 3},
+		"worker":  {Max: 2},
+		"api":     {Min: 2, Max: 4},
+		"missing": {Count: 10},
+	})
+	c.Assert(toAdd, check.HasLen, 4)
+	c.Assert(toAdd["web"].Quantity, check.Equals, 3)
+	c.Assert(toAdd["worker"].Quantity, check.Equals, 2)
+	c.Assert(toAdd["api"].Quantity, check.Equals, 2)
+	c.Assert(toAdd["other"].Quantity, check.Equals, 2)
+}
+
 func (s *S) TestDeployQuotaExceeded(c *check.C) {
 	stopCh := s.stopContainers(s.server.URL(), 1)
 	defer func() { <-stopCh }()
@@ -588,6 +721,95 @@ func (s *S) TestImageDeploy(c *check.C) {
 	c.Assert(units, check.HasLen, 1)
 }
 
+func (s *S) TestImageDeployRunsReleaseHooks(c *check.C) {
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+		"hooks": map[string]interface{}{
+			"release": []string{"python manage.py migrate", "python manage.py clear_cache"},
+		},
+	}
+	err := s.newFakeImage(s.p, "tsuru/app-otherapp:v1", customData)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("otherapp", "tsuru/app-otherapp:v1")
+	c.Assert(err, check.IsNil)
+	var cmds [][]string
+	s.server.CustomHandler("/containers/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewBuffer(data))
+		var result docker.Config
+		json.Unmarshal(data, &result)
+		cmds = append(cmds, result.Cmd)
+		s.server.DefaultHandler().ServeHTTP(w, r)
+	}))
+	defer s.server.CustomHandler("/containers/create", s.server.DefaultHandler())
+	a := app.App{
+		Name:     "otherapp",
+		Platform: "python",
+		Quota:    quota.Unlimited,
+	}
+	err = s.storage.Apps().Insert(a)
+	c.Assert(err, check.IsNil)
+	s.p.Provision(&a)
+	defer s.p.Destroy(&a)
+	w := safe.NewBuffer(make([]byte, 2048))
+	err = app.Deploy(app.DeployOptions{
+		App:          &a,
+		OutputStream: w,
+		Image:        "tsuru/app-otherapp:v1",
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(cmds, check.HasLen, 2)
+	c.Assert(cmds[0], check.DeepEquals, []string{"/bin/bash", "-lc", "python manage.py migrate && python manage.py clear_cache"})
+	units, err := a.Units()
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	var deploy app.DeployData
+	err = s.storage.Deploys().Find(bson.M{"app": a.Name}).One(&deploy)
+	c.Assert(err, check.IsNil)
+	c.Assert(deploy.Log, check.Matches, "(?s).*---- Running release hooks ----.*")
+}
+
+func (s *S) TestImageDeployReleaseHookFailureKeepsOldUnits(c *check.C) {
+	a := s.setUpCanaryApp(c)
+	defer s.p.Destroy(a)
+	customData := map[string]interface{}{
+		"processes": map[string]interface{}{
+			"web": "python myapp.py",
+		},
+		"hooks": map[string]interface{}{
+			"release": []string{"python manage.py migrate"},
+		},
+	}
+	err := s.newFakeImage(s.p, "tsuru/app-otherapp:v3", customData)
+	c.Assert(err, check.IsNil)
+	err = appendAppImageName("otherapp", "tsuru/app-otherapp:v3")
+	c.Assert(err, check.IsNil)
+	s.server.CustomHandler("/containers/.*/wait", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"StatusCode":1}`))
+	}))
+	defer s.server.CustomHandler("/containers/.*/wait", s.server.DefaultHandler())
+	w := safe.NewBuffer(make([]byte, 2048))
+	err = app.Deploy(app.DeployOptions{
+		App:          a,
+		OutputStream: w,
+		Image:        "tsuru/app-otherapp:v3",
+	})
+	c.Assert(err, check.ErrorMatches, "release hook failed with exit status 1, the deploy was aborted")
+	containers, err := s.p.listContainersByApp(a.Name)
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 3)
+	for _, cont := range containers {
+		c.Assert(cont.Image, check.Equals, "tsuru/app-otherapp:v1")
+	}
+	var deploy app.DeployData
+	err = s.storage.Deploys().Find(bson.M{"app": a.Name}).Sort("-timestamp").One(&deploy)
+	c.Assert(err, check.IsNil)
+	c.Assert(deploy.Log, check.Matches, "(?s).*---- Running release hooks ----.*")
+	c.Assert(deploy.Error, check.Not(check.Equals), "")
+}
+
 func (s *S) TestImageDeployInvalidImage(c *check.C) {
 	a := app.App{
 		Name:     "otherapp",
@@ -1203,6 +1425,29 @@ func (s *S) TestProvisionerSetUnitStatus(c *check.C) {
 	c.Assert(container.Status, check.Equals, provision.StatusError.String())
 }
 
+func (s *S) TestProvisionerSetUnitStatusPublishesUnitEvent(c *check.C) {
+	err := s.newFakeImage(s.p, "tsuru/python:latest", nil)
+	c.Assert(err, check.IsNil)
+	opts := newContainerOpts{Status: provision.StatusStarted.String(), AppName: "someapp"}
+	container, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(container)
+	l, err := app.NewUnitEventListener(&app.App{Name: "someapp"})
+	c.Assert(err, check.IsNil)
+	defer l.Close()
+	err = s.p.SetUnitStatus(provision.Unit{ID: container.ID, AppName: container.AppName}, provision.StatusError)
+	c.Assert(err, check.IsNil)
+	select {
+	case evt := <-l.C:
+		c.Assert(evt.Unit, check.Equals, container.ID)
+		c.Assert(evt.Kind, check.Equals, app.UnitEventCrashed)
+		c.Assert(evt.PreviousStatus, check.Equals, provision.StatusStarted.String())
+		c.Assert(evt.Status, check.Equals, provision.StatusError.String())
+	case <-time.After(5 * time.Second):
+		c.Fatal("timed out waiting for unit event")
+	}
+}
+
 func (s *S) TestProvisionerSetUnitStatusUpdatesIp(c *check.C) {
 	err := s.storage.Apps().Insert(&app.App{Name: "myawesomeapp"})
 	c.Assert(err, check.IsNil)
@@ -1360,6 +1605,66 @@ func (s *S) TestProvisionerExecuteCommandOnceNoContainers(c *check.C) {
 	c.Assert(err, check.Equals, provision.ErrEmptyApp)
 }
 
+func (s *S) TestProvisionerExecuteCommandInUnits(c *check.C) {
+	app := provisiontest.NewFakeApp("starbreaker", "python", 1)
+	container1, err := s.newContainer(&newContainerOpts{AppName: app.GetName()}, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(container1)
+	container2, err := s.newContainer(&newContainerOpts{AppName: app.GetName()}, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(container2)
+	var stdout, stderr bytes.Buffer
+	filter := provision.RunFilter{Units: []string{container2.ShortID()}}
+	err = s.p.ExecuteCommandInUnits(&stdout, &stderr, app, filter, "ls", "-l")
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestProvisionerExecuteCommandInUnitsNotFound(c *check.C) {
+	app := provisiontest.NewFakeApp("starbreaker", "python", 1)
+	container, err := s.newContainer(&newContainerOpts{AppName: app.GetName()}, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(container)
+	var buf bytes.Buffer
+	filter := provision.RunFilter{Units: []string{"abc123"}}
+	err = s.p.ExecuteCommandInUnits(&buf, &buf, app, filter, "ls", "-l")
+	c.Assert(err, check.ErrorMatches, `unit "abc123" not found`)
+}
+
+func (s *S) TestProvisionerExecuteCommandInUnitsNoContainers(c *check.C) {
+	app := provisiontest.NewFakeApp("almah", "static", 2)
+	var buf bytes.Buffer
+	err := s.p.ExecuteCommandInUnits(&buf, &buf, app, provision.RunFilter{Process: "web"}, "ls", "-lh")
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+}
+
+func (s *S) TestFilterContainersToRun(c *check.C) {
+	containers := []container.Container{
+		{ID: "abc111", ProcessName: "web"},
+		{ID: "abc222", ProcessName: "web"},
+		{ID: "def333", ProcessName: "worker"},
+	}
+	result, err := filterContainersToRun(containers, provision.RunFilter{})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, containers)
+	result, err = filterContainersToRun(containers, provision.RunFilter{Units: []string{"def", "abc2"}})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, []container.Container{containers[2], containers[1]})
+	result, err = filterContainersToRun(containers, provision.RunFilter{Process: "web"})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, containers[:2])
+	result, err = filterContainersToRun(containers, provision.RunFilter{Process: "web", Once: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(result, check.DeepEquals, containers[:1])
+	_, err = filterContainersToRun(containers, provision.RunFilter{Units: []string{"def333"}, Process: "web"})
+	c.Assert(err, check.Equals, provision.InvalidProcessError{Msg: `no units found for process "web"`})
+	_, err = filterContainersToRun(containers, provision.RunFilter{Process: "cron"})
+	c.Assert(err, check.Equals, provision.InvalidProcessError{Msg: `no units found for process "cron"`})
+	_, err = filterContainersToRun(nil, provision.RunFilter{Process: "web"})
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+	_, err = filterContainersToRun(containers, provision.RunFilter{Units: []string{"abc"}})
+	c.Assert(err, check.ErrorMatches, `unit "abc" matches more than one unit`)
+}
+
 func (s *S) TestProvisionCollection(c *check.C) {
 	collection := s.p.Collection()
 	defer collection.Close()
@@ -1409,14 +1714,28 @@ func (s *S) TestAdminCommands(c *check.C) {
 		fixContainersCmd{},
 		&healer.ListHealingHistoryCmd{},
 		&autoScaleRunCmd{},
+		&autoScaleSimulateCmd{},
+		&imageGCReportCmd{},
+		&reconcileReportCmd{},
+		&poolQuotaSetCmd{},
+		&poolQuotaRemoveCmd{},
+		&poolUsageCmd{},
 		&listAutoScaleHistoryCmd{},
 		&autoScaleInfoCmd{},
 		&autoScaleSetRuleCmd{},
 		&autoScaleDeleteRuleCmd{},
 		&updateNodeToSchedulerCmd{},
+		cordonNodeCmd{},
+		uncordonNodeCmd{},
+		&drainNodeCmd{},
 		&bs.EnvSetCmd{},
 		&bs.InfoCmd{},
 		&bs.UpgradeCmd{},
+		&canaryPromoteCmd{},
+		&canaryAbortCmd{},
+		&unitsMetricsCmd{},
+		&jobListCmd{},
+		&jobRunsCmd{},
 	}
 	c.Assert(s.p.AdminCommands(), check.DeepEquals, expected)
 }
@@ -0,0 +1,395 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	driftOrphan  = "orphan container"
+	driftMissing = "missing container"
+	driftNode    = "node drift"
+	driftName    = "id drift"
+	driftImage   = "image drift"
+	driftStatus  = "status drift"
+
+	reconcileRemove     = "remove"
+	reconcileRecreate   = "recreate"
+	reconcileReregister = "re-register"
+)
+
+// reconcileEntry is a difference between the containers collection and the
+// containers running in the docker nodes. Action is the fix applied, or that
+// would be applied in a dry run, and is empty for differences that are only
+// reported.
+type reconcileEntry struct {
+	Node      string
+	Container string
+	App       string
+	Drift     string
+	Detail    string
+	Action    string
+	Error     string
+}
+
+type reconcileReport struct {
+	DryRun  bool
+	Entries []reconcileEntry
+	Errors  []string
+}
+
+// reconciler periodically compares the containers in the database with the
+// containers in the docker nodes, fixing the differences that are safe to fix.
+// Only the API server holding the reconciler lease runs it.
+type reconciler struct {
+	provisioner *dockerProvisioner
+	minAge      time.Duration
+	interval    time.Duration
+	owner       string
+	done        chan bool
+}
+
+const reconcilerLeaseID = "reconciler"
+
+// reconcileMinAge returns how old a container unknown to the database must be
+// to be considered an orphan, so containers being created are preserved.
+func reconcileMinAge() time.Duration {
+	minAge, err := config.GetInt("docker:reconciler:min-age")
+	if err != nil {
+		minAge = 600
+	}
+	return time.Duration(minAge) * time.Second
+}
+
+func (p *dockerProvisioner) initReconciler() *reconciler {
+	interval, _ := config.GetInt("docker:reconciler:interval")
+	if interval <= 0 {
+		return nil
+	}
+	return &reconciler{
+		provisioner: p,
+		minAge:      reconcileMinAge(),
+		interval:    time.Duration(interval) * time.Second,
+		owner:       randomString(),
+		done:        make(chan bool),
+	}
+}
+
+func (r *reconciler) run() {
+	for {
+		r.runOnce()
+		select {
+		case <-r.done:
+			return
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+func (r *reconciler) runOnce() {
+	acquired, err := acquireLease(reconcilerLeaseID, r.owner, 2*r.interval)
+	if err != nil {
+		log.Errorf("[reconciler] unable to acquire the reconciler lease: %s", err)
+		return
+	}
+	if !acquired {
+		return
+	}
+	report, err := r.provisioner.reconcile(r.minAge, false)
+	if err != nil {
+		log.Errorf("[reconciler] unable to reconcile containers: %s", err)
+		return
+	}
+	for _, entry := range report.Entries {
+		if entry.Action == "" || entry.Error != "" {
+			log.Errorf("[reconciler] %s %s in %s: %s %s", entry.Drift, entry.Container, entry.Node, entry.Detail, entry.Error)
+		}
+	}
+	log.Debugf("[reconciler] %d differences found, %d errors", len(report.Entries), len(report.Errors))
+}
+
+func (r *reconciler) Shutdown() {
+	r.done <- true
+}
+
+func (r *reconciler) String() string {
+	return "containers reconciler"
+}
+
+type nodeContainers struct {
+	address    string
+	client     *docker.Client
+	containers []docker.APIContainers
+}
+
+func apiContainerName(c *docker.APIContainers) string {
+	for _, name := range c.Names {
+		name = strings.TrimPrefix(name, "/")
+		if !strings.Contains(name, "/") {
+			return name
+		}
+	}
+	return ""
+}
+
+// isAppContainer reports whether the container was created by tsuru to run an
+// app, based on the environment set in every app container.
+func isAppContainer(c *docker.Container) bool {
+	if c.Config == nil {
+		return false
+	}
+	for _, env := range c.Config.Env {
+		if strings.HasPrefix(env, "TSURU_HOST=") {
+			return true
+		}
+	}
+	return false
+}
+
+// reconcile compares the containers in the database with the containers in
+// every docker node. Orphan app containers are removed, containers found
+// with a different id or in a different node are re-registered and missing
+// containers are recreated, while image and status differences are only
+// reported. In dry run mode nothing is changed, the report lists the
+// differences and the actions that would be taken.
+func (p *dockerProvisioner) reconcile(minAge time.Duration, dryRun bool) (*reconcileReport, error) {
+	containers, err := p.listAllContainers()
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := p.Cluster().UnfilteredNodes()
+	if err != nil {
+		return nil, err
+	}
+	report := reconcileReport{DryRun: dryRun}
+	var listed []nodeContainers
+	failedHosts := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		client, err := docker.NewClient(node.Address)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", node.Address, err))
+			failedHosts[urlToHost(node.Address)] = true
+			continue
+		}
+		apiContainers, err := client.ListContainers(docker.ListContainersOptions{All: true})
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("unable to list containers in %s: %s", node.Address, err))
+			failedHosts[urlToHost(node.Address)] = true
+			continue
+		}
+		for _, c := range apiContainers {
+			seen[c.ID] = true
+		}
+		listed = append(listed, nodeContainers{address: node.Address, client: client, containers: apiContainers})
+	}
+	byID := make(map[string]*container.Container, len(containers))
+	byName := make(map[string]*container.Container, len(containers))
+	for i := range containers {
+		cont := &containers[i]
+		if cont.ID != "" {
+			byID[cont.ID] = cont
+		}
+		if cont.Name != "" {
+			byName[cont.Name] = cont
+		}
+	}
+	reregistered := make(map[*container.Container]bool)
+	for _, node := range listed {
+		host := urlToHost(node.address)
+		for i := range node.containers {
+			apiCont := &node.containers[i]
+			if cont, ok := byID[apiCont.ID]; ok {
+				if cont.HostAddr != host {
+					entry := reconcileEntry{
+						Drift:  driftNode,
+						Detail: fmt.Sprintf("registered in %s", cont.HostAddr),
+						Action: reconcileReregister,
+					}
+					report.Entries = append(report.Entries, p.reregisterContainer(cont, apiCont.ID, host, node.address, entry, dryRun))
+				}
+				report.Entries = append(report.Entries, runningDrifts(node.address, cont, apiCont)...)
+				continue
+			}
+			name := apiContainerName(apiCont)
+			if cont, ok := byName[name]; ok && name != "" && !seen[cont.ID] && !reregistered[cont] {
+				reregistered[cont] = true
+				entry := reconcileEntry{
+					Drift:  driftName,
+					Detail: fmt.Sprintf("registered with id %q", cont.ID),
+					Action: reconcileReregister,
+				}
+				report.Entries = append(report.Entries, p.reregisterContainer(cont, apiCont.ID, host, node.address, entry, dryRun))
+				continue
+			}
+			if time.Since(time.Unix(apiCont.Created, 0)) < minAge {
+				continue
+			}
+			dockerContainer, err := node.client.InspectContainer(apiCont.ID)
+			if err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("unable to inspect container %s in %s: %s", apiCont.ID, node.address, err))
+				continue
+			}
+			if !isAppContainer(dockerContainer) {
+				continue
+			}
+			entry := reconcileEntry{
+				Node:      node.address,
+				Container: apiCont.ID,
+				Drift:     driftOrphan,
+				Detail:    "not found in the database",
+				Action:    reconcileRemove,
+			}
+			if !dryRun {
+				err = node.client.RemoveContainer(docker.RemoveContainerOptions{ID: apiCont.ID, Force: true})
+				if err != nil {
+					entry.Error = err.Error()
+				}
+			}
+			report.Entries = append(report.Entries, entry)
+		}
+	}
+	for i := range containers {
+		cont := &containers[i]
+		if seen[cont.ID] || reregistered[cont] || failedHosts[cont.HostAddr] {
+			continue
+		}
+		if cont.Status == provision.StatusCreated.String() || cont.Status == provision.StatusBuilding.String() {
+			continue
+		}
+		entry := reconcileEntry{
+			Node:      cont.HostAddr,
+			Container: cont.ID,
+			App:       cont.AppName,
+			Drift:     driftMissing,
+			Detail:    "not found in any node",
+			Action:    reconcileRecreate,
+		}
+		if !dryRun {
+			recreated, err := p.recreateMissingContainer(cont)
+			if err != nil {
+				entry.Error = err.Error()
+			} else if !recreated {
+				continue
+			}
+		}
+		report.Entries = append(report.Entries, entry)
+	}
+	return &report, nil
+}
+
+// recreateMissingContainer recreates a container missing from the docker
+// nodes. The container is read again after locking the app, as it may have
+// been removed after the containers were listed, in which case nothing is
+// recreated.
+func (p *dockerProvisioner) recreateMissingContainer(cont *container.Container) (bool, error) {
+	locker := &appLocker{}
+	if !locker.Lock(cont.AppName) {
+		return false, fmt.Errorf("unable to lock %q", cont.AppName)
+	}
+	defer locker.Unlock(cont.AppName)
+	current, err := p.GetContainer(cont.ID)
+	if err == provision.ErrUnitNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	errors := make(chan error, 1)
+	created := p.MoveOneContainer(*current, "", errors, nil, ioutil.Discard, locker)
+	close(errors)
+	p.publishUnitMoved(*current, created)
+	return true, <-errors
+}
+
+// reregisterContainer points the container record to the given docker
+// container, updating its id, host and network info, and its routes when the
+// container is available.
+func (p *dockerProvisioner) reregisterContainer(cont *container.Container, id, host, address string, entry reconcileEntry, dryRun bool) reconcileEntry {
+	entry.Node = address
+	entry.Container = id
+	entry.App = cont.AppName
+	if dryRun {
+		return entry
+	}
+	if cont.Available() {
+		err := p.RemoveContainerRoute(*cont)
+		if err != nil {
+			log.Errorf("[reconciler] unable to remove route of container %s: %s", cont.ID, err)
+		}
+	}
+	query := bson.M{"id": cont.ID}
+	if cont.Name != "" {
+		query = bson.M{"name": cont.Name}
+	}
+	cont.ID = id
+	cont.HostAddr = host
+	coll := p.Collection()
+	defer coll.Close()
+	err := coll.Update(query, bson.M{"$set": bson.M{"id": cont.ID, "hostaddr": cont.HostAddr}})
+	if err != nil {
+		entry.Error = err.Error()
+		return entry
+	}
+	if cont.Available() {
+		info, err := cont.NetworkInfo(p)
+		if err == nil {
+			err = p.fixContainer(cont, info)
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		}
+	}
+	return entry
+}
+
+// runningDrifts returns the differences between the image and the status of
+// the container in the database and in the docker node. They're only
+// reported, as fixing them requires knowing why they happened.
+func runningDrifts(address string, cont *container.Container, apiCont *docker.APIContainers) []reconcileEntry {
+	var entries []reconcileEntry
+	if cont.Image != "" && apiCont.Image != cont.Image {
+		entries = append(entries, reconcileEntry{
+			Node:      address,
+			Container: cont.ID,
+			App:       cont.AppName,
+			Drift:     driftImage,
+			Detail:    fmt.Sprintf("running image %s, expected %s", apiCont.Image, cont.Image),
+		})
+	}
+	running := strings.HasPrefix(apiCont.Status, "Up")
+	var detail string
+	switch provision.Status(cont.Status) {
+	case provision.StatusStarted:
+		if !running {
+			detail = fmt.Sprintf("not running in the node, status is %q", cont.Status)
+		}
+	case provision.StatusStopped, provision.StatusCrashLoop:
+		if running {
+			detail = fmt.Sprintf("running in the node, status is %q", cont.Status)
+		}
+	}
+	if detail != "" {
+		entries = append(entries, reconcileEntry{
+			Node:      address,
+			Container: cont.ID,
+			App:       cont.AppName,
+			Drift:     driftStatus,
+			Detail:    detail,
+		})
+	}
+	return entries
+}
@@ -0,0 +1,220 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/tsuru/app"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) createUnregisteredContainer(c *check.C, env []string) string {
+	err := s.newFakeImage(s.p, "tsuru/python:latest", nil)
+	c.Assert(err, check.IsNil)
+	opts := docker.CreateContainerOptions{
+		Name:   randomString(),
+		Config: &docker.Config{Image: "tsuru/python:latest", Env: env},
+	}
+	_, cont, err := s.p.Cluster().CreateContainer(opts)
+	c.Assert(err, check.IsNil)
+	return cont.ID
+}
+
+func findReconcileEntry(report *reconcileReport, id, drift string) *reconcileEntry {
+	for i, entry := range report.Entries {
+		if entry.Container == id && entry.Drift == drift {
+			return &report.Entries[i]
+		}
+	}
+	return nil
+}
+
+func (s *S) TestReconcileRemovesOrphanContainers(c *check.C) {
+	orphan := s.createUnregisteredContainer(c, []string{"TSURU_HOST=tsuru.io"})
+	other := s.createUnregisteredContainer(c, nil)
+	report, err := s.p.reconcile(0, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DryRun, check.Equals, false)
+	c.Assert(report.Errors, check.HasLen, 0)
+	c.Assert(report.Entries, check.DeepEquals, []reconcileEntry{{
+		Node:      s.server.URL(),
+		Container: orphan,
+		Drift:     driftOrphan,
+		Detail:    "not found in the database",
+		Action:    reconcileRemove,
+	}})
+	_, err = s.p.Cluster().InspectContainer(orphan)
+	c.Assert(err, check.NotNil)
+	_, err = s.p.Cluster().InspectContainer(other)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReconcileDryRun(c *check.C) {
+	orphan := s.createUnregisteredContainer(c, []string{"TSURU_HOST=tsuru.io"})
+	report, err := s.p.reconcile(0, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.DryRun, check.Equals, true)
+	entry := findReconcileEntry(report, orphan, driftOrphan)
+	c.Assert(entry, check.NotNil)
+	c.Assert(entry.Action, check.Equals, reconcileRemove)
+	_, err = s.p.Cluster().InspectContainer(orphan)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReconcileKeepsRecentContainers(c *check.C) {
+	orphan := s.createUnregisteredContainer(c, []string{"TSURU_HOST=tsuru.io"})
+	report, err := s.p.reconcile(time.Hour, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Entries, check.HasLen, 0)
+	_, err = s.p.Cluster().InspectContainer(orphan)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReconcileReregistersContainerByName(c *check.C) {
+	cont, err := s.newContainer(nil, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(cont)
+	coll := s.p.Collection()
+	defer coll.Close()
+	err = coll.Update(bson.M{"id": cont.ID}, bson.M{"$set": bson.M{"id": "old-id"}})
+	c.Assert(err, check.IsNil)
+	report, err := s.p.reconcile(0, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Entries, check.DeepEquals, []reconcileEntry{{
+		Node:      s.server.URL(),
+		Container: cont.ID,
+		App:       cont.AppName,
+		Drift:     driftName,
+		Detail:    `registered with id "old-id"`,
+		Action:    reconcileReregister,
+	}})
+	dbCont, err := s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbCont.Name, check.Equals, cont.Name)
+	n, err := coll.Find(bson.M{"id": "old-id"}).Count()
+	c.Assert(err, check.IsNil)
+	c.Assert(n, check.Equals, 0)
+}
+
+func (s *S) TestReconcileReregistersContainerInOtherNode(c *check.C) {
+	cont, err := s.newContainer(nil, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(cont)
+	coll := s.p.Collection()
+	defer coll.Close()
+	err = coll.Update(bson.M{"id": cont.ID}, bson.M{"$set": bson.M{"hostaddr": "10.0.0.9"}})
+	c.Assert(err, check.IsNil)
+	report, err := s.p.reconcile(0, false)
+	c.Assert(err, check.IsNil)
+	entry := findReconcileEntry(report, cont.ID, driftNode)
+	c.Assert(entry, check.NotNil)
+	c.Assert(entry.Detail, check.Equals, "registered in 10.0.0.9")
+	c.Assert(entry.Action, check.Equals, reconcileReregister)
+	c.Assert(entry.Error, check.Equals, "")
+	dbCont, err := s.p.GetContainer(cont.ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(dbCont.HostAddr, check.Equals, "127.0.0.1")
+}
+
+func (s *S) TestReconcileReportsImageAndStatusDrift(c *check.C) {
+	opts := newContainerOpts{Status: provision.StatusStarted.String(), AppName: "myapp"}
+	cont, err := s.newContainer(&opts, nil)
+	c.Assert(err, check.IsNil)
+	defer s.removeTestContainer(cont)
+	coll := s.p.Collection()
+	defer coll.Close()
+	err = coll.Update(bson.M{"id": cont.ID}, bson.M{"$set": bson.M{"image": "tsuru/app-myapp:v2"}})
+	c.Assert(err, check.IsNil)
+	report, err := s.p.reconcile(0, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Entries, check.DeepEquals, []reconcileEntry{
+		{
+			Node:      s.server.URL(),
+			Container: cont.ID,
+			App:       "myapp",
+			Drift:     driftImage,
+			Detail:    "running image tsuru/python:latest, expected tsuru/app-myapp:v2",
+		},
+		{
+			Node:      s.server.URL(),
+			Container: cont.ID,
+			App:       "myapp",
+			Drift:     driftStatus,
+			Detail:    `not running in the node, status is "started"`,
+		},
+	})
+}
+
+func (s *S) TestReconcileRecreatesMissingContainers(c *check.C) {
+	err := s.newFakeImage(s.p, "tsuru/app-myapp", nil)
+	c.Assert(err, check.IsNil)
+	appInstance := provisiontest.NewFakeApp("myapp", "python", 0)
+	defer s.p.Destroy(appInstance)
+	s.p.Provision(appInstance)
+	err = s.storage.Apps().Insert(&app.App{Name: appInstance.GetName()})
+	c.Assert(err, check.IsNil)
+	imageId, err := appCurrentImageName(appInstance.GetName())
+	c.Assert(err, check.IsNil)
+	conts, err := addContainersWithHost(&changeUnitsPipelineArgs{
+		toHost:      "127.0.0.1",
+		toAdd:       map[string]*containersToAdd{"web": {Quantity: 1}},
+		app:         appInstance,
+		imageId:     imageId,
+		provisioner: s.p,
+	})
+	c.Assert(err, check.IsNil)
+	c.Assert(conts, check.HasLen, 1)
+	err = s.p.Cluster().RemoveContainer(docker.RemoveContainerOptions{ID: conts[0].ID, Force: true})
+	c.Assert(err, check.IsNil)
+	report, err := s.p.reconcile(0, false)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Entries, check.DeepEquals, []reconcileEntry{{
+		Node:      "127.0.0.1",
+		Container: conts[0].ID,
+		App:       "myapp",
+		Drift:     driftMissing,
+		Detail:    "not found in any node",
+		Action:    reconcileRecreate,
+	}})
+	containers, err := s.p.listContainersByApp("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 1)
+	c.Assert(containers[0].ID, check.Not(check.Equals), conts[0].ID)
+	_, err = s.p.Cluster().InspectContainer(containers[0].ID)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestReconcileIgnoresContainersInUnreachableNodes(c *check.C) {
+	coll := s.p.Collection()
+	defer coll.Close()
+	err := coll.Insert(bson.M{"id": "missing", "appname": "myapp", "hostaddr": "127.0.0.1", "status": provision.StatusStarted.String()})
+	c.Assert(err, check.IsNil)
+	s.server.Stop()
+	report, err := s.p.reconcile(0, true)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Entries, check.HasLen, 0)
+	c.Assert(report.Errors, check.HasLen, 1)
+	c.Assert(report.Errors[0], check.Matches, "unable to list containers in .*")
+}
+
+func (s *S) TestReconcileSkipsContainersRemovedMeanwhile(c *check.C) {
+	err := s.storage.Apps().Insert(&app.App{Name: "myapp"})
+	c.Assert(err, check.IsNil)
+	recreated, err := s.p.recreateMissingContainer(&container.Container{ID: "gone", AppName: "myapp"})
+	c.Assert(err, check.IsNil)
+	c.Assert(recreated, check.Equals, false)
+	containers, err := s.p.listContainersByApp("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(containers, check.HasLen, 0)
+	dbApp, err := app.GetByName("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(dbApp.Lock.Locked, check.Equals, false)
+}
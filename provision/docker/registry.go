@@ -0,0 +1,180 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/log"
+)
+
+// registry is a docker registry where app and platform images are pushed.
+// Mirrors are pull-only registries serving the same images, they're never
+// written by tsuru but nodes pull images from them before trying the
+// registry.
+type registry struct {
+	Address string
+	Auth    docker.AuthConfiguration
+	Mirrors []string
+}
+
+// defaultRegistry returns the registry defined in docker:registry, used by
+// every pool without its own registry.
+func defaultRegistry() registry {
+	var r registry
+	r.Address, _ = config.GetString("docker:registry")
+	r.Auth.Email, _ = config.GetString("docker:registry-auth:email")
+	r.Auth.Username, _ = config.GetString("docker:registry-auth:username")
+	r.Auth.Password, _ = config.GetString("docker:registry-auth:password")
+	r.Auth.ServerAddress = r.Address
+	r.Mirrors, _ = config.GetList("docker:registry-mirrors")
+	return r
+}
+
+// registryPools returns the names of the pools with their own registry,
+// defined in docker:registries:<pool>.
+func registryPools() []string {
+	value, err := config.Get("docker:registries")
+	if err != nil {
+		return nil
+	}
+	registries, _ := value.(map[interface{}]interface{})
+	pools := make([]string, 0, len(registries))
+	for key := range registries {
+		if pool, ok := key.(string); ok {
+			pools = append(pools, pool)
+		}
+	}
+	sort.Strings(pools)
+	return pools
+}
+
+// poolRegistry returns the registry used by the apps in the given pool,
+// falling back to the default registry when the pool has no registry.
+func poolRegistry(pool string) registry {
+	if pool == "" {
+		return defaultRegistry()
+	}
+	prefix := "docker:registries:" + pool
+	address, _ := config.GetString(prefix + ":address")
+	if address == "" {
+		return defaultRegistry()
+	}
+	r := registry{Address: address}
+	r.Auth.Email, _ = config.GetString(prefix + ":auth:email")
+	r.Auth.Username, _ = config.GetString(prefix + ":auth:username")
+	r.Auth.Password, _ = config.GetString(prefix + ":auth:password")
+	r.Auth.ServerAddress = address
+	r.Mirrors, _ = config.GetList(prefix + ":mirrors")
+	return r
+}
+
+// allRegistries returns every configured registry, starting with the
+// default one. Pools sharing a registry address share the first registry
+// found with it.
+func allRegistries() []registry {
+	var registries []registry
+	seen := make(map[string]bool)
+	if r := defaultRegistry(); r.Address != "" {
+		registries = append(registries, r)
+		seen[r.Address] = true
+	}
+	for _, pool := range registryPools() {
+		r := poolRegistry(pool)
+		if r.Address == "" || seen[r.Address] {
+			continue
+		}
+		registries = append(registries, r)
+		seen[r.Address] = true
+	}
+	return registries
+}
+
+// registryForImage returns the registry where the given image is stored,
+// based on the address in its name.
+func registryForImage(imageName string) registry {
+	for _, r := range allRegistries() {
+		if strings.HasPrefix(imageName, r.Address+"/") {
+			return r
+		}
+	}
+	return defaultRegistry()
+}
+
+// imagePrefix returns the prefix of the names of the images stored in the
+// registry, including the repository namespace.
+func (r registry) imagePrefix() string {
+	repoNamespace, _ := config.GetString("docker:repository-namespace")
+	if r.Address == "" {
+		return repoNamespace
+	}
+	return r.Address + "/" + repoNamespace
+}
+
+// imagePusher is implemented by both the cluster and docker clients, allowing
+// images to be sent from any node.
+type imagePusher interface {
+	TagImage(name string, opts docker.TagImageOptions) error
+	PushImage(opts docker.PushImageOptions, auth docker.AuthConfiguration) error
+}
+
+// pushPlatformToPoolRegistries tags the platform image with the name used in
+// each pool registry and sends it there, so apps in pools with their own
+// registry are built from it.
+func pushPlatformToPoolRegistries(pusher imagePusher, name string, w io.Writer) error {
+	defaultAddress := defaultRegistry().Address
+	imageName := platformImageName(name)
+	for _, r := range allRegistries() {
+		if r.Address == defaultAddress {
+			continue
+		}
+		repository, tag := splitImageName(fmt.Sprintf("%s/%s:latest", r.imagePrefix(), name))
+		err := pusher.TagImage(imageName, docker.TagImageOptions{Repo: repository, Tag: tag, Force: true})
+		if err != nil {
+			return err
+		}
+		err = pusher.PushImage(docker.PushImageOptions{Name: repository, Tag: tag, OutputStream: w}, r.Auth)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PullFromMirrors makes the node pull the image from the mirrors of its
+// registry, tagging it with its original name, so the node doesn't pull it
+// from the registry when the container is created. Mirrors are tried in
+// order, nothing is pulled if the node already has the image.
+func (p *dockerProvisioner) PullFromMirrors(nodeAddress, imageName string) error {
+	r := registryForImage(imageName)
+	if len(r.Mirrors) == 0 || r.Address == "" || !strings.HasPrefix(imageName, r.Address+"/") {
+		return nil
+	}
+	client, err := docker.NewClient(nodeAddress)
+	if err != nil {
+		return err
+	}
+	if _, err = client.InspectImage(imageName); err == nil {
+		return nil
+	}
+	repository, tag := splitImageName(imageName)
+	path := strings.TrimPrefix(imageName, r.Address+"/")
+	for _, mirror := range r.Mirrors {
+		mirrorRepository, mirrorTag := splitImageName(mirror + "/" + path)
+		pullOpts := docker.PullImageOptions{Repository: mirrorRepository, Tag: mirrorTag}
+		err = client.PullImage(pullOpts, docker.AuthConfiguration{})
+		if err != nil {
+			log.Errorf("[registry] unable to pull %s from mirror %s in %s: %s", imageName, mirror, nodeAddress, err)
+			continue
+		}
+		return client.TagImage(mirrorRepository+":"+mirrorTag, docker.TagImageOptions{Repo: repository, Tag: tag, Force: true})
+	}
+	return fmt.Errorf("unable to pull %s from any mirror of %s", imageName, r.Address)
+}
@@ -0,0 +1,196 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/fsouza/go-dockerclient"
+	dtesting "github.com/fsouza/go-dockerclient/testing"
+	"github.com/tsuru/config"
+	"gopkg.in/check.v1"
+)
+
+func setPoolRegistries() {
+	config.Set("docker:registry", "registry.tsuru.io")
+	config.Set("docker:registry-auth:username", "tsuru")
+	config.Set("docker:registry-auth:password", "tsurupass")
+	config.Set("docker:registries:pool1:address", "registry.pool1.io")
+	config.Set("docker:registries:pool1:auth:username", "pool1")
+	config.Set("docker:registries:pool1:auth:password", "pool1pass")
+	config.Set("docker:registries:pool1:mirrors", []interface{}{"mirror1.pool1.io", "mirror2.pool1.io"})
+	config.Set("docker:registries:pool2:address", "registry.pool1.io")
+	config.Set("docker:registries:pool3:mirrors", []interface{}{"mirror.pool3.io"})
+}
+
+func unsetPoolRegistries() {
+	config.Unset("docker:registry")
+	config.Unset("docker:registry-auth")
+	config.Unset("docker:registries")
+}
+
+func (s *S) TestDefaultRegistry(c *check.C) {
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	c.Assert(defaultRegistry(), check.DeepEquals, registry{
+		Address: "registry.tsuru.io",
+		Auth: docker.AuthConfiguration{
+			Username:      "tsuru",
+			Password:      "tsurupass",
+			ServerAddress: "registry.tsuru.io",
+		},
+	})
+}
+
+func (s *S) TestPoolRegistry(c *check.C) {
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	c.Assert(poolRegistry("pool1"), check.DeepEquals, registry{
+		Address: "registry.pool1.io",
+		Auth: docker.AuthConfiguration{
+			Username:      "pool1",
+			Password:      "pool1pass",
+			ServerAddress: "registry.pool1.io",
+		},
+		Mirrors: []string{"mirror1.pool1.io", "mirror2.pool1.io"},
+	})
+	c.Assert(poolRegistry("pool2").Address, check.Equals, "registry.pool1.io")
+	c.Assert(poolRegistry("pool2").Auth.Username, check.Equals, "")
+	c.Assert(poolRegistry("pool3"), check.DeepEquals, defaultRegistry())
+	c.Assert(poolRegistry("other"), check.DeepEquals, defaultRegistry())
+	c.Assert(poolRegistry(""), check.DeepEquals, defaultRegistry())
+}
+
+func (s *S) TestAllRegistries(c *check.C) {
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	registries := allRegistries()
+	c.Assert(registries, check.HasLen, 2)
+	c.Assert(registries[0], check.DeepEquals, defaultRegistry())
+	c.Assert(registries[1], check.DeepEquals, poolRegistry("pool1"))
+}
+
+func (s *S) TestAllRegistriesNoRegistry(c *check.C) {
+	c.Assert(allRegistries(), check.HasLen, 0)
+}
+
+func (s *S) TestRegistryForImage(c *check.C) {
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	c.Assert(registryForImage("registry.pool1.io/tsuru/app-myapp:v1"), check.DeepEquals, poolRegistry("pool1"))
+	c.Assert(registryForImage("registry.tsuru.io/tsuru/app-myapp:v1"), check.DeepEquals, defaultRegistry())
+	c.Assert(registryForImage("tsuru/app-myapp:v1"), check.DeepEquals, defaultRegistry())
+}
+
+func (s *S) TestRegistryImagePrefix(c *check.C) {
+	c.Assert(registry{Address: "registry.pool1.io"}.imagePrefix(), check.Equals, "registry.pool1.io/tsuru")
+	c.Assert(registry{}.imagePrefix(), check.Equals, "tsuru")
+}
+
+type fakeImagePusher struct {
+	tagged []string
+	pushed []docker.PushImageOptions
+	auths  []docker.AuthConfiguration
+}
+
+func (p *fakeImagePusher) TagImage(name string, opts docker.TagImageOptions) error {
+	p.tagged = append(p.tagged, name+" -> "+opts.Repo+":"+opts.Tag)
+	return nil
+}
+
+func (p *fakeImagePusher) PushImage(opts docker.PushImageOptions, auth docker.AuthConfiguration) error {
+	p.pushed = append(p.pushed, opts)
+	p.auths = append(p.auths, auth)
+	return nil
+}
+
+func (s *S) TestPushPlatformToPoolRegistries(c *check.C) {
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	var pusher fakeImagePusher
+	var buf bytes.Buffer
+	err := pushPlatformToPoolRegistries(&pusher, "python", &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(pusher.tagged, check.DeepEquals, []string{
+		"registry.tsuru.io/tsuru/python:latest -> registry.pool1.io/tsuru/python:latest",
+	})
+	c.Assert(pusher.pushed, check.HasLen, 1)
+	c.Assert(pusher.pushed[0].Name, check.Equals, "registry.pool1.io/tsuru/python")
+	c.Assert(pusher.pushed[0].Tag, check.Equals, "latest")
+	c.Assert(pusher.auths, check.DeepEquals, []docker.AuthConfiguration{poolRegistry("pool1").Auth})
+}
+
+func (s *S) TestPushPlatformToPoolRegistriesWithoutPoolRegistries(c *check.C) {
+	config.Set("docker:registry", "registry.tsuru.io")
+	defer config.Unset("docker:registry")
+	var pusher fakeImagePusher
+	err := pushPlatformToPoolRegistries(&pusher, "python", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(pusher.tagged, check.HasLen, 0)
+	c.Assert(pusher.pushed, check.HasLen, 0)
+}
+
+func (s *S) TestPullFromMirrors(c *check.C) {
+	var requests []*http.Request
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, func(r *http.Request) {
+		requests = append(requests, r)
+	})
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	server.CustomHandler("/images/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fromImage") == "mirror1.pool1.io/tsuru/app-myapp" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		server.DefaultHandler().ServeHTTP(w, r)
+	}))
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	err = s.p.PullFromMirrors(server.URL(), "registry.pool1.io/tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	var pulls []string
+	for _, r := range requests {
+		if r.URL.Path == "/images/create" {
+			pulls = append(pulls, r.URL.Query().Get("fromImage")+":"+r.URL.Query().Get("tag"))
+		}
+	}
+	c.Assert(pulls, check.DeepEquals, []string{
+		"mirror1.pool1.io/tsuru/app-myapp:v1",
+		"mirror2.pool1.io/tsuru/app-myapp:v1",
+	})
+	client, err := docker.NewClient(server.URL())
+	c.Assert(err, check.IsNil)
+	_, err = client.InspectImage("registry.pool1.io/tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	requests = nil
+	err = s.p.PullFromMirrors(server.URL(), "registry.pool1.io/tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	for _, r := range requests {
+		c.Assert(r.URL.Path, check.Not(check.Equals), "/images/create")
+	}
+}
+
+func (s *S) TestPullFromMirrorsAllMirrorsFail(c *check.C) {
+	server, err := dtesting.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, check.IsNil)
+	defer server.Stop()
+	server.CustomHandler("/images/create", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	err = s.p.PullFromMirrors(server.URL(), "registry.pool1.io/tsuru/app-myapp:v1")
+	c.Assert(err, check.ErrorMatches, "unable to pull registry.pool1.io/tsuru/app-myapp:v1 from any mirror of registry.pool1.io")
+}
+
+func (s *S) TestPullFromMirrorsWithoutMirrors(c *check.C) {
+	setPoolRegistries()
+	defer unsetPoolRegistries()
+	err := s.p.PullFromMirrors("http://localhost:1", "registry.tsuru.io/tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+	err = s.p.PullFromMirrors("http://localhost:1", "tsuru/app-myapp:v1")
+	c.Assert(err, check.IsNil)
+}
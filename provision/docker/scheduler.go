@@ -7,7 +7,6 @@ package docker
 import (
 	"errors"
 	"fmt"
-	"math"
 	"strconv"
 	"sync"
 
@@ -28,6 +27,8 @@ type segregatedScheduler struct {
 	hostMutex           sync.Mutex
 	maxMemoryRatio      float32
 	TotalMemoryMetadata string
+	maxCPURatio         float32
+	TotalCPUMetadata    string
 	provisioner         *dockerProvisioner
 	// ignored containers is only set in provisioner returned by
 	// cloneProvisioner which will set this field to exclude some container
@@ -47,14 +48,28 @@ func (s *segregatedScheduler) Schedule(c *cluster.Cluster, opts docker.CreateCon
 	if err != nil {
 		return cluster.Node{}, err
 	}
+	nodes = schedulableNodes(nodes)
+	if len(nodes) == 0 {
+		return cluster.Node{}, fmt.Errorf("no schedulable nodes found for app %q: all nodes are cordoned", appName)
+	}
 	nodes, err = s.filterByMemoryUsage(a, nodes, s.maxMemoryRatio, s.TotalMemoryMetadata)
 	if err != nil {
 		return cluster.Node{}, err
 	}
+	nodes, err = s.filterByCPUUsage(a, nodes, s.maxCPURatio, s.TotalCPUMetadata)
+	if err != nil {
+		return cluster.Node{}, err
+	}
 	node, err := s.chooseNode(nodes, opts.Name, appName, processName)
 	if err != nil {
 		return cluster.Node{}, err
 	}
+	if opts.Config != nil {
+		err = s.provisioner.PullFromMirrors(node, opts.Config.Image)
+		if err != nil {
+			log.Errorf("error pulling image %s from mirrors in %s, falling back to the registry: %s", opts.Config.Image, node, err)
+		}
+	}
 	return cluster.Node{Address: node}, nil
 }
 
@@ -116,6 +131,93 @@ func (s *segregatedScheduler) filterByMemoryUsage(a *app.App, nodes []cluster.No
 	return nodeList, nil
 }
 
+// cpuSharesPerCore is the amount of CPU shares available in each CPU of a
+// node. It's the default CPU share of a Docker container.
+const cpuSharesPerCore = 1024
+
+// nodeCPUShares returns the CPU capacity of the node, in CPU shares. The
+// amount of CPUs is read from the given node metadata, falling back to the
+// amount of CPUs reported by the Docker daemon running in the node.
+func nodeCPUShares(node *cluster.Node, TotalCPUMetadata string) (int64, error) {
+	cpus, _ := strconv.ParseFloat(node.Metadata[TotalCPUMetadata], 64)
+	if cpus == 0 {
+		client, err := docker.NewClient(node.Address)
+		if err != nil {
+			return 0, err
+		}
+		info, err := client.Info()
+		if err != nil {
+			return 0, err
+		}
+		cpus = float64(info.GetInt("NCPU"))
+	}
+	return int64(cpus * cpuSharesPerCore), nil
+}
+
+// containersCPUShares returns the amount of CPU shares reserved in each host
+// by the plans of the apps running in the given containers.
+func containersCPUShares(containers []container.Container) (map[string]int64, error) {
+	hostReserved := make(map[string]int64)
+	for _, cont := range containers {
+		contApp, err := app.GetByName(cont.AppName)
+		if err != nil {
+			return nil, err
+		}
+		hostReserved[cont.HostAddr] += int64(contApp.Plan.CpuShare)
+	}
+	return hostReserved, nil
+}
+
+func (s *segregatedScheduler) filterByCPUUsage(a *app.App, nodes []cluster.Node, maxCPURatio float32, TotalCPUMetadata string) ([]cluster.Node, error) {
+	if maxCPURatio == 0 {
+		return nodes, nil
+	}
+	hosts := make([]string, len(nodes))
+	for i := range nodes {
+		hosts[i] = urlToHost(nodes[i].Address)
+	}
+	containers, err := s.provisioner.ListContainers(bson.M{"hostaddr": bson.M{"$in": hosts}, "id": bson.M{"$nin": s.ignoredContainers}})
+	if err != nil {
+		return nil, err
+	}
+	hostReserved, err := containersCPUShares(containers)
+	if err != nil {
+		return nil, err
+	}
+	cpuShare := int64(a.Plan.CpuShare)
+	nodeList := make([]cluster.Node, 0, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		host := urlToHost(node.Address)
+		totalShares, err := nodeCPUShares(node, TotalCPUMetadata)
+		if err != nil {
+			log.Errorf("Unable to get the amount of CPUs of node %q, ignoring CPU restrictions: %s", host, err)
+		}
+		if totalShares != 0 {
+			maxShares := int64(float64(totalShares) * float64(maxCPURatio))
+			if hostReserved[host]+cpuShare > maxShares {
+				log.Errorf("Node %q has reached its CPU limit. "+
+					"Limit %d shares. Reserved: %d shares. Needed additional %d shares",
+					host, maxShares, hostReserved[host], cpuShare)
+				continue
+			}
+		}
+		nodeList = append(nodeList, *node)
+	}
+	if len(nodeList) == 0 {
+		autoScaleEnabled, _ := config.GetBool("docker:auto-scale:enabled")
+		errMsg := fmt.Sprintf("no nodes found with enough CPU for container of %q: %d shares", a.Name, cpuShare)
+		if autoScaleEnabled {
+			// Allow going over quota temporarily because auto-scale will be
+			// able to detect this and automatically add a new nodes.
+			log.Errorf("WARNING: %s. Will ignore CPU restrictions.", errMsg)
+			return nodes, nil
+		}
+		return nil, errors.New(errMsg)
+	}
+	return nodeList, nil
+}
+
 type nodeAggregate struct {
 	HostAddr string `bson:"_id"`
 	Count    int
@@ -169,34 +271,28 @@ func (s *segregatedScheduler) GetRemovableContainer(appName string, process stri
 	return s.chooseContainerFromMaxContainersCountInNode(nodes, appName, process)
 }
 
-// chooseNodeWithMaxContainersCount finds which is the node with maximum number
-// of containers and returns it
+// chooseContainerFromMaxContainersCountInNode finds the node, according to
+// the scheduling strategy of the pool, from where a container of the app
+// process should be removed and returns one of its containers
 func (s *segregatedScheduler) chooseContainerFromMaxContainersCountInNode(nodes []cluster.Node, appName, process string) (string, error) {
 	hosts, hostsMap := s.nodesToHosts(nodes)
 	log.Debugf("[scheduler] Possible nodes for remove a container: %#v", hosts)
+	strategy, err := getSchedulingStrategy(poolFromNodes(nodes))
+	if err != nil {
+		return "", err
+	}
 	s.hostMutex.Lock()
 	defer s.hostMutex.Unlock()
-	hostCountMap, err := s.aggregateContainersByHost(hosts)
+	data, err := s.schedulingData(nodes, hosts, appName, process)
 	if err != nil {
 		return "", err
 	}
-	appCountMap, err := s.aggregateContainersByHostAppProcess(hosts, appName, process)
+	maxHost, err := strategy.chooseNodeToRemove(data)
 	if err != nil {
 		return "", err
 	}
-	// Finally finding the host with the maximum value for
-	// the pair [appCount, hostCount]
-	var maxHost string
-	maxCount := 0
-	for _, host := range hosts {
-		adjCount := appCountMap[host]*10000 + hostCountMap[host]
-		if adjCount > maxCount {
-			maxCount = adjCount
-			maxHost = host
-		}
-	}
 	chosenNode := hostsMap[maxHost]
-	log.Debugf("[scheduler] Chosen node for remove a container: %#v Count: %d", chosenNode, hostCountMap[maxHost])
+	log.Debugf("[scheduler] Chosen node for remove a container: %#v Count: %d", chosenNode, data.hostCount[maxHost])
 	containerID, err := s.getContainerFromHost(maxHost, appName, process)
 	if err != nil {
 		return "", err
@@ -204,6 +300,29 @@ func (s *segregatedScheduler) chooseContainerFromMaxContainersCountInNode(nodes
 	return containerID, err
 }
 
+func (s *segregatedScheduler) schedulingData(nodes []cluster.Node, hosts []string, appName, process string) (*schedulingData, error) {
+	hostCountMap, err := s.aggregateContainersByHost(hosts)
+	if err != nil {
+		return nil, err
+	}
+	appCountMap, err := s.aggregateContainersByHostAppProcess(hosts, appName, process)
+	if err != nil {
+		return nil, err
+	}
+	metadata := make(map[string]map[string]string, len(nodes))
+	for _, node := range nodes {
+		metadata[urlToHost(node.Address)] = node.Metadata
+	}
+	return &schedulingData{
+		appName:   appName,
+		process:   process,
+		hosts:     hosts,
+		metadata:  metadata,
+		hostCount: hostCountMap,
+		appCount:  appCountMap,
+	}, nil
+}
+
 func (s *segregatedScheduler) getContainerFromHost(host string, appName, process string) (string, error) {
 	coll := s.provisioner.Collection()
 	defer coll.Close()
@@ -235,35 +354,29 @@ func (s *segregatedScheduler) nodesToHosts(nodes []cluster.Node) ([]string, map[
 	return hosts, hostsMap
 }
 
-// chooseNode finds which is the node with the minimum number
-// of containers and returns it
+// chooseNode finds, according to the scheduling strategy of the pool, the
+// node where a new container of the app process should be created and
+// returns it
 func (s *segregatedScheduler) chooseNode(nodes []cluster.Node, contName string, appName, process string) (string, error) {
 	var chosenNode string
 	hosts, hostsMap := s.nodesToHosts(nodes)
 	log.Debugf("[scheduler] Possible nodes for container %s: %#v", contName, hosts)
+	strategy, err := getSchedulingStrategy(poolFromNodes(nodes))
+	if err != nil {
+		return chosenNode, err
+	}
 	s.hostMutex.Lock()
 	defer s.hostMutex.Unlock()
-	hostCountMap, err := s.aggregateContainersByHost(hosts)
+	data, err := s.schedulingData(nodes, hosts, appName, process)
 	if err != nil {
 		return chosenNode, err
 	}
-	appCountMap, err := s.aggregateContainersByHostAppProcess(hosts, appName, process)
+	minHost, err := strategy.chooseNode(data)
 	if err != nil {
 		return chosenNode, err
 	}
-	// Finally finding the host with the minimum value for
-	// the pair [appCount, hostCount]
-	var minHost string
-	minCount := math.MaxInt32
-	for _, host := range hosts {
-		adjCount := appCountMap[host]*10000 + hostCountMap[host]
-		if adjCount < minCount {
-			minCount = adjCount
-			minHost = host
-		}
-	}
 	chosenNode = hostsMap[minHost]
-	log.Debugf("[scheduler] Chosen node for container %s: %#v Count: %d", contName, chosenNode, minCount)
+	log.Debugf("[scheduler] Chosen node for container %s: %#v Count: %d", contName, chosenNode, data.hostCount[minHost])
 	if contName != "" {
 		coll := s.provisioner.Collection()
 		defer coll.Close()
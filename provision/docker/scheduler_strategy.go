@@ -0,0 +1,224 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/docker-cluster/cluster"
+)
+
+const (
+	balancedStrategyName     = "balanced"
+	spreadStrategyName       = "spread"
+	antiAffinityStrategyName = "anti-affinity"
+	binPackStrategyName      = "binpack"
+)
+
+// schedulingData holds the state of the candidate hosts for placing or
+// removing a unit of a process of an app.
+type schedulingData struct {
+	appName   string
+	process   string
+	hosts     []string
+	metadata  map[string]map[string]string
+	hostCount map[string]int
+	appCount  map[string]int
+}
+
+// schedulingStrategy decides where the units of an app are created and from
+// where they're removed. Strategies are configured per pool, through the
+// docker:scheduler:pools:<pool>:strategy setting, falling back to
+// docker:scheduler:strategy and then to the balanced strategy.
+type schedulingStrategy interface {
+	// chooseNode returns the host that will receive a new unit.
+	chooseNode(data *schedulingData) (string, error)
+
+	// chooseNodeToRemove returns the host from where a unit will be
+	// removed. The host must have at least one unit of the app process.
+	chooseNodeToRemove(data *schedulingData) (string, error)
+}
+
+// getSchedulingStrategy returns the strategy configured for the given pool.
+func getSchedulingStrategy(pool string) (schedulingStrategy, error) {
+	prefix := "docker:scheduler"
+	name, _ := config.GetString("docker:scheduler:strategy")
+	if pool != "" {
+		poolPrefix := "docker:scheduler:pools:" + pool
+		if poolName, _ := config.GetString(poolPrefix + ":strategy"); poolName != "" {
+			name = poolName
+			prefix = poolPrefix
+		}
+	}
+	switch name {
+	case "", balancedStrategyName:
+		return &balancedStrategy{}, nil
+	case binPackStrategyName:
+		return &binPackStrategy{}, nil
+	case spreadStrategyName:
+		metadata, _ := config.GetString(prefix + ":metadata")
+		if metadata == "" {
+			return nil, fmt.Errorf("%s: the spread strategy requires a metadata name", prefix)
+		}
+		return &spreadStrategy{metadata: metadata}, nil
+	case antiAffinityStrategyName:
+		processes, _ := config.GetList(prefix + ":processes")
+		return &antiAffinityStrategy{processes: processes}, nil
+	}
+	return nil, fmt.Errorf("%s: unknown scheduling strategy %q", prefix, name)
+}
+
+func poolFromNodes(nodes []cluster.Node) string {
+	for _, node := range nodes {
+		if pool := node.Metadata["pool"]; pool != "" {
+			return pool
+		}
+	}
+	return ""
+}
+
+// balancedStrategy places units on the host with fewer units of the same app
+// process, using the total number of units as the tie breaker.
+type balancedStrategy struct{}
+
+func (balancedStrategy) chooseNode(data *schedulingData) (string, error) {
+	return minHost(data.hosts, func(host string) int {
+		return data.appCount[host]*10000 + data.hostCount[host]
+	}), nil
+}
+
+func (balancedStrategy) chooseNodeToRemove(data *schedulingData) (string, error) {
+	return maxHost(data.hosts, func(host string) int {
+		return data.appCount[host]*10000 + data.hostCount[host]
+	}), nil
+}
+
+// binPackStrategy fills the busiest hosts first, so idle hosts may be
+// removed from the pool. Units are removed from the least busy hosts.
+type binPackStrategy struct{}
+
+func (binPackStrategy) chooseNode(data *schedulingData) (string, error) {
+	return maxHost(data.hosts, func(host string) int {
+		return data.hostCount[host]*10000 - data.appCount[host]
+	}), nil
+}
+
+func (binPackStrategy) chooseNodeToRemove(data *schedulingData) (string, error) {
+	return minHost(data.hosts, func(host string) int {
+		if data.appCount[host] == 0 {
+			return math.MaxInt32
+		}
+		return data.hostCount[host]
+	}), nil
+}
+
+// spreadStrategy distributes the units of an app process evenly among the
+// values of a node metadata, like an availability zone, balancing the hosts
+// inside each value.
+type spreadStrategy struct {
+	metadata string
+}
+
+func (s *spreadStrategy) groupCount(data *schedulingData) map[string]int {
+	counts := make(map[string]int)
+	for _, host := range data.hosts {
+		counts[data.metadata[host][s.metadata]] += data.appCount[host]
+	}
+	return counts
+}
+
+func (s *spreadStrategy) filter(data *schedulingData, wanted func(count, best int) bool, initial int) *schedulingData {
+	counts := s.groupCount(data)
+	best := initial
+	for _, count := range counts {
+		if wanted(count, best) {
+			best = count
+		}
+	}
+	filtered := *data
+	filtered.hosts = nil
+	for _, host := range data.hosts {
+		if counts[data.metadata[host][s.metadata]] == best {
+			filtered.hosts = append(filtered.hosts, host)
+		}
+	}
+	return &filtered
+}
+
+func (s *spreadStrategy) chooseNode(data *schedulingData) (string, error) {
+	filtered := s.filter(data, func(count, best int) bool { return count < best }, math.MaxInt32)
+	return balancedStrategy{}.chooseNode(filtered)
+}
+
+func (s *spreadStrategy) chooseNodeToRemove(data *schedulingData) (string, error) {
+	filtered := s.filter(data, func(count, best int) bool { return count > best }, 0)
+	return balancedStrategy{}.chooseNodeToRemove(filtered)
+}
+
+// antiAffinityStrategy never places two units of the same app process in the
+// same host. It applies to the configured processes, or to all processes if
+// none is configured.
+type antiAffinityStrategy struct {
+	processes []string
+}
+
+func (s *antiAffinityStrategy) applies(process string) bool {
+	if len(s.processes) == 0 {
+		return true
+	}
+	for _, p := range s.processes {
+		if p == process {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *antiAffinityStrategy) chooseNode(data *schedulingData) (string, error) {
+	if !s.applies(data.process) {
+		return balancedStrategy{}.chooseNode(data)
+	}
+	filtered := *data
+	filtered.hosts = nil
+	for _, host := range data.hosts {
+		if data.appCount[host] == 0 {
+			filtered.hosts = append(filtered.hosts, host)
+		}
+	}
+	if len(filtered.hosts) == 0 {
+		return "", fmt.Errorf("no nodes available for a new unit of process %q of app %q: all nodes already run one (anti-affinity)", data.process, data.appName)
+	}
+	return balancedStrategy{}.chooseNode(&filtered)
+}
+
+func (s *antiAffinityStrategy) chooseNodeToRemove(data *schedulingData) (string, error) {
+	return balancedStrategy{}.chooseNodeToRemove(data)
+}
+
+func minHost(hosts []string, score func(string) int) string {
+	var chosen string
+	minScore := math.MaxInt32
+	for _, host := range hosts {
+		if value := score(host); value < minScore {
+			minScore = value
+			chosen = host
+		}
+	}
+	return chosen
+}
+
+func maxHost(hosts []string, score func(string) int) string {
+	var chosen string
+	maxScore := math.MinInt32
+	for _, host := range hosts {
+		if value := score(host); value > maxScore {
+			maxScore = value
+			chosen = host
+		}
+	}
+	return chosen
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package docker
+
+import (
+	"github.com/tsuru/config"
+	"github.com/tsuru/docker-cluster/cluster"
+	"github.com/tsuru/tsuru/provision/docker/container"
+	"gopkg.in/check.v1"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func (s *S) TestGetSchedulingStrategy(c *check.C) {
+	defer config.Unset("docker:scheduler:strategy")
+	defer config.Unset("docker:scheduler:pools")
+	strategy, err := getSchedulingStrategy("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(strategy, check.FitsTypeOf, &balancedStrategy{})
+	config.Set("docker:scheduler:strategy", "binpack")
+	strategy, err = getSchedulingStrategy("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(strategy, check.FitsTypeOf, &binPackStrategy{})
+	config.Set("docker:scheduler:pools:pool1:strategy", "spread")
+	config.Set("docker:scheduler:pools:pool1:metadata", "zone")
+	config.Set("docker:scheduler:pools:pool2:strategy", "anti-affinity")
+	config.Set("docker:scheduler:pools:pool2:processes", []interface{}{"web"})
+	strategy, err = getSchedulingStrategy("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(strategy, check.DeepEquals, &spreadStrategy{metadata: "zone"})
+	strategy, err = getSchedulingStrategy("pool2")
+	c.Assert(err, check.IsNil)
+	c.Assert(strategy, check.DeepEquals, &antiAffinityStrategy{processes: []string{"web"}})
+	strategy, err = getSchedulingStrategy("pool3")
+	c.Assert(err, check.IsNil)
+	c.Assert(strategy, check.FitsTypeOf, &binPackStrategy{})
+}
+
+func (s *S) TestGetSchedulingStrategyInvalid(c *check.C) {
+	defer config.Unset("docker:scheduler:pools")
+	config.Set("docker:scheduler:pools:pool1:strategy", "random")
+	_, err := getSchedulingStrategy("pool1")
+	c.Assert(err, check.ErrorMatches, `docker:scheduler:pools:pool1: unknown scheduling strategy "random"`)
+	config.Set("docker:scheduler:pools:pool1:strategy", "spread")
+	_, err = getSchedulingStrategy("pool1")
+	c.Assert(err, check.ErrorMatches, `docker:scheduler:pools:pool1: the spread strategy requires a metadata name`)
+}
+
+func (s *S) TestBalancedStrategy(c *check.C) {
+	data := &schedulingData{
+		hosts:     []string{"server1", "server2", "server3"},
+		hostCount: map[string]int{"server1": 1, "server2": 5, "server3": 3},
+		appCount:  map[string]int{"server1": 1, "server2": 0, "server3": 1},
+	}
+	host, err := balancedStrategy{}.chooseNode(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server2")
+	host, err = balancedStrategy{}.chooseNodeToRemove(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server3")
+}
+
+func (s *S) TestBinPackStrategy(c *check.C) {
+	data := &schedulingData{
+		hosts:     []string{"server1", "server2", "server3"},
+		hostCount: map[string]int{"server1": 1, "server2": 5, "server3": 3},
+		appCount:  map[string]int{"server1": 1, "server2": 0, "server3": 1},
+	}
+	host, err := binPackStrategy{}.chooseNode(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server2")
+	host, err = binPackStrategy{}.chooseNodeToRemove(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server1")
+}
+
+func (s *S) TestSpreadStrategy(c *check.C) {
+	data := &schedulingData{
+		hosts: []string{"server1", "server2", "server3", "server4"},
+		metadata: map[string]map[string]string{
+			"server1": {"zone": "a"},
+			"server2": {"zone": "a"},
+			"server3": {"zone": "b"},
+			"server4": {"zone": "b"},
+		},
+		hostCount: map[string]int{"server1": 1, "server2": 0, "server3": 9, "server4": 8},
+		appCount:  map[string]int{"server1": 1, "server2": 0, "server3": 0, "server4": 0},
+	}
+	strategy := &spreadStrategy{metadata: "zone"}
+	host, err := strategy.chooseNode(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server4")
+	data.appCount["server4"] = 2
+	host, err = strategy.chooseNode(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server2")
+	host, err = strategy.chooseNodeToRemove(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server4")
+}
+
+func (s *S) TestAntiAffinityStrategy(c *check.C) {
+	data := &schedulingData{
+		appName:   "myapp",
+		process:   "web",
+		hosts:     []string{"server1", "server2"},
+		hostCount: map[string]int{"server1": 1, "server2": 5},
+		appCount:  map[string]int{"server1": 1, "server2": 0},
+	}
+	strategy := &antiAffinityStrategy{processes: []string{"web"}}
+	host, err := strategy.chooseNode(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server2")
+	data.appCount["server2"] = 1
+	_, err = strategy.chooseNode(data)
+	c.Assert(err, check.ErrorMatches, `no nodes available for a new unit of process "web" of app "myapp": all nodes already run one \(anti-affinity\)`)
+	data.process = "worker"
+	host, err = strategy.chooseNode(data)
+	c.Assert(err, check.IsNil)
+	c.Assert(host, check.Equals, "server1")
+}
+
+func (s *S) TestChooseNodeSpreadByPoolMetadata(c *check.C) {
+	defer config.Unset("docker:scheduler:pools")
+	config.Set("docker:scheduler:pools:pool1:strategy", "spread")
+	config.Set("docker:scheduler:pools:pool1:metadata", "zone")
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234", Metadata: map[string]string{"pool": "pool1", "zone": "a"}},
+		{Address: "http://server2:1234", Metadata: map[string]string{"pool": "pool1", "zone": "a"}},
+		{Address: "http://server3:1234", Metadata: map[string]string{"pool": "pool1", "zone": "b"}},
+	}
+	contColl := s.p.Collection()
+	defer contColl.Close()
+	defer contColl.RemoveAll(bson.M{"appname": "spreadapp"})
+	err := contColl.Insert(container.Container{ID: "pre1", Name: "existingUnit1", AppName: "spreadapp", HostAddr: "server3", ProcessName: "web"})
+	c.Assert(err, check.IsNil)
+	sched := segregatedScheduler{provisioner: s.p}
+	node, err := sched.chooseNode(nodes, "", "spreadapp", "web")
+	c.Assert(err, check.IsNil)
+	c.Assert(node, check.Equals, "http://server1:1234")
+	err = contColl.Insert(container.Container{ID: "pre2", Name: "existingUnit2", AppName: "spreadapp", HostAddr: "server1", ProcessName: "web"})
+	c.Assert(err, check.IsNil)
+	err = contColl.Insert(container.Container{ID: "pre3", Name: "existingUnit3", AppName: "spreadapp", HostAddr: "server2", ProcessName: "web"})
+	c.Assert(err, check.IsNil)
+	node, err = sched.chooseNode(nodes, "", "spreadapp", "web")
+	c.Assert(err, check.IsNil)
+	c.Assert(node, check.Equals, "http://server3:1234")
+	id, err := sched.chooseContainerFromMaxContainersCountInNode(nodes, "spreadapp", "web")
+	c.Assert(err, check.IsNil)
+	c.Assert(id == "pre2" || id == "pre3", check.Equals, true)
+}
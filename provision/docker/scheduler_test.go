@@ -385,6 +385,75 @@ func (s *S) TestSchedulerScheduleWithMemoryAwareness(c *check.C) {
 	c.Assert(node, check.DeepEquals, cluster.Node{})
 }
 
+func (s *S) TestSchedulerFilterByCPUUsage(c *check.C) {
+	logBuf := bytes.NewBuffer(nil)
+	log.SetLogger(log.NewWriterLogger(logBuf, false))
+	defer log.SetLogger(nil)
+	app1 := app.App{Name: "skyrim", Plan: app.Plan{CpuShare: 1024}}
+	err := s.storage.Apps().Insert(app1)
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": app1.Name})
+	app2 := app.App{Name: "oblivion", Plan: app.Plan{CpuShare: 512}}
+	err = s.storage.Apps().Insert(app2)
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": app2.Name})
+	segSched := segregatedScheduler{provisioner: s.p}
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234", Metadata: map[string]string{"cpus": "2"}},
+		{Address: "http://server2:1234", Metadata: map[string]string{"cpus": "1"}},
+	}
+	contColl := s.p.Collection()
+	defer contColl.Close()
+	defer contColl.RemoveAll(bson.M{"appname": "skyrim"})
+	err = contColl.Insert(container.Container{ID: "pre1", Name: "existingUnit1", AppName: "skyrim", HostAddr: "server2"})
+	c.Assert(err, check.IsNil)
+	filtered, err := segSched.filterByCPUUsage(&app2, nodes, 0, "cpus")
+	c.Assert(err, check.IsNil)
+	c.Assert(filtered, check.DeepEquals, nodes)
+	filtered, err = segSched.filterByCPUUsage(&app2, nodes, 1.0, "cpus")
+	c.Assert(err, check.IsNil)
+	c.Assert(filtered, check.DeepEquals, nodes[:1])
+	c.Assert(logBuf.String(), check.Matches, `(?s).*Node "server2" has reached its CPU limit. Limit 1024 shares. Reserved: 1024 shares. Needed additional 512 shares.*`)
+	err = contColl.Insert(container.Container{ID: "pre2", Name: "existingUnit2", AppName: "skyrim", HostAddr: "server1"})
+	c.Assert(err, check.IsNil)
+	err = contColl.Insert(container.Container{ID: "pre3", Name: "existingUnit3", AppName: "skyrim", HostAddr: "server1"})
+	c.Assert(err, check.IsNil)
+	filtered, err = segSched.filterByCPUUsage(&app2, nodes, 1.0, "cpus")
+	c.Assert(err, check.ErrorMatches, `no nodes found with enough CPU for container of "oblivion": 512 shares`)
+	c.Assert(filtered, check.IsNil)
+	filtered, err = segSched.filterByCPUUsage(&app2, nodes, 1.5, "cpus")
+	c.Assert(err, check.IsNil)
+	c.Assert(filtered, check.DeepEquals, nodes)
+}
+
+func (s *S) TestSchedulerFilterByCPUUsageWithAutoScale(c *check.C) {
+	config.Set("docker:auto-scale:enabled", true)
+	defer config.Unset("docker:auto-scale:enabled")
+	app1 := app.App{Name: "skyrim", Plan: app.Plan{CpuShare: 1024}}
+	err := s.storage.Apps().Insert(app1)
+	c.Assert(err, check.IsNil)
+	defer s.storage.Apps().Remove(bson.M{"name": app1.Name})
+	segSched := segregatedScheduler{provisioner: s.p}
+	nodes := []cluster.Node{
+		{Address: "http://server1:1234", Metadata: map[string]string{"cpus": "1"}},
+	}
+	contColl := s.p.Collection()
+	defer contColl.Close()
+	defer contColl.RemoveAll(bson.M{"appname": "skyrim"})
+	err = contColl.Insert(container.Container{ID: "pre1", Name: "existingUnit1", AppName: "skyrim", HostAddr: "server1"})
+	c.Assert(err, check.IsNil)
+	filtered, err := segSched.filterByCPUUsage(&app1, nodes, 1.0, "cpus")
+	c.Assert(err, check.IsNil)
+	c.Assert(filtered, check.DeepEquals, nodes)
+}
+
+func (s *S) TestNodeCPUShares(c *check.C) {
+	node := cluster.Node{Address: "http://server1:1234", Metadata: map[string]string{"cpus": "1.5"}}
+	shares, err := nodeCPUShares(&node, "cpus")
+	c.Assert(err, check.IsNil)
+	c.Assert(shares, check.Equals, int64(1536))
+}
+
 func (s *S) TestSchedulerScheduleWithMemoryAwarenessWithAutoScale(c *check.C) {
 	config.Set("docker:auto-scale:enabled", true)
 	defer config.Unset("docker:auto-scale:enabled")
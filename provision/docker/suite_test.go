@@ -5,6 +5,7 @@
 package docker
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"github.com/tsuru/tsuru/iaas"
 	"github.com/tsuru/tsuru/log"
 	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
 	"github.com/tsuru/tsuru/queue"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/repository/repositorytest"
@@ -61,6 +63,50 @@ type S struct {
 
 var _ = check.Suite(&S{})
 
+func init() {
+	base := &S{}
+	suite := &provisiontest.ProvisionerSuite{
+		ArchiveURL:    "https://s3.amazonaws.com/wat/archive.tar.gz",
+		UploadArchive: []byte("fake archive"),
+	}
+	suite.SetUpSuiteFunc = base.SetUpSuite
+	suite.SetUpTestFunc = func(c *check.C) {
+		base.SetUpTest(c)
+		err := base.newFakeImage(base.p, "tsuru/python:latest", nil)
+		c.Assert(err, check.IsNil)
+		base.server.CustomHandler("/containers/.*/wait", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"StatusCode":0}`))
+		}))
+		suite.Provisioner = base.p
+	}
+	suite.NewAppFunc = func(c *check.C, name string) provision.App {
+		a := &app.App{Name: name, Platform: "python", Quota: quota.Unlimited}
+		err := base.storage.Apps().Insert(a)
+		c.Assert(err, check.IsNil)
+		return a
+	}
+	suite.DeployFunc = func(c *check.C, a provision.App) string {
+		imageId, err := appNewImageName(a.GetName(), a.GetPool())
+		c.Assert(err, check.IsNil)
+		customData := map[string]interface{}{
+			"processes": map[string]interface{}{
+				"web":    "python web.py",
+				"worker": "python worker.py",
+			},
+		}
+		err = base.newFakeImage(base.p, imageId, customData)
+		c.Assert(err, check.IsNil)
+		err = appendAppImageName(a.GetName(), imageId)
+		c.Assert(err, check.IsNil)
+		imageId, err = base.p.ImageDeploy(a, imageId, ioutil.Discard)
+		c.Assert(err, check.IsNil)
+		return imageId
+	}
+	suite.TearDownTestFunc = base.TearDownTest
+	suite.TearDownSuiteFunc = base.TearDownSuite
+	check.Suite(suite)
+}
+
 func (s *S) SetUpSuite(c *check.C) {
 	s.collName = "docker_unit"
 	s.imageCollName = "docker_image"
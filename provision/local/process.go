@@ -0,0 +1,258 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/log"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2/bson"
+	"gopkg.in/yaml.v1"
+)
+
+const (
+	defaultRoot      = "/var/lib/tsuru/local"
+	defaultHost      = "127.0.0.1"
+	defaultPortStart = 10000
+)
+
+var errInvalidProcfile = errors.New("invalid Procfile")
+
+func rootDir() string {
+	root, err := config.GetString("local:root")
+	if err != nil {
+		root = defaultRoot
+	}
+	return root
+}
+
+func unitHost() string {
+	host, err := config.GetString("local:host")
+	if err != nil {
+		host = defaultHost
+	}
+	return host
+}
+
+func appDir(appName string) string {
+	return filepath.Join(rootDir(), appName)
+}
+
+func releaseDir(appName, version string) string {
+	return filepath.Join(appDir(appName), "releases", version)
+}
+
+func logFile(u *unit) string {
+	return filepath.Join(appDir(u.AppName), "logs", u.ID+".log")
+}
+
+func newUnitID() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", b), nil
+}
+
+// readProcfile returns the processes declared in the Procfile of the given
+// release.
+func readProcfile(appName, version string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(releaseDir(appName, version), "Procfile"))
+	if err != nil {
+		return nil, err
+	}
+	var processes map[string]string
+	err = yaml.Unmarshal(data, &processes)
+	if err != nil || len(processes) == 0 {
+		return nil, errInvalidProcfile
+	}
+	return processes, nil
+}
+
+// webProcessName returns the name of the process that receives requests,
+// which is the only process declared in the Procfile or the one named web.
+func webProcessName(processes map[string]string) string {
+	if len(processes) == 1 {
+		for name := range processes {
+			return name
+		}
+	}
+	return "web"
+}
+
+// processName returns the name of the process that should be used when the
+// user doesn't specify one, which is only possible when there's just one
+// process declared in the Procfile.
+func processName(processes map[string]string, name string) (string, error) {
+	if name == "" {
+		if len(processes) > 1 {
+			return "", provision.InvalidProcessError{Msg: "no process name specified and more than one declared in Procfile"}
+		}
+		for n := range processes {
+			name = n
+		}
+	}
+	if processes[name] == "" {
+		return "", provision.InvalidProcessError{Msg: fmt.Sprintf("no command declared in Procfile for process %q", name)}
+	}
+	return name, nil
+}
+
+// nextPorts returns n ports that are not used by any unit, starting from the
+// port configured in local:port-range-start.
+func nextPorts(n int) ([]int, error) {
+	start, err := config.GetInt("local:port-range-start")
+	if err != nil {
+		start = defaultPortStart
+	}
+	units, err := listUnits(bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[int]bool, len(units))
+	for _, u := range units {
+		used[u.Port] = true
+	}
+	ports := make([]int, 0, n)
+	for port := start; len(ports) < n; port++ {
+		if !used[port] {
+			ports = append(ports, port)
+		}
+	}
+	return ports, nil
+}
+
+func unitEnvs(a provision.App, u *unit) []string {
+	envs := []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+	}
+	for _, envData := range a.Envs() {
+		envs = append(envs, fmt.Sprintf("%s=%s", envData.Name, envData.Value))
+	}
+	host, _ := config.GetString("host")
+	port := strconv.Itoa(u.Port)
+	return append(envs, []string{
+		fmt.Sprintf("%s=%s", "TSURU_PROCESSNAME", u.ProcessName),
+		fmt.Sprintf("%s=%s", "port", port),
+		fmt.Sprintf("%s=%s", "PORT", port),
+		fmt.Sprintf("%s=%s", "TSURU_HOST", host),
+	}...)
+}
+
+// shellQuote quotes the given string to be used as a single word in a shell
+// command line.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// startUnit starts the process of the unit in background, in its own process
+// group, so it can be stopped along with all its children. The command runs
+// in a subshell, so lists and pipes declared in the Procfile are redirected
+// to the log file and stopped as a whole.
+func (p *localProvisioner) startUnit(a provision.App, u *unit) error {
+	processes, err := readProcfile(u.AppName, u.Release)
+	if err != nil {
+		return err
+	}
+	cmd := processes[u.ProcessName]
+	if cmd == "" {
+		return provision.InvalidProcessError{Msg: fmt.Sprintf("no command declared in Procfile for process %q", u.ProcessName)}
+	}
+	err = os.MkdirAll(filepath.Dir(logFile(u)), 0755)
+	if err != nil {
+		return err
+	}
+	var stdout, stderr bytes.Buffer
+	err = p.getExecutor().Execute(exec.ExecuteOptions{
+		Cmd:    "/bin/sh",
+		Args:   []string{"-c", fmt.Sprintf("set -m; ( %s ) >> %s 2>&1 & echo $!", cmd, shellQuote(logFile(u)))},
+		Envs:   unitEnvs(a, u),
+		Dir:    releaseDir(u.AppName, u.Release),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("unable to start unit %s: %s %s", u.ID, err, stderr.String())
+	}
+	u.Pid, err = strconv.Atoi(strings.TrimSpace(stdout.String()))
+	if err != nil {
+		return fmt.Errorf("unable to start unit %s: invalid pid %q", u.ID, stdout.String())
+	}
+	u.Status = provision.StatusStarted.String()
+	return saveUnit(u)
+}
+
+// stopUnit kills the process group of the unit. Units whose process is
+// already gone are stopped without errors.
+func (p *localProvisioner) stopUnit(u *unit) error {
+	if u.Pid > 0 {
+		err := p.getExecutor().Execute(exec.ExecuteOptions{
+			Cmd:    "kill",
+			Args:   []string{"-TERM", "--", fmt.Sprintf("-%d", u.Pid)},
+			Stdout: ioutil.Discard,
+			Stderr: ioutil.Discard,
+		})
+		if err != nil {
+			log.Errorf("[local] unable to kill process %d of unit %s: %s", u.Pid, u.ID, err)
+		}
+	}
+	u.Pid = 0
+	u.Status = provision.StatusStopped.String()
+	return saveUnit(u)
+}
+
+// refreshUnitsStatus marks the units whose process group is gone, because
+// the process crashed or was killed outside of tsuru, with the error status.
+func (p *localProvisioner) refreshUnitsStatus(units []unit) error {
+	for i := range units {
+		u := &units[i]
+		if u.Pid <= 0 {
+			continue
+		}
+		err := p.getExecutor().Execute(exec.ExecuteOptions{
+			Cmd:    "kill",
+			Args:   []string{"-0", "--", fmt.Sprintf("-%d", u.Pid)},
+			Stdout: ioutil.Discard,
+			Stderr: ioutil.Discard,
+		})
+		if err == nil {
+			continue
+		}
+		log.Errorf("[local] process %d of unit %s is gone", u.Pid, u.ID)
+		u.Pid = 0
+		u.Status = provision.StatusError.String()
+		err = saveUnit(u)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) runInUnit(u *unit, a provision.App, stdout, stderr io.Writer, cmd string, args ...string) error {
+	cmds := append([]string{cmd}, args...)
+	return p.getExecutor().Execute(exec.ExecuteOptions{
+		Cmd:    "/bin/sh",
+		Args:   []string{"-c", strings.Join(cmds, " ")},
+		Envs:   unitEnvs(a, u),
+		Dir:    releaseDir(u.AppName, u.Release),
+		Stdout: stdout,
+		Stderr: stderr,
+	})
+}
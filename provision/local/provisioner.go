@@ -0,0 +1,562 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package local provides a provisioner that runs the processes declared in
+// the Procfile of apps as plain processes in the machine running tsurud.
+//
+// Apps are not isolated from each other nor from tsurud, so this provisioner
+// is intended for development and CI environments, where tsuru must run apps
+// end-to-end without a Docker daemon. It can be used by setting the
+// provisioner to "local" in the configuration file:
+//
+//     provisioner: local
+//     local:
+//       root: /var/lib/tsuru/local
+//       host: 127.0.0.1
+//       port-range-start: 10000
+package local
+
+import (
+	stderr "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/tsuru/tsuru/errors"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router"
+	_ "github.com/tsuru/tsuru/router/galeb"
+	_ "github.com/tsuru/tsuru/router/galebv2"
+	_ "github.com/tsuru/tsuru/router/hipache"
+	_ "github.com/tsuru/tsuru/router/routertest"
+	_ "github.com/tsuru/tsuru/router/vulcand"
+)
+
+var (
+	errNotDeployed       = stderr.New("New units can only be added after the first deployment")
+	errShellNotSupported = stderr.New("the local provisioner does not support remote shells")
+	errInvalidRelease    = stderr.New("invalid release for app")
+)
+
+func init() {
+	provision.Register("local", &localProvisioner{})
+}
+
+type localProvisioner struct {
+	executor exec.Executor
+	// portsMut protects the allocation of ports, which happens between
+	// listing the ports in use and saving the new units.
+	portsMut sync.Mutex
+}
+
+func (p *localProvisioner) getExecutor() exec.Executor {
+	if p.executor == nil {
+		p.executor = exec.OsExecutor{}
+	}
+	return p.executor
+}
+
+func getRouterForApp(app provision.App) (router.Router, error) {
+	routerName, err := app.GetRouter()
+	if err != nil {
+		return nil, err
+	}
+	return router.Get(routerName)
+}
+
+func (p *localProvisioner) Provision(app provision.App) error {
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return err
+	}
+	return r.AddBackend(app.GetName())
+}
+
+func (p *localProvisioner) Destroy(app provision.App) error {
+	units, err := listUnitsByProcess(app.GetName(), "")
+	if err != nil {
+		return err
+	}
+	for i := range units {
+		err = p.stopUnit(&units[i])
+		if err != nil {
+			return err
+		}
+		err = removeUnit(units[i].ID)
+		if err != nil {
+			return err
+		}
+	}
+	err = removeReleases(app.GetName())
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(appDir(app.GetName()))
+	if err != nil {
+		return err
+	}
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return err
+	}
+	return r.RemoveBackend(app.GetName())
+}
+
+// addUnits creates and starts the given number of units of each process, using
+// the given release, adding the units of the web process to the router.
+func (p *localProvisioner) addUnits(app provision.App, version string, toAdd map[string]int, w io.Writer) ([]unit, error) {
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return nil, err
+	}
+	processes, err := readProcfile(app.GetName(), version)
+	if err != nil {
+		return nil, err
+	}
+	webProcess := webProcessName(processes)
+	total := 0
+	for _, n := range toAdd {
+		total += n
+	}
+	p.portsMut.Lock()
+	defer p.portsMut.Unlock()
+	ports, err := nextPorts(total)
+	if err != nil {
+		return nil, err
+	}
+	added := make([]unit, 0, total)
+	for process, n := range toAdd {
+		fmt.Fprintf(w, "\n---- Starting %d new %s [%s] ----\n", n, pluralize("unit", n), process)
+		for i := 0; i < n; i++ {
+			id, err := newUnitID()
+			if err != nil {
+				p.rollbackUnits(r, added)
+				return nil, err
+			}
+			u := unit{
+				ID:          id,
+				AppName:     app.GetName(),
+				ProcessName: process,
+				Release:     version,
+				Port:        ports[len(added)],
+				Status:      provision.StatusCreated.String(),
+			}
+			err = p.startUnit(app, &u)
+			if err != nil {
+				p.rollbackUnits(r, added)
+				return nil, err
+			}
+			added = append(added, u)
+			if process == webProcess {
+				err = r.AddRoute(app.GetName(), u.address())
+				if err != nil {
+					p.rollbackUnits(r, added)
+					return nil, err
+				}
+			}
+			fmt.Fprintf(w, " ---> Started unit %s [%s]\n", u.ID, process)
+		}
+	}
+	return added, nil
+}
+
+func (p *localProvisioner) rollbackUnits(r router.Router, units []unit) {
+	for i := range units {
+		p.removeUnit(r, &units[i])
+	}
+}
+
+func (p *localProvisioner) removeUnit(r router.Router, u *unit) error {
+	err := r.RemoveRoute(u.AppName, u.address())
+	if err != nil && err != router.ErrRouteNotFound {
+		return err
+	}
+	err = p.stopUnit(u)
+	if err != nil {
+		return err
+	}
+	return removeUnit(u.ID)
+}
+
+func (p *localProvisioner) AddUnits(app provision.App, n uint, process string, w io.Writer) ([]provision.Unit, error) {
+	if n == 0 {
+		return nil, stderr.New("Cannot add 0 units")
+	}
+	if w == nil {
+		w = ioutil.Discard
+	}
+	releases, err := getReleases(app.GetName())
+	if err != nil {
+		return nil, err
+	}
+	if releases.Current == "" {
+		return nil, errNotDeployed
+	}
+	processes, err := readProcfile(app.GetName(), releases.Current)
+	if err != nil {
+		return nil, err
+	}
+	process, err = processName(processes, process)
+	if err != nil {
+		return nil, err
+	}
+	added, err := p.addUnits(app, releases.Current, map[string]int{process: int(n)}, w)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]provision.Unit, len(added))
+	for i := range added {
+		result[i] = added[i].asUnit(app)
+	}
+	return result, nil
+}
+
+func (p *localProvisioner) RemoveUnits(app provision.App, n uint, process string, w io.Writer) error {
+	if n == 0 {
+		return stderr.New("cannot remove 0 units")
+	}
+	units, err := listUnitsByProcess(app.GetName(), process)
+	if err != nil {
+		return err
+	}
+	if len(units) < int(n) {
+		return fmt.Errorf("cannot remove %d units from process %q, only %d available", n, process, len(units))
+	}
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(n); i++ {
+		err = p.removeUnit(r, &units[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) SetUnitStatus(u provision.Unit, status provision.Status) error {
+	localUnit, err := getUnit(u.ID)
+	if err != nil {
+		return err
+	}
+	if u.AppName != "" && localUnit.AppName != u.AppName {
+		return stderr.New("wrong app name")
+	}
+	localUnit.Status = status.String()
+	return saveUnit(localUnit)
+}
+
+func (p *localProvisioner) RegisterUnit(u provision.Unit, customData map[string]interface{}) error {
+	localUnit, err := getUnit(u.ID)
+	if err != nil {
+		return err
+	}
+	localUnit.Status = provision.StatusStarted.String()
+	return saveUnit(localUnit)
+}
+
+func (p *localProvisioner) ExecuteCommandOnce(stdout, stderr io.Writer, app provision.App, cmd string, args ...string) error {
+	units, err := listUnitsByProcess(app.GetName(), "")
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return provision.ErrEmptyApp
+	}
+	return p.runInUnit(&units[0], app, stdout, stderr, cmd, args...)
+}
+
+func (p *localProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision.App, cmd string, args ...string) error {
+	units, err := listUnitsByProcess(app.GetName(), "")
+	if err != nil {
+		return err
+	}
+	if len(units) == 0 {
+		return provision.ErrEmptyApp
+	}
+	for i := range units {
+		err = p.runInUnit(&units[i], app, stdout, stderr, cmd, args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) Restart(app provision.App, process string, w io.Writer) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	units, err := listUnitsByProcess(app.GetName(), process)
+	if err != nil {
+		return err
+	}
+	for i := range units {
+		u := &units[i]
+		fmt.Fprintf(w, " ---> Restarting unit %s [%s]\n", u.ID, u.ProcessName)
+		err = p.stopUnit(u)
+		if err != nil {
+			return err
+		}
+		err = p.startUnit(app, u)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) Start(app provision.App, process string) error {
+	units, err := listUnitsByProcess(app.GetName(), process)
+	if err != nil {
+		return err
+	}
+	err = p.refreshUnitsStatus(units)
+	if err != nil {
+		return err
+	}
+	for i := range units {
+		if units[i].Pid > 0 {
+			continue
+		}
+		err = p.startUnit(app, &units[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) Stop(app provision.App, process string) error {
+	units, err := listUnitsByProcess(app.GetName(), process)
+	if err != nil {
+		return err
+	}
+	for i := range units {
+		err = p.stopUnit(&units[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *localProvisioner) Addr(app provision.App) (string, error) {
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return "", err
+	}
+	return r.Addr(app.GetName())
+}
+
+func (p *localProvisioner) Swap(app1, app2 provision.App) error {
+	r, err := getRouterForApp(app1)
+	if err != nil {
+		return err
+	}
+	return r.Swap(app1.GetName(), app2.GetName())
+}
+
+func (p *localProvisioner) Units(app provision.App) ([]provision.Unit, error) {
+	units, err := listUnitsByProcess(app.GetName(), "")
+	if err != nil {
+		return nil, err
+	}
+	err = p.refreshUnitsStatus(units)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]provision.Unit, len(units))
+	for i := range units {
+		result[i] = units[i].asUnit(app)
+	}
+	return result, nil
+}
+
+// RoutableUnits returns the units of the web process of the release each unit
+// is running.
+func (p *localProvisioner) RoutableUnits(app provision.App) ([]provision.Unit, error) {
+	units, err := listUnitsByProcess(app.GetName(), "")
+	if err != nil {
+		return nil, err
+	}
+	err = p.refreshUnitsStatus(units)
+	if err != nil {
+		return nil, err
+	}
+	webProcesses := make(map[string]string)
+	var result []provision.Unit
+	for i := range units {
+		webProcess, ok := webProcesses[units[i].Release]
+		if !ok {
+			processes, err := readProcfile(app.GetName(), units[i].Release)
+			if err != nil {
+				return nil, err
+			}
+			webProcess = webProcessName(processes)
+			webProcesses[units[i].Release] = webProcess
+		}
+		if units[i].ProcessName == webProcess {
+			result = append(result, units[i].asUnit(app))
+		}
+	}
+	return result, nil
+}
+
+func (p *localProvisioner) Shell(opts provision.ShellOptions) error {
+	return errShellNotSupported
+}
+
+func (p *localProvisioner) ValidAppImages(appName string) ([]string, error) {
+	releases, err := getReleases(appName)
+	if err != nil {
+		return nil, err
+	}
+	return releases.Versions, nil
+}
+
+func (p *localProvisioner) MetricEnvs(app provision.App) map[string]string {
+	return map[string]string{}
+}
+
+func (p *localProvisioner) UploadDeploy(app provision.App, archiveFile io.ReadCloser, w io.Writer) (string, error) {
+	defer archiveFile.Close()
+	version, err := p.extractRelease(app, archiveFile, w)
+	if err != nil {
+		return "", err
+	}
+	return version, p.deployRelease(app, version, w)
+}
+
+func (p *localProvisioner) ArchiveDeploy(app provision.App, archiveURL string, w io.Writer) (string, error) {
+	resp, err := http.Get(archiveURL)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unable to download the archive: unexpected status %d", resp.StatusCode)
+	}
+	version, err := p.extractRelease(app, resp.Body, w)
+	if err != nil {
+		return "", err
+	}
+	return version, p.deployRelease(app, version, w)
+}
+
+// ImageDeploy deploys a release previously deployed for the app, which is the
+// way rollbacks work in this provisioner.
+func (p *localProvisioner) ImageDeploy(app provision.App, version string, w io.Writer) (string, error) {
+	releases, err := getReleases(app.GetName())
+	if err != nil {
+		return "", err
+	}
+	if !releases.has(version) {
+		return "", errInvalidRelease
+	}
+	return version, p.deployRelease(app, version, w)
+}
+
+// extractRelease creates a new release for the app, extracting the given
+// tar.gz archive into its directory.
+func (p *localProvisioner) extractRelease(app provision.App, archive io.Reader, w io.Writer) (string, error) {
+	releases, err := getReleases(app.GetName())
+	if err != nil {
+		return "", err
+	}
+	version := fmt.Sprintf("v%d", len(releases.Versions)+1)
+	dir := releaseDir(app.GetName(), version)
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	err = p.getExecutor().Execute(exec.ExecuteOptions{
+		Cmd:    "tar",
+		Args:   []string{"-xzf", "-", "-C", dir},
+		Stdin:  archive,
+		Stdout: w,
+		Stderr: w,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	return version, addRelease(app.GetName(), version)
+}
+
+// deployRelease replaces all the units of the app with units running the
+// given release. Each process keeps its number of units, processes without
+// units get one unit and processes removed from the Procfile are removed.
+func (p *localProvisioner) deployRelease(app provision.App, version string, w io.Writer) error {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	processes, err := readProcfile(app.GetName(), version)
+	if err != nil {
+		return err
+	}
+	oldUnits, err := listUnitsByProcess(app.GetName(), "")
+	if err != nil {
+		return err
+	}
+	toAdd := make(map[string]int, len(processes))
+	for process := range processes {
+		toAdd[process] = 0
+	}
+	for _, u := range oldUnits {
+		if _, ok := toAdd[u.ProcessName]; ok {
+			toAdd[u.ProcessName]++
+		}
+	}
+	total := 0
+	for process, n := range toAdd {
+		if n == 0 {
+			toAdd[process] = 1
+		}
+		total += toAdd[process]
+	}
+	if quota := app.GetQuota(); !quota.Unlimited() {
+		err = app.SetQuotaInUse(total)
+		if err != nil {
+			return &errors.CompositeError{
+				Base:    err,
+				Message: "Cannot start application units",
+			}
+		}
+	}
+	_, err = p.addUnits(app, version, toAdd, w)
+	if err != nil {
+		return err
+	}
+	err = setCurrentRelease(app.GetName(), version)
+	if err != nil {
+		return err
+	}
+	r, err := getRouterForApp(app)
+	if err != nil {
+		return err
+	}
+	if len(oldUnits) > 0 {
+		fmt.Fprintf(w, "\n---- Removing %d old %s ----\n", len(oldUnits), pluralize("unit", len(oldUnits)))
+	}
+	for i := range oldUnits {
+		err = p.removeUnit(r, &oldUnits[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func pluralize(str string, sz int) string {
+	if sz == 0 || sz > 1 {
+		str = str + "s"
+	}
+	return str
+}
@@ -0,0 +1,350 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/exec/exectest"
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func buildArchive(c *check.C, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for name, content := range files {
+		err := tarWriter.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content))})
+		c.Assert(err, check.IsNil)
+		_, err = tarWriter.Write([]byte(content))
+		c.Assert(err, check.IsNil)
+	}
+	c.Assert(tarWriter.Close(), check.IsNil)
+	c.Assert(gzipWriter.Close(), check.IsNil)
+	return buf.Bytes()
+}
+
+// createRelease creates a release for the app without running any command,
+// so tests can use the fake executor.
+func (s *S) createRelease(c *check.C, appName, version, procfile string) {
+	dir := releaseDir(appName, version)
+	err := os.MkdirAll(dir, 0755)
+	c.Assert(err, check.IsNil)
+	err = ioutil.WriteFile(filepath.Join(dir, "Procfile"), []byte(procfile), 0644)
+	c.Assert(err, check.IsNil)
+	err = addRelease(appName, version)
+	c.Assert(err, check.IsNil)
+	err = setCurrentRelease(appName, version)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) TestShouldBeRegistered(c *check.C) {
+	p, err := provision.Get("local")
+	c.Assert(err, check.IsNil)
+	c.Assert(p, check.FitsTypeOf, &localProvisioner{})
+}
+
+func (s *S) TestProvision(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	err := s.p.Provision(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasBackend("myapp"), check.Equals, true)
+}
+
+func (s *S) TestAddUnits(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py")
+	units, err := s.p.AddUnits(a, 2, "", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	for _, u := range units {
+		c.Assert(u.ProcessName, check.Equals, "web")
+		c.Assert(u.Status, check.Equals, provision.StatusStarted)
+		c.Assert(u.Ip, check.Equals, "127.0.0.1")
+		c.Assert(routertest.FakeRouter.HasRoute("myapp", u.Address.String()), check.Equals, true)
+	}
+	c.Assert(units[0].Address.String(), check.Equals, "http://127.0.0.1:19000")
+	c.Assert(units[1].Address.String(), check.Equals, "http://127.0.0.1:19001")
+	cmds := s.exec.GetCommands("/bin/sh")
+	c.Assert(cmds, check.HasLen, 2)
+	logFile := filepath.Join(s.root, "myapp", "logs", units[0].ID+".log")
+	c.Assert(cmds[0].GetArgs(), check.DeepEquals, []string{"-c", "set -m; ( python app.py ) >> '" + logFile + "' 2>&1 & echo $!"})
+	c.Assert(cmds[0].GetDir(), check.Equals, releaseDir("myapp", "v1"))
+	c.Assert(cmds[0].GetEnvs(), check.DeepEquals, []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + os.Getenv("HOME"),
+		"TSURU_PROCESSNAME=web",
+		"port=19000",
+		"PORT=19000",
+		"TSURU_HOST=",
+	})
+	localUnit, err := getUnit(units[0].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(localUnit.Pid, check.Equals, 1234)
+	c.Assert(localUnit.Release, check.Equals, "v1")
+}
+
+func (s *S) TestAddUnitsOnlyRoutesWebProcess(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py\nworker: python worker.py")
+	web, err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	worker, err := s.p.AddUnits(a, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(routertest.FakeRouter.HasRoute("myapp", web[0].Address.String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute("myapp", worker[0].Address.String()), check.Equals, false)
+	routable, err := s.p.RoutableUnits(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(routable, check.HasLen, 1)
+	c.Assert(routable[0].ID, check.Equals, web[0].ID)
+}
+
+func (s *S) TestWebProcessName(c *check.C) {
+	c.Assert(webProcessName(map[string]string{"worker": "python worker.py"}), check.Equals, "worker")
+	c.Assert(webProcessName(map[string]string{"web": "python app.py", "worker": "python worker.py"}), check.Equals, "web")
+}
+
+func (s *S) TestAddUnitsNotDeployed(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	_, err := s.p.AddUnits(a, 1, "", nil)
+	c.Assert(err, check.Equals, errNotDeployed)
+}
+
+func (s *S) TestAddUnitsInvalidProcess(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py\nworker: python worker.py")
+	_, err := s.p.AddUnits(a, 1, "", nil)
+	c.Assert(err, check.FitsTypeOf, provision.InvalidProcessError{})
+	_, err = s.p.AddUnits(a, 1, "cron", nil)
+	c.Assert(err, check.ErrorMatches, `process error: no command declared in Procfile for process "cron"`)
+}
+
+func (s *S) TestRemoveUnits(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py")
+	units, err := s.p.AddUnits(a, 3, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.RemoveUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	remaining, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(remaining, check.HasLen, 1)
+	removed := 0
+	for _, u := range units {
+		if !routertest.FakeRouter.HasRoute("myapp", u.Address.String()) {
+			removed++
+		}
+	}
+	c.Assert(removed, check.Equals, 2)
+	c.Assert(s.exec.ExecutedCmd("kill", []string{"-TERM", "--", "-1234"}), check.Equals, true)
+	err = s.p.RemoveUnits(a, 2, "web", nil)
+	c.Assert(err, check.ErrorMatches, `cannot remove 2 units from process "web", only 1 available`)
+}
+
+func (s *S) TestStopAndStart(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py")
+	units, err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Stop(a, "")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.exec.ExecutedCmd("kill", []string{"-TERM", "--", "-1234"}), check.Equals, true)
+	localUnit, err := getUnit(units[0].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(localUnit.Status, check.Equals, provision.StatusStopped.String())
+	c.Assert(localUnit.Pid, check.Equals, 0)
+	err = s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	localUnit, err = getUnit(units[0].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(localUnit.Status, check.Equals, provision.StatusStarted.String())
+	c.Assert(localUnit.Pid, check.Equals, 1234)
+	c.Assert(s.exec.GetCommands("/bin/sh"), check.HasLen, 2)
+}
+
+func (s *S) TestUnitsProcessGone(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py")
+	_, err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	c.Assert(units[0].Status, check.Equals, provision.StatusStarted)
+	c.Assert(s.exec.ExecutedCmd("kill", []string{"-0", "--", "-1234"}), check.Equals, true)
+	s.p.executor = &exectest.ErrorExecutor{}
+	units, err = s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	c.Assert(units[0].Status, check.Equals, provision.StatusError)
+	localUnit, err := getUnit(units[0].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(localUnit.Pid, check.Equals, 0)
+	s.p.executor = s.exec
+	err = s.p.Start(a, "")
+	c.Assert(err, check.IsNil)
+	localUnit, err = getUnit(units[0].ID)
+	c.Assert(err, check.IsNil)
+	c.Assert(localUnit.Status, check.Equals, provision.StatusStarted.String())
+	c.Assert(localUnit.Pid, check.Equals, 1234)
+}
+
+func (s *S) TestShellQuote(c *check.C) {
+	c.Assert(shellQuote("/var/log/my app.log"), check.Equals, "'/var/log/my app.log'")
+	c.Assert(shellQuote("it's"), check.Equals, `'it'\''s'`)
+}
+
+func (s *S) TestRestart(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py\nworker: python worker.py")
+	_, err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	_, err = s.p.AddUnits(a, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	var buf bytes.Buffer
+	err = s.p.Restart(a, "worker", &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(buf.String(), check.Matches, `(?s) ---> Restarting unit \w+ \[worker\].*`)
+	c.Assert(s.exec.GetCommands("kill"), check.HasLen, 1)
+	c.Assert(s.exec.GetCommands("/bin/sh"), check.HasLen, 3)
+}
+
+func (s *S) TestExecuteCommand(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py")
+	_, err := s.p.AddUnits(a, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	var stdout, stderr bytes.Buffer
+	err = s.p.ExecuteCommand(&stdout, &stderr, a, "ls", "-l")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.exec.ExecutedCmd("/bin/sh", []string{"-c", "ls -l"}), check.Equals, true)
+	c.Assert(s.exec.GetCommands("/bin/sh"), check.HasLen, 4)
+	err = s.p.ExecuteCommandOnce(&stdout, &stderr, a, "ls", "-l")
+	c.Assert(err, check.IsNil)
+	c.Assert(s.exec.GetCommands("/bin/sh"), check.HasLen, 5)
+}
+
+func (s *S) TestExecuteCommandNoUnits(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	err := s.p.ExecuteCommand(nil, nil, a, "ls")
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+	err = s.p.ExecuteCommandOnce(nil, nil, a, "ls")
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+}
+
+func (s *S) TestSetUnitStatus(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py")
+	units, err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.SetUnitStatus(units[0], provision.StatusError)
+	c.Assert(err, check.IsNil)
+	units, err = s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units[0].Status, check.Equals, provision.StatusError)
+	err = s.p.SetUnitStatus(provision.Unit{ID: "unknown"}, provision.StatusError)
+	c.Assert(err, check.Equals, provision.ErrUnitNotFound)
+}
+
+func (s *S) TestImageDeployInvalidRelease(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	_, err := s.p.ImageDeploy(a, "v1", nil)
+	c.Assert(err, check.Equals, errInvalidRelease)
+}
+
+func (s *S) TestUploadDeploy(c *check.C) {
+	s.p.executor = exec.OsExecutor{}
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	defer s.p.Destroy(a)
+	archive := buildArchive(c, map[string]string{"Procfile": "web: sleep 30\nworker: sleep 30"})
+	var buf bytes.Buffer
+	version, err := s.p.UploadDeploy(a, ioutil.NopCloser(bytes.NewReader(archive)), &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(version, check.Equals, "v1")
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	for _, u := range units {
+		c.Assert(u.Status, check.Equals, provision.StatusStarted)
+		c.Assert(routertest.FakeRouter.HasRoute("myapp", u.Address.String()), check.Equals, true)
+	}
+	_, err = s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	archive = buildArchive(c, map[string]string{"Procfile": "web: sleep 30"})
+	version, err = s.p.UploadDeploy(a, ioutil.NopCloser(bytes.NewReader(archive)), &buf)
+	c.Assert(err, check.IsNil)
+	c.Assert(version, check.Equals, "v2")
+	localUnits, err := listUnitsByProcess(a.GetName(), "")
+	c.Assert(err, check.IsNil)
+	c.Assert(localUnits, check.HasLen, 2)
+	for _, u := range localUnits {
+		c.Assert(u.ProcessName, check.Equals, "web")
+		c.Assert(u.Release, check.Equals, "v2")
+		c.Assert(u.Pid > 0, check.Equals, true)
+	}
+	images, err := s.p.ValidAppImages(a.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(images, check.DeepEquals, []string{"v1", "v2"})
+	routes, err := routertest.FakeRouter.Routes("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.HasLen, 2)
+}
+
+func (s *S) TestArchiveDeploy(c *check.C) {
+	s.p.executor = exec.OsExecutor{}
+	archive := buildArchive(c, map[string]string{"Procfile": "web: sleep 30"})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive)
+	}))
+	defer server.Close()
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	defer s.p.Destroy(a)
+	version, err := s.p.ArchiveDeploy(a, server.URL, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(version, check.Equals, "v1")
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+}
+
+func (s *S) TestDestroy(c *check.C) {
+	a := provisiontest.NewFakeApp("myapp", "python", 0)
+	s.p.Provision(a)
+	s.createRelease(c, a.GetName(), "v1", "web: python app.py")
+	_, err := s.p.AddUnits(a, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	err = s.p.Destroy(a)
+	c.Assert(err, check.IsNil)
+	units, err := s.p.Units(a)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+	c.Assert(routertest.FakeRouter.HasBackend("myapp"), check.Equals, false)
+	_, err = os.Stat(appDir("myapp"))
+	c.Assert(os.IsNotExist(err), check.Equals, true)
+	images, err := s.p.ValidAppImages("myapp")
+	c.Assert(err, check.IsNil)
+	c.Assert(images, check.HasLen, 0)
+}
@@ -0,0 +1,180 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/storage"
+	"github.com/tsuru/tsuru/provision"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const defaultCollection = "local"
+
+// unit is a process declared in the Procfile of an app, running as a plain
+// process in the machine running tsurud.
+type unit struct {
+	ID          string `bson:"_id"`
+	AppName     string
+	ProcessName string
+	Release     string
+	Pid         int
+	Port        int
+	Status      string
+}
+
+func (u *unit) address() *url.URL {
+	return &url.URL{
+		Scheme: "http",
+		Host:   fmt.Sprintf("%s:%d", unitHost(), u.Port),
+	}
+}
+
+func (u *unit) asUnit(a provision.App) provision.Unit {
+	return provision.Unit{
+		ID:          u.ID,
+		AppName:     u.AppName,
+		ProcessName: u.ProcessName,
+		Type:        a.GetPlatform(),
+		Ip:          unitHost(),
+		Status:      provision.Status(u.Status),
+		Address:     u.address(),
+	}
+}
+
+// appReleases stores the releases deployed for an app. Each release is a
+// directory containing the code of the app, Current is the one units run.
+type appReleases struct {
+	AppName  string `bson:"_id"`
+	Current  string
+	Versions []string
+}
+
+func (r *appReleases) has(version string) bool {
+	for _, v := range r.Versions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func collection(suffix string) (*storage.Collection, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	name, err := config.GetString("local:collection")
+	if err != nil {
+		name = defaultCollection
+	}
+	return conn.Collection(fmt.Sprintf("%s_%s", name, suffix)), nil
+}
+
+func listUnits(query bson.M) ([]unit, error) {
+	coll, err := collection("units")
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var units []unit
+	err = coll.Find(query).All(&units)
+	return units, err
+}
+
+func listUnitsByProcess(appName, processName string) ([]unit, error) {
+	query := bson.M{"appname": appName}
+	if processName != "" {
+		query["processname"] = processName
+	}
+	return listUnits(query)
+}
+
+func getUnit(id string) (*unit, error) {
+	coll, err := collection("units")
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	var u unit
+	err = coll.FindId(id).One(&u)
+	if err == mgo.ErrNotFound {
+		return nil, provision.ErrUnitNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func saveUnit(u *unit) error {
+	coll, err := collection("units")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(u.ID, u)
+	return err
+}
+
+func removeUnit(id string) error {
+	coll, err := collection("units")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.RemoveId(id)
+}
+
+func getReleases(appName string) (*appReleases, error) {
+	coll, err := collection("releases")
+	if err != nil {
+		return nil, err
+	}
+	defer coll.Close()
+	releases := appReleases{AppName: appName}
+	err = coll.FindId(appName).One(&releases)
+	if err != nil && err != mgo.ErrNotFound {
+		return nil, err
+	}
+	return &releases, nil
+}
+
+func addRelease(appName, version string) error {
+	coll, err := collection("releases")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	_, err = coll.UpsertId(appName, bson.M{"$push": bson.M{"versions": version}})
+	return err
+}
+
+func setCurrentRelease(appName, version string) error {
+	coll, err := collection("releases")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	return coll.UpdateId(appName, bson.M{"$set": bson.M{"current": version}})
+}
+
+func removeReleases(appName string) error {
+	coll, err := collection("releases")
+	if err != nil {
+		return err
+	}
+	defer coll.Close()
+	err = coll.RemoveId(appName)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
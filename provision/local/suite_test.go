@@ -0,0 +1,89 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/tsuru/config"
+	"github.com/tsuru/tsuru/db"
+	"github.com/tsuru/tsuru/db/dbtest"
+	"github.com/tsuru/tsuru/exec"
+	"github.com/tsuru/tsuru/exec/exectest"
+	"github.com/tsuru/tsuru/provision/provisiontest"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type S struct {
+	conn *db.Storage
+	root string
+	p    *localProvisioner
+	exec *exectest.FakeExecutor
+}
+
+var _ = check.Suite(&S{})
+
+func init() {
+	base := &S{}
+	suite := &provisiontest.ProvisionerSuite{}
+	var server *httptest.Server
+	suite.SetUpSuiteFunc = func(c *check.C) {
+		base.SetUpSuite(c)
+		suite.UploadArchive = buildArchive(c, map[string]string{"Procfile": "web: sleep 30\nworker: sleep 30"})
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(suite.UploadArchive)
+		}))
+		suite.ArchiveURL = server.URL
+	}
+	suite.SetUpTestFunc = func(c *check.C) {
+		base.SetUpTest(c)
+		base.p.executor = exec.OsExecutor{}
+		suite.Provisioner = base.p
+	}
+	suite.TearDownTestFunc = base.TearDownTest
+	suite.TearDownSuiteFunc = func(c *check.C) {
+		server.Close()
+		base.TearDownSuite(c)
+	}
+	check.Suite(suite)
+}
+
+func (s *S) SetUpSuite(c *check.C) {
+	config.Set("database:url", "127.0.0.1:27017")
+	config.Set("database:name", "local_provision_tests")
+	config.Set("local:port-range-start", 19000)
+	var err error
+	s.conn, err = db.Conn()
+	c.Assert(err, check.IsNil)
+}
+
+func (s *S) SetUpTest(c *check.C) {
+	var err error
+	s.root, err = ioutil.TempDir("", "tsuru-local")
+	c.Assert(err, check.IsNil)
+	config.Set("local:root", s.root)
+	s.exec = &exectest.FakeExecutor{Output: map[string][][]byte{"*": {[]byte("1234\n")}}}
+	s.p = &localProvisioner{executor: s.exec}
+	routertest.FakeRouter.Reset()
+}
+
+func (s *S) TearDownTest(c *check.C) {
+	os.RemoveAll(s.root)
+	dbtest.ClearAllCollections(s.conn.Apps().Database)
+}
+
+func (s *S) TearDownSuite(c *check.C) {
+	s.conn.Apps().Database.DropDatabase()
+	s.conn.Close()
+	config.Unset("local:root")
+	config.Unset("local:port-range-start")
+}
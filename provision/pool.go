@@ -8,6 +8,7 @@ import (
 	"errors"
 
 	"github.com/tsuru/tsuru/db"
+	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
@@ -16,10 +17,22 @@ type Pool struct {
 	Teams   []string
 	Public  bool
 	Default bool
+	Quotas  []PoolQuota
+}
+
+// PoolQuota limits the resources used by the apps of a team in a pool. Memory
+// and CpuShare limit the sum of the memory and CPU share of the plans of every
+// unit, while Units limits the number of units. Zero means no limit.
+type PoolQuota struct {
+	Team     string
+	Memory   int64
+	CpuShare int
+	Units    int
 }
 
 var ErrPublicDefaultPollCantHaveTeams = errors.New("Public/Default pool can't have teams.")
 var ErrDefaultPoolAlreadyExists = errors.New("Default pool already exists.")
+var ErrPoolNotFound = errors.New("Pool does not exist.")
 
 const poolCollection = "pool"
 
@@ -139,6 +152,77 @@ func PoolUpdate(poolName string, query bson.M, forceDefault bool) error {
 	return conn.Collection(poolCollection).UpdateId(poolName, bson.M{"$set": query})
 }
 
+// GetPool returns the pool with the given name.
+func GetPool(name string) (*Pool, error) {
+	conn, err := db.Conn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var pool Pool
+	err = conn.Collection(poolCollection).FindId(name).One(&pool)
+	if err == mgo.ErrNotFound {
+		return nil, ErrPoolNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pool, nil
+}
+
+// QuotaForTeam returns the quota of the given team in the pool, or nil if the
+// team has no quota in it.
+func (p *Pool) QuotaForTeam(team string) *PoolQuota {
+	for i := range p.Quotas {
+		if p.Quotas[i].Team == team {
+			return &p.Quotas[i]
+		}
+	}
+	return nil
+}
+
+// SetPoolQuota defines the quota of a team in the pool, replacing the current
+// quota of the team, if any.
+func SetPoolQuota(poolName string, quota PoolQuota) error {
+	if quota.Team == "" {
+		return errors.New("Team name is required.")
+	}
+	if quota.Memory < 0 || quota.CpuShare < 0 || quota.Units < 0 {
+		return errors.New("Quota limits can't be negative.")
+	}
+	pool, err := GetPool(poolName)
+	if err != nil {
+		return err
+	}
+	quotas := []PoolQuota{quota}
+	for _, q := range pool.Quotas {
+		if q.Team != quota.Team {
+			quotas = append(quotas, q)
+		}
+	}
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return conn.Collection(poolCollection).UpdateId(poolName, bson.M{"$set": bson.M{"quotas": quotas}})
+}
+
+// RemovePoolQuota removes the quota of a team in the pool, so the apps of the
+// team are no longer limited in it.
+func RemovePoolQuota(poolName, team string) error {
+	conn, err := db.Conn()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.Collection(poolCollection).UpdateId(poolName, bson.M{"$pull": bson.M{"quotas": bson.M{"team": team}}})
+	if err == mgo.ErrNotFound {
+		return ErrPoolNotFound
+	}
+	return err
+}
+
 // GetPoolsNames find teams by a list of team names.
 func GetPoolsNames(pools []Pool) []string {
 	pn := make([]string, len(pools))
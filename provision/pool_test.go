@@ -336,3 +336,65 @@ func (s *S) TestListPoolEmpty(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(len(pools), check.Equals, 0)
 }
+
+func (s *S) TestGetPool(c *check.C) {
+	coll := s.storage.Collection(poolCollection)
+	pool := Pool{Name: "pool1", Teams: []string{"team1"}}
+	err := coll.Insert(pool)
+	c.Assert(err, check.IsNil)
+	defer coll.RemoveId(pool.Name)
+	p, err := GetPool("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p.Name, check.Equals, "pool1")
+	c.Assert(p.Teams, check.DeepEquals, []string{"team1"})
+	p, err = GetPool("pool2")
+	c.Assert(err, check.Equals, ErrPoolNotFound)
+	c.Assert(p, check.IsNil)
+}
+
+func (s *S) TestSetPoolQuota(c *check.C) {
+	coll := s.storage.Collection(poolCollection)
+	pool := Pool{Name: "pool1", Quotas: []PoolQuota{{Team: "team1", Units: 2}, {Team: "team2", Units: 3}}}
+	err := coll.Insert(pool)
+	c.Assert(err, check.IsNil)
+	defer coll.RemoveId(pool.Name)
+	err = SetPoolQuota("pool1", PoolQuota{Team: "team1", Memory: 1024, CpuShare: 100, Units: 10})
+	c.Assert(err, check.IsNil)
+	p, err := GetPool("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p.Quotas, check.DeepEquals, []PoolQuota{
+		{Team: "team1", Memory: 1024, CpuShare: 100, Units: 10},
+		{Team: "team2", Units: 3},
+	})
+	c.Assert(p.QuotaForTeam("team2"), check.DeepEquals, &PoolQuota{Team: "team2", Units: 3})
+	c.Assert(p.QuotaForTeam("team3"), check.IsNil)
+}
+
+func (s *S) TestSetPoolQuotaInvalid(c *check.C) {
+	coll := s.storage.Collection(poolCollection)
+	pool := Pool{Name: "pool1"}
+	err := coll.Insert(pool)
+	c.Assert(err, check.IsNil)
+	defer coll.RemoveId(pool.Name)
+	err = SetPoolQuota("pool1", PoolQuota{Units: 1})
+	c.Assert(err, check.ErrorMatches, "Team name is required.")
+	err = SetPoolQuota("pool1", PoolQuota{Team: "team1", Memory: -1})
+	c.Assert(err, check.ErrorMatches, "Quota limits can't be negative.")
+	err = SetPoolQuota("pool2", PoolQuota{Team: "team1", Units: 1})
+	c.Assert(err, check.Equals, ErrPoolNotFound)
+}
+
+func (s *S) TestRemovePoolQuota(c *check.C) {
+	coll := s.storage.Collection(poolCollection)
+	pool := Pool{Name: "pool1", Quotas: []PoolQuota{{Team: "team1", Units: 2}, {Team: "team2", Units: 3}}}
+	err := coll.Insert(pool)
+	c.Assert(err, check.IsNil)
+	defer coll.RemoveId(pool.Name)
+	err = RemovePoolQuota("pool1", "team1")
+	c.Assert(err, check.IsNil)
+	p, err := GetPool("pool1")
+	c.Assert(err, check.IsNil)
+	c.Assert(p.Quotas, check.DeepEquals, []PoolQuota{{Team: "team2", Units: 3}})
+	err = RemovePoolQuota("pool2", "team1")
+	c.Assert(err, check.Equals, ErrPoolNotFound)
+}
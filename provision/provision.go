@@ -11,6 +11,8 @@ import (
 	"fmt"
 	"io"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/tsuru/tsuru/app/bind"
 	"github.com/tsuru/tsuru/quota"
@@ -20,6 +22,9 @@ var (
 	ErrInvalidStatus = errors.New("invalid status")
 	ErrEmptyApp      = errors.New("no units for this app")
 	ErrUnitNotFound  = errors.New("unit not found")
+
+	ErrCanaryNotFound   = errors.New("no canary deploy in progress for this app")
+	ErrCanaryInProgress = errors.New("there is a canary deploy in progress for this app, promote or abort it first")
 )
 
 type InvalidProcessError struct {
@@ -51,6 +56,8 @@ func ParseStatus(status string) (Status, error) {
 		return StatusStarting, nil
 	case "stopped":
 		return StatusStopped, nil
+	case "crashloop":
+		return StatusCrashLoop, nil
 	}
 	return Status(""), ErrInvalidStatus
 }
@@ -98,6 +105,11 @@ const (
 
 	// StatusStopped is for cases where the unit has been stopped.
 	StatusStopped = Status("stopped")
+
+	// StatusCrashLoop is for units that crashed repeatedly in a short
+	// period. They're kept stopped until the backoff period expires, and
+	// then started again.
+	StatusCrashLoop = Status("crashloop")
 )
 
 // Unit represents a provision unit. Can be a machine, container or anything
@@ -111,6 +123,30 @@ type Unit struct {
 	Ip          string
 	Status      Status
 	Address     *url.URL
+	Ports       []UnitPort
+	CrashLoop   *UnitCrashLoop
+}
+
+// UnitCrashLoop describes why a unit is in the crashloop status. Restarts is
+// the number of consecutive crashes of the unit, LastLogs holds the last
+// lines logged by the unit before the latest crash and RetryAt is when the
+// unit will be started again.
+type UnitCrashLoop struct {
+	Restarts     int
+	LastExitCode int
+	Reason       string
+	LastLogs     []string
+	RetryAt      time.Time
+}
+
+// UnitPort is a named port exposed by a unit, declared in tsuru.yaml.
+// HostPort is the port bound to it in the host where the unit runs. Only
+// the routed port receives requests from the router.
+type UnitPort struct {
+	Name     string
+	Port     int
+	HostPort string
+	Routed   bool
 }
 
 // GetName returns the name of the unit.
@@ -161,7 +197,7 @@ type App interface {
 	// Run executes the command in app units. Commands executed with this
 	// method should have access to environment variables defined in the
 	// app.
-	Run(cmd string, w io.Writer, once bool, interactive bool) error
+	Run(cmd string, w io.Writer, args RunArgs) error
 
 	Envs() map[string]bind.EnvVar
 
@@ -179,6 +215,10 @@ type App interface {
 
 	GetTeamsName() []string
 
+	// GetRollingOptions returns the options used when replacing the units
+	// of the app, or nil if all units should be replaced at once.
+	GetRollingOptions() *RollingOptions
+
 	GetQuota() quota.Quota
 	SetQuotaInUse(int) error
 }
@@ -223,6 +263,122 @@ type ImageDeployer interface {
 	ImageDeploy(app App, image string, w io.Writer) (string, error)
 }
 
+// RollingOptions controls how the units of an app are replaced during
+// restarts and deploys. Units are replaced in batches of at most MaxSurge plus
+// MaxUnavailable units: up to MaxUnavailable old units are removed before the
+// new units of the batch are started, and up to MaxSurge new units are
+// started before old units are removed. Pause is the time to wait between
+// batches. A failed healthcheck aborts the remaining batches.
+type RollingOptions struct {
+	MaxSurge       int           `json:"max_surge" bson:"max_surge"`
+	MaxUnavailable int           `json:"max_unavailable" bson:"max_unavailable"`
+	Pause          time.Duration `json:"pause"`
+}
+
+// Enabled returns whether the options describe a rolling replacement.
+func (o *RollingOptions) Enabled() bool {
+	return o != nil && (o.MaxSurge > 0 || o.MaxUnavailable > 0)
+}
+
+// CanaryOptions is the set of options used when deploying a new version of
+// an app as a canary. Units is the number of units started for each process
+// and Weight is the percentage of the traffic routed to them. When Weight is
+// zero, traffic is split evenly among old and new units.
+//
+// The new version is built from Version, ArchiveURL or File, or taken from a
+// previously generated Image, in this order of precedence.
+type CanaryOptions struct {
+	Units      int
+	Weight     int
+	Image      string
+	Version    string
+	ArchiveURL string
+	File       io.ReadCloser
+}
+
+// CanaryDeployer is a provisioner that can deploy a new image alongside the
+// current units of the app, routing only part of the traffic to it. The canary
+// must then be either promoted, replacing all the old units, or aborted.
+type CanaryDeployer interface {
+	CanaryDeploy(app App, opts CanaryOptions, w io.Writer) (string, error)
+	PromoteCanary(app App, w io.Writer) error
+	AbortCanary(app App, w io.Writer) error
+}
+
+// UnitMetrics represents the resource usage of a unit at a given moment.
+type UnitMetrics struct {
+	ID          string
+	ProcessName string
+	CPUPercent  float64
+	MemoryUsage uint64
+	MemoryLimit uint64
+	NetworkRx   uint64
+	NetworkTx   uint64
+}
+
+// MetricsProvisioner is a provisioner that is able to report the live
+// resource usage of the units of an app.
+type MetricsProvisioner interface {
+	UnitsMetrics(App) ([]UnitMetrics, error)
+}
+
+// RunArgs holds the options for running a command in the units of an app.
+// When Units or Process is set, the command runs only in the matching units,
+// with the output of each unit prefixed by its ID.
+type RunArgs struct {
+	Once        bool
+	Interactive bool
+	Units       []string
+	Process     string
+}
+
+// RunFilter selects the units of an app where a command runs. Units holds
+// unit IDs, which may be abbreviated, and Process restricts the command to
+// the units of a process. When Once is set, the command runs only in the
+// first matching unit.
+type RunFilter struct {
+	Units   []string
+	Process string
+	Once    bool
+}
+
+// UnitCommandFailure describes the failure of a command in a unit. ExitCode
+// is -1 when the command could not be run at all.
+type UnitCommandFailure struct {
+	Unit     string
+	ExitCode int
+	Err      string
+}
+
+// UnitsCommandError is returned when a command fails in some of the units
+// where it was run.
+type UnitsCommandError struct {
+	Total    int
+	Failures []UnitCommandFailure
+}
+
+func (e *UnitsCommandError) Error() string {
+	failures := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		if f.ExitCode == -1 {
+			failures[i] = fmt.Sprintf("%s (%s)", f.Unit, f.Err)
+		} else {
+			failures[i] = fmt.Sprintf("%s (exit code %d)", f.Unit, f.ExitCode)
+		}
+	}
+	return fmt.Sprintf("command failed in %d of %d units: %s", len(e.Failures), e.Total, strings.Join(failures, ", "))
+}
+
+// UnitCommandExecutor is a provisioner that is able to run commands in
+// specific units of an app.
+type UnitCommandExecutor interface {
+	// ExecuteCommandInUnits runs a command in the units of the app matching
+	// the filter, prefixing each line of the output with the unit ID. The
+	// command runs in every matching unit even if it fails in some of them,
+	// in which case an *UnitsCommandError is returned.
+	ExecuteCommandInUnits(stdout, stderr io.Writer, app App, filter RunFilter, cmd string, args ...string) error
+}
+
 // Provisioner is the basic interface of this package.
 //
 // Any tsuru provisioner must implement this interface in order to provision
@@ -367,6 +523,7 @@ type TsuruYamlRestartHooks struct {
 type TsuruYamlHooks struct {
 	Restart TsuruYamlRestartHooks
 	Build   []string
+	Release []string
 }
 
 type TsuruYamlHealthcheck struct {
@@ -375,9 +532,44 @@ type TsuruYamlHealthcheck struct {
 	Status          int
 	Match           string
 	AllowedFailures int `json:"allowed_failures" bson:"allowed_failures"`
+	// Interval is the number of seconds between liveness checks of
+	// running units, zero disables liveness checks.
+	Interval int
+	// Threshold is the number of consecutive failed liveness checks
+	// before a unit is replaced.
+	Threshold int
+}
+
+// TsuruYamlUnits is the desired number of units of a process. Count is the
+// number of units the process should have after a deploy, while Min and Max
+// bound the number of units kept from the previous deploy. Zero values mean
+// no restriction.
+type TsuruYamlUnits struct {
+	Count int
+	Min   int
+	Max   int
+}
+
+// TsuruYamlJob is a command executed periodically in one unit of the app.
+// Schedule uses the cron format.
+type TsuruYamlJob struct {
+	Schedule string
+	Command  string
+}
+
+// TsuruYamlPort is a port exposed by the units of a process, besides the
+// one in the PORT environment variable. When Router is set, the router sends
+// requests to this port instead of the default one.
+type TsuruYamlPort struct {
+	Name   string
+	Port   int
+	Router bool
 }
 
 type TsuruYamlData struct {
 	Hooks       TsuruYamlHooks
 	Healthcheck TsuruYamlHealthcheck
+	Units       map[string]TsuruYamlUnits
+	Jobs        map[string]TsuruYamlJob
+	Ports       map[string][]TsuruYamlPort
 }
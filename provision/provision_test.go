@@ -71,6 +71,7 @@ func (ProvisionSuite) TestStatuses(c *check.C) {
 	c.Check(StatusStarted.String(), check.Equals, "started")
 	c.Check(StatusStopped.String(), check.Equals, "stopped")
 	c.Check(StatusStarting.String(), check.Equals, "starting")
+	c.Check(StatusCrashLoop.String(), check.Equals, "crashloop")
 }
 
 func (ProvisionSuite) TestParseStatus(c *check.C) {
@@ -85,6 +86,7 @@ func (ProvisionSuite) TestParseStatus(c *check.C) {
 		{"started", StatusStarted, nil},
 		{"stopped", StatusStopped, nil},
 		{"starting", StatusStarting, nil},
+		{"crashloop", StatusCrashLoop, nil},
 		{"something", Status(""), ErrInvalidStatus},
 		{"otherthing", Status(""), ErrInvalidStatus},
 	}
@@ -105,6 +107,7 @@ func (ProvisionSuite) TestUnitAvailable(c *check.C) {
 		{StatusStarted, true},
 		{StatusBuilding, false},
 		{StatusError, true},
+		{StatusCrashLoop, false},
 	}
 	for _, test := range tests {
 		u := Unit{Status: test.input}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/tsuru/tsuru/action"
 	"github.com/tsuru/tsuru/app/bind"
+	tsuruIo "github.com/tsuru/tsuru/io"
 	"github.com/tsuru/tsuru/provision"
 	"github.com/tsuru/tsuru/quota"
 	"github.com/tsuru/tsuru/router/routertest"
@@ -49,6 +50,7 @@ type FakeApp struct {
 	UpdatePlatform bool
 	TeamOwner      string
 	Teams          []string
+	Rolling        *provision.RollingOptions
 	quota.Quota
 }
 
@@ -125,6 +127,10 @@ func (a *FakeApp) UnbindUnit(unit *provision.Unit) error {
 	return nil
 }
 
+func (a *FakeApp) GetRollingOptions() *provision.RollingOptions {
+	return a.Rolling
+}
+
 func (a *FakeApp) GetQuota() quota.Quota {
 	return a.Quota
 }
@@ -282,7 +288,7 @@ func (a *FakeApp) SerializeEnvVars() error {
 	return nil
 }
 
-func (a *FakeApp) Run(cmd string, w io.Writer, once bool, interactive bool) error {
+func (a *FakeApp) Run(cmd string, w io.Writer, args provision.RunArgs) error {
 	a.commMut.Lock()
 	a.Commands = append(a.Commands, fmt.Sprintf("ran %s", cmd))
 	a.commMut.Unlock()
@@ -306,9 +312,10 @@ func (app *FakeApp) GetTeamOwner() string {
 }
 
 type Cmd struct {
-	Cmd  string
-	Args []string
-	App  provision.App
+	Cmd   string
+	Args  []string
+	App   provision.App
+	Units []string
 }
 
 type failure struct {
@@ -421,6 +428,22 @@ func (p *FakeProvisioner) GetUnits(app provision.App) []provision.Unit {
 	return pApp.units
 }
 
+// RestartRollingOptions returns the rolling options of the app in the last
+// call to Restart.
+func (p *FakeProvisioner) RestartRollingOptions(app provision.App) *provision.RollingOptions {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.apps[app.GetName()].rolling
+}
+
+// Canary returns the options of the canary deploy in progress for the given
+// app, or nil if there is no canary deploy in progress.
+func (p *FakeProvisioner) Canary(app provision.App) *provision.CanaryOptions {
+	p.mut.RLock()
+	defer p.mut.RUnlock()
+	return p.apps[app.GetName()].canary
+}
+
 // Version returns the last deployed for a given app.
 func (p *FakeProvisioner) Version(app provision.App) string {
 	p.mut.RLock()
@@ -541,6 +564,66 @@ func (p *FakeProvisioner) ImageDeploy(app provision.App, img string, w io.Writer
 	return img, nil
 }
 
+func (p *FakeProvisioner) CanaryDeploy(app provision.App, opts provision.CanaryOptions, w io.Writer) (string, error) {
+	if err := p.getError("CanaryDeploy"); err != nil {
+		return "", err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return "", errNotProvisioned
+	}
+	if pApp.canary != nil {
+		return "", provision.ErrCanaryInProgress
+	}
+	w.Write([]byte("Canary deploy called"))
+	pApp.canary = &opts
+	p.apps[app.GetName()] = pApp
+	if opts.Image != "" {
+		return opts.Image, nil
+	}
+	return "app-image", nil
+}
+
+func (p *FakeProvisioner) PromoteCanary(app provision.App, w io.Writer) error {
+	if err := p.getError("PromoteCanary"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	if pApp.canary == nil {
+		return provision.ErrCanaryNotFound
+	}
+	w.Write([]byte("Promote canary called"))
+	pApp.canary = nil
+	p.apps[app.GetName()] = pApp
+	return nil
+}
+
+func (p *FakeProvisioner) AbortCanary(app provision.App, w io.Writer) error {
+	if err := p.getError("AbortCanary"); err != nil {
+		return err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return errNotProvisioned
+	}
+	if pApp.canary == nil {
+		return provision.ErrCanaryNotFound
+	}
+	w.Write([]byte("Abort canary called"))
+	pApp.canary = nil
+	p.apps[app.GetName()] = pApp
+	return nil
+}
+
 func (p *FakeProvisioner) Provision(app provision.App) error {
 	if err := p.getError("Provision"); err != nil {
 		return err
@@ -574,6 +657,8 @@ func (p *FakeProvisioner) Restart(app provision.App, process string, w io.Writer
 		return errNotProvisioned
 	}
 	pApp.restarts[process]++
+	pApp.rolling = app.GetRollingOptions()
+	pApp.setStatus(process, provision.StatusStarted)
 	p.apps[app.GetName()] = pApp
 	if w != nil {
 		fmt.Fprintf(w, "restarting app")
@@ -589,6 +674,7 @@ func (p *FakeProvisioner) Start(app provision.App, process string) error {
 		return errNotProvisioned
 	}
 	pApp.starts[process]++
+	pApp.setStatus(process, provision.StatusStarted)
 	p.apps[app.GetName()] = pApp
 	return nil
 }
@@ -636,9 +722,11 @@ func (p *FakeProvisioner) AddUnits(app provision.App, n uint, process string, w
 				Host:   fmt.Sprintf("10.10.10.%d:%d", val, val),
 			},
 		}
-		err := routertest.FakeRouter.AddRoute(name, unit.Address)
-		if err != nil {
-			return nil, err
+		if isRoutableProcess(process) {
+			err := routertest.FakeRouter.AddRoute(name, unit.Address)
+			if err != nil {
+				return nil, err
+			}
 		}
 		pApp.units = append(pApp.units, unit)
 		pApp.unitLen++
@@ -665,22 +753,30 @@ func (p *FakeProvisioner) RemoveUnits(app provision.App, n uint, process string,
 	if !ok {
 		return errNotProvisioned
 	}
+	var processUnits uint
+	for _, u := range pApp.units {
+		if u.ProcessName == process {
+			processUnits++
+		}
+	}
+	if processUnits < n {
+		return errors.New("too many units to remove")
+	}
 	var newUnits []provision.Unit
 	removedCount := n
 	for _, u := range pApp.units {
 		if removedCount > 0 && u.ProcessName == process {
 			removedCount--
-			err := routertest.FakeRouter.RemoveRoute(app.GetName(), u.Address)
-			if err != nil {
-				return err
+			if isRoutableProcess(process) {
+				err := routertest.FakeRouter.RemoveRoute(app.GetName(), u.Address)
+				if err != nil {
+					return err
+				}
 			}
 			continue
 		}
 		newUnits = append(newUnits, u)
 	}
-	if removedCount > 0 {
-		return errors.New("too many units to remove")
-	}
 	if w != nil {
 		fmt.Fprintf(w, "removing %d units", n)
 	}
@@ -748,6 +844,64 @@ func (p *FakeProvisioner) ExecuteCommand(stdout, stderr io.Writer, app provision
 	return err
 }
 
+// ExecuteCommandInUnits pretends to execute the given command in the units
+// matching the filter, recording their IDs in the command data. Each unit
+// consumes one output prepared with PrepareOutput, which is written with the
+// unit ID as prefix. Units in the filter must have their full IDs.
+func (p *FakeProvisioner) ExecuteCommandInUnits(stdout, stderr io.Writer, app provision.App, filter provision.RunFilter, cmd string, args ...string) error {
+	if err := p.getError("ExecuteCommandInUnits"); err != nil {
+		return err
+	}
+	units, err := app.Units()
+	if err != nil {
+		return err
+	}
+	var selected []string
+	for _, unit := range units {
+		if filter.Process != "" && unit.ProcessName != filter.Process {
+			continue
+		}
+		if len(filter.Units) > 0 {
+			var found bool
+			for _, id := range filter.Units {
+				if id == unit.ID {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+		selected = append(selected, unit.ID)
+	}
+	if len(selected) == 0 {
+		return provision.ErrEmptyApp
+	}
+	if filter.Once {
+		selected = selected[:1]
+	}
+	command := Cmd{
+		Cmd:   cmd,
+		Args:  args,
+		App:   app,
+		Units: selected,
+	}
+	p.cmdMut.Lock()
+	p.cmds = append(p.cmds, command)
+	p.cmdMut.Unlock()
+	for _, id := range selected {
+		select {
+		case output := <-p.outputs:
+			w := tsuruIo.PrefixWriter{Writer: stdout, Prefix: "[" + id + "] "}
+			w.Write(output)
+		case <-time.After(2e9):
+			return errors.New("FakeProvisioner timed out waiting for output.")
+		}
+	}
+	return nil
+}
+
 func (p *FakeProvisioner) ExecuteCommandOnce(stdout, stderr io.Writer, app provision.App, cmd string, args ...string) error {
 	var output []byte
 	command := Cmd{
@@ -793,10 +947,40 @@ func (p *FakeProvisioner) Units(app provision.App) ([]provision.Unit, error) {
 	return p.apps[app.GetName()].units, nil
 }
 
+// UnitsMetrics returns empty metrics for each unit of the app.
+func (p *FakeProvisioner) UnitsMetrics(app provision.App) ([]provision.UnitMetrics, error) {
+	if err := p.getError("UnitsMetrics"); err != nil {
+		return nil, err
+	}
+	p.mut.Lock()
+	defer p.mut.Unlock()
+	pApp, ok := p.apps[app.GetName()]
+	if !ok {
+		return nil, errNotProvisioned
+	}
+	metrics := make([]provision.UnitMetrics, len(pApp.units))
+	for i, unit := range pApp.units {
+		metrics[i] = provision.UnitMetrics{ID: unit.ID, ProcessName: unit.ProcessName}
+	}
+	return metrics, nil
+}
+
+// isRoutableProcess reports whether units of the process receive requests,
+// which are the ones of the web process or without a process name.
+func isRoutableProcess(process string) bool {
+	return process == "" || process == "web"
+}
+
 func (p *FakeProvisioner) RoutableUnits(app provision.App) ([]provision.Unit, error) {
 	p.mut.Lock()
 	defer p.mut.Unlock()
-	return p.apps[app.GetName()].units, nil
+	var units []provision.Unit
+	for _, u := range p.apps[app.GetName()].units {
+		if isRoutableProcess(u.ProcessName) {
+			units = append(units, u)
+		}
+	}
+	return units, nil
 }
 
 func (p *FakeProvisioner) SetUnitStatus(unit provision.Unit, status provision.Status) error {
@@ -894,10 +1078,7 @@ func (p *FakeProvisioner) Stop(app provision.App, process string) error {
 		return errNotProvisioned
 	}
 	pApp.stops[process]++
-	for i, u := range pApp.units {
-		u.Status = provision.StatusStopped
-		pApp.units[i] = u
-	}
+	pApp.setStatus(process, provision.StatusStopped)
 	p.apps[app.GetName()] = pApp
 	return nil
 }
@@ -1060,6 +1241,19 @@ type provisionedApp struct {
 	cnames      []string
 	unitLen     int
 	lastData    map[string]interface{}
+	canary      *provision.CanaryOptions
+	rolling     *provision.RollingOptions
+}
+
+// setStatus changes the status of the units of the given process, or of all
+// units if process is empty.
+func (a *provisionedApp) setStatus(process string, status provision.Status) {
+	for i, u := range a.units {
+		if process == "" || u.ProcessName == process {
+			u.Status = status
+			a.units[i] = u
+		}
+	}
 }
 
 type provisionedPlatform struct {
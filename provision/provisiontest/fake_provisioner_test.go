@@ -25,6 +25,18 @@ type S struct{}
 
 var _ = check.Suite(&S{})
 
+func init() {
+	suite := &ProvisionerSuite{
+		ArchiveURL:    "https://s3.amazonaws.com/smt/archive.tar.gz",
+		UploadArchive: []byte("my app"),
+	}
+	suite.SetUpTestFunc = func(c *check.C) {
+		routertest.FakeRouter.Reset()
+		suite.Provisioner = NewFakeProvisioner()
+	}
+	check.Suite(suite)
+}
+
 func (s *S) SetUpTest(c *check.C) {
 	routertest.FakeRouter.Reset()
 }
@@ -507,6 +519,25 @@ func (s *S) TestStop(c *check.C) {
 	c.Assert(p.Stops(app, ""), check.Equals, 1)
 }
 
+func (s *S) TestStopAndStartProcess(c *check.C) {
+	app := NewFakeApp("kid-gloves", "rush", 0)
+	p := NewFakeProvisioner()
+	p.Provision(app)
+	_, err := p.AddUnits(app, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	_, err = p.AddUnits(app, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	err = p.Stop(app, "worker")
+	c.Assert(err, check.IsNil)
+	units := p.GetUnits(app)
+	c.Assert(units[0].Status, check.Equals, provision.StatusStarted)
+	c.Assert(units[1].Status, check.Equals, provision.StatusStopped)
+	err = p.Start(app, "worker")
+	c.Assert(err, check.IsNil)
+	units = p.GetUnits(app)
+	c.Assert(units[1].Status, check.Equals, provision.StatusStarted)
+}
+
 func (s *S) TestRestartNotProvisioned(c *check.C) {
 	app := NewFakeApp("kid-gloves", "rush", 1)
 	p := NewFakeProvisioner()
@@ -565,8 +596,8 @@ func (s *S) TestAddUnits(c *check.C) {
 	c.Assert(allUnits[3].ProcessName, check.Equals, "worker")
 	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), allUnits[0].Address.String()), check.Equals, true)
 	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), allUnits[1].Address.String()), check.Equals, true)
-	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), allUnits[2].Address.String()), check.Equals, true)
-	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), allUnits[3].Address.String()), check.Equals, true)
+	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), allUnits[2].Address.String()), check.Equals, false)
+	c.Assert(routertest.FakeRouter.HasRoute(app.GetName(), allUnits[3].Address.String()), check.Equals, false)
 }
 
 func (s *S) TestAddUnitsCopiesTheUnitsSlice(c *check.C) {
@@ -831,6 +862,29 @@ func (s *S) TestExecuteCommandOnce(c *check.C) {
 	c.Assert(buf.String(), check.Equals, string(output))
 }
 
+func (s *S) TestExecuteCommandInUnits(c *check.C) {
+	var buf bytes.Buffer
+	app := NewFakeApp("grand-designs", "rush", 3)
+	p := NewFakeProvisioner()
+	p.PrepareOutput([]byte("first\n"))
+	p.PrepareOutput([]byte("second\n"))
+	filter := provision.RunFilter{Units: []string{"grand-designs-0", "grand-designs-2"}}
+	err := p.ExecuteCommandInUnits(&buf, nil, app, filter, "ls", "-l")
+	c.Assert(err, check.IsNil)
+	cmds := p.GetCmds("ls", app)
+	c.Assert(cmds, check.HasLen, 1)
+	c.Assert(cmds[0].Units, check.DeepEquals, []string{"grand-designs-0", "grand-designs-2"})
+	c.Assert(buf.String(), check.Equals, "[grand-designs-0] first\n[grand-designs-2] second\n")
+}
+
+func (s *S) TestExecuteCommandInUnitsNoMatchingUnits(c *check.C) {
+	app := NewFakeApp("grand-designs", "rush", 1)
+	p := NewFakeProvisioner()
+	filter := provision.RunFilter{Process: "worker"}
+	err := p.ExecuteCommandInUnits(nil, nil, app, filter, "ls")
+	c.Assert(err, check.Equals, provision.ErrEmptyApp)
+}
+
 func (s *S) TestExtensiblePlatformAdd(c *check.C) {
 	p := ExtensibleFakeProvisioner{FakeProvisioner: NewFakeProvisioner()}
 	args := map[string]string{"dockerfile": "mydockerfile.txt"}
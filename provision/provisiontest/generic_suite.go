@@ -0,0 +1,370 @@
+// Copyright 2015 tsuru authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package provisiontest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+
+	"github.com/tsuru/tsuru/provision"
+	"github.com/tsuru/tsuru/router/routertest"
+	"gopkg.in/check.v1"
+)
+
+// ProvisionerSuite is a set of tests describing the behavior expected from
+// any provision.Provisioner. Provisioners may run it by registering the suite
+// in their tests, setting the Provisioner and, optionally, the functions used
+// to prepare the environment.
+//
+// Apps used in the suite declare two processes, "web" and "worker". Routes are
+// checked in routertest.FakeRouter, so the provisioner must use it as the
+// router of the apps.
+type ProvisionerSuite struct {
+	Provisioner provision.Provisioner
+	// NewAppFunc returns the app used in a test, storing it wherever the
+	// provisioner expects to find it. Defaults to NewFakeApp.
+	NewAppFunc func(c *check.C, name string) provision.App
+	// DeployFunc deploys a new version of the app, declaring the processes
+	// "web" and "worker", and returns the deployed image. Defaults to an
+	// archive deploy using ArchiveURL.
+	DeployFunc func(c *check.C, app provision.App) string
+	// ArchiveURL is the archive used in archive deploys.
+	ArchiveURL string
+	// UploadArchive is the content of the file sent in upload deploys.
+	UploadArchive     []byte
+	SetUpSuiteFunc    func(c *check.C)
+	SetUpTestFunc     func(c *check.C)
+	TearDownSuiteFunc func(c *check.C)
+	TearDownTestFunc  func(c *check.C)
+}
+
+func (s *ProvisionerSuite) SetUpSuite(c *check.C) {
+	if s.SetUpSuiteFunc != nil {
+		s.SetUpSuiteFunc(c)
+	}
+}
+
+func (s *ProvisionerSuite) SetUpTest(c *check.C) {
+	if s.SetUpTestFunc != nil {
+		s.SetUpTestFunc(c)
+	}
+}
+
+func (s *ProvisionerSuite) TearDownSuite(c *check.C) {
+	if s.TearDownSuiteFunc != nil {
+		s.TearDownSuiteFunc(c)
+	}
+}
+
+func (s *ProvisionerSuite) TearDownTest(c *check.C) {
+	if s.TearDownTestFunc != nil {
+		s.TearDownTestFunc(c)
+	}
+}
+
+func (s *ProvisionerSuite) newApp(c *check.C, name string) provision.App {
+	var app provision.App
+	if s.NewAppFunc != nil {
+		app = s.NewAppFunc(c, name)
+	} else {
+		app = NewFakeApp(name, "python", 0)
+	}
+	err := s.Provisioner.Provision(app)
+	c.Assert(err, check.IsNil)
+	return app
+}
+
+func (s *ProvisionerSuite) deploy(c *check.C, app provision.App) string {
+	var img string
+	if s.DeployFunc != nil {
+		img = s.DeployFunc(c, app)
+	} else {
+		deployer, ok := s.Provisioner.(provision.ArchiveDeployer)
+		if !ok {
+			c.Skip("provisioner doesn't support archive deploys and no DeployFunc was set")
+		}
+		var err error
+		img, err = deployer.ArchiveDeploy(app, s.ArchiveURL, ioutil.Discard)
+		c.Assert(err, check.IsNil)
+	}
+	if fakeApp, ok := app.(*FakeApp); ok {
+		fakeApp.Deploys++
+	}
+	return img
+}
+
+func (s *ProvisionerSuite) unitsByProcess(c *check.C, app provision.App) map[string][]provision.Unit {
+	units, err := s.Provisioner.Units(app)
+	c.Assert(err, check.IsNil)
+	result := make(map[string][]provision.Unit)
+	for _, u := range units {
+		result[u.ProcessName] = append(result[u.ProcessName], u)
+	}
+	return result
+}
+
+// routes returns the routes of the app in routertest.FakeRouter, sorted.
+func (s *ProvisionerSuite) routes(c *check.C, app provision.App) []string {
+	urls, err := routertest.FakeRouter.Routes(app.GetName())
+	c.Assert(err, check.IsNil)
+	routes := make([]string, len(urls))
+	for i, u := range urls {
+		routes[i] = u.String()
+	}
+	sort.Strings(routes)
+	return routes
+}
+
+func (s *ProvisionerSuite) assertAddr(c *check.C, app provision.App, expected string) {
+	addr, err := s.Provisioner.Addr(app)
+	c.Assert(err, check.IsNil)
+	c.Assert(addr, check.Equals, expected)
+}
+
+func (s *ProvisionerSuite) assertValidImage(c *check.C, app provision.App, img string) {
+	c.Assert(img, check.Not(check.Equals), "")
+	images, err := s.Provisioner.ValidAppImages(app.GetName())
+	c.Assert(err, check.IsNil)
+	c.Assert(images, check.Not(check.HasLen), 0)
+	c.Assert(images[len(images)-1], check.Equals, img)
+}
+
+func (s *ProvisionerSuite) TestProvisionAndDestroy(c *check.C) {
+	app := s.newApp(c, "myapp")
+	units, err := s.Provisioner.Units(app)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 0)
+	err = s.Provisioner.Destroy(app)
+	c.Assert(err, check.IsNil)
+}
+
+func (s *ProvisionerSuite) TestArchiveDeploy(c *check.C) {
+	deployer, ok := s.Provisioner.(provision.ArchiveDeployer)
+	if !ok {
+		c.Skip("provisioner doesn't support archive deploys")
+	}
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	img, err := deployer.ArchiveDeploy(app, s.ArchiveURL, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	s.assertValidImage(c, app, img)
+}
+
+func (s *ProvisionerSuite) TestUploadDeploy(c *check.C) {
+	deployer, ok := s.Provisioner.(provision.UploadDeployer)
+	if !ok {
+		c.Skip("provisioner doesn't support upload deploys")
+	}
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	file := ioutil.NopCloser(bytes.NewReader(s.UploadArchive))
+	img, err := deployer.UploadDeploy(app, file, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	s.assertValidImage(c, app, img)
+}
+
+func (s *ProvisionerSuite) TestImageDeploy(c *check.C) {
+	deployer, ok := s.Provisioner.(provision.ImageDeployer)
+	if !ok {
+		c.Skip("provisioner doesn't support image deploys")
+	}
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	deployed := s.deploy(c, app)
+	img, err := deployer.ImageDeploy(app, deployed, ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	c.Assert(img, check.Equals, deployed)
+}
+
+func (s *ProvisionerSuite) TestAddUnitsPerProcess(c *check.C) {
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	s.deploy(c, app)
+	before := s.unitsByProcess(c, app)
+	units, err := s.Provisioner.AddUnits(app, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 2)
+	units, err = s.Provisioner.AddUnits(app, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(units, check.HasLen, 1)
+	c.Assert(units[0].ProcessName, check.Equals, "worker")
+	c.Assert(units[0].AppName, check.Equals, app.GetName())
+	after := s.unitsByProcess(c, app)
+	c.Assert(after["web"], check.HasLen, len(before["web"])+2)
+	c.Assert(after["worker"], check.HasLen, len(before["worker"])+1)
+	ids := make(map[string]bool)
+	for _, units := range after {
+		for _, u := range units {
+			c.Assert(ids[u.ID], check.Equals, false)
+			ids[u.ID] = true
+			c.Assert(u.Status, check.Not(check.Equals), provision.StatusStopped)
+		}
+	}
+}
+
+func (s *ProvisionerSuite) TestAddZeroUnits(c *check.C) {
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	s.deploy(c, app)
+	_, err := s.Provisioner.AddUnits(app, 0, "web", nil)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *ProvisionerSuite) TestRemoveUnitsPerProcess(c *check.C) {
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	s.deploy(c, app)
+	_, err := s.Provisioner.AddUnits(app, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	_, err = s.Provisioner.AddUnits(app, 2, "worker", nil)
+	c.Assert(err, check.IsNil)
+	before := s.unitsByProcess(c, app)
+	err = s.Provisioner.RemoveUnits(app, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	after := s.unitsByProcess(c, app)
+	c.Assert(after["web"], check.HasLen, len(before["web"]))
+	c.Assert(after["worker"], check.HasLen, len(before["worker"])-1)
+}
+
+func (s *ProvisionerSuite) TestRemoveTooManyUnits(c *check.C) {
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	s.deploy(c, app)
+	_, err := s.Provisioner.AddUnits(app, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	before := s.unitsByProcess(c, app)
+	err = s.Provisioner.RemoveUnits(app, uint(len(before["worker"])+1), "worker", nil)
+	c.Assert(err, check.NotNil)
+	after := s.unitsByProcess(c, app)
+	c.Assert(after["worker"], check.HasLen, len(before["worker"]))
+	c.Assert(after["web"], check.HasLen, len(before["web"]))
+}
+
+func (s *ProvisionerSuite) TestStopAndStartPerProcess(c *check.C) {
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	s.deploy(c, app)
+	_, err := s.Provisioner.AddUnits(app, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	_, err = s.Provisioner.AddUnits(app, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	err = s.Provisioner.Stop(app, "worker")
+	c.Assert(err, check.IsNil)
+	units := s.unitsByProcess(c, app)
+	for _, u := range units["worker"] {
+		c.Check(u.Status, check.Equals, provision.StatusStopped)
+	}
+	for _, u := range units["web"] {
+		c.Check(u.Status, check.Not(check.Equals), provision.StatusStopped)
+	}
+	err = s.Provisioner.Start(app, "worker")
+	c.Assert(err, check.IsNil)
+	units = s.unitsByProcess(c, app)
+	for _, u := range units["worker"] {
+		c.Check(u.Status, check.Not(check.Equals), provision.StatusStopped)
+	}
+	err = s.Provisioner.Stop(app, "")
+	c.Assert(err, check.IsNil)
+	units = s.unitsByProcess(c, app)
+	for _, process := range []string{"web", "worker"} {
+		for _, u := range units[process] {
+			c.Check(u.Status, check.Equals, provision.StatusStopped)
+		}
+	}
+}
+
+func (s *ProvisionerSuite) TestRestartKeepsUnits(c *check.C) {
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	s.deploy(c, app)
+	_, err := s.Provisioner.AddUnits(app, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	_, err = s.Provisioner.AddUnits(app, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	before := s.unitsByProcess(c, app)
+	err = s.Provisioner.Restart(app, "web", ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	err = s.Provisioner.Restart(app, "", ioutil.Discard)
+	c.Assert(err, check.IsNil)
+	after := s.unitsByProcess(c, app)
+	for process, units := range before {
+		c.Assert(after[process], check.HasLen, len(units))
+		for _, u := range after[process] {
+			c.Check(u.Status, check.Not(check.Equals), provision.StatusStopped)
+		}
+	}
+}
+
+func (s *ProvisionerSuite) TestSwap(c *check.C) {
+	app1 := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app1)
+	app2 := s.newApp(c, "otherapp")
+	defer s.Provisioner.Destroy(app2)
+	s.deploy(c, app1)
+	s.deploy(c, app2)
+	_, err := s.Provisioner.AddUnits(app1, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	_, err = s.Provisioner.AddUnits(app2, 1, "web", nil)
+	c.Assert(err, check.IsNil)
+	routes1 := s.routes(c, app1)
+	c.Assert(routes1, check.Not(check.HasLen), 0)
+	routes2 := s.routes(c, app2)
+	c.Assert(routes2, check.Not(check.HasLen), 0)
+	addr1, err := s.Provisioner.Addr(app1)
+	c.Assert(err, check.IsNil)
+	addr2, err := s.Provisioner.Addr(app2)
+	c.Assert(err, check.IsNil)
+	err = s.Provisioner.Swap(app1, app2)
+	c.Assert(err, check.IsNil)
+	s.assertAddr(c, app1, addr2)
+	s.assertAddr(c, app2, addr1)
+	c.Assert(s.routes(c, app1), check.DeepEquals, routes1)
+	c.Assert(s.routes(c, app2), check.DeepEquals, routes2)
+	for _, route := range routes1 {
+		c.Check(routertest.FakeRouter.HasRoute(app2.GetName(), route), check.Equals, true)
+		c.Check(routertest.FakeRouter.HasRoute(app1.GetName(), route), check.Equals, false)
+	}
+	for _, route := range routes2 {
+		c.Check(routertest.FakeRouter.HasRoute(app1.GetName(), route), check.Equals, true)
+		c.Check(routertest.FakeRouter.HasRoute(app2.GetName(), route), check.Equals, false)
+	}
+	err = s.Provisioner.Swap(app1, app2)
+	c.Assert(err, check.IsNil)
+	s.assertAddr(c, app1, addr1)
+	s.assertAddr(c, app2, addr2)
+	for _, route := range routes1 {
+		c.Check(routertest.FakeRouter.HasRoute(app1.GetName(), route), check.Equals, true)
+	}
+	for _, route := range routes2 {
+		c.Check(routertest.FakeRouter.HasRoute(app2.GetName(), route), check.Equals, true)
+	}
+}
+
+func (s *ProvisionerSuite) TestRoutableUnits(c *check.C) {
+	app := s.newApp(c, "myapp")
+	defer s.Provisioner.Destroy(app)
+	s.deploy(c, app)
+	_, err := s.Provisioner.AddUnits(app, 2, "web", nil)
+	c.Assert(err, check.IsNil)
+	workers, err := s.Provisioner.AddUnits(app, 1, "worker", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(workers, check.HasLen, 1)
+	routable, err := s.Provisioner.RoutableUnits(app)
+	c.Assert(err, check.IsNil)
+	routableIDs := make(map[string]bool, len(routable))
+	for _, u := range routable {
+		routableIDs[u.ID] = true
+		c.Check(u.Address, check.NotNil)
+		c.Check(u.ProcessName, check.Equals, "web")
+	}
+	c.Assert(routableIDs[workers[0].ID], check.Equals, false)
+	webIDs := make(map[string]bool)
+	for _, u := range s.unitsByProcess(c, app)["web"] {
+		webIDs[u.ID] = true
+	}
+	c.Assert(routableIDs, check.DeepEquals, webIDs)
+	c.Assert(routable, check.HasLen, len(webIDs))
+}
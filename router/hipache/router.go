@@ -17,6 +17,7 @@ import (
 	"fmt"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/tsuru/config"
@@ -33,7 +34,11 @@ func init() {
 }
 
 func createRouter(prefix string) (router.Router, error) {
-	return &hipacheRouter{prefix: prefix}, nil
+	r := &hipacheRouter{prefix: prefix}
+	if reports, _ := config.GetBool(prefix + ":traffic-reports"); reports {
+		return &trafficHipacheRouter{r}, nil
+	}
+	return r, nil
 }
 
 func (r *hipacheRouter) connect() redis.Conn {
@@ -234,6 +239,51 @@ func (r *hipacheRouter) RemoveRoute(name string, address *url.URL) error {
 	return nil
 }
 
+// SetRouteWeight sets the weight of a route by repeating its address in the
+// list of backends of the frontend, as Hipache picks one of them at random.
+func (r *hipacheRouter) SetRouteWeight(name string, address *url.URL, weight int) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	domain, err := config.GetString(r.prefix + ":domain")
+	if err != nil {
+		return &router.RouterError{Op: "weight", Err: err}
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	frontend := "frontend:" + backendName + "." + domain
+	count, err := r.removeElement(frontend, address.String())
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return router.ErrRouteNotFound
+	}
+	cnames, err := r.getCNames(backendName)
+	if err != nil {
+		return &router.RouterError{Op: "weight", Err: err}
+	}
+	frontends := []string{frontend}
+	for _, cname := range cnames {
+		_, err = r.removeElement("frontend:"+cname, address.String())
+		if err != nil {
+			return err
+		}
+		frontends = append(frontends, "frontend:"+cname)
+	}
+	for _, f := range frontends {
+		for i := 0; i < weight; i++ {
+			err = r.addRoute(f, address.String())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func (r *hipacheRouter) HealthCheck() error {
 	conn := r.connect()
 	defer conn.Close()
@@ -392,16 +442,63 @@ func (r *hipacheRouter) Routes(name string) ([]*url.URL, error) {
 	if err != nil {
 		return nil, &router.RouterError{Op: "routes", Err: err}
 	}
-	result := make([]*url.URL, len(routes))
-	for i, route := range routes {
-		result[i], err = url.Parse(route)
+	result := make([]*url.URL, 0, len(routes))
+	seen := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		if seen[route] {
+			continue
+		}
+		seen[route] = true
+		u, err := url.Parse(route)
 		if err != nil {
 			return nil, err
 		}
+		result = append(result, u)
 	}
 	return result, nil
 }
 
+// trafficHipacheRouter is a hipache router whose proxies store the time of the
+// last request of each frontend in Redis, under the key lastrequest:<frontend>,
+// as a unix timestamp.
+type trafficHipacheRouter struct {
+	*hipacheRouter
+}
+
+// LastRequest returns the time of the most recent request received by the
+// backend, in its main frontend or in any of its cnames.
+func (r *trafficHipacheRouter) LastRequest(name string) (time.Time, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	domain, err := config.GetString(r.prefix + ":domain")
+	if err != nil {
+		return time.Time{}, &router.RouterError{Op: "lastRequest", Err: err}
+	}
+	cnames, err := r.getCNames(backendName)
+	if err != nil {
+		return time.Time{}, err
+	}
+	frontends := append([]string{backendName + "." + domain}, cnames...)
+	conn := r.connect()
+	defer conn.Close()
+	var last time.Time
+	for _, frontend := range frontends {
+		timestamp, err := redis.Int64(conn.Do("GET", "lastrequest:"+frontend))
+		if err == redis.ErrNil {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, &router.RouterError{Op: "lastRequest", Err: err}
+		}
+		if t := time.Unix(timestamp, 0); t.After(last) {
+			last = t
+		}
+	}
+	return last, nil
+}
+
 func (r *hipacheRouter) removeElement(name, address string) (int, error) {
 	conn := r.connect()
 	defer conn.Close()
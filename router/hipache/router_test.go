@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/tsuru/config"
@@ -65,6 +66,7 @@ func (s *S) SetUpTest(c *check.C) {
 	conn = rtest.connect()
 	ClearRedisKeys("frontend*", conn, c)
 	ClearRedisKeys("cname*", conn, c)
+	ClearRedisKeys("lastrequest*", conn, c)
 	ClearRedisKeys("*.com", conn, c)
 }
 
@@ -622,6 +624,95 @@ func (s *S) TestRoutes(c *check.C) {
 	c.Assert(routes, check.DeepEquals, []*url.URL{addr})
 }
 
+func (s *S) TestSetRouteWeight(c *check.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.AddBackend("tip")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("tip")
+	addr1, _ := url.Parse("http://10.10.10.10:8080")
+	addr2, _ := url.Parse("http://10.10.10.11:8080")
+	err = r.AddRoute("tip", addr1)
+	c.Assert(err, check.IsNil)
+	err = r.AddRoute("tip", addr2)
+	c.Assert(err, check.IsNil)
+	err = r.SetCName("mycname.com", "tip")
+	c.Assert(err, check.IsNil)
+	err = r.SetRouteWeight("tip", addr1, 3)
+	c.Assert(err, check.IsNil)
+	expected := []string{addr2.String(), addr1.String(), addr1.String(), addr1.String()}
+	backends, err := redis.Strings(conn.Do("LRANGE", "frontend:tip.golang.org", 1, -1))
+	c.Assert(err, check.IsNil)
+	c.Assert(backends, check.DeepEquals, expected)
+	backends, err = redis.Strings(conn.Do("LRANGE", "frontend:mycname.com", 1, -1))
+	c.Assert(err, check.IsNil)
+	c.Assert(backends, check.DeepEquals, expected)
+	routes, err := r.Routes("tip")
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []*url.URL{addr2, addr1})
+	err = r.SetRouteWeight("tip", addr1, 1)
+	c.Assert(err, check.IsNil)
+	backends, err = redis.Strings(conn.Do("LRANGE", "frontend:tip.golang.org", 1, -1))
+	c.Assert(err, check.IsNil)
+	c.Assert(backends, check.DeepEquals, []string{addr2.String(), addr1.String()})
+	err = r.RemoveRoute("tip", addr1)
+	c.Assert(err, check.IsNil)
+	routes, err = r.Routes("tip")
+	c.Assert(err, check.IsNil)
+	c.Assert(routes, check.DeepEquals, []*url.URL{addr2})
+}
+
+func (s *S) TestSetRouteWeightRouteNotFound(c *check.C) {
+	r := hipacheRouter{prefix: "hipache"}
+	err := r.AddBackend("tip")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("tip")
+	addr, _ := url.Parse("http://10.10.10.10:8080")
+	err = r.SetRouteWeight("tip", addr, 3)
+	c.Assert(err, check.Equals, router.ErrRouteNotFound)
+}
+
+func (s *S) TestCreateRouterTrafficReports(c *check.C) {
+	r, err := createRouter("hipache")
+	c.Assert(err, check.IsNil)
+	_, ok := r.(router.TrafficReporter)
+	c.Assert(ok, check.Equals, false)
+	config.Set("hipache:traffic-reports", true)
+	defer config.Unset("hipache:traffic-reports")
+	r, err = createRouter("hipache")
+	c.Assert(err, check.IsNil)
+	_, ok = r.(router.TrafficReporter)
+	c.Assert(ok, check.Equals, true)
+}
+
+func (s *S) TestLastRequest(c *check.C) {
+	r := trafficHipacheRouter{&hipacheRouter{prefix: "hipache"}}
+	err := r.AddBackend("tip")
+	c.Assert(err, check.IsNil)
+	defer r.RemoveBackend("tip")
+	last, err := r.LastRequest("tip")
+	c.Assert(err, check.IsNil)
+	c.Assert(last.IsZero(), check.Equals, true)
+	err = r.SetCName("mycname.com", "tip")
+	c.Assert(err, check.IsNil)
+	now := time.Now()
+	_, err = conn.Do("SET", "lastrequest:tip.golang.org", now.Add(-time.Hour).Unix())
+	c.Assert(err, check.IsNil)
+	last, err = r.LastRequest("tip")
+	c.Assert(err, check.IsNil)
+	c.Assert(last.Unix(), check.Equals, now.Add(-time.Hour).Unix())
+	_, err = conn.Do("SET", "lastrequest:mycname.com", now.Unix())
+	c.Assert(err, check.IsNil)
+	last, err = r.LastRequest("tip")
+	c.Assert(err, check.IsNil)
+	c.Assert(last.Unix(), check.Equals, now.Unix())
+}
+
+func (s *S) TestLastRequestBackendNotFound(c *check.C) {
+	r := trafficHipacheRouter{&hipacheRouter{prefix: "hipache"}}
+	_, err := r.LastRequest("notfound")
+	c.Assert(err, check.Equals, router.ErrBackendNotFound)
+}
+
 func (s *S) TestSwap(c *check.C) {
 	backend1 := "b1"
 	backend2 := "b2"
@@ -12,6 +12,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db"
@@ -91,6 +92,19 @@ type HealthChecker interface {
 	HealthCheck() error
 }
 
+// WeightedRouter is a router that is able to distribute traffic unevenly
+// among the routes of a backend. Weights are relative to each other, routes
+// without an explicit weight have weight 1.
+type WeightedRouter interface {
+	SetRouteWeight(name string, address *url.URL, weight int) error
+}
+
+// TrafficReporter is a router that knows when a backend last received a
+// request. A zero time means the backend never received requests.
+type TrafficReporter interface {
+	LastRequest(name string) (time.Time, error)
+}
+
 type RouterError struct {
 	Op  string
 	Err error
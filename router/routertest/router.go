@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/tsuru/tsuru/router"
 )
@@ -33,13 +34,15 @@ func createHCRouter(prefix string) (router.Router, error) {
 }
 
 func newFakeRouter() fakeRouter {
-	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), mutex: &sync.Mutex{}}
+	return fakeRouter{cnames: make(map[string]string), backends: make(map[string][]string), failuresByIp: make(map[string]bool), weights: make(map[string]int), lastRequests: make(map[string]time.Time), mutex: &sync.Mutex{}}
 }
 
 type fakeRouter struct {
 	backends     map[string][]string
 	cnames       map[string]string
 	failuresByIp map[string]bool
+	weights      map[string]int
+	lastRequests map[string]time.Time
 	mutex        *sync.Mutex
 }
 
@@ -161,9 +164,56 @@ func (r *fakeRouter) RemoveRoute(name string, address *url.URL) error {
 	}
 	routes[index] = routes[len(routes)-1]
 	r.backends[backendName] = routes[:len(routes)-1]
+	delete(r.weights, address.String())
 	return nil
 }
 
+func (r *fakeRouter) SetRouteWeight(name string, address *url.URL, weight int) error {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return err
+	}
+	if !r.HasRoute(backendName, address.String()) {
+		return router.ErrRouteNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if weight == 1 {
+		delete(r.weights, address.String())
+	} else {
+		r.weights[address.String()] = weight
+	}
+	return nil
+}
+
+func (r *fakeRouter) RouteWeight(address string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if weight, ok := r.weights[address]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (r *fakeRouter) SetLastRequest(name string, t time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lastRequests[name] = t
+}
+
+func (r *fakeRouter) LastRequest(name string) (time.Time, error) {
+	backendName, err := router.Retrieve(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !r.HasBackend(backendName) {
+		return time.Time{}, router.ErrBackendNotFound
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lastRequests[backendName], nil
+}
+
 func (r *fakeRouter) SetCName(cname, name string) error {
 	backendName, err := router.Retrieve(name)
 	if err != nil {
@@ -220,6 +270,8 @@ func (r *fakeRouter) Reset() {
 	r.backends = make(map[string][]string)
 	r.failuresByIp = make(map[string]bool)
 	r.cnames = make(map[string]string)
+	r.weights = make(map[string]int)
+	r.lastRequests = make(map[string]time.Time)
 }
 
 func (r *fakeRouter) Routes(name string) ([]*url.URL, error) {
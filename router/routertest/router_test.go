@@ -7,6 +7,7 @@ package routertest
 import (
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/tsuru/config"
 	"github.com/tsuru/tsuru/db"
@@ -239,3 +240,40 @@ func (s *S) TestSwap(c *check.C) {
 	c.Assert(err, check.IsNil)
 	c.Assert(addr, check.Equals, "b1.fakerouter.com")
 }
+
+func (s *S) TestSetRouteWeight(c *check.C) {
+	r := newFakeRouter()
+	err := r.AddBackend("name")
+	c.Assert(err, check.IsNil)
+	err = r.AddRoute("name", s.localhost)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.RouteWeight(s.localhost.String()), check.Equals, 1)
+	err = r.SetRouteWeight("name", s.localhost, 10)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.RouteWeight(s.localhost.String()), check.Equals, 10)
+	err = r.RemoveRoute("name", s.localhost)
+	c.Assert(err, check.IsNil)
+	c.Assert(r.RouteWeight(s.localhost.String()), check.Equals, 1)
+}
+
+func (s *S) TestSetRouteWeightRouteNotFound(c *check.C) {
+	r := newFakeRouter()
+	err := r.AddBackend("name")
+	c.Assert(err, check.IsNil)
+	err = r.SetRouteWeight("name", s.localhost, 10)
+	c.Assert(err, check.Equals, router.ErrRouteNotFound)
+}
+
+func (s *S) TestLastRequest(c *check.C) {
+	r := newFakeRouter()
+	err := r.AddBackend("name")
+	c.Assert(err, check.IsNil)
+	last, err := r.LastRequest("name")
+	c.Assert(err, check.IsNil)
+	c.Assert(last.IsZero(), check.Equals, true)
+	now := time.Now()
+	r.SetLastRequest("name", now)
+	last, err = r.LastRequest("name")
+	c.Assert(err, check.IsNil)
+	c.Assert(last, check.Equals, now)
+}